@@ -0,0 +1,118 @@
+package myGoRPC
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+AcceptFilter
+Accept 得到连接之后、握手之前调用，返回非 nil 时直接关闭连接，不做握手，
+用于尽早拒绝滥用的来源；设置在 Server.AcceptFilter，nil 即为不过滤
+
+只有 Accept 会调用，ServeConn、ServeHTTP 的连接由调用方自行过滤
+*/
+type AcceptFilter func(remote net.Addr) error
+
+var ErrConnRejected = errors.New("rpc server: connection rejected")
+
+func hostIP(remote net.Addr) net.IP {
+	switch a := remote.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+/*
+IPFilter
+deny 中的网段优先拒绝；allow 非空时只接受其中的网段。网段为 CIDR（10.0.0.0/8）或单个 IP
+无法解析出 IP 的地址（如 unix socket）不受限制
+*/
+func IPFilter(allow, deny []string) (AcceptFilter, error) {
+	allowNets, err := parseNets(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseNets(deny)
+	if err != nil {
+		return nil, err
+	}
+	return func(remote net.Addr) error {
+		ip := hostIP(remote)
+		if ip == nil {
+			return nil
+		}
+		if containsIP(denyNets, ip) || (len(allowNets) > 0 && !containsIP(allowNets, ip)) {
+			return ErrConnRejected
+		}
+		return nil
+	}, nil
+}
+
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: invalid network %q: %v", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+PerIPRate
+每个 IP 在 window 内最多建立 max 个连接，超过的连接被拒绝（固定窗口计数）；
+过期的计数在之后的调用中顺带清除，内存占用与一个窗口内出现过的 IP 数成正比
+*/
+func PerIPRate(max int, window time.Duration) AcceptFilter {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	windowStart := time.Now()
+	return func(remote net.Addr) error {
+		ip := hostIP(remote)
+		if ip == nil {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if now := time.Now(); now.Sub(windowStart) >= window {
+			counts = make(map[string]int)
+			windowStart = now
+		}
+		key := ip.String()
+		if counts[key] >= max {
+			return ErrConnRejected
+		}
+		counts[key]++
+		return nil
+	}
+}
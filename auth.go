@@ -0,0 +1,46 @@
+package myGoRPC
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+/*
+AuthFunc
+握手时校验客户端的凭证（Option.Token），返回非 nil 时拒绝连接，不处理任何请求；设置在 Server.AuthFunc，nil 即为不校验
+remote 为连接的对端地址，ServeConn 传入的不是 net.Conn 时为 nil
+
+V1 握手时拒绝的原因回复给客户端，Dial 返回包装了 ErrUnauthenticated 的错误；V0 握手没有回复，客户端只会看到连接被关闭
+Token 在 Option 中明文发送（StartTLS 升级之前同样是明文），需要保密时使用 DialTLS、AcceptTLS
+连接建立之后刷新的凭证通过请求的元数据传递，不必重新连接，见 AuthInterceptor、MetadataFromContext
+*/
+type AuthFunc func(token string, remote net.Addr) error
+
+// ErrUnauthenticated 服务端的 AuthFunc 拒绝了 Option.Token
+var ErrUnauthenticated = errors.New("rpc server: unauthenticated")
+
+// authenticate 调用 AuthFunc，拒绝时返回的错误以 "unauthenticated: " 开头，回复客户端时加上 "rpc server: " 前缀
+func (server *Server) authenticate(conn io.ReadWriteCloser, token string) error {
+	if server.AuthFunc == nil {
+		return nil
+	}
+	var remote net.Addr
+	if c, ok := conn.(net.Conn); ok {
+		remote = c.RemoteAddr()
+	}
+	if err := server.AuthFunc(token, remote); err != nil {
+		return fmt.Errorf("unauthenticated: %v", err)
+	}
+	return nil
+}
+
+// authError 将握手回复中的拒绝原因还原为包装了 ErrUnauthenticated 的错误
+func authError(msg string) error {
+	if !strings.HasPrefix(msg, ErrUnauthenticated.Error()) {
+		return nil
+	}
+	return fmt.Errorf("%w%s", ErrUnauthenticated, strings.TrimPrefix(msg, ErrUnauthenticated.Error()))
+}
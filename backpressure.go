@@ -0,0 +1,196 @@
+package myGoRPC
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+背压信号
+
+服务端设置 Server.Backpressure 后，负载（正在处理与排队的请求数之和，见 LoadReport）达到 Threshold 时，
+在经过处理的请求的回复的 trailer 中附带 BackpressureTrailerKey，告知客户端放慢发送，先于 Shed、BusyReject 的硬拒绝平滑负载。
+值为空格分隔的键值对，如 "rate=20.000 ttl=1s"，解析时忽略未知的键：
+ - rate 每个连接的目标速率，次/秒
+ - ttl 信号的有效期，time.ParseDuration 的格式
+
+客户端收到信号后按 rate 用令牌桶为之后的请求限速（容量为 rate 向上取整），超过速率的请求在发送前等待：
+Call 等到 ctx 结束，Go 一直等待，client 关闭时以 ErrShutdown 失败；心跳不受限制。
+每个信号都以最新的 rate 替换之前的速率并重新计算有效期，ttl 内没有收到新的信号即解除限速，
+服务端仍然繁忙时下一个回复会再次发出信号。Option.IgnoreBackpressure 为 true 时忽略信号；
+旧版本的客户端只是在 trailer 中多看到一个键
+*/
+
+// BackpressureTrailerKey 回复 trailer 中背压信号的键
+const BackpressureTrailerKey = "rpc-backpressure"
+
+// DefaultBackpressureTTL Backpressure.TTL 的默认值
+const DefaultBackpressureTTL = time.Second
+
+// Backpressure 服务端的背压策略
+type Backpressure struct {
+	Threshold int64         // 开始发出信号的负载，正在处理与排队的请求数之和，不含本次
+	Rate      float64       // 告知每个连接的目标速率，次/秒，需为正数
+	TTL       time.Duration // 信号的有效期，0 即为 DefaultBackpressureTTL
+}
+
+// BackpressureSignal 回复中的背压信号
+type BackpressureSignal struct {
+	Rate float64
+	TTL  time.Duration
+}
+
+func (s BackpressureSignal) String() string {
+	return "rate=" + strconv.FormatFloat(s.Rate, 'f', 3, 64) + " ttl=" + s.TTL.String()
+}
+
+// ParseBackpressure 从 trailer 中读取背压信号，没有信号或格式错误时返回 false
+func ParseBackpressure(md map[string]string) (BackpressureSignal, bool) {
+	var s BackpressureSignal
+	v, ok := md[BackpressureTrailerKey]
+	if !ok {
+		return s, false
+	}
+	for _, kv := range strings.Fields(v) {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return BackpressureSignal{}, false
+		}
+		var err error
+		switch kv[:i] {
+		case "rate":
+			s.Rate, err = strconv.ParseFloat(kv[i+1:], 64)
+		case "ttl":
+			s.TTL, err = time.ParseDuration(kv[i+1:])
+		}
+		if err != nil {
+			return BackpressureSignal{}, false
+		}
+	}
+	if s.Rate <= 0 || math.IsInf(s.Rate, 0) || math.IsNaN(s.Rate) {
+		return BackpressureSignal{}, false
+	}
+	if s.TTL <= 0 {
+		s.TTL = DefaultBackpressureTTL
+	}
+	return s, true
+}
+
+// withBackpressure 负载达到 Backpressure.Threshold 时在回复的 trailer 中附带背压信号
+func (server *Server) withBackpressure(md map[string]string) map[string]string {
+	bp := server.Backpressure
+	if bp == nil || bp.Rate <= 0 {
+		return md
+	}
+	if load := server.loadReport(); load.Inflight+load.Queued < bp.Threshold {
+		return md
+	}
+	ttl := bp.TTL
+	if ttl <= 0 {
+		ttl = DefaultBackpressureTTL
+	}
+	if md == nil {
+		md = make(map[string]string, 1)
+	}
+	md[BackpressureTrailerKey] = BackpressureSignal{Rate: bp.Rate, TTL: ttl}.String()
+	return md
+}
+
+// pacer 客户端按背压信号限速的令牌桶
+type pacer struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64 // 可以为负数，即已经预定给等待中的请求
+	last    time.Time
+	expires time.Time // 之后解除限速，零值即为没有限速
+}
+
+// update 按信号替换速率；开始限速时只留一个令牌，避免立即放出整个容量
+func (p *pacer) update(s BackpressureSignal, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Before(p.expires) {
+		p.refill(now)
+	} else {
+		p.tokens = 1
+	}
+	p.rate, p.burst, p.last = s.Rate, burstOf(s.Rate, 0), now
+	p.tokens = math.Min(p.tokens, p.burst)
+	p.expires = now.Add(s.TTL)
+}
+
+func (p *pacer) refill(now time.Time) {
+	p.tokens = math.Min(p.burst, p.tokens+now.Sub(p.last).Seconds()*p.rate)
+	p.last = now
+}
+
+// reserve 取一个令牌，返回需要等待的时长
+func (p *pacer) reserve(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !now.Before(p.expires) {
+		return 0
+	}
+	p.refill(now)
+	p.tokens--
+	if p.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-p.tokens / p.rate * float64(time.Second))
+}
+
+// noteBackpressure 记录回复中的背压信号
+func (client *Client) noteBackpressure(md map[string]string) {
+	if client.option.IgnoreBackpressure {
+		return
+	}
+	if s, ok := ParseBackpressure(md); ok {
+		client.pacer.update(s, time.Now())
+	}
+}
+
+/*
+pace
+背压限速时等待发送的时机；ctx 结束或 client 终止时放弃，设置 call.Error 并返回 false
+*/
+func (client *Client) pace(call *Call) bool {
+	if call.Service == heartbeatService {
+		return true
+	}
+	wait := client.pacer.reserve(time.Now())
+	if wait <= 0 {
+		return true
+	}
+	var ctxDone <-chan struct{}
+	if call.ctx != nil {
+		ctxDone = call.ctx.Done()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctxDone:
+		// 与 invoke 中 ctx 结束时的错误一致
+		call.Error = fmt.Errorf("rpc client: call failed: %w", call.ctx.Err())
+	case <-client.terminated:
+		call.Error = ErrShutdown
+	}
+	return false
+}
+
+// Backpressure 当前生效的背压速率（次/秒），没有限速时返回 0
+func (client *Client) Backpressure() float64 {
+	p := &client.pacer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !time.Now().Before(p.expires) {
+		return 0
+	}
+	return p.rate
+}
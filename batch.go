@@ -0,0 +1,64 @@
+package myGoRPC
+
+import "myGoRPC/codec"
+
+/*
+Batch
+把多个互不依赖的请求合并为一轮发送：只获取一次 sending，连续写入全部请求，再等待全部回复
+只是客户端的优化，每个请求仍是普通的请求报文，服务端不需要任何改动
+
+	b := client.NewBatch()
+	b.Add("Foo", "Sum", args1, &reply1)
+	b.Add("Foo", "Sum", args2, &reply2)
+	errs := b.Do()
+
+与 Go 相同，Batch 中的请求不经过拦截器；Do 只能调用一次
+*/
+type Batch struct {
+	client *Client
+	calls  []*Call
+}
+
+func (client *Client) NewBatch() *Batch {
+	return &Batch{client: client}
+}
+
+// Add 添加一个请求，返回的 Call 在 Do 返回后结束
+func (b *Batch) Add(service, method string, args, reply interface{}) *Call {
+	call := newCall(service, method, args, reply, make(chan *Call, 1))
+	b.calls = append(b.calls, call)
+	return call
+}
+
+/*
+Do
+发送全部请求并等待结束，按 Add 的顺序返回每个请求的错误
+写入连接失败时，之后尚未发送的请求以同一个错误失败
+*/
+func (b *Batch) Do() []error {
+	client := b.client
+	for _, call := range b.calls {
+		client.prepare(call)
+	}
+	client.sending.Lock()
+	var failed error
+	for _, call := range b.calls {
+		if failed != nil {
+			call.Error = failed
+			call.done()
+			continue
+		}
+		// 编码 body 失败只影响这一个请求
+		if err := client.write(call); err != nil && !codec.EncodeFailed(err) {
+			failed = err
+		}
+	}
+	client.sending.Unlock()
+
+	errs := make([]error, len(b.calls))
+	for i, call := range b.calls {
+		<-call.Done
+		errs[i] = call.Error
+	}
+	return errs
+}
@@ -0,0 +1,177 @@
+package myGoRPC
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"myGoRPC/service"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheSize = 1024
+
+/*
+responseCache
+服务端响应缓存，键为 Service.Method + 参数的哈希，LRU 淘汰，容量有上限
+
+只有注册时通过 RegisterCacheable 显式标记的方法才会被缓存，
+方法结果不确定（依赖时间、随机数、外部状态）的方法不应标记，默认所有方法都不缓存；
+处理出错的结果不缓存。缓存失效：条目超过 TTL 后失效，也可以调用 Server.InvalidateCache 主动清除
+*/
+type responseCache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List // 队首为最近使用的条目
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	reply  interface{}
+	expire time.Time
+}
+
+func newResponseCache(max int) *responseCache {
+	if max <= 0 {
+		max = defaultCacheSize
+	}
+	return &responseCache{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *responseCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ele, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := ele.Value.(*cacheEntry)
+	if time.Now().After(entry.expire) {
+		c.ll.Remove(ele)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(ele)
+	return entry.reply, true
+}
+
+func (c *responseCache) add(key string, reply interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expire := time.Now().Add(ttl)
+	if ele, ok := c.items[key]; ok {
+		entry := ele.Value.(*cacheEntry)
+		entry.reply, entry.expire = reply, expire
+		c.ll.MoveToFront(ele)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, reply: reply, expire: expire})
+	for c.ll.Len() > c.max {
+		ele := c.ll.Back()
+		c.ll.Remove(ele)
+		delete(c.items, ele.Value.(*cacheEntry).key)
+	}
+}
+
+// removePrefix 删除所有以 prefix 开头的条目
+func (c *responseCache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, ele := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.ll.Remove(ele)
+			delete(c.items, key)
+		}
+	}
+}
+
+/*
+RegisterCacheable
+注册服务，并将 methods 标记为可缓存，缓存时间为 ttl
+只应标记幂等且结果确定的读方法
+*/
+func (server *Server) RegisterCacheable(rcvr interface{}, ttl time.Duration, methods ...string) error {
+	if ttl <= 0 {
+		return errors.New("rpc server: cache ttl must be positive")
+	}
+	s, err := service.New("", rcvr)
+	if err != nil {
+		return err
+	}
+	for _, name := range methods {
+		mtype := s.Method[name]
+		if mtype == nil {
+			return errors.New("rpc server: can't cache unknown method " + s.Name + "." + name)
+		}
+		mtype.CacheTTL = ttl
+	}
+	if err := server.addService(s, rcvr); err != nil {
+		return err
+	}
+	server.cacheOnce.Do(func() {
+		server.cache = newResponseCache(server.CacheSize)
+	})
+	return nil
+}
+
+/*
+InvalidateCache
+清除缓存，method 为空时清除整个 service 的缓存，service 也为空时清除全部缓存
+*/
+func (server *Server) InvalidateCache(service, method string) {
+	if server.cache == nil {
+		return
+	}
+	prefix := ""
+	if service != "" {
+		prefix = service + "."
+		if method != "" {
+			prefix += method + "|"
+		}
+	}
+	server.cache.removePrefix(prefix)
+}
+
+// cacheKey Service.Method|参数 gob 编码后的 sha256，参数无法编码时不缓存
+func cacheKey(req *request) (string, bool) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(req.argV.Interface()); err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return req.header.Service + "." + req.header.Method + "|" + hex.EncodeToString(sum[:]), true
+}
+
+/*
+call
+调用 rpc 方法，可缓存的方法先查缓存，命中时不调用方法，直接用缓存的结果替换 req.replyV
+*/
+func (server *Server) call(req *request) error {
+	ttl := req.mtype.CacheTTL
+	if ttl == 0 || server.cache == nil {
+		return req.svc.CallContext(req.ctx, req.mtype, req.argV, req.replyV)
+	}
+	key, ok := cacheKey(req)
+	if !ok {
+		return req.svc.CallContext(req.ctx, req.mtype, req.argV, req.replyV)
+	}
+	if reply, hit := server.cache.get(key); hit {
+		req.replyV = reflect.ValueOf(reply)
+		return nil
+	}
+	if err := req.svc.CallContext(req.ctx, req.mtype, req.argV, req.replyV); err != nil {
+		return err
+	}
+	server.cache.add(key, req.replyV.Interface(), ttl)
+	return nil
+}
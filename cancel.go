@@ -0,0 +1,59 @@
+package myGoRPC
+
+import (
+	"context"
+	"myGoRPC/codec"
+)
+
+/*
+远程取消
+
+服务端传给方法的 ctx 携带请求的元数据与调用方的 deadline（见 handleRequest），并在以下情况下结束：
+ 1. 客户端放弃请求：Call 的 ctx 结束或 Call.Cancel 时，客户端发送 Seq 为该请求的 FrameCancel 帧
+ 2. 连接断开：serveCodec 读取结束时取消该连接上全部未结束的请求；Option.Resumable 的连接除外，
+    它们的回复暂存在会话中等待客户端恢复
+耗时的方法应在 ctx.Done() 后尽快返回。不认识 FrameCancel 的旧服务端会丢弃该帧，请求照常处理
+*/
+
+// FrameCancel 客户端放弃一个未结束的一元请求，Seq 为该请求的序号，body 为空
+const FrameCancel = FrameNotify + 1
+
+// sendCancel 通知服务端取消 seq 的请求；另起协程写入，不让调用方等待发送锁
+func (client *Client) sendCancel(seq uint64) {
+	go func() {
+		client.sending.Lock()
+		defer client.sending.Unlock()
+		if !client.IsAvailable() {
+			return
+		}
+		_ = client.cc.Write(&codec.Header{Seq: seq, Frame: FrameCancel}, invalidRequest)
+	}()
+}
+
+// trackCall 登记正在处理的请求的 cancel，单向调用（Seq 为 0）不登记；返回的函数结束登记
+func (sc *serverConn) trackCall(seq uint64, cancel context.CancelFunc) func() {
+	if seq == 0 {
+		return func() {}
+	}
+	sc.mu.Lock()
+	if sc.calls == nil {
+		sc.calls = make(map[uint64]context.CancelFunc)
+	}
+	sc.calls[seq] = cancel
+	sc.mu.Unlock()
+	return func() {
+		sc.mu.Lock()
+		delete(sc.calls, seq)
+		sc.mu.Unlock()
+	}
+}
+
+// cancelCall 收到 FrameCancel，取消 seq 的请求；请求已经结束时什么也不做
+func (sc *serverConn) cancelCall(seq uint64) {
+	sc.mu.Lock()
+	cancel := sc.calls[seq]
+	sc.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
@@ -0,0 +1,155 @@
+package myGoRPC
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+导出为 Chrome trace JSON（火焰图）
+
+ChromeTrace 收集 NewTracer 导出的跨度，WriteTo 输出 Chrome Trace Event Format 的 JSON，
+可以用 chrome://tracing、Perfetto（ui.perfetto.dev）或 speedscope 以火焰图查看：
+
+	trace := new(myGoRPC.ChromeTrace)
+	tracer := myGoRPC.NewTracer(trace.Export)
+	client.Use(myGoRPC.TracingInterceptor(tracer))
+	server.Use(myGoRPC.TracingServerInterceptor(tracer))
+	// 方法内以收到的 ctx 经过 TracingInterceptor 的 Client 发起的下游调用，成为该 server 跨度的子跨度
+	...
+	_, _ = trace.WriteTo(f)
+
+格式：{"traceEvents": [...], "displayTimeUnit": "ms"}，每个跨度为一个 "ph":"X"（complete）事件：
+  - name 为 "Service.Method"，cat 为 "client" 或 "server"，ts、dur 为微秒，ts 从最早的跨度开始计算
+  - pid 为链路（TraceID）的序号，同一条链路的跨度在同一个进程下
+  - args 记录 trace_id、span_id、parent_id，出错时还有 error
+
+嵌套：查看器按同一 pid、tid 下时间区间的包含关系画出调用栈，因此跨度放在父跨度（由传播的 traceparent 得到的 ParentID）
+所在的 tid 上；与同一 tid 上非祖先的跨度时间重叠时（如 Broadcast、对冲等并发的下游调用）改用新的 tid，
+避免并发的兄弟跨度被画成互相嵌套。时间来自各个跨度所在进程的时钟，跨机器收集时需要时钟同步
+*/
+type ChromeTrace struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Export 记录一个结束的跨度，作为 NewTracer 的 export；可以并发调用
+func (c *ChromeTrace) Export(s SpanData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, s)
+}
+
+// Spans 返回已记录的跨度的副本
+func (c *ChromeTrace) Spans() []SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SpanData(nil), c.spans...)
+}
+
+// chromeEvent Chrome Trace Event Format 的 complete 事件
+type chromeEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args"`
+}
+
+type chromeTraceFile struct {
+	TraceEvents     []chromeEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+// WriteTo 输出已记录的跨度，见 ChromeTrace
+func (c *ChromeTrace) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.Marshal(chromeTraceFile{TraceEvents: chromeEvents(c.Spans()), DisplayTimeUnit: "ms"})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// chromeEvents 按开始时间排列跨度，父跨度先于子跨度放置，见 ChromeTrace 中的嵌套
+func chromeEvents(spans []SpanData) []chromeEvent {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	var origin time.Time
+	if len(spans) > 0 {
+		origin = spans[0].Start
+	}
+	type placed struct {
+		span *SpanData
+		pid  int
+		tid  int
+	}
+	byID := make(map[string]*placed, len(spans))
+	pids := make(map[string]int)
+	lanes := make(map[[2]int][]*SpanData) // (pid, tid) 上已放置的跨度
+	tids := make(map[int]int)             // pid 已使用的最大 tid
+	// isAncestor a 是否为 s 的祖先
+	isAncestor := func(a, s *SpanData) bool {
+		for p := byID[s.ParentID]; p != nil; p = byID[p.span.ParentID] {
+			if p.span == a {
+				return true
+			}
+		}
+		return false
+	}
+	fits := func(s *SpanData, pid, tid int) bool {
+		for _, other := range lanes[[2]int{pid, tid}] {
+			overlap := s.Start.Before(other.Start.Add(other.Duration)) && other.Start.Before(s.Start.Add(s.Duration))
+			if overlap && !isAncestor(other, s) {
+				return false
+			}
+		}
+		return true
+	}
+
+	events := make([]chromeEvent, 0, len(spans))
+	for i := range spans {
+		s := &spans[i]
+		pid, ok := pids[s.TraceID]
+		if !ok {
+			pid = len(pids) + 1
+			pids[s.TraceID] = pid
+		}
+		tid := 1
+		if parent := byID[s.ParentID]; parent != nil && parent.pid == pid {
+			tid = parent.tid
+		}
+		if !fits(s, pid, tid) {
+			tid = tids[pid] + 1
+		}
+		if tid > tids[pid] {
+			tids[pid] = tid
+		}
+		lanes[[2]int{pid, tid}] = append(lanes[[2]int{pid, tid}], s)
+		byID[s.SpanID] = &placed{span: s, pid: pid, tid: tid}
+
+		cat := "client"
+		if s.Kind == SpanServer {
+			cat = "server"
+		}
+		args := map[string]string{"trace_id": s.TraceID, "span_id": s.SpanID}
+		if s.ParentID != "" {
+			args["parent_id"] = s.ParentID
+		}
+		if s.Err != nil {
+			args["error"] = s.Err.Error()
+		}
+		events = append(events, chromeEvent{
+			Name: s.Name, Cat: cat, Ph: "X",
+			Ts:  float64(s.Start.Sub(origin).Nanoseconds()) / 1e3,
+			Dur: float64(s.Duration.Nanoseconds()) / 1e3,
+			Pid: pid, Tid: tid, Args: args,
+		})
+	}
+	return events
+}
@@ -3,16 +3,15 @@ package myGoRPC
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"myGoRPC/codec"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,22 +28,103 @@ type Call struct {
 	Reply   interface{}
 	Error   error
 	Done    chan *Call
+
+	// 随请求头发送的元数据，由 WithMetadata、GoWithMeta 或拦截器填写，服务端通过 MetadataFromContext 读取，见 interceptor.go
+	Metadata map[string]string
+	// 回复携带的元数据，由服务端方法通过 SetTrailer 设置，收到回复后填写
+	Trailer map[string]string
+
+	deadline   time.Time // 来自 Call 的 ctx，非零时随请求发送剩余的时间预算
+	registered time.Time // 注册到 pending 的时间，见 lifetime.go
+	onFinish   func(call *Call, latency time.Duration)
+	client     *Client // 发送该请求的 Client，Cancel 使用
+	ctx        context.Context // Call 的 ctx，等待 Option.PendingWait 的名额时使用，Go 发起的请求为 nil
+	holdsSlot  bool            // 占用了 Option.PendingWait 的名额，done 时归还
+}
+
+var ErrCallCancelled = errors.New("rpc client: call cancelled")
+
+/*
+Cancel
+放弃一个已发送的请求：从 pending 中移除，以 ErrCallCancelled 结束；之后到达的回复被 receive 丢弃
+请求已经结束（收到回复、出错或已取消）时什么也不做，可以重复调用
+服务端收到 FrameCancel 后取消方法的 ctx，见 cancel.go
+*/
+func (call *Call) Cancel() {
+	if call.client == nil {
+		return
+	}
+	if call.client.removeCall(call.Seq) != nil {
+		call.Error = ErrCallCancelled
+		call.done()
+		call.client.sendCancel(call.Seq)
+	}
 }
 
+// done 每个 Call 只调用一次，先调用 Option.OnFinish，再送入 Done
 func (call *Call) done() {
+	if call.onFinish != nil {
+		var latency time.Duration
+		if !call.registered.IsZero() {
+			latency = time.Since(call.registered)
+		}
+		call.onFinish(call, latency)
+	}
+	if call.client != nil {
+		call.client.observe(call)
+		call.client.recordCall(call)
+	}
+	if call.holdsSlot {
+		call.holdsSlot = false
+		<-call.client.pendingSlots
+	}
 	call.Done <- call
+	// 请求送入 Done 之后才算结束，此时关闭连接不会打断 receive 读取回复
+	if client := call.client; client != nil && !call.registered.IsZero() &&
+		atomic.AddInt64(&client.active, -1) == 0 && atomic.LoadInt32(&client.draining) == 1 {
+		client.markDrained()
+	}
 }
 
 type Client struct {
 	cc       codec.Codec      // 消息的编解码器，序列化请求，以及反序列化响应
 	option   *Option     // 编解码方式
 	sending  sync.Mutex       // 保证请求的有序发送，防止出现多个请求报文混淆
+	queued   int32            // 在 sending 上等待的 send 数，原子操作，见 coalesce.go
 	header   codec.Header     // 每个请求的消息头
 	mu       sync.Mutex       // 保护以下
 	seq      uint64           // 每个请求拥有唯一编号
 	pending  map[uint64]*Call // 存储未处理完的请求，键是编号
 	closing  bool             // 用户主动关闭的；值置为 true，则表示 Client 处于不可用的状态
 	shutdown bool             // 一般有错误发生；值置为 true，则表示 Client 处于不可用的状态
+	seqMon   *seqMonitor      // 响应序号检查，nil 即为不检查
+
+	session   string   // 会话令牌，非空时连接断开后尝试恢复，见 session.go
+	addr      net.Addr // 恢复会话时重新拨号的地址
+	resumeSeq uint64   // 最近一次恢复时的下一个序号，之前的请求在恢复完成后仍未回复即为丢失
+
+	reconnectErr *ReconnectError // Option.Reconnect 重连期间非 nil，新的请求以此失败，mu 保护，见 reconnect.go
+	reconnects   uint64          // Option.Reconnect 重连成功的次数，原子操作
+
+	sweepFrom  uint64        // 下一次检查请求存活时间的起始序号
+	terminated chan struct{} // receive 结束时关闭
+	poolKey    string        // 由 Pool 创建时所属的地址，见 pool.go
+	heartbeatErr error       // 心跳超时后置为 ErrHeartbeatTimeout，见 heartbeat.go
+	observer     atomic.Value // *callObserver，见 observer.go
+	interceptors []Interceptor // Call 的拦截器，mu 保护，见 interceptor.go
+	active       int64         // 已注册、尚未结束（送入 Done）的请求数，原子操作
+	draining     int32         // CloseGracefully 开始等待后置为 1，原子操作
+	drained      chan struct{} // draining 且 active 归零时关闭
+	drainOnce    sync.Once
+	stats        statsRegistry // 按方法的调用统计，见 stats.go
+	pendingSlots chan struct{} // Option.PendingWait 时的名额，容量为 MaxPending
+	streams      map[uint64]*Stream // 未结束的流，见 stream.go
+	maxStreams   int                // 服务端在握手时告知的流的上限，0 即为不限制，mu 保护，见 stream.go
+	subs         map[string][]chan Message // 主题 -> 订阅，见 pubsub.go
+	load         atomic.Value              // loadSample，最近一次收到的负载报告，见 load.go
+	lastReply    int64                     // 最近一次收到请求回复的时间，UnixNano，原子操作，见 heartbeat.go
+	pings        uint64                    // 发送的心跳数，原子操作
+	pacer        pacer                     // 按服务端的背压信号限速，见 backpressure.go
 }
 
 // 确保实现
@@ -52,6 +132,9 @@ var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection has been shut down")
 
+// ErrTooManyPending 未结束的请求数达到 Option.MaxPending
+var ErrTooManyPending = errors.New("rpc client: too many pending calls")
+
 func (client *Client) Close() error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -62,6 +145,43 @@ func (client *Client) Close() error {
 	return client.cc.Close()
 }
 
+/*
+CloseGracefully
+不再接受新的请求（之后的请求以 ErrShutdown 失败），等待已发送的请求全部结束后再关闭连接
+ctx 结束时仍有未结束的请求，则强制关闭，这些请求以 ErrShutdown 失败，返回的错误中给出放弃的请求数
+连接因错误断开时立即返回
+*/
+func (client *Client) CloseGracefully(ctx context.Context) error {
+	client.mu.Lock()
+	if client.closing {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	client.mu.Unlock()
+	atomic.StoreInt32(&client.draining, 1)
+	if atomic.LoadInt64(&client.active) == 0 {
+		client.markDrained()
+	}
+
+	select {
+	case <-client.drained:
+	case <-client.terminated:
+	case <-ctx.Done():
+		abandoned := client.PendingCount()
+		_ = client.cc.Close()
+		if abandoned > 0 {
+			return fmt.Errorf("rpc client: graceful close abandoned %d pending calls: %w", abandoned, ctx.Err())
+		}
+		return nil
+	}
+	return client.cc.Close()
+}
+
+func (client *Client) markDrained() {
+	client.drainOnce.Do(func() { close(client.drained) })
+}
+
 /*
 IsAvailable
 查询client是否关闭（主动关闭、错误关闭）
@@ -82,12 +202,45 @@ func (client *Client) registerCall(call *Call) (seq uint64, err error) {
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
-	call.Seq = client.seq
+	if client.reconnectErr != nil {
+		return 0, client.reconnectErr
+	}
+	// ping 不受 MaxPending 限制：pending 已满的半开连接正是需要心跳发现的情况
+	if max := client.option.MaxPending; max > 0 && len(client.pending) >= max && call.Service != heartbeatService {
+		return 0, ErrTooManyPending
+	}
+	call.Seq = client.takeSeq()
+	call.registered = time.Now()
 	client.pending[call.Seq] = call
-	client.seq++
+	atomic.AddInt64(&client.active, 1)
 	return call.Seq, nil
 }
 
+// takeSeq 分配一个序号，调用方需持有 mu
+func (client *Client) takeSeq() uint64 {
+	seq := client.seq
+	client.seq++
+	// 0 为非法序号，回绕时跳过
+	if client.seq == 0 {
+		client.seq = 1
+	}
+	return seq
+}
+
+// PendingCount 返回已发送、尚未结束的请求数
+func (client *Client) PendingCount() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
+}
+
+// nextSeq 返回下一个将要分配的序号
+func (client *Client) nextSeq() uint64 {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.seq
+}
+
 /*
 removeCall
 根据 seq，从 client.pending 中移除对应的 call，并返回
@@ -100,17 +253,35 @@ func (client *Client) removeCall(seq uint64) *Call {
 	return call
 }
 
+// markShutdown 不再接受新的请求，IsAvailable 返回 false
+func (client *Client) markShutdown() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.shutdown = true
+}
+
 /*
 terminateCalls
 服务端或客户端发生错误时调用，将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call
 */
 func (client *Client) terminateCalls(err error) {
 	client.sending.Lock()
-	defer client.sending.Unlock()
 	client.mu.Lock()
-	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	calls := make([]*Call, 0, len(client.pending))
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		calls = append(calls, call)
+	}
+	client.mu.Unlock()
+	client.sending.Unlock()
+	failCalls(calls, err)
+	close(client.terminated)
+}
+
+// failCalls 以 err 结束已从 pending 中移除的请求，需在释放 mu、sending 之后调用：OnFinish 可能调用 Client 的方法
+func failCalls(calls []*Call, err error) {
+	for _, call := range calls {
 		call.Error = err
 		call.done()
 	}
@@ -122,26 +293,58 @@ NewClient
 创建 Client 实例；  完成协议交换；  创建子协程调用 receive 接受响应
 */
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
+	f := codec.Get(opt.CodecType)
 	if f == nil {
-		err := fmt.Errorf("invalid codec type %s ", opt.CodecType)
-		log.Println("rpc client: codec err: ", err)
+		err := fmt.Errorf("%w %q, client supports %v", ErrUnsupportedCodec, opt.CodecType, supportedCodecs())
+		optionLogger(opt).Log(LevelError, "rpc client: codec error", F("err", err))
 		return nil, err
 	}
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+	if opt.HandshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(opt.HandshakeTimeout))
+	}
+	rwc, reply, err := clientHandshake(conn, opt)
+	var counted *byteCounter
+	if err == nil {
+		counted = &byteCounter{ReadWriteCloser: newWriteCoalescer(rwc)}
+		rwc, err = compress(opt, opt.MaxBodySize, counted)
+	}
+	if err != nil {
+		optionLogger(opt).Log(LevelError, "rpc client: handshake error", F("remote", conn.RemoteAddr()), F("err", err))
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	if opt.HandshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	client := newClientCodec(messageCodec(f, f(rwc), opt, opt.MaxBodySize), opt, counted)
+	client.session, client.addr = reply.Session, conn.RemoteAddr()
+	client.maxStreams = reply.MaxConcurrentStreams
+	return client, nil
 }
 
-func newClientCodec(cc codec.Codec, opt *Option) *Client {
+// newClientCodec conn 为 cc 底层的连接，用于统计读写的字节数，nil 即为不统计字节数，见 stats.go
+func newClientCodec(cc codec.Codec, opt *Option, conn *byteCounter) *Client {
 	client := &Client{
-		seq:     1, // starts with 1, 0 invalid call
-		cc:      cc,
-		option:  opt,
-		pending: make(map[uint64]*Call),
+		seq:        1, // starts with 1, 0 invalid call
+		option:     opt,
+		pending:    make(map[uint64]*Call),
+		sweepFrom:  1,
+		terminated: make(chan struct{}),
+		drained:    make(chan struct{}),
+	}
+	client.cc = client.countStats(cc, conn)
+	if opt.PendingWait && opt.MaxPending > 0 {
+		client.pendingSlots = make(chan struct{}, opt.MaxPending)
+	}
+	if opt.SeqCheckWindow > 0 {
+		client.seqMon = newSeqMonitor(opt.SeqCheckWindow)
+		client.seqMon.logger = client.logger()
+	}
+	if lifetime := client.maxCallLifetime(); lifetime > 0 {
+		go client.sweepCalls(lifetime)
+	}
+	if opt.HeartbeatInterval > 0 {
+		go client.heartbeat(opt.HeartbeatInterval, opt.HeartbeatTimeout, opt.HeartbeatMaxMisses)
 	}
 	go client.receive()
 	return client
@@ -164,6 +367,7 @@ func parseOptions(opts ...*Option) (*Option, error) {
 
 	opt := opts[0]
 	opt.RpcNumber = DefaultOption.RpcNumber
+	opt.Version = DefaultOption.Version
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
@@ -198,7 +402,8 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 		}
 	}()
 
-	ch := make(chan clientResult)
+	// 带缓冲：超时返回后 f 仍会结束（conn 已关闭），结果写入缓冲即可退出，协程不会泄露
+	ch := make(chan clientResult, 1)
 	go func() {
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
@@ -209,8 +414,8 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 	}
 	select {
 	case <-time.After(opt.ConnectTimeout):
-		// todo: 监测func未关闭，占用系统资源?
-		return nil, fmt.Errorf("rpc client: connection timeout")
+		// 返回后 defer 关闭 conn，阻塞在握手中的 f 随之返回
+		return nil, fmt.Errorf("rpc client: connection timeout: expect within %s", opt.ConnectTimeout)
 	case result := <-ch:
 		return result.client, result.err
 	}
@@ -237,45 +442,192 @@ func Dial(network, address string, opts ...*Option) (client *Client, err error)
 */
 func (client *Client) receive() {
 	var err error
-	for err == nil {
+	for {
+		if err != nil {
+			// 流、订阅不参与会话恢复与重连
+			client.endStreams(err)
+			client.closeSubs()
+			// 可恢复的会话：重新拨号后继续接收；无法恢复时按 Option.Reconnect 重新建立连接
+			resumed := client.session != "" && client.resume() == nil
+			if !resumed && (!client.option.Reconnect || client.reconnect(err) != nil) {
+				break
+			}
+			err = nil
+		}
 		var header codec.Header
 		if err = client.cc.ReadHeader(&header); err != nil {
-			break
+			continue
+		}
+		// 服务端推送的消息，见 pubsub.go
+		if header.Frame == FramePush {
+			err = client.push(&header)
+			continue
+		}
+		// 流的帧，或不支持流的旧服务端对 FrameOpen 的回复，见 stream.go；同一个 Seq 有多个帧，不做序号检查
+		if s := client.lookupStream(header.Seq); s != nil || header.Frame != FrameUnary {
+			err = client.streamFrame(s, &header)
+			continue
+		}
+		if client.seqMon != nil && header.Seq != 0 {
+			client.seqMon.observe(header.Seq, client.nextSeq())
 		}
 		call := client.removeCall(header.Seq)
+		if call != nil {
+			atomic.StoreInt64(&client.lastReply, time.Now().UnixNano())
+		}
 		switch {
+		case header.Seq == 0 && header.Error == ErrConnQuiescing.Error():
+			// 服务端的排空信号：不再发送新的请求，已发送的请求照常等待回复，之后服务端会关闭连接
+			client.markShutdown()
+			err = client.cc.ReadBody(nil)
+		case header.Seq == 0 && header.Error == errSessionResumed.Error():
+			// 会话恢复完成，旧连接上的回复已全部送达
+			client.failLostCalls()
+			err = client.cc.ReadBody(nil)
 		case call == nil:
 			// 有错误出现，call 已经被清除
 			// cc.ReadBody 调用 gob.Decode，读入 nil，数据会被丢弃
 			err = client.cc.ReadBody(nil)
 		case header.Error != "":
 			// 服务端处理出错
-			call.Error = fmt.Errorf(header.Error)
+			call.Error = serverError(&header)
+			call.Trailer = header.Metadata
+			client.noteLoad(header.Metadata)
+			client.noteBackpressure(header.Metadata)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
 			// 正常处理
+			call.Trailer = header.Metadata
+			client.noteLoad(header.Metadata)
+			client.noteBackpressure(header.Metadata)
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
 				call.Error = errors.New("reading body " + err.Error())
+				if _, tooLarge := err.(*codec.BodyTooLargeError); tooLarge {
+					// 数据流已不可信，之后由 terminateCalls 结束其余的请求
+					call.Error = err
+				}
+				if client.isClosing() {
+					call.Error = ErrShutdown
+				}
+				// 只有这一个响应无法解码，连接仍然可用
+				if _, ok := err.(*codec.BodyError); ok {
+					err = nil
+				}
 			}
 			call.done()
 		}
 	}
+	if client.isClosing() {
+		err = ErrShutdown
+	} else if hbErr := client.heartbeatError(); hbErr != nil {
+		err = hbErr
+	}
+	client.endStreams(err)
+	client.closeSubs()
 	client.terminateCalls(err)
 }
 
+func (client *Client) heartbeatError() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.heartbeatErr
+}
+
+/*
+isClosing
+Close 会关闭 receive 正在读取的连接，此时读取的错误（use of closed network connection 等）没有意义，
+正在读取 body 的请求与 pending 中的请求统一以 ErrShutdown 失败
+*/
+func (client *Client) isClosing() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.closing
+}
+
+/*
+serverError
+将 header.Error 还原为 error，服务端过载的错误还原为 ErrServerBusy，便于调用方使用 errors.Is 判断后重试；
+附带分类（header.ErrorCode）或可以重试（header.ErrorRetryable）的错误还原为 *RPCError
+*/
+func serverError(header *codec.Header) error {
+	switch header.Error {
+	case ErrServerBusy.Error():
+		return ErrServerBusy
+	case ErrConnQuiescing.Error():
+		return ErrConnQuiescing
+	}
+	if header.ErrorCode != CodeUnknown || header.ErrorRetryable {
+		return &RPCError{Code: header.ErrorCode, Message: header.Error, Detail: header.ErrorDetail, Retryable: header.ErrorRetryable}
+	}
+	return errors.New(header.Error)
+}
+
 // -------------- send call -----------------
 func (client *Client) send(call *Call) {
+	client.prepare(call)
+	if !client.acquirePending(call) || !client.pace(call) {
+		call.done()
+		return
+	}
+	// 排队的请求数，见 coalesce.go
+	atomic.AddInt32(&client.queued, 1)
 	client.sending.Lock()
+	atomic.AddInt32(&client.queued, -1)
 	defer client.sending.Unlock()
+	client.writeCoalesced(call)
+}
+
+// prepare 发送之前的准备：记录所属的 Client，调用 Option.OnStart
+func (client *Client) prepare(call *Call) {
+	call.client = client
+	if call.Service != heartbeatService {
+		call.onFinish = client.option.OnFinish
+		if client.option.OnStart != nil {
+			client.option.OnStart(call)
+		}
+	}
+}
 
+/*
+acquirePending
+Option.PendingWait 时等待一个名额，名额在 Call 结束（done）时归还；
+ctx 结束或 client 终止时放弃，设置 call.Error 并返回 false
+*/
+func (client *Client) acquirePending(call *Call) bool {
+	if client.pendingSlots == nil || call.Service == heartbeatService {
+		return true
+	}
+	var ctxDone <-chan struct{}
+	if call.ctx != nil {
+		ctxDone = call.ctx.Done()
+	}
+	select {
+	case client.pendingSlots <- struct{}{}:
+		call.holdsSlot = true
+		return true
+	case <-ctxDone:
+		// 与 invoke 中 ctx 结束时的错误一致
+		call.Error = fmt.Errorf("rpc client: call failed: %w", call.ctx.Err())
+	case <-client.terminated:
+		call.Error = ErrShutdown
+	}
+	return false
+}
+
+/*
+write
+注册并写入一个请求，调用方需持有 sending；失败时结束该请求，
+返回写入连接的错误（不含注册失败），codec.EncodeFailed 之外的写入错误说明连接已不可用
+*/
+func (client *Client) write(call *Call) error {
 	// register
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
 		call.done()
-		return
+		return nil
 	}
 
 	// prepare header
@@ -283,6 +635,16 @@ func (client *Client) send(call *Call) {
 	client.header.Method = call.Method
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Timeout = 0
+	client.header.Metadata = call.Metadata
+	client.header.Frame = FrameUnary
+	if !call.deadline.IsZero() {
+		// 至少保留 1ns，0 表示无限制
+		client.header.Timeout = int64(time.Until(call.deadline))
+		if client.header.Timeout <= 0 {
+			client.header.Timeout = 1
+		}
+	}
 
 	// encode and send the request
 	if err = client.cc.Write(&client.header, call.Args); err != nil {
@@ -293,49 +655,104 @@ func (client *Client) send(call *Call) {
 			call.done()
 		}
 	}
+	return err
 }
 
 // ----------------- Invoke func --------------
 
+/*
+Go
+异步发起请求，结束时 Call 送入 Done
+client 已关闭或已断开（IsAvailable 为 false）时快速失败：不获取 sending、不写入连接，
+返回的 Call 的 Error 已置为 ErrShutdown，并且已经送入 Done
+*/
 func (client *Client) Go(service, method string, args, reply interface{}, done chan *Call) *Call {
+	return client.goCall(newCall(service, method, args, reply, done))
+}
+
+// GoWithMeta 与 Go 相同，请求头携带元数据 md
+func (client *Client) GoWithMeta(service, method string, args, reply interface{}, done chan *Call, md map[string]string) *Call {
+	call := newCall(service, method, args, reply, done)
+	call.Metadata = md
+	return client.goCall(call)
+}
+
+// goCall Go、GoWithMeta 的发送：client 不可用时快速失败，见 Go
+func (client *Client) goCall(call *Call) *Call {
+	if !client.IsAvailable() {
+		client.prepare(call)
+		call.Error = ErrShutdown
+		call.done()
+		return call
+	}
+	client.send(call)
+	return call
+}
+
+func newCall(service, method string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
-		log.Panic("rpc client: done channel is unbuffered")
+		panic("rpc client: done channel is unbuffered")
 	}
-	call := &Call{
+	return &Call{
 		Service: service,
 		Method:  method,
 		Args:    args,
 		Reply:   reply,
 		Done:    done,
 	}
-	client.send(call)
-	return call
 }
 
 /*
 Call
 
 使用context包，超时处理
+ctx 的 deadline 会以剩余时长的形式随请求发送给服务端，服务端以此限制处理时间，
+并传给第一个入参为 context.Context 的方法，方法内的下游调用继续使用该 ctx，deadline 逐跳缩短
+client 已关闭或已断开时直接返回 ErrShutdown，不会写入连接
 */
 func (client *Client) Call(ctx context.Context, service, method string, args, reply interface{}) error {
-	call := client.Go(service, method, args, reply, make(chan *Call, 1))
+	call := newCall(service, method, args, reply, make(chan *Call, 1))
+	call.Metadata = outgoingMetadata(ctx)
+	return client.intercept(client.retry(client.invoke))(ctx, call)
+}
+
+// invoke 拦截器链的最内层，发送请求并等待回复
+func (client *Client) invoke(ctx context.Context, call *Call) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		call.deadline = deadline
+	}
+	call.ctx = ctx
+	client.send(call)
 
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		// 包装 ctx.Err()，调用方可以用 errors.Is 判断 context.DeadlineExceeded、context.Canceled
+		err := fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+		// 已从 pending 中移除的 call 由 receive 等处结束，这里不再重复结束
+		if client.removeCall(call.Seq) != nil {
+			call.Error = err
+			call.done()
+			client.sendCancel(call.Seq)
+		}
+		return err
 	case call := <-call.Done:
+		if md, ok := ctx.Value(trailerTargetKey{}).(*map[string]string); ok && md != nil {
+			*md = call.Trailer
+		}
 		return call.Error
 	}
-	return call.Error
 }
 
-// 客户端发起 HTTP CONNECT 链接
+// 客户端发起 HTTP CONNECT 链接，路径为 Option.RPCPath，默认 DefaultRPCPath
 
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", DefaultRPCPath))
+	path := opt.RPCPath
+	if path == "" {
+		path = DefaultRPCPath
+	}
+	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\r\n\r\n", path))
 
 	// 接受到 HTTP 响应 200 后，交换到 RPC 协议
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
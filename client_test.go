@@ -1,12 +1,22 @@
 package myGoRPC
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"myGoRPC/codec"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -40,6 +50,27 @@ func TestClient_dialTimeout(t *testing.T) {
 		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
 		_assert(err == nil, "0 means no limit")
 	})
+	t.Run("server never reads the option", func(t *testing.T) {
+		silent, _ := net.Listen("tcp", ":0")
+		defer silent.Close()
+		go func() {
+			for {
+				if _, err := silent.Accept(); err != nil {
+					return
+				}
+			}
+		}()
+		start := time.Now()
+		_, err := Dial("tcp", silent.Addr().String(), &Option{ConnectTimeout: time.Millisecond * 100})
+		_assert(err != nil && strings.Contains(err.Error(), "100ms"), "expect a timeout error with the duration, got %v", err)
+		_assert(time.Since(start) < time.Second, "dial should give up after the timeout")
+
+		// 不限制连接时长，只限制握手
+		start = time.Now()
+		_, err = Dial("tcp", silent.Addr().String(), &Option{HandshakeTimeout: time.Millisecond * 100})
+		_assert(err != nil && strings.Contains(err.Error(), "timeout"), "expect a handshake timeout, got %v", err)
+		_assert(time.Since(start) < time.Second, "handshake should give up after the timeout")
+	})
 }
 
 /*
@@ -55,37 +86,80 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
-func startServer(addr chan string) {
-	var b Bar
-	testServer := NewServer()
-	_ = testServer.Register(&b)
+/*
+startServer
+在随机端口上运行 server，返回监听的地址，测试结束时关闭 server（listener 与全部连接）；
+server 为 nil 时使用只注册了 Bar 的 server
+*/
+func startServer(t testing.TB, server *Server) string {
+	t.Helper()
+	if server == nil {
+		server = NewServer()
+		if err := server.Register(new(Bar)); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
 	// pick a free port
-	l, _ := net.Listen("tcp", ":0")
-	addr <- l.Addr().String()
-	testServer.Accept(l)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = server.Close() })
+	return l.Addr().String()
+}
+
+// dial 以 opts 连接 addr，失败时结束测试，测试结束时关闭 client；只能在测试的协程中调用
+func dial(t testing.TB, addr string, opts ...*Option) *Client {
+	t.Helper()
+	client, err := Dial("tcp", addr, opts...)
+	if err != nil {
+		t.Fatalf("dial %s failed: %v", addr, err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
 }
 
 func TestClient_Call(t *testing.T) {
 	t.Parallel()
-	addrCh := make(chan string)
-	go startServer(addrCh)
-	addr := <-addrCh
-	time.Sleep(time.Second)
+	addr := startServer(t, nil)
 	t.Run("client timeout", func(t *testing.T) {
-		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		client := dial(t, addr)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar", "Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
 	})
+	t.Run("client deadline returns promptly", func(t *testing.T) {
+		client := dial(t, addr)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		var reply int
+		start := time.Now()
+		err := client.Call(ctx, "Bar", "Timeout", 1, &reply)
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+		_assert(time.Since(start) < time.Second, "call should return promptly, took %v", time.Since(start))
+		_assert(client.removeCall(1) == nil, "timed out call should be removed from pending")
+	})
 	t.Run("server handle timeout", func(t *testing.T) {
-		client, _ := Dial("tcp", addr, &Option{
+		client := dial(t, addr, &Option{
 			HandleTimeout: time.Second,
 		})
 		var reply int
 		err := client.Call(context.Background(), "Bar", "Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
+	t.Run("max call lifetime", func(t *testing.T) {
+		client := dial(t, addr, &Option{
+			MaxCallLifetime: time.Millisecond * 200,
+		})
+		var reply int
+		call := client.Go("Bar", "Timeout", 1, &reply, nil)
+		<-call.Done
+		_assert(errors.Is(call.Error, ErrCallLifetimeExceeded), "expect lifetime exceeded, got %v", call.Error)
+		_assert(client.removeCall(call.Seq) == nil, "expired call should be removed from pending")
+	})
 }
 
 func TestXDail(t *testing.T) {
@@ -96,7 +170,8 @@ func TestXDail(t *testing.T) {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				return
 			}
 			ch <- struct{}{}
 			testServer := NewServer()
@@ -107,3 +182,1112 @@ func TestXDail(t *testing.T) {
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+func TestSeqMonitor(t *testing.T) {
+	m := newSeqMonitor(4)
+	for _, seq := range []uint64{2, 1, 3, 4} {
+		m.observe(seq, 5)
+	}
+	_assert(m.anomalies == 0, "out-of-order responses are not anomalies, got %d", m.anomalies)
+	m.observe(3, 5)
+	_assert(m.anomalies == 1, "expect a duplicate anomaly")
+	m.observe(9, 10)
+	_assert(m.anomalies == 2, "expect a jump anomaly")
+	m.observe(10, 10)
+	_assert(m.anomalies == 3, "expect an unknown seq anomaly")
+	_assert(len(m.seen) <= 4 && len(m.ring) <= 4, "window must bound memory")
+}
+
+func TestClient_handshakeVersion(t *testing.T) {
+	t.Parallel()
+	var foo Counter
+	server := NewServer()
+	_ = server.Register(&foo)
+	addr := startServer(t, server)
+
+	t.Run("legacy", func(t *testing.T) {
+		client := dial(t, addr, &Option{LegacyHandshake: true})
+		var reply int
+		err := client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+		_assert(err == nil, "call over legacy handshake failed: %v", err)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		conn, _ := net.Dial("tcp", addr)
+		_, _, err := clientHandshake(conn, &Option{RpcNumber: RpcNumber, CodecType: "application/unknown", Version: HandshakeVersion})
+		_assert(errors.Is(err, ErrUnsupportedCodec) && strings.Contains(err.Error(), string(codec.GobType)), "expect a rejection listing supported codecs, got %v", err)
+	})
+	t.Run("json", func(t *testing.T) {
+		// V1 客户端的 JSON 握手，服务端以 JSON 回复
+		client := dial(t, addr, &Option{JSONHandshake: true})
+		var reply int
+		err := client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+		_assert(err == nil, "call over json handshake failed: %v", err)
+		_ = client.Close()
+	})
+	t.Run("mismatch", func(t *testing.T) {
+		// 不是 myGoRPC 的服务端
+		other, _ := net.Listen("tcp", ":0")
+		go func() {
+			conn, err := other.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		}()
+		_, err := Dial("tcp", other.Addr().String())
+		_assert(errors.Is(err, ErrProtocolMismatch), "expect ErrProtocolMismatch, got %v", err)
+
+		var opt Option
+		_, err = readHandshakeFrame(strings.NewReader("GET / HTTP/1.1\r\n"), &opt)
+		_assert(errors.Is(err, ErrProtocolMismatch), "expect the server side to detect a mismatch, got %v", err)
+		var buf bytes.Buffer
+		_ = writeHandshakeFrame(&buf, HandshakeV2, &Option{CodecType: codec.JsonType})
+		version, err := readHandshakeFrame(&buf, &opt)
+		_assert(err == nil && version == HandshakeV2 && opt.CodecType == codec.JsonType, "frame round trip failed: %v", err)
+	})
+}
+
+// Fragile 的 Broken 为 true 时，gob、json 编码都会 panic
+type Fragile struct {
+	Broken bool
+	N      int
+}
+
+func (f Fragile) GobEncode() ([]byte, error) {
+	if f.Broken {
+		panic("broken GobEncode")
+	}
+	return []byte{byte(f.N)}, nil
+}
+
+func (f *Fragile) GobDecode(b []byte) error {
+	f.N = int(b[0])
+	return nil
+}
+
+func (f Fragile) MarshalJSON() ([]byte, error) {
+	if f.Broken {
+		panic("broken MarshalJSON")
+	}
+	return []byte(strconv.Itoa(f.N)), nil
+}
+
+func (f *Fragile) UnmarshalJSON(b []byte) error {
+	n, err := strconv.Atoi(string(b))
+	f.N = n
+	return err
+}
+
+type Echo int
+
+func (e Echo) Fragile(args Fragile, reply *Fragile) error {
+	*reply = args
+	return nil
+}
+
+// 回复的编码 panic
+func (e Echo) Break(args Fragile, reply *Fragile) error {
+	*reply = Fragile{Broken: true}
+	return nil
+}
+
+func TestClient_encodePanic(t *testing.T) {
+	t.Parallel()
+	var e Echo
+	server := NewServer()
+	_ = server.Register(&e)
+	addr := startServer(t, server)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+		var reply Fragile
+		err := client.Call(context.Background(), "Echo", "Fragile", Fragile{Broken: true}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "panic"), "%s: expect an encoding panic error, got %v", typ, err)
+		_assert(client.IsAvailable(), "%s: client should survive an encoding panic", typ)
+
+		err = client.Call(context.Background(), "Echo", "Fragile", Fragile{N: 7}, &reply)
+		_assert(err == nil && reply.N == 7, "%s: call after the panic failed: %v", typ, err)
+
+		err = client.Call(context.Background(), "Echo", "Break", Fragile{N: 1}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "panic"), "%s: expect a reply encoding error, got %v", typ, err)
+		err = client.Call(context.Background(), "Echo", "Fragile", Fragile{N: 8}, &reply)
+		_assert(err == nil && reply.N == 8, "%s: call after the reply panic failed: %v", typ, err)
+		_ = client.Close()
+	}
+}
+
+// 服务端只发送响应的 header，客户端阻塞在 ReadBody 时 Close，正在读取的请求与其他 pending 请求都应以 ErrShutdown 失败
+func TestClient_closeDuringReadBody(t *testing.T) {
+	t.Parallel()
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		var opt Option
+		_ = json.NewDecoder(serverConn).Decode(&opt)
+		cc := codec.NewGobCodec(serverConn)
+		enc := gob.NewEncoder(serverConn)
+		var header codec.Header
+		for i := 0; i < 2; i++ {
+			_ = cc.ReadHeader(&header)
+			_ = cc.ReadBody(nil)
+		}
+		header.Seq = 1
+		_ = enc.Encode(&header)
+	}()
+	client, err := NewClient(clientConn, &Option{CodecType: codec.GobType, LegacyHandshake: true})
+	_assert(err == nil, "new client failed: %v", err)
+	var reply int
+	reading := client.Go("Bar", "Timeout", 1, &reply, nil)
+	pending := client.Go("Bar", "Timeout", 2, &reply, nil)
+	time.Sleep(time.Millisecond * 100)
+	_ = client.Close()
+	<-reading.Done
+	<-pending.Done
+	_assert(reading.Error == ErrShutdown, "expect ErrShutdown for the call being read, got %v", reading.Error)
+	_assert(pending.Error == ErrShutdown, "expect ErrShutdown for pending calls, got %v", pending.Error)
+}
+
+// OnStart、OnFinish 在成功、出错、取消、已关闭各路径上都恰好调用一次
+func TestClient_hooks(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+
+	var mu sync.Mutex
+	started, finished := 0, map[*Call]int{}
+	client := dial(t, addr, &Option{
+		OnStart: func(call *Call) {
+			mu.Lock()
+			defer mu.Unlock()
+			started++
+		},
+		OnFinish: func(call *Call, latency time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			finished[call]++
+		},
+	})
+	var reply int
+	_ = client.Call(context.Background(), "Bar", "Missing", 1, &reply)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := client.Call(ctx, "Bar", "Timeout", 1, &reply)
+	_assert(err != nil, "expect a timeout error")
+	_ = client.Close()
+	_ = client.Call(context.Background(), "Bar", "Timeout", 1, &reply)
+
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(started == 3 && len(finished) == 3, "expect 3 starts and 3 finished calls, got %d and %d", started, len(finished))
+	for call, n := range finished {
+		_assert(n == 1 && call.Error != nil, "expect one finish with an error, got %d, %v", n, call.Error)
+	}
+}
+
+// OnFinish 在释放 Client 的锁之后调用，可以调用 PendingCount、IsAvailable、Stats 等方法
+func TestClient_hooksReentrant(t *testing.T) {
+	t.Parallel()
+	// 完成握手后不回复，收到 drop 时断开连接
+	l, _ := net.Listen("tcp", ":0")
+	drop := make(chan struct{})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = json.NewDecoder(conn).Decode(new(Option))
+				_ = json.NewEncoder(conn).Encode(&handshakeReply{Version: HandshakeV1})
+				go func() { _, _ = io.Copy(io.Discard, conn) }()
+				<-drop
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	var client *Client
+	finished := make(chan error, 2)
+	onFinish := func(call *Call, latency time.Duration) {
+		_ = client.PendingCount()
+		_ = client.IsAvailable()
+		_ = client.Stats()
+		finished <- call.Error
+	}
+	wait := func(what string) error {
+		select {
+		case err := <-finished:
+			return err
+		case <-time.After(time.Second * 2):
+			t.Fatalf("OnFinish deadlocked on %s", what)
+			return nil
+		}
+	}
+	var reply int
+	var err error
+
+	// expireCalls
+	client = dial(t, l.Addr().String(), &Option{JSONHandshake: true, MaxCallLifetime: time.Millisecond * 50, OnFinish: onFinish})
+	client.Go("Bar", "Timeout", 1, &reply, nil)
+	err = wait("an expired call")
+	_assert(err == ErrCallLifetimeExceeded, "expect ErrCallLifetimeExceeded, got %v", err)
+	_ = client.Close()
+
+	// terminateCalls
+	client = dial(t, l.Addr().String(), &Option{JSONHandshake: true, OnFinish: onFinish})
+	client.Go("Bar", "Timeout", 1, &reply, nil)
+	close(drop)
+	_assert(wait("a terminated call") != nil, "expect the call to fail when the connection drops")
+}
+
+// Server 挂载在普通 mux 的自定义路径上，与其他 HTTP handler 共用端口
+func TestDialHTTP_customPath(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	mux := http.NewServeMux()
+	mux.Handle("/_goRPC_", server)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "regular page")
+	})
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = http.Serve(l, mux) }()
+
+	client, err := DialHTTP("tcp", l.Addr().String(), &Option{RPCPath: "/_goRPC_", HandleTimeout: time.Millisecond * 10})
+	_assert(err == nil, "dial http failed: %v", err)
+	var reply int
+	err = client.Call(context.Background(), "Bar", "Timeout", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect the rpc server to answer, got %v", err)
+
+	_, err = DialHTTP("tcp", l.Addr().String())
+	_assert(err != nil && strings.Contains(err.Error(), "200 OK"), "expect the status line in the error, got %v", err)
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+	pool := NewPool(1, 2)
+
+	c1, err := pool.GetClient("tcp", addr)
+	_assert(err == nil, "get client failed: %v", err)
+	_ = pool.Put(c1)
+	reused, _ := pool.GetClient("tcp", addr)
+	_assert(reused == c1, "expect the idle client to be reused")
+	c2, _ := pool.GetClient("tcp", addr)
+	_assert(c2 != nil && c2 != c1, "expect a second client")
+	_, err = pool.GetClient("tcp", addr)
+	_assert(err == ErrPoolExhausted, "expect ErrPoolExhausted, got %v", err)
+
+	// 断开的 Client 不会再被返回
+	_ = c1.Close()
+	_ = pool.Put(c1)
+	_ = pool.Put(c2)
+	c3, _ := pool.GetClient("tcp", addr)
+	_assert(c3 == c2, "expect the healthy client, got a broken or new one")
+	_assert(pool.Put(c3) == nil, "put failed")
+
+	// 重复归还、以及不属于该 Pool 的 Client 不影响计数
+	_assert(pool.Put(c3) == ErrPoolDoublePut, "expect ErrPoolDoublePut")
+	_assert(pool.Put(c1) == ErrNotPooled, "a client closed by the pool should no longer belong to it")
+	foreign := dial(t, addr)
+	_assert(pool.Put(foreign) == ErrNotPooled && foreign.IsAvailable(), "a foreign client should be rejected and left open")
+	_ = foreign.Close()
+	other := NewPool(1, 2)
+	oc, _ := other.GetClient("tcp", addr)
+	_assert(pool.Put(oc) == ErrNotPooled && other.Put(oc) == nil, "a client should only be put back to its own pool")
+	_ = other.Close()
+	pool.mu.Lock()
+	active := pool.active["tcp@"+addr]
+	pool.mu.Unlock()
+	_assert(active == 1, "expect one active client, got %d", active)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if client, err := pool.GetClient("tcp", addr); err == nil {
+				_ = pool.Put(client)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_ = pool.Close()
+	_assert(!c2.IsAvailable(), "pooled clients should be closed")
+	_, err = pool.GetClient("tcp", addr)
+	_assert(err == ErrPoolClosed, "expect ErrPoolClosed, got %v", err)
+}
+
+func TestCall_Cancel(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+	client := dial(t, addr)
+
+	var reply int
+	call := client.Go("Bar", "Timeout", 1, &reply, nil)
+	call.Cancel()
+	<-call.Done
+	_assert(call.Error == ErrCallCancelled, "expect ErrCallCancelled, got %v", call.Error)
+	call.Cancel()
+
+	// 等待服务端的回复到达（Bar.Timeout 耗时 2s），回复应被丢弃，不会再次送入 Done
+	time.Sleep(time.Millisecond * 2200)
+	select {
+	case <-call.Done:
+		t.Fatal("cancelled call was signalled twice")
+	default:
+	}
+	_assert(client.IsAvailable(), "late reply should not break the client")
+}
+
+func TestClient_heartbeat(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+	healthy := dial(t, addr, &Option{HeartbeatInterval: time.Millisecond * 20})
+
+	// 完成握手之后不再回复任何请求的服务端
+	l, _ := net.Listen("tcp", ":0")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				dec := json.NewDecoder(conn)
+				var opt Option
+				_ = dec.Decode(&opt)
+				_ = json.NewEncoder(conn).Encode(&handshakeReply{Version: HandshakeV1})
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	silent := dial(t, l.Addr().String(), &Option{JSONHandshake: true, HeartbeatInterval: time.Millisecond * 50, HeartbeatTimeout: time.Millisecond * 50})
+	var reply int
+	call := silent.Go("Bar", "Timeout", 1, &reply, nil)
+
+	<-call.Done
+	_assert(call.Error == ErrHeartbeatTimeout, "expect ErrHeartbeatTimeout, got %v", call.Error)
+	_assert(!silent.IsAvailable(), "client should be unavailable after a missed heartbeat")
+	_assert(healthy.IsAvailable(), "healthy client should stay available")
+
+	// pending 已满时 ping 仍然发出，不会因为 ErrTooManyPending 立即结束而被当作存活
+	saturated := dial(t, l.Addr().String(), &Option{JSONHandshake: true, MaxPending: 1, HeartbeatInterval: time.Millisecond * 50, HeartbeatTimeout: time.Millisecond * 50})
+	call = saturated.Go("Bar", "Timeout", 1, &reply, nil)
+	select {
+	case <-call.Done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("heartbeat should detect the silent peer while pending is full")
+	}
+	_assert(call.Error == ErrHeartbeatTimeout, "expect ErrHeartbeatTimeout with pending full, got %v", call.Error)
+}
+
+func TestClient_MaxPending(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+	client := dial(t, addr, &Option{MaxPending: 2})
+
+	var reply int
+	first := client.Go("Bar", "Timeout", 1, &reply, nil)
+	second := client.Go("Bar", "Timeout", 1, &reply, nil)
+	_assert(client.PendingCount() == 2, "expect 2 pending calls, got %d", client.PendingCount())
+	third := client.Go("Bar", "Timeout", 1, &reply, nil)
+	<-third.Done
+	_assert(third.Error == ErrTooManyPending, "expect ErrTooManyPending, got %v", third.Error)
+
+	first.Cancel()
+	<-first.Done
+	fourth := client.Go("Bar", "Timeout", 1, &reply, nil)
+	_assert(client.PendingCount() == 2, "a released slot should be reusable, got %d pending", client.PendingCount())
+	fourth.Cancel()
+	second.Cancel()
+	_assert(client.PendingCount() == 0, "expect no pending calls, got %d", client.PendingCount())
+
+	client.mu.Lock()
+	client.seq = ^uint64(0)
+	client.mu.Unlock()
+	wrapped := client.Go("Bar", "Timeout", 1, &reply, nil)
+	_assert(client.nextSeq() == 1, "seq should skip 0 on wraparound, got %d", client.nextSeq())
+	wrapped.Cancel()
+}
+
+func TestClient_PendingWait(t *testing.T) {
+	t.Parallel()
+	addr := startServer(t, nil)
+	client := dial(t, addr, &Option{MaxPending: 1, PendingWait: true})
+
+	var reply int
+	first := client.Go("Bar", "Timeout", 1, &reply, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := client.Call(ctx, "Bar", "Timeout", 1, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect a deadline while waiting for a slot, got %v", err)
+
+	// Go 阻塞到有名额为止
+	started := make(chan *Call)
+	go func() { started <- client.Go("Bar", "Timeout", 1, &reply, nil) }()
+	select {
+	case <-started:
+		t.Fatal("Go should block while the pending limit is reached")
+	case <-time.After(time.Millisecond * 100):
+	}
+	first.Cancel()
+	<-first.Done
+	second := <-started
+	_assert(client.PendingCount() == 1, "the released slot should go to the waiting call, got %d pending", client.PendingCount())
+	second.Cancel()
+}
+
+func TestClient_SetObserver(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var mu sync.Mutex
+	events := map[uint64]CallInfo{}
+	seen := make(chan struct{}, 10)
+	client.SetObserver(func(info CallInfo) {
+		// 慢的观察者不应阻塞 receive
+		time.Sleep(time.Millisecond * 20)
+		mu.Lock()
+		if _, dup := events[info.Seq]; dup {
+			t.Errorf("seq %d observed twice", info.Seq)
+		}
+		events[info.Seq] = info
+		mu.Unlock()
+		seen <- struct{}{}
+	})
+
+	var reply Fragile
+	start := time.Now()
+	_assert(client.Call(context.Background(), "Echo", "Fragile", Fragile{N: 1}, &reply) == nil, "success call failed")
+	_assert(client.Call(context.Background(), "Echo", "Missing", Fragile{N: 1}, &reply) != nil, "expect a server error")
+	_assert(time.Since(start) < time.Millisecond*30, "observer should not delay calls, took %v", time.Since(start))
+	var n int
+	pending := client.Go("Bar", "Timeout", 1, &n, nil)
+	time.Sleep(time.Millisecond * 50)
+	_ = client.Close()
+	<-pending.Done
+
+	for i := 0; i < 3; i++ {
+		<-seen
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(events) == 3, "expect one event per call, got %d", len(events))
+	_assert(events[1].Error == nil && events[1].Method == "Fragile" && !events[1].End.Before(events[1].Start), "unexpected success event %+v", events[1])
+	_assert(events[2].Error != nil, "expect the server error, got %+v", events[2])
+	_assert(events[3].Error == ErrShutdown, "expect the terminated call's error, got %+v", events[3])
+}
+
+// Token 回复请求携带的认证令牌
+func (e Echo) Token(ctx context.Context, args int, reply *string) error {
+	*reply = MetadataFromContext(ctx)[AuthMetadataKey]
+	return nil
+}
+
+func TestClient_Use(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	conn, _ := net.Dial("tcp", addr)
+	var written int64
+	client, err := NewClient(countingConn{Conn: conn, written: &written}, DefaultOption)
+	_assert(err == nil, "new client failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var order []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, call *Call, next Invoker) error {
+			order = append(order, name+">")
+			err := next(ctx, call)
+			order = append(order, "<"+name)
+			return err
+		}
+	}
+	client.Use(trace("a"), AuthInterceptor("secret"))
+	client.Use(trace("b"))
+	var reply string
+	err = client.Call(context.Background(), "Echo", "Token", 1, &reply)
+	_assert(err == nil && reply == "secret", "expect the token from metadata, got %q, %v", reply, err)
+	_assert(strings.Join(order, " ") == "a> b> <b <a", "unexpected interceptor order %v", order)
+
+	denied := errors.New("denied")
+	client.Use(func(ctx context.Context, call *Call, next Invoker) error {
+		return denied
+	})
+	before := written
+	order = nil
+	err = client.Call(context.Background(), "Echo", "Token", 1, &reply)
+	_assert(err == denied, "expect the short-circuit error, got %v", err)
+	_assert(written == before, "short-circuited call should not be sent")
+	_assert(strings.Join(order, " ") == "a> b> <b <a", "unexpected interceptor order %v", order)
+}
+
+// Trace 回复请求携带的 trace-id
+func (e Echo) Trace(ctx context.Context, args int, reply *string) error {
+	*reply = MetadataFromContext(ctx)["trace-id"]
+	return nil
+}
+
+func TestClient_metadata(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+		var reply string
+		ctx := WithMetadata(context.Background(), map[string]string{"trace-id": "t-1"})
+		err := client.Call(ctx, "Echo", "Trace", 1, &reply)
+		_assert(err == nil && reply == "t-1", "%s: expect trace id back, got %q, %v", typ, reply, err)
+
+		call := <-client.GoWithMeta("Echo", "Trace", 1, &reply, nil, map[string]string{"trace-id": "t-2"}).Done
+		_assert(call.Error == nil && reply == "t-2", "%s: GoWithMeta: got %q, %v", typ, reply, call.Error)
+
+		err = client.Call(context.Background(), "Echo", "Trace", 1, &reply)
+		_assert(err == nil && reply == "", "%s: expect no metadata, got %q, %v", typ, reply, err)
+		_ = client.Close()
+	}
+	h, _ := json.Marshal(codec.Header{Service: "Echo"})
+	_assert(!strings.Contains(string(h), "Metadata"), "empty metadata should be omitted: %s", h)
+}
+
+// Sleep 睡眠 ms 毫秒后回复
+func (e Echo) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestClient_CloseGracefully(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+
+	client := dial(t, addr)
+	var r1, r2 int
+	c1 := client.Go("Echo", "Sleep", 100, &r1, nil)
+	c2 := client.Go("Echo", "Sleep", 150, &r2, nil)
+	closed := make(chan error, 1)
+	start := time.Now()
+	go func() { closed <- client.CloseGracefully(context.Background()) }()
+	time.Sleep(time.Millisecond * 20)
+	var n int
+	err := client.Call(context.Background(), "Echo", "Sleep", 1, &n)
+	_assert(err == ErrShutdown, "new calls during drain should fail with ErrShutdown, got %v", err)
+	_assert((<-closed) == nil, "graceful close failed")
+	_assert(time.Since(start) >= time.Millisecond*150, "graceful close returned before in-flight calls finished")
+	_assert((<-c1.Done).Error == nil && r1 == 100, "first call failed: %v", c1.Error)
+	_assert((<-c2.Done).Error == nil && r2 == 150, "second call failed: %v", c2.Error)
+
+	client = dial(t, addr)
+	slow := client.Go("Echo", "Sleep", 1000, &n, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = client.CloseGracefully(ctx)
+	_assert(err != nil && strings.Contains(err.Error(), "abandoned 1") && errors.Is(err, context.DeadlineExceeded), "expect one abandoned call, got %v", err)
+	_assert((<-slow.Done).Error == ErrShutdown, "abandoned call should fail with ErrShutdown, got %v", slow.Error)
+}
+
+// failingConn fail 置为非 0 后写入失败
+type failingConn struct {
+	net.Conn
+	fail *int32
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (c failingConn) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(c.fail) != 0 {
+		return 0, errWriteFailed
+	}
+	return c.Conn.Write(p)
+}
+
+func TestClient_Batch(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	conn, _ := net.Dial("tcp", addr)
+	var fail int32
+	client, err := NewClient(failingConn{Conn: conn, fail: &fail}, DefaultOption)
+	_assert(err == nil, "new client failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	b := client.NewBatch()
+	replies := make([]int, 3)
+	b.Add("Echo", "Sleep", 30, &replies[0])
+	b.Add("Echo", "Missing", 1, &replies[1])
+	b.Add("Echo", "Sleep", 10, &replies[2])
+	errs := b.Do()
+	_assert(len(errs) == 3 && errs[0] == nil && errs[2] == nil, "unexpected batch errors %v", errs)
+	_assert(errs[1] != nil && strings.Contains(errs[1].Error(), "can't find method"), "expect a per-call error, got %v", errs[1])
+	_assert(replies[0] == 30 && replies[2] == 10, "unexpected replies %v", replies)
+
+	atomic.StoreInt32(&fail, 1)
+	b = client.NewBatch()
+	for i := 0; i < 3; i++ {
+		b.Add("Echo", "Sleep", 1, &replies[i])
+	}
+	errs = b.Do()
+	for i, err := range errs {
+		_assert(err == errWriteFailed, "call %d: expect the transport error, got %v", i, err)
+	}
+}
+
+// N 个顺序的 Call 与一个 N 个请求的 Batch
+func BenchmarkBatch(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	client := dial(b, startServer(b, server))
+	const n = 16
+	var reply int
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < n; j++ {
+				_ = client.Call(context.Background(), "Echo", "Sleep", 0, &reply)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		replies := make([]int, n)
+		for i := 0; i < b.N; i++ {
+			batch := client.NewBatch()
+			for j := 0; j < n; j++ {
+				batch.Add("Echo", "Sleep", 0, &replies[j])
+			}
+			batch.Do()
+		}
+	})
+}
+
+func TestClient_goAfterShutdown(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	l, _ := net.Listen("tcp", ":0")
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+		server.ServeConn(conn)
+	}()
+	conn, _ := net.Dial("tcp", l.Addr().String())
+	var written int64
+	client, err := NewClient(countingConn{Conn: conn, written: &written}, DefaultOption)
+	_assert(err == nil, "new client failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 服务端断开连接，receive 结束全部请求
+	_ = (<-accepted).Close()
+	for i := 0; i < 100 && client.IsAvailable(); i++ {
+		time.Sleep(time.Millisecond * 5)
+	}
+	_assert(!client.IsAvailable(), "client should be shut down")
+
+	before := written
+	var reply int
+	call := client.Go("Echo", "Sleep", 1, &reply, nil)
+	select {
+	case <-call.Done:
+	default:
+		t.Fatal("Done should already be signaled")
+	}
+	_assert(call.Error == ErrShutdown, "expect ErrShutdown from Go, got %v", call.Error)
+	call = client.GoWithMeta("Echo", "Sleep", 1, &reply, nil, map[string]string{"k": "v"})
+	select {
+	case <-call.Done:
+	default:
+		t.Fatal("Done should already be signaled by GoWithMeta")
+	}
+	_assert(call.Error == ErrShutdown, "expect ErrShutdown from GoWithMeta, got %v", call.Error)
+	err = client.Call(context.Background(), "Echo", "Sleep", 1, &reply)
+	_assert(err == ErrShutdown, "expect ErrShutdown from Call, got %v", err)
+	_assert(written == before, "no bytes should be written after shutdown")
+}
+
+var errFlaky = errors.New("flaky")
+
+// Flaky 前 Fails 次调用返回 errFlaky
+type Flaky struct {
+	Fails int32
+	calls int32
+}
+
+func (f *Flaky) Get(args int, reply *int) error {
+	if n := atomic.AddInt32(&f.calls, 1); n <= f.Fails {
+		return errFlaky
+	}
+	*reply = args
+	return nil
+}
+
+func (f *Flaky) Put(args int, reply *int) error {
+	return f.Get(args, reply)
+}
+
+func TestClient_Retry(t *testing.T) {
+	t.Parallel()
+	flaky := &Flaky{Fails: 2}
+	server := NewServer()
+	_ = server.Register(flaky)
+	addr := startServer(t, server)
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Retryable:   func(err error) bool { return err.Error() == errFlaky.Error() },
+		Idempotent:  []string{"Flaky.Get"},
+	}
+	client := dial(t, addr, &Option{Retry: policy})
+	intercepted := 0
+	client.Use(func(ctx context.Context, call *Call, next Invoker) error {
+		intercepted++
+		return next(ctx, call)
+	})
+
+	reset := func() { atomic.StoreInt32(&flaky.calls, 0) }
+	var reply int
+	err := client.Call(context.Background(), "Flaky", "Get", 7, &reply)
+	_assert(err == nil && reply == 7, "idempotent call should succeed on the third attempt, got %v", err)
+	_assert(atomic.LoadInt32(&flaky.calls) == 3 && intercepted == 1, "expect 3 attempts through 1 interceptor run, got %d, %d", flaky.calls, intercepted)
+
+	reset()
+	err = client.Call(context.Background(), "Flaky", "Put", 7, &reply)
+	_assert(err != nil && atomic.LoadInt32(&flaky.calls) == 1, "non-idempotent call should not be retried, got %d attempts", flaky.calls)
+
+	reset()
+	err = client.Call(WithIdempotent(context.Background()), "Flaky", "Put", 7, &reply)
+	_assert(err == nil && atomic.LoadInt32(&flaky.calls) == 3, "WithIdempotent should allow retries, got %v", err)
+
+	reset()
+	err = client.Call(WithRetryPolicy(context.Background(), nil), "Flaky", "Get", 7, &reply)
+	_assert(err != nil && atomic.LoadInt32(&flaky.calls) == 1, "a nil per-call policy should disable retries")
+
+	_assert(DefaultRetryable(ErrServerBusy) && DefaultRetryable(&ReconnectError{Err: io.EOF}), "connection-level errors are retryable")
+	_assert(!DefaultRetryable(errFlaky) && !DefaultRetryable(&RPCError{Code: CodeHandler}), "handler errors are not retryable")
+}
+
+// Tenant 回复请求携带的 tenant，并以 trailer 返回；args 为负数时返回错误
+func (e Echo) Tenant(ctx context.Context, args int, reply *string) error {
+	tenant := MetadataFromContext(ctx)["tenant"]
+	_ = SetTrailer(ctx, map[string]string{"served-for": tenant})
+	_ = SetTrailer(ctx, map[string]string{"cost": strconv.Itoa(args)})
+	if args < 0 {
+		return errors.New("negative cost")
+	}
+	*reply = tenant
+	return nil
+}
+
+func TestClient_trailer(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+
+		var trailer map[string]string
+		var reply string
+		ctx := WithTrailer(WithMetadata(context.Background(), map[string]string{"tenant": "acme"}), &trailer)
+		err := client.Call(ctx, "Echo", "Tenant", 3, &reply)
+		_assert(err == nil && reply == "acme", "%s: call failed: %q, %v", typ, reply, err)
+		_assert(trailer["served-for"] == "acme" && trailer["cost"] == "3", "%s: unexpected trailer %v", typ, trailer)
+
+		call := <-client.Go("Echo", "Tenant", -1, &reply, nil).Done
+		_assert(call.Error != nil && call.Trailer["cost"] == "-1", "%s: error replies should carry the trailer, got %v", typ, call.Trailer)
+		call = <-client.Go("Echo", "Sleep", 1, new(int), nil).Done
+		_assert(call.Error == nil && call.Trailer == nil, "%s: request metadata should not be echoed, got %v", typ, call.Trailer)
+		_ = client.Close()
+	}
+	_assert(SetTrailer(context.Background(), map[string]string{"k": "v"}) != nil, "SetTrailer outside a request should fail")
+}
+
+func TestInvoke(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(new(Blob))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	n, err := Invoke[int, int](context.Background(), client, "Counter", "Incr", 2)
+	_assert(err == nil && n == 2, "Invoke with a value reply failed: %d, %v", n, err)
+	p, err := Invoke[int, *int](context.Background(), client, "Counter", "Incr", 3)
+	_assert(err == nil && p != nil && *p == 5, "Invoke with a pointer reply failed: %v, %v", p, err)
+	items, err := Invoke[[]BlobItem, []BlobItem](context.Background(), client, "Blob", "Echo", []BlobItem{{Name: "a"}})
+	_assert(err == nil && len(items) == 1 && items[0].Name == "a", "Invoke with a slice reply failed: %v, %v", items, err)
+	p, err = Invoke[int, *int](context.Background(), client, "Counter", "Missing", 1)
+	_assert(p == nil && Code(err) == CodeNotFound, "expect a zero reply and the call error, got %v, %v", p, err)
+}
+
+func TestNewInProcClient(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(&Tail{})
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client, err := NewInProcClient(server, &Option{CodecType: typ})
+		_assert(err == nil, "%s: in-process client failed: %v", typ, err)
+		var n int
+		err = client.Call(context.Background(), "Counter", "Incr", 1, &n)
+		_assert(err == nil && n > 0, "%s: in-process call failed: %v", typ, err)
+		stream, err := client.Stream(context.Background(), "Tail", "Double")
+		_assert(err == nil && stream.Send(21) == nil && stream.Recv(&n) == nil && n == 42, "%s: in-process stream failed: %v", typ, err)
+		_ = stream.Close()
+		_ = client.Close()
+	}
+
+	// 服务端关闭连接后 Reconnect 建立新的内存连接
+	idle := NewServer()
+	idle.IdleTimeout = 50 * time.Millisecond
+	_ = idle.Register(&Counter{})
+	client, err := NewInProcClient(idle, &Option{Reconnect: true, ReconnectBackoff: 10 * time.Millisecond})
+	_assert(err == nil, "in-process client failed: %v", err)
+	defer func() { _ = client.Close() }()
+	time.Sleep(200 * time.Millisecond)
+	var n int
+	for i := 0; i < 50; i++ {
+		if err = client.Call(context.Background(), "Counter", "Incr", 1, &n); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(err == nil, "call after reconnect failed: %v", err)
+}
+
+func TestDialPool(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+
+	_, err := DialPool("tcp", addr, 0)
+	_assert(err != nil, "a pool of size 0 should be rejected")
+	pool, err := DialPool("tcp", addr, 3)
+	_assert(err == nil, "DialPool failed: %v", err)
+	_assert(len(server.Connections()) == 3, "expect 3 connections, got %d", len(server.Connections()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int
+			_assert(pool.Call(context.Background(), "Echo", "Sleep", 0, &n) == nil, "pooled call failed")
+		}()
+	}
+	wg.Wait()
+	inUse := 0
+	for _, client := range pool.members {
+		if client.Stats().Methods["Echo.Sleep"].Calls > 0 {
+			inUse++
+		}
+	}
+	_assert(inUse == 3, "calls should be spread over all connections, %d used", inUse)
+
+	// 断开的连接在后台替换，其间调用使用其余的连接
+	broken := pool.members[0]
+	_ = broken.Close()
+	var n int
+	call := <-pool.Go("Echo", "Sleep", 0, &n, nil).Done
+	_assert(call.Error == nil, "call with a broken member failed: %v", call.Error)
+	replaced := false
+	for i := 0; i < 100 && !replaced; i++ {
+		pool.mu.Lock()
+		replaced = pool.members[0] != broken && pool.members[0].IsAvailable()
+		pool.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(replaced, "the broken connection should be replaced")
+
+	_ = pool.Close()
+	err = pool.Call(context.Background(), "Echo", "Sleep", 0, &n)
+	_assert(errors.Is(err, ErrShutdown), "calls after Close should fail with ErrShutdown, got %v", err)
+}
+
+// SharedHeartbeat：繁忙的连接不发送心跳，空闲的连接各自 ping，无响应的连接逐个被发现
+func TestDialPool_sharedHeartbeat(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	opt := &Option{SharedHeartbeat: true, HeartbeatInterval: time.Millisecond * 40}
+	pool, err := DialPool("tcp", addr, 4, opt)
+	_assert(err == nil, "DialPool failed: %v", err)
+	defer func() { _ = pool.Close() }()
+	pings := func() (total uint64, idle int) {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		for _, client := range pool.members {
+			n := client.Stats().Heartbeats
+			total += n
+			if n > 0 {
+				idle++
+			}
+		}
+		return
+	}
+
+	for deadline := time.Now().Add(time.Millisecond * 300); time.Now().Before(deadline); {
+		var n int
+		_assert(pool.Call(context.Background(), "Echo", "Sleep", 0, &n) == nil, "pooled call failed")
+	}
+	busy, _ := pings()
+	_assert(busy <= 4, "busy connections should not ping, got %d pings", busy)
+	time.Sleep(time.Millisecond * 300)
+	total, pinged := pings()
+	_assert(total > busy && pinged == 4, "every idle connection should ping, %d of 4 pinged", pinged)
+
+	// 完成握手后不再回复的服务端：每个连接各自因心跳超时关闭
+	silent, _ := net.Listen("tcp", ":0")
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = json.NewDecoder(conn).Decode(new(Option))
+				_ = json.NewEncoder(conn).Encode(&handshakeReply{Version: HandshakeV1})
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	defer func() { _ = silent.Close() }()
+	dead, err := DialPool("tcp", silent.Addr().String(), 3, &Option{JSONHandshake: true, SharedHeartbeat: true, HeartbeatInterval: time.Millisecond * 30})
+	_assert(err == nil, "DialPool failed: %v", err)
+	defer func() { _ = dead.Close() }()
+	members := append([]*Client(nil), dead.members...)
+	var n int
+	call := members[0].Go("Echo", "Sleep", 0, &n, nil)
+	for _, client := range members {
+		for i := 0; i < 100 && client.IsAvailable(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(!client.IsAvailable(), "each unresponsive connection should be detected")
+	}
+	<-call.Done
+	_assert(call.Error == ErrHeartbeatTimeout, "expect ErrHeartbeatTimeout, got %v", call.Error)
+}
+
+func TestClient_Notify(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client := dial(t, addr, &Option{CodecType: typ, Serial: true})
+		for i := 0; i < 3; i++ {
+			_assert(client.Notify("Counter", "Incr", 1) == nil, "%s: notify failed", typ)
+		}
+		_assert(client.Notify("Counter", "Missing", 1) == nil && client.Notify("Counter", "Incr", "not a number") == nil,
+			"%s: notify of a bad request should still be written", typ)
+		client.mu.Lock()
+		pending := len(client.pending)
+		client.mu.Unlock()
+		_assert(pending == 0, "%s: notifications should not be pending, got %d", typ, pending)
+
+		var n int
+		err := client.Call(context.Background(), "Counter", "Incr", 0, &n)
+		_assert(err == nil && n%3 == 0 && n > 0, "%s: notifications should have run, got %d, %v", typ, n, err)
+		_ = client.Close()
+		_assert(client.Notify("Counter", "Incr", 1) == ErrShutdown, "%s: expect ErrShutdown after close", typ)
+	}
+	_assert(server.Stats().Methods["Counter.Incr"].Calls == 8, "expect 8 calls, got %+v", server.Stats().Methods["Counter.Incr"])
+}
+
+// writeCounter 统计对连接的 Write 调用次数，每次 Write 先等待 delay
+type writeCounter struct {
+	net.Conn
+	writes *int64
+	delay  time.Duration
+}
+
+func (c writeCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+// 写入较慢时，排队的请求合并写入连接，写入次数少于请求数，且全部正常回复
+func TestClient_writeCoalescing(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	conn, _ := net.Dial("tcp", addr)
+	var writes int64
+	client, err := NewClient(writeCounter{Conn: conn, writes: &writes, delay: time.Millisecond}, &Option{
+		RpcNumber: RpcNumber, CodecType: codec.GobType, Version: HandshakeVersion,
+	})
+	_assert(err == nil, "new client failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	const n = 200
+	atomic.StoreInt64(&writes, 0)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			var reply int
+			if err := client.Call(context.Background(), "Echo", "Sleep", 0, &reply); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("call failed: %v", err)
+	}
+	got := atomic.LoadInt64(&writes)
+	_assert(got > 0 && got < n, "expect queued calls to share writes, got %d writes for %d calls", got, n)
+}
+
+// 并发调用的吞吐，writes/op 为每个请求对连接的 Write 次数
+func BenchmarkClient_parallel(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	conn, err := net.Dial("tcp", startServer(b, server))
+	if err != nil {
+		b.Fatal(err)
+	}
+	var writes int64
+	client, err := NewClient(writeCounter{Conn: conn, writes: &writes}, &Option{
+		RpcNumber: RpcNumber, CodecType: codec.GobType, Version: HandshakeVersion,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	b.ReportAllocs()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	atomic.StoreInt64(&writes, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		var reply int
+		for pb.Next() {
+			if err := client.Call(context.Background(), "Echo", "Sleep", 0, &reply); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N), "writes/op")
+}
@@ -0,0 +1,76 @@
+package myGoRPC
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+)
+
+/*
+合并写入
+
+每个请求由 codec 编码后立即刷新，高并发时每个请求都是一次系统调用。client.send 在获取 sending 之前登记排队，
+持有 sending 时仍有其他请求在排队，就把这一次的请求留在连接的缓冲区中，由排在最后的请求一起写入连接：
+ 1. 缓冲位于统计字节数的 byteCounter 与连接之间，压缩、分块、按方法的字节统计都不受影响
+ 2. 只有 send 会推迟写入；Notify、流、心跳等其他写入照常立即写入，同时带上缓冲区中已有的内容
+ 3. 推迟的写入失败时（连接已断开）关闭连接，尚未回复的请求随 receive 结束而失败
+*/
+
+const coalesceBufferSize = 32 << 10
+
+// writeCoalescer hold 为 true 时 Write 写入缓冲区，否则连同缓冲区中的内容一起写入连接；调用方需持有 client.sending
+type writeCoalescer struct {
+	io.ReadWriteCloser
+	buf  *bufio.Writer
+	hold bool
+}
+
+func newWriteCoalescer(rwc io.ReadWriteCloser) *writeCoalescer {
+	return &writeCoalescer{ReadWriteCloser: rwc, buf: bufio.NewWriterSize(rwc, coalesceBufferSize)}
+}
+
+func (w *writeCoalescer) Write(p []byte) (int, error) {
+	if !w.hold && w.buf.Buffered() == 0 {
+		return w.ReadWriteCloser.Write(p)
+	}
+	n, err := w.buf.Write(p)
+	if err == nil && !w.hold {
+		err = w.buf.Flush()
+	}
+	return n, err
+}
+
+func (w *writeCoalescer) flush() error {
+	return w.buf.Flush()
+}
+
+// coalescer 当前连接的合并写入缓冲，不统计字节数的连接（见 newClientCodec）为 nil；调用方需持有 sending
+func (client *Client) coalescer() *writeCoalescer {
+	if c, ok := client.cc.(*statsCodec); ok {
+		w, _ := c.conn.ReadWriteCloser.(*writeCoalescer)
+		return w
+	}
+	return nil
+}
+
+/*
+writeCoalesced
+与 write 相同，仍有请求在 sending 上排队时推迟写入连接，见 client.send；调用方需持有 sending
+*/
+func (client *Client) writeCoalesced(call *Call) {
+	w := client.coalescer()
+	if w == nil {
+		_ = client.write(call)
+		return
+	}
+	w.hold = atomic.LoadInt32(&client.queued) > 0
+	_ = client.write(call)
+	if w.hold {
+		w.hold = false
+		return
+	}
+	if err := w.flush(); err != nil {
+		client.logger().Log(LevelWarn, "rpc client: write error", F("err", err))
+		_ = client.cc.Close()
+	}
+}
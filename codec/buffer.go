@@ -0,0 +1,29 @@
+package codec
+
+import (
+	"bytes"
+	"sync"
+)
+
+/*
+缓冲区的复用
+
+编码用的临时缓冲区从 bufferPool 获取，Write 结束后归还，空闲的连接不长期占用内存；
+超过 maxPooledBuffer 的缓冲区（大消息）直接丢弃，避免池中积累大块内存
+*/
+
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
@@ -1,6 +1,11 @@
 package codec
 
-import "io"
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
 
 /*
 Header
@@ -12,6 +17,18 @@ type Header struct {
 	Method  string // 方法名
 	Seq     uint64 // 请求序列号
 	Error   string // 错误信息
+	Timeout int64  // 调用方剩余的时间预算（纳秒），0 即为无限制；传递剩余时长而不是截止时刻，避免两端时钟偏差
+	// Error 的分类，0 即为未分类，见 myGoRPC.RPCError；旧版本的对端会忽略该字段
+	ErrorCode int
+	// 错误的附加信息（如 JSON 编码的结构化内容），见 myGoRPC.RPCError.Detail
+	ErrorDetail string `json:",omitempty"`
+	// 错误是否可以重试，见 myGoRPC.RPCError.Retryable；旧版本的对端会忽略该字段
+	ErrorRetryable bool `json:",omitempty"`
+	// 请求携带的元数据（见 myGoRPC.WithMetadata），或回复携带的 trailer（见 myGoRPC.SetTrailer）；
+	// 为空时 gob、JSON 都不编码该字段，旧版本的对端会忽略该字段
+	Metadata map[string]string `json:",omitempty"`
+	// 流式调用的帧类型，0 即为普通的请求/回复，见 myGoRPC.Stream；旧版本的对端会忽略该字段
+	Frame int `json:",omitempty"`
 }
 
 /*
@@ -33,6 +50,102 @@ type Codec interface {
 	Write(header *Header, body interface{}) error
 }
 
+/*
+PanicError
+Write 编码 body 时发生 panic（如自定义的 MarshalJSON、GobEncode 出错），
+此时这一次的 header、body 都没有写入连接，连接仍然可用，只有这一次的请求/响应失败
+*/
+type PanicError struct {
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("codec: panic while encoding body: %v", e.Value)
+}
+
+/*
+EncodeError
+Write 无法编码 body（如 body 的类型不被该编解码方式支持），与 PanicError 相同，
+这一次的 header、body 都没有写入连接，连接仍然可用，只有这一次的请求/响应失败
+*/
+type EncodeError struct {
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return "codec: cannot encode body: " + e.Err.Error()
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeFailed err 为 *PanicError 或 *EncodeError 时返回 true，即 Write 失败但连接仍然可用
+func EncodeFailed(err error) bool {
+	switch err.(type) {
+	case *PanicError, *EncodeError:
+		return true
+	}
+	return false
+}
+
+/*
+BodyError
+ReadBody 解码失败（格式错误、类型不匹配等），但这一个 body 已被完整读取，数据流的位置仍然正确，
+只有这一次的请求/响应失败，连接可以继续使用；其他错误（EOF、帧损坏）说明数据流已无法继续解析
+*/
+type BodyError struct {
+	Err error
+}
+
+func (e *BodyError) Error() string {
+	return "codec: malformed body: " + e.Err.Error()
+}
+
+func (e *BodyError) Unwrap() error {
+	return e.Err
+}
+
+/*
+BodyTooLargeError
+ReadBody 读取的 body 超过 SetMaxBodySize 设置的上限，此时不会为其分配内存；
+body 没有被完整读取，数据流的位置已不可信，连接不能继续使用
+Write 编码的 body 超过 SetMaxWriteSize 设置的上限时，包装在 *EncodeError 中返回，此时连接仍然可用
+*/
+type BodyTooLargeError struct {
+	Limit int
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("codec: body exceeds the limit of %d bytes", e.Limit)
+}
+
+/*
+BodyLimiter
+可选接口，限制 ReadBody 读取的单个 body 的字节数，超过时返回 *BodyTooLargeError；0 即为不限制
+需要在第一次读取之前调用；内置的 gob、JSON、raw codec 都实现了该接口
+*/
+type BodyLimiter interface {
+	SetMaxBodySize(max int)
+}
+
+/*
+WriteLimiter
+可选接口，限制 Write 写出的单个 body 编码后的字节数，0 即为不限制；超过时返回包装了 *BodyTooLargeError 的 *EncodeError，
+这一次的 header、body 都不写入连接，连接仍然可用；内置的 codec 都实现了该接口
+*/
+type WriteLimiter interface {
+	SetMaxWriteSize(max int)
+}
+
+// checkWriteSize 编码后的 body 超过 max 时返回 Write 应返回的错误
+func checkWriteSize(size, max int) error {
+	if max > 0 && size > max {
+		return &EncodeError{Err: &BodyTooLargeError{Limit: max}}
+	}
+	return nil
+}
+
 /*
 NewCodecFunc
 
@@ -43,18 +156,85 @@ type NewCodecFunc func(closer io.ReadWriteCloser) Codec
 
 /*
 Type
-定义 Codec 类型，GobType, JsonType(未实现)
+定义 Codec 类型，GobType, JsonType, RawType, ProtobufType, MsgpackType
  */
 type Type string
 
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	JsonType Type = "application/json"
+	RawType  Type = "application/raw"
+
+	// ProtobufType body 使用 protobuf 编码，见 ProtobufCodec
+	ProtobufType Type = "application/protobuf"
+	// MsgpackType header、body 都使用 MessagePack 编码，见 MsgpackCodec
+	MsgpackType Type = "application/msgpack"
 )
 
-var NewCodecFuncMap map[Type]NewCodecFunc
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[Type]NewCodecFunc)
+)
+
+/*
+NewCodecFuncMap
+Deprecated: 使用 Register、Get。Register 注册的编解码方式同样写入该 map，
+Get 在 registry 中找不到时查找该 map，因此旧代码直接写入的编解码方式仍然可用；
+该 map 没有加锁，与旧版本相同，只应在 init 中读写
+*/
+var NewCodecFuncMap = make(map[Type]NewCodecFunc)
 
 func init() {
-	NewCodecFuncMap = make(map[Type]NewCodecFunc)
-	NewCodecFuncMap[GobType] = NewGobCodec
+	Register(GobType, NewGobCodec)
+	Register(JsonType, NewJsonCodec)
+	Register(RawType, NewRawCodec)
+	Register(ProtobufType, NewProtobufCodec)
+	Register(MsgpackType, NewMsgpackCodec)
+}
+
+/*
+Register
+注册编解码方式，客户端、服务端通过 Get 按 Option.CodecType 查找；可以在任意时刻并发调用
+name 已被注册或 fn 为 nil 时 panic（与 database/sql.Register 相同），需要不同配置的内置编解码方式时，注册为新的 Type：
+
+	codec.Register("application/json+indent", codec.NewJsonCodecFunc(codec.JsonOptions{Indent: "  "}))
+*/
+func Register(name Type, fn NewCodecFunc) {
+	if fn == nil {
+		panic("codec: Register codec " + string(name) + " is nil")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("codec: Register called twice for codec " + string(name))
+	}
+	registry[name] = fn
+	NewCodecFuncMap[name] = fn
+}
+
+// Get 返回 name 的构造函数，没有注册时返回 nil
+func Get(name Type) NewCodecFunc {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if fn, ok := registry[name]; ok {
+		return fn
+	}
+	return NewCodecFuncMap[name]
+}
+
+// Types 返回已注册的编解码方式，按名称排序
+func Types() []Type {
+	registryMu.RLock()
+	types := make([]Type, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	for t := range NewCodecFuncMap {
+		if _, ok := registry[t]; !ok {
+			types = append(types, t)
+		}
+	}
+	registryMu.RUnlock()
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
 }
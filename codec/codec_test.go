@@ -0,0 +1,157 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type limitArgs struct {
+	Name string
+	Vals []int
+}
+
+// 上限以内的 body 正常解码，超过上限的 body 返回 *BodyTooLargeError
+func TestCodec_MaxBodySize(t *testing.T) {
+	for _, typ := range []Type{GobType, JsonType, RawType, MsgpackType} {
+		f := Get(typ)
+		conn := new(bufferConn)
+		w, r := f(conn), f(conn)
+		r.(BodyLimiter).SetMaxBodySize(1024)
+
+		small := limitArgs{Name: "small", Vals: []int{1, 2, 3}}
+		for seq := uint64(1); seq <= 2; seq++ {
+			if err := w.Write(&Header{Service: "Foo", Seq: seq}, small); err != nil {
+				t.Fatalf("%s: write: %v", typ, err)
+			}
+		}
+		if err := w.Write(&Header{Service: "Foo", Seq: 3}, limitArgs{Name: strings.Repeat("x", 8192)}); err != nil {
+			t.Fatalf("%s: write: %v", typ, err)
+		}
+
+		var h Header
+		for seq := uint64(1); seq <= 2; seq++ {
+			var got limitArgs
+			if err := r.ReadHeader(&h); err != nil || h.Seq != seq {
+				t.Fatalf("%s: read header %d: %v %+v", typ, seq, err, h)
+			}
+			if err := r.ReadBody(&got); err != nil || got.Name != "small" || len(got.Vals) != 3 {
+				t.Fatalf("%s: read body %d: %v %+v", typ, seq, err, got)
+			}
+		}
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 3 {
+			t.Fatalf("%s: read header 3: %v %+v", typ, err, h)
+		}
+		var big limitArgs
+		err := r.ReadBody(&big)
+		if e, ok := err.(*BodyTooLargeError); !ok || e.Limit != 1024 {
+			t.Fatalf("%s: expect *BodyTooLargeError, got %v", typ, err)
+		}
+	}
+}
+
+// 超过写入上限的 body 不写入，连接仍然可用；gob 的第一条消息超限时，之后同类型的消息仍能解码
+func TestCodec_MaxWriteSize(t *testing.T) {
+	for _, typ := range []Type{GobType, JsonType, RawType, MsgpackType} {
+		f := Get(typ)
+		conn := new(bufferConn)
+		w, r := f(conn), f(conn)
+		w.(WriteLimiter).SetMaxWriteSize(1024)
+
+		err := w.Write(&Header{Service: "Foo", Seq: 1}, limitArgs{Name: strings.Repeat("x", 8192)})
+		var tooLarge *BodyTooLargeError
+		if !EncodeFailed(err) || !errors.As(err, &tooLarge) || tooLarge.Limit != 1024 {
+			t.Fatalf("%s: expect a *BodyTooLargeError encode failure, got %v", typ, err)
+		}
+		if err := w.Write(&Header{Service: "Foo", Seq: 2}, limitArgs{Name: "small"}); err != nil {
+			t.Fatalf("%s: write after the oversized body: %v", typ, err)
+		}
+		var h Header
+		var got limitArgs
+		if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+			t.Fatalf("%s: expect only seq 2, got %v %+v", typ, err, h)
+		}
+		if err := r.ReadBody(&got); err != nil || got.Name != "small" {
+			t.Fatalf("%s: read body: %v %+v", typ, err, got)
+		}
+	}
+}
+
+// registered 为每次运行的 TestRegister 生成不同的名称，-count 大于 1 时不会重复注册
+var registered int64
+
+func TestRegister(t *testing.T) {
+	name := Type(fmt.Sprintf("application/test+gob-%d", atomic.AddInt64(&registered, 1)))
+	Register(name, NewGobCodec)
+	if Get(name) == nil || Get("application/unknown") != nil {
+		t.Fatal("Get should find exactly the registered codecs")
+	}
+	found := false
+	for _, typ := range Types() {
+		found = found || typ == name
+	}
+	if !found {
+		t.Fatalf("Types() misses %s: %v", name, Types())
+	}
+
+	for _, fn := range []NewCodecFunc{NewJsonCodec, nil} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expect Register to panic")
+				}
+			}()
+			if fn == nil {
+				Register("application/test+nil", fn)
+			} else {
+				Register(name, fn)
+			}
+		}()
+	}
+}
+
+// 旧代码直接读写 NewCodecFuncMap
+func TestNewCodecFuncMap(t *testing.T) {
+	if NewCodecFuncMap[GobType] == nil || NewCodecFuncMap[MsgpackType] == nil {
+		t.Fatalf("built-in codecs should be visible in NewCodecFuncMap: %v", NewCodecFuncMap)
+	}
+	name := Type(fmt.Sprintf("application/test+legacy-%d", atomic.AddInt64(&registered, 1)))
+	NewCodecFuncMap[name] = NewJsonCodec
+	if Get(name) == nil {
+		t.Fatal("Get should find codecs written to NewCodecFuncMap")
+	}
+	found := false
+	for _, typ := range Types() {
+		found = found || typ == name
+	}
+	if !found {
+		t.Fatalf("Types() misses %s: %v", name, Types())
+	}
+}
+
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+// 每次 Write 的分配，编码用的缓冲区在 Write 之间复用
+func BenchmarkCodec_Write(b *testing.B) {
+	body := limitArgs{Name: "bench", Vals: []int{1, 2, 3, 4, 5, 6, 7, 8}}
+	for _, typ := range []Type{GobType, JsonType, RawType, MsgpackType} {
+		b.Run(string(typ), func(b *testing.B) {
+			c := Get(typ)(discardConn{})
+			h := &Header{Service: "Foo", Method: "Bar"}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.Seq = uint64(i + 1)
+				if err := c.Write(h, body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,9 +2,11 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"io"
-	"log"
+	"strings"
 )
 
 type GobCodec struct {
@@ -12,6 +14,19 @@ type GobCodec struct {
 	buf  *bufio.Writer      // 防止阻塞的带缓冲 Writer
 	dec  *gob.Decoder
 	enc  *gob.Encoder
+	w    *switchWriter // enc 的输出，Write 时在 head、body 之间切换
+
+	// 设置了 body 上限时，dec 改为从 in 读取：先从 r 按 gob 的长度前缀逐条读取完整的消息，检查长度后再交给 dec
+	maxBody int
+	r       *bufio.Reader
+	in      bytes.Buffer
+
+	maxWrite int // 见 WriteLimiter
+}
+
+// switchWriter 转发到当前的 Writer，使同一个 gob.Encoder 可以把 header、body 分别编码到不同的缓冲区
+type switchWriter struct {
+	io.Writer
 }
 
 /*
@@ -23,11 +38,13 @@ var _ Codec = (*GobCodec)(nil)
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	// 使用 buffer 来优化写入效率, 先写入到 buffer 中, 再调用 buffer.Flush() 来将 buffer 中的全部内容写入到 conn 中
 	buf := bufio.NewWriter(conn)
+	w := &switchWriter{Writer: buf}
 	return &GobCodec{
 		conn: conn,
 		buf:  buf,
 		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
+		enc:  gob.NewEncoder(w),
+		w:    w,
 	}
 }
 
@@ -35,30 +52,175 @@ func (g *GobCodec) Close() error {
 	return g.conn.Close()
 }
 
+// SetMaxBodySize 见 BodyLimiter，需要在第一次读取之前调用
+func (g *GobCodec) SetMaxBodySize(max int) {
+	if max <= 0 {
+		return
+	}
+	g.maxBody = max
+	g.r = bufio.NewReader(g.conn)
+	g.dec = gob.NewDecoder(&g.in)
+}
+
+// SetMaxWriteSize 见 WriteLimiter，body 的字节数包含其中的类型定义消息
+func (g *GobCodec) SetMaxWriteSize(max int) {
+	g.maxWrite = max
+}
+
 func (g *GobCodec) ReadHeader(header *Header) error {
+	if g.r != nil {
+		if err := g.fill(0); err != nil {
+			return err
+		}
+	}
 	return g.dec.Decode(header)
 }
 
+/*
+fill
+从 r 读取一个值对应的全部 gob 消息（若干类型定义消息，以及最后的值消息）写入 in；
+limit 大于 0 时，消息的总长度超过 limit 即返回 *BodyTooLargeError，超出的消息不会被读取
+*/
+func (g *GobCodec) fill(limit int) error {
+	var total uint64
+	for {
+		var prefix [9]byte
+		n, width, err := readGobUint(g.r, prefix[:])
+		if err != nil {
+			return err
+		}
+		total += n
+		if limit > 0 && (n > uint64(limit) || total > uint64(limit)) {
+			return &BodyTooLargeError{Limit: limit}
+		}
+		start := g.in.Len() + width
+		g.in.Write(prefix[:width])
+		if _, err = io.CopyN(&g.in, g.r, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		// 消息以类型编号开头，类型定义消息的编号为负数
+		id, _, err := readGobUint(bytes.NewReader(g.in.Bytes()[start:]), prefix[:])
+		if err != nil {
+			return err
+		}
+		if id&1 == 0 {
+			return nil
+		}
+	}
+}
+
+// readGobUint 读取 gob 编码的无符号整数，原始字节写入 buf，返回数值与字节数
+func readGobUint(r io.Reader, buf []byte) (uint64, int, error) {
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, 0, err
+	}
+	b := buf[0]
+	if b <= 0x7f {
+		return uint64(b), 1, nil
+	}
+	width := -int(int8(b))
+	if width > 8 {
+		return 0, 0, errors.New("gob: invalid uint length")
+	}
+	if _, err := io.ReadFull(r, buf[1:1+width]); err != nil {
+		return 0, 0, err
+	}
+	var x uint64
+	for _, c := range buf[1 : 1+width] {
+		x = x<<8 | uint64(c)
+	}
+	return x, 1 + width, nil
+}
+
+/*
+ReadBody
+gob 的消息带长度前缀，Decoder 总是先读取完整的消息再解码，
+因此解码阶段的错误（均以 "gob: " 开头）不影响数据流的位置，返回 *BodyError
+*/
 func (g *GobCodec) ReadBody(body interface{}) error {
-	return g.dec.Decode(body)
+	if g.r != nil {
+		if err := g.fill(g.maxBody); err != nil {
+			return err
+		}
+	}
+	err := g.dec.Decode(body)
+	if err != nil && strings.HasPrefix(err.Error(), "gob: ") {
+		return &BodyError{Err: err}
+	}
+	return err
 }
 
+/*
+Write
+先把 body 编码到 payload，再把 header 编码到 head，都成功后按 header、body 的顺序写入连接；两者都取自 bufferPool
+
+body 先编码：自定义的 GobEncode 等发生 panic 时 header 尚未编码，只需放弃这一次的请求/响应，返回 *PanicError，连接仍然可用；
+payload 中此时可能已有完整的类型描述消息，encoder 认为对端已经知道这些类型，因此仍需发送，对端解码时会直接吸收。
+body 超过 SetMaxWriteSize 的上限时同理，只发送其中的类型描述消息
+*/
 func (g *GobCodec) Write(header *Header, body interface{}) (err error) {
 	defer func() {
-		// 一次写入
-		_ = g.buf.Flush()
-		if err != nil {
+		// 一次写入；写入连接失败（Flush 的错误）同样说明连接已不可用
+		if ferr := g.buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err != nil && !EncodeFailed(err) {
 			_ = g.Close()
 		}
 	}()
-	// 如果 header body 写入错误，返回
-	if err := g.enc.Encode(header); err != nil {
-		log.Println("rpc codec.gob error encoding header:", err)
+	head, payload := getBuffer(), getBuffer()
+	defer func() { putBuffer(head); putBuffer(payload) }()
+	if err = g.encode(payload, body); err == nil {
+		if err = checkWriteSize(payload.Len(), g.maxWrite); err != nil {
+			_, _ = g.buf.Write(gobTypeMessages(payload.Bytes()))
+			return err
+		}
+	}
+	if err != nil {
+		if _, isPanic := err.(*PanicError); isPanic {
+			_, _ = g.buf.Write(payload.Bytes())
+		}
+		return err
+	}
+	if err = g.encode(head, header); err != nil {
 		return err
 	}
-	if err := g.enc.Encode(body); err != nil {
-		log.Println("rpc codec.gob error encoding body:", err)
+	if _, err = g.buf.Write(head.Bytes()); err != nil {
 		return err
 	}
-	return nil
+	_, err = g.buf.Write(payload.Bytes())
+	return err
+}
+
+// gobTypeMessages b 中值消息之前的类型定义消息
+func gobTypeMessages(b []byte) []byte {
+	var prefix [9]byte
+	r := bytes.NewReader(b)
+	for {
+		start := len(b) - r.Len()
+		n, _, err := readGobUint(r, prefix[:])
+		if err != nil || n > uint64(r.Len()) {
+			return b[:start]
+		}
+		msg := len(b) - r.Len()
+		id, _, err := readGobUint(bytes.NewReader(b[msg:msg+int(n)]), prefix[:])
+		if err != nil || id&1 == 0 {
+			return b[:start]
+		}
+		_, _ = r.Seek(int64(n), io.SeekCurrent)
+	}
+}
+
+func (g *GobCodec) encode(w *bytes.Buffer, v interface{}) (err error) {
+	g.w.Writer = w
+	defer func() {
+		g.w.Writer = g.buf
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	return g.enc.Encode(v)
 }
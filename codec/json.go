@@ -0,0 +1,214 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+/*
+JsonEncoder, JsonDecoder, JsonAPI
+抽象出 JSON 的编解码实现，默认使用标准库 encoding/json，
+也可以替换为更快的实现（如 jsoniter），只需要写一个返回其 Encoder/Decoder 的适配器
+*/
+type JsonEncoder interface {
+	Encode(v interface{}) error
+}
+
+type JsonDecoder interface {
+	Decode(v interface{}) error
+}
+
+type JsonAPI interface {
+	NewEncoder(w io.Writer) JsonEncoder
+	NewDecoder(r io.Reader) JsonDecoder
+}
+
+/*
+JsonOptions
+JSON codec 的可选配置，零值即为标准库的默认行为
+
+DisableHTMLEscape: 不转义 <、>、& 等字符
+Indent: 非空时缩进输出，便于调试时直接阅读报文
+API: 替换 JSON 实现，此时 DisableHTMLEscape、Indent 由 API 自行决定
+*/
+type JsonOptions struct {
+	DisableHTMLEscape bool
+	Indent            string
+	API               JsonAPI
+}
+
+type stdJsonAPI struct {
+	opts JsonOptions
+}
+
+func (s stdJsonAPI) NewEncoder(w io.Writer) JsonEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!s.opts.DisableHTMLEscape)
+	if s.opts.Indent != "" {
+		enc.SetIndent("", s.opts.Indent)
+	}
+	return enc
+}
+
+func (s stdJsonAPI) NewDecoder(r io.Reader) JsonDecoder {
+	return json.NewDecoder(r)
+}
+
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	api  JsonAPI
+	dec  JsonDecoder
+	enc  JsonEncoder   // 输出到 w，header、body 都编码成功后才写入 buf
+	w    *switchWriter // Write 期间指向从 bufferPool 取得的缓冲区
+	in   *capReader    // dec 的输入，ReadBody 期间限制读取的字节数
+
+	maxWrite int // 见 WriteLimiter
+}
+
+/*
+capReader
+统计从连接读取的字节数，limit 不小于 0 时最多读到第 limit 个字节，之后返回 *BodyTooLargeError
+*/
+type capReader struct {
+	r       io.Reader
+	n       int64
+	limit   int64
+	maxBody int
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.limit >= 0 {
+		room := c.limit - c.n
+		if room <= 0 {
+			return 0, &BodyTooLargeError{Limit: c.maxBody}
+		}
+		if int64(len(p)) > room {
+			p = p[:room]
+		}
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+// NewJsonCodec 使用标准库默认行为的 JSON codec，注册为 JsonType
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	return NewJsonCodecFunc(JsonOptions{})(conn)
+}
+
+/*
+NewJsonCodecFunc
+根据 opts 返回 NewCodecFunc，通过 Register 注册为新的 Type，客户端选择该 Type 即可让两端使用这些配置：
+
+	codec.Register("application/json+indent", codec.NewJsonCodecFunc(codec.JsonOptions{Indent: "  "}))
+*/
+func NewJsonCodecFunc(opts JsonOptions) NewCodecFunc {
+	api := opts.API
+	if api == nil {
+		api = stdJsonAPI{opts: opts}
+	}
+	return func(conn io.ReadWriteCloser) Codec {
+		j := &JsonCodec{
+			conn: conn,
+			buf:  bufio.NewWriter(conn),
+			api:  api,
+			w:    &switchWriter{},
+			in:   &capReader{r: conn, limit: -1},
+		}
+		j.dec = api.NewDecoder(j.in)
+		j.enc = api.NewEncoder(j.w)
+		return j
+	}
+}
+
+func (j *JsonCodec) Close() error {
+	return j.conn.Close()
+}
+
+func (j *JsonCodec) ReadHeader(header *Header) error {
+	return j.dec.Decode(header)
+}
+
+/*
+ReadBody
+body 为 nil 时丢弃该值
+先完整读取一个 JSON 值，再解码到 body：语法错误、EOF 说明数据流已无法继续解析，原样返回；
+解码到 body 的错误（类型不匹配等）不影响数据流的位置，返回 *BodyError
+*/
+func (j *JsonCodec) ReadBody(body interface{}) error {
+	var raw json.RawMessage
+	if j.in.maxBody > 0 {
+		j.in.limit = j.in.n + int64(j.in.maxBody)
+	}
+	err := j.dec.Decode(&raw)
+	j.in.limit = -1
+	if err != nil || body == nil {
+		return err
+	}
+	if err := j.api.NewDecoder(bytes.NewReader(raw)).Decode(body); err != nil {
+		return &BodyError{Err: err}
+	}
+	return nil
+}
+
+/*
+SetMaxBodySize
+见 BodyLimiter；Decoder 会预读，上限按读取 body 期间从连接新读取的字节数计算，已预读的字节不计入
+*/
+func (j *JsonCodec) SetMaxBodySize(max int) {
+	j.in.maxBody = max
+}
+
+// SetMaxWriteSize 见 WriteLimiter
+func (j *JsonCodec) SetMaxWriteSize(max int) {
+	j.maxWrite = max
+}
+
+/*
+Write
+header、body 先编码到从 bufferPool 取得的缓冲区，都成功后才写入连接；JSON 的编码没有跨消息的状态，
+编码 body 时发生 panic 直接丢弃缓冲区的内容即可，返回 *PanicError，连接仍然可用
+*/
+func (j *JsonCodec) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		// 写入连接失败（Flush 的错误）同样说明连接已不可用
+		if ferr := j.buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err != nil && !EncodeFailed(err) {
+			_ = j.Close()
+		}
+	}()
+	wbuf := getBuffer()
+	j.w.Writer = wbuf
+	defer func() {
+		j.w.Writer = nil
+		putBuffer(wbuf)
+	}()
+	if err = j.enc.Encode(header); err != nil {
+		return err
+	}
+	head := wbuf.Len()
+	if err = j.encodeBody(body); err == nil {
+		err = checkWriteSize(wbuf.Len()-head, j.maxWrite)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = j.buf.Write(wbuf.Bytes())
+	return err
+}
+
+func (j *JsonCodec) encodeBody(body interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	return j.enc.Encode(body)
+}
@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+func TestJsonCodec_Options(t *testing.T) {
+	conn := new(bufferConn)
+	cc := NewJsonCodecFunc(JsonOptions{DisableHTMLEscape: true, Indent: "  "})(conn)
+	if err := cc.Write(&Header{Service: "Foo", Method: "Sum", Seq: 1}, "<a&b>"); err != nil {
+		t.Fatal(err)
+	}
+	out := conn.String()
+	if !strings.Contains(out, "\"<a&b>\"") {
+		t.Fatalf("html should not be escaped: %s", out)
+	}
+	if !strings.Contains(out, "\n  \"Service\"") {
+		t.Fatalf("output should be indented: %s", out)
+	}
+
+	var h Header
+	var body string
+	if err := cc.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("read header: %v %+v", err, h)
+	}
+	if err := cc.ReadBody(&body); err != nil || body != "<a&b>" {
+		t.Fatalf("read body: %v %q", err, body)
+	}
+}
+
+func TestJsonCodec_DefaultEscapesHTML(t *testing.T) {
+	conn := new(bufferConn)
+	cc := NewJsonCodec(conn)
+	_ = cc.Write(&Header{Seq: 1}, "<a>")
+	if strings.Contains(conn.String(), "<a>") {
+		t.Fatalf("standard library default should escape html: %s", conn.String())
+	}
+	var h Header
+	_ = cc.ReadHeader(&h)
+	if err := cc.ReadBody(nil); err != nil {
+		t.Fatalf("nil body should be discarded: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+)
+
+/*
+MsgpackCodec
+header、body 都使用 MessagePack 编码，值本身带有长度信息，不需要额外的分帧；
+header 编码为以字段名为键的 map，省略零值字段，其他语言的客户端按字段名读写即可
+
+读取时先完整读取一个值，再解码：类型不匹配等错误不影响数据流的位置，返回 *BodyError；
+编解码规则见 msgpack_value.go
+*/
+type MsgpackCodec struct {
+	conn     io.ReadWriteCloser
+	r        *bufio.Reader
+	buf      *bufio.Writer
+	maxBody  int
+	maxWrite int    // 见 WriteLimiter
+	wbuf     []byte // 编码用的缓冲区，Write 之间复用
+}
+
+var _ Codec = (*MsgpackCodec)(nil)
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	return &MsgpackCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+	}
+}
+
+func (c *MsgpackCodec) Close() error {
+	return c.conn.Close()
+}
+
+// SetMaxBodySize 见 BodyLimiter，读取的字节数超过上限时不再读取
+func (c *MsgpackCodec) SetMaxBodySize(max int) {
+	c.maxBody = max
+}
+
+// SetMaxWriteSize 见 WriteLimiter
+func (c *MsgpackCodec) SetMaxWriteSize(max int) {
+	c.maxWrite = max
+}
+
+func (c *MsgpackCodec) ReadHeader(header *Header) error {
+	raw, err := readMsgpackValue(c.r, 0)
+	if err != nil {
+		return err
+	}
+	*header = Header{}
+	return unmarshalMsgpack(raw, header)
+}
+
+// ReadBody body 为 nil 时丢弃该值
+func (c *MsgpackCodec) ReadBody(body interface{}) error {
+	raw, err := readMsgpackValue(c.r, c.maxBody)
+	if err != nil || body == nil {
+		return err
+	}
+	if err := unmarshalMsgpack(raw, body); err != nil {
+		return &BodyError{Err: err}
+	}
+	return nil
+}
+
+/*
+Write
+header、body 都编码成功后才写入连接；body 的类型不支持时返回 *EncodeError，panic 时返回 *PanicError，都不关闭连接
+body、header 依次编码到复用的 wbuf 中，超过 maxPooledBuffer 的缓冲区不保留
+*/
+func (c *MsgpackCodec) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		if ferr := c.buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err != nil && !EncodeFailed(err) {
+			_ = c.Close()
+		}
+	}()
+	b, err := appendMsgpack(c.wbuf[:0], body)
+	if err == nil {
+		err = checkWriteSize(len(b), c.maxWrite)
+	}
+	if err != nil {
+		if !EncodeFailed(err) {
+			err = &EncodeError{Err: err}
+		}
+		return err
+	}
+	n := len(b)
+	if b = appendMsgpackHeader(b, header); cap(b) <= maxPooledBuffer {
+		c.wbuf = b
+	}
+	if _, err = c.buf.Write(b[n:]); err != nil {
+		return err
+	}
+	_, err = c.buf.Write(b[:n])
+	return err
+}
+
+// appendMsgpackHeader 将 h 编码后追加到 b，只写入非零值的字段
+func appendMsgpackHeader(b []byte, h *Header) []byte {
+	e := msgpackEncoder{b: b}
+	n := 0
+	for _, set := range []bool{h.Service != "", h.Method != "", h.Seq != 0, h.Error != "", h.Timeout != 0, h.ErrorCode != 0, len(h.Metadata) > 0, h.Frame != 0, h.ErrorDetail != "", h.ErrorRetryable} {
+		if set {
+			n++
+		}
+	}
+	e.encodeMapLen(n)
+	writeString := func(key, v string) {
+		if v != "" {
+			e.encodeString(key)
+			e.encodeString(v)
+		}
+	}
+	writeString("Service", h.Service)
+	writeString("Method", h.Method)
+	if h.Seq != 0 {
+		e.encodeString("Seq")
+		e.encodeUint(h.Seq)
+	}
+	writeString("Error", h.Error)
+	if h.Timeout != 0 {
+		e.encodeString("Timeout")
+		e.encodeInt(h.Timeout)
+	}
+	if h.ErrorCode != 0 {
+		e.encodeString("ErrorCode")
+		e.encodeInt(int64(h.ErrorCode))
+	}
+	if len(h.Metadata) > 0 {
+		e.encodeString("Metadata")
+		e.encodeMapLen(len(h.Metadata))
+		for k, v := range h.Metadata {
+			e.encodeString(k)
+			e.encodeString(v)
+		}
+	}
+	if h.Frame != 0 {
+		e.encodeString("Frame")
+		e.encodeInt(int64(h.Frame))
+	}
+	writeString("ErrorDetail", h.ErrorDetail)
+	if h.ErrorRetryable {
+		e.encodeString("ErrorRetryable")
+		e.writeByte(0xc3)
+	}
+	return e.b
+}
+
+/*
+readMsgpackValue
+从 r 中读取一个完整的 MessagePack 值的原始字节，不解码；
+limit 大于 0 时，读取的字节数将超过 limit 即返回 *BodyTooLargeError，在读取 str、bin 的内容之前检查，不会为其分配内存
+*/
+func readMsgpackValue(r *bufio.Reader, limit int) ([]byte, error) {
+	var out []byte
+	read := func(n uint64) error {
+		if limit > 0 && uint64(len(out))+n > uint64(limit) {
+			return &BodyTooLargeError{Limit: limit}
+		}
+		start := len(out)
+		out = append(out, make([]byte, n)...)
+		_, err := io.ReadFull(r, out[start:])
+		if err == io.EOF && start > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	readUint := func(n int) (uint64, error) {
+		start := len(out)
+		if err := read(uint64(n)); err != nil {
+			return 0, err
+		}
+		var v uint64
+		for _, c := range out[start:] {
+			v = v<<8 | uint64(c)
+		}
+		return v, nil
+	}
+	// pending 尚未读取的值的个数，array、map 的元素依次加入
+	for pending := uint64(1); pending > 0; pending-- {
+		if err := read(1); err != nil {
+			return nil, err
+		}
+		c := out[len(out)-1]
+		var err error
+		var size, children uint64
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c&0xf0 == 0x80:
+			children = 2 * uint64(c&0x0f)
+		case c&0xf0 == 0x90:
+			children = uint64(c & 0x0f)
+		case c&0xe0 == 0xa0:
+			size = uint64(c & 0x1f)
+		case c >= 0xc4 && c <= 0xc6:
+			size, err = readUint(1 << (c - 0xc4))
+		case c >= 0xc7 && c <= 0xc9:
+			if size, err = readUint(1 << (c - 0xc7)); err == nil {
+				size++ // 扩展类型的 type 字节
+			}
+		case c == 0xca:
+			size = 4
+		case c == 0xcb:
+			size = 8
+		case c >= 0xcc && c <= 0xcf:
+			size = 1 << (c - 0xcc)
+		case c >= 0xd0 && c <= 0xd3:
+			size = 1 << (c - 0xd0)
+		case c >= 0xd4 && c <= 0xd8:
+			size = 1 + 1<<(c-0xd4)
+		case c >= 0xd9 && c <= 0xdb:
+			size, err = readUint(1 << (c - 0xd9))
+		case c == 0xdc || c == 0xdd:
+			children, err = readUint(2 << (c - 0xdc))
+		case c == 0xde || c == 0xdf:
+			children, err = readUint(2 << (c - 0xde))
+			children *= 2
+		default:
+			// 0xc1 未使用，数据流已无法继续解析
+			return nil, errMalformedMsgpack
+		}
+		if err == nil && size > 0 {
+			err = read(size)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pending += children
+	}
+	return out, nil
+}
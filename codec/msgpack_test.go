@@ -0,0 +1,111 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type msgpackArgs struct {
+	Name  string
+	Small int8
+	Neg   int64
+	Big   uint64
+	Ratio float64
+	Tags  []string
+	Attrs map[string]int
+	When  time.Time
+	Raw   []byte
+	Next  *msgpackArgs
+	Skip  string `msgpack:"-"`
+	Alias int    `msgpack:"a,omitempty"`
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	conn := new(bufferConn)
+	w, r := NewMsgpackCodec(conn), NewMsgpackCodec(conn)
+
+	in := msgpackArgs{
+		Name: "m", Small: -3, Neg: -1 << 40, Big: 1<<64 - 1, Ratio: 0.5,
+		Tags: []string{"a", "b"}, Attrs: map[string]int{"x": 300},
+		When: time.Unix(1700000000, 123).UTC(), Raw: []byte{0, 1},
+		Next: &msgpackArgs{Name: "child"}, Skip: "dropped", Alias: 7,
+	}
+	header := &Header{Service: "Foo", Method: "Bar", Seq: 1, Timeout: int64(time.Second), Metadata: map[string]string{"k": "v"}, Frame: 2}
+	if err := w.Write(header, in); err != nil {
+		t.Fatal(err)
+	}
+	var h Header
+	var out msgpackArgs
+	if err := r.ReadHeader(&h); err != nil || !reflect.DeepEqual(&h, header) {
+		t.Fatalf("read header: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skip = ""
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("expect %+v, got %+v", in, out)
+	}
+
+	// 解码到 interface{}、nil body
+	_ = w.Write(&Header{Seq: 2}, []interface{}{1, "s", nil, true})
+	_ = w.Write(&Header{Seq: 3}, nil)
+	var v interface{}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 || h.Metadata != nil {
+		t.Fatalf("read header 2: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&v); err != nil || !reflect.DeepEqual(v, []interface{}{int64(1), "s", nil, true}) {
+		t.Fatalf("interface body: %v %#v", err, v)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("read header 3: %v %+v", err, h)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 类型不匹配只影响这一次的 body，之后的请求继续解析
+func TestMsgpackCodec_typeMismatch(t *testing.T) {
+	conn := new(bufferConn)
+	w, r := NewMsgpackCodec(conn), NewMsgpackCodec(conn)
+	_ = w.Write(&Header{Seq: 1}, "not a number")
+	_ = w.Write(&Header{Seq: 2}, 42)
+
+	var h Header
+	var n int
+	_ = r.ReadHeader(&h)
+	if _, ok := r.ReadBody(&n).(*BodyError); !ok {
+		t.Fatal("expect a *BodyError")
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("read header 2: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&n); err != nil || n != 42 {
+		t.Fatalf("read body 2: %v %d", err, n)
+	}
+
+	// 不支持的类型不写入任何数据
+	before := conn.Len()
+	if err := w.Write(&Header{Seq: 3}, make(chan int)); !EncodeFailed(err) {
+		t.Fatalf("expect an encode failure, got %v", err)
+	}
+	if conn.Len() != before {
+		t.Fatal("failed write left bytes on the stream")
+	}
+}
+
+func TestReadMsgpackValue(t *testing.T) {
+	b, _ := marshalMsgpack(map[string]interface{}{"a": []interface{}{1, "x", map[string]int{"b": 2}}})
+	stream := append(append([]byte{}, b...), 0xc3)
+	c := NewMsgpackCodec(&bufferConn{Buffer: *bytes.NewBuffer(stream)}).(*MsgpackCodec)
+	got, err := readMsgpackValue(c.r, 0)
+	if err != nil || !bytes.Equal(got, b) {
+		t.Fatalf("expect % x, got % x, %v", b, got, err)
+	}
+	if got, err := readMsgpackValue(c.r, 0); err != nil || !bytes.Equal(got, []byte{0xc3}) {
+		t.Fatalf("expect c3, got % x, %v", got, err)
+	}
+}
@@ -0,0 +1,766 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+MessagePack 的编解码，只依赖 reflect：
+
+  - 整数编码为能容纳其值的最短格式，浮点数按 float32/float64 编码
+  - string 编码为 str，[]byte 编码为 bin，slice、array 编码为 array
+  - map 编码为 map；struct 编码为以字段名为键的 map，字段名可以用 `msgpack:"name"` 修改，
+    `msgpack:"-"` 跳过该字段，`msgpack:",omitempty"` 在零值时省略
+  - time.Time 编码为 timestamp 扩展类型（-1）
+  - nil 指针、nil slice、nil map、nil interface 编码为 nil
+
+解码时 struct 的键先精确匹配字段名，再忽略大小写匹配，未知的键跳过；解码到 interface{} 时
+map 为 map[string]interface{}（键不是字符串时为 map[interface{}]interface{}），array 为 []interface{}，
+整数为 int64（超出 int64 的正数为 uint64）
+*/
+
+const msgpackTimestamp = -1
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type msgpackEncoder struct {
+	b []byte
+}
+
+func (e *msgpackEncoder) writeByte(c byte) {
+	e.b = append(e.b, c)
+}
+
+func (e *msgpackEncoder) write16(c byte, v uint16) {
+	e.b = append(e.b, c, byte(v>>8), byte(v))
+}
+
+func (e *msgpackEncoder) write32(c byte, v uint32) {
+	e.b = append(e.b, c)
+	e.append32(v)
+}
+
+func (e *msgpackEncoder) write64(c byte, v uint64) {
+	e.b = append(e.b, c)
+	e.append64(v)
+}
+
+func (e *msgpackEncoder) append32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *msgpackEncoder) append64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *msgpackEncoder) encodeNil() {
+	e.writeByte(0xc0)
+}
+
+func (e *msgpackEncoder) encodeInt(v int64) {
+	switch {
+	case v >= 0:
+		e.encodeUint(uint64(v))
+	case v >= -32:
+		e.writeByte(byte(v))
+	case v >= math.MinInt8:
+		e.b = append(e.b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.write16(0xd1, uint16(v))
+	case v >= math.MinInt32:
+		e.write32(0xd2, uint32(v))
+	default:
+		e.write64(0xd3, uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.writeByte(byte(v))
+	case v <= math.MaxUint8:
+		e.b = append(e.b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.write16(0xcd, uint16(v))
+	case v <= math.MaxUint32:
+		e.write32(0xce, uint32(v))
+	default:
+		e.write64(0xcf, v)
+	}
+}
+
+// encodeLen 写入 str、bin、array、map 的长度；fix 为 fix 格式的前缀，0 表示没有 fix 格式，fixMax 为其最大长度
+func (e *msgpackEncoder) encodeLen(n int, fix byte, fixMax int, c8, c16, c32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.writeByte(fix | byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		e.b = append(e.b, c8, byte(n))
+	case n <= math.MaxUint16:
+		e.write16(c16, uint16(n))
+	default:
+		e.write32(c32, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	e.encodeLen(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	e.b = append(e.b, s...)
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) {
+	e.encodeLen(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+	e.b = append(e.b, b...)
+}
+
+func (e *msgpackEncoder) encodeArrayLen(n int) {
+	e.encodeLen(n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func (e *msgpackEncoder) encodeMapLen(n int) {
+	e.encodeLen(n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// encodeTime timestamp 96：ext8，长度 12，类型 -1，纳秒（uint32）+ 秒（int64）
+func (e *msgpackEncoder) encodeTime(t time.Time) {
+	e.b = append(e.b, 0xc7, 12, byte(msgpackTimestamp&0xff))
+	e.append32(uint32(t.Nanosecond()))
+	e.append64(uint64(t.Unix()))
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.encodeNil()
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeByte(0xc3)
+		} else {
+			e.writeByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.write32(0xca, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.write64(0xcb, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.encodeNil()
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.encodeNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.encodeNil()
+			return nil
+		}
+		e.encodeMapLen(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayLen(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := cachedFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+	e.encodeMapLen(n)
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		e.encodeString(f.name)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type msgpackField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []msgpackField
+
+// cachedFields struct 中参与编解码的导出字段
+func cachedFields(t reflect.Type) []msgpackField {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := msgpackField{name: sf.Name, index: i}
+		if tag, ok := sf.Tag.Lookup("msgpack"); ok {
+			if tag == "-" {
+				continue
+			}
+			name, opts := tag, ""
+			if comma := strings.IndexByte(tag, ','); comma >= 0 {
+				name, opts = tag[:comma], tag[comma+1:]
+			}
+			if name != "" {
+				f.name = name
+			}
+			f.omitEmpty = opts == "omitempty"
+		}
+		fields = append(fields, f)
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func marshalMsgpack(body interface{}) ([]byte, error) {
+	return appendMsgpack(nil, body)
+}
+
+// appendMsgpack 将 body 编码后追加到 b
+func appendMsgpack(b []byte, body interface{}) (_ []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	e := msgpackEncoder{b: b}
+	if err := e.encode(reflect.ValueOf(body)); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+// ---------------------------------------------------------------------------
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+var errMalformedMsgpack = errors.New("msgpack: malformed data")
+
+type msgpackDecoder struct {
+	b []byte
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b) < n {
+		return nil, errMsgpackShort
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p, nil
+}
+
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	p, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range p {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// msgpackKind 一个值的类别，由首字节决定
+type msgpackKind int
+
+const (
+	kindNil msgpackKind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindStr
+	kindBin
+	kindArray
+	kindMap
+	kindExt
+)
+
+// msgpackItem 读取的值：标量直接给出，str、bin 给出内容，array、map 给出元素个数，之后依次读取
+type msgpackItem struct {
+	kind    msgpackKind
+	b       bool
+	i       int64
+	u       uint64
+	f       float64
+	data    []byte
+	n       int
+	extType int8
+}
+
+// readItem 读取一个值的首部；长度首部的解析与 RawMessage 的读取（msgpackReader）一致
+func (d *msgpackDecoder) readItem() (msgpackItem, error) {
+	var it msgpackItem
+	p, err := d.next(1)
+	if err != nil {
+		return it, err
+	}
+	c := p[0]
+	readLen := func(n int) (int, error) {
+		v, err := d.readUint(n)
+		return int(v), err
+	}
+	readData := func(n int, err error) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		return d.next(n)
+	}
+	switch {
+	case c <= 0x7f:
+		it.kind, it.i = kindInt, int64(c)
+	case c >= 0xe0:
+		it.kind, it.i = kindInt, int64(int8(c))
+	case c&0xf0 == 0x80:
+		it.kind, it.n = kindMap, int(c&0x0f)
+	case c&0xf0 == 0x90:
+		it.kind, it.n = kindArray, int(c&0x0f)
+	case c&0xe0 == 0xa0:
+		it.kind = kindStr
+		it.data, err = d.next(int(c & 0x1f))
+	default:
+		switch c {
+		case 0xc0:
+			it.kind = kindNil
+		case 0xc2, 0xc3:
+			it.kind, it.b = kindBool, c == 0xc3
+		case 0xc4, 0xc5, 0xc6:
+			it.kind = kindBin
+			it.data, err = readData(readLen(1 << (c - 0xc4)))
+		case 0xc7, 0xc8, 0xc9:
+			var n int
+			if n, err = readLen(1 << (c - 0xc7)); err == nil {
+				it, err = d.readExt(n)
+			}
+		case 0xca:
+			var v uint64
+			v, err = d.readUint(4)
+			it.kind, it.f = kindFloat, float64(math.Float32frombits(uint32(v)))
+		case 0xcb:
+			var v uint64
+			v, err = d.readUint(8)
+			it.kind, it.f = kindFloat, math.Float64frombits(v)
+		case 0xcc, 0xcd, 0xce, 0xcf:
+			it.kind = kindUint
+			it.u, err = d.readUint(1 << (c - 0xcc))
+		case 0xd0, 0xd1, 0xd2, 0xd3:
+			var v uint64
+			n := 1 << (c - 0xd0)
+			v, err = d.readUint(n)
+			// 符号扩展
+			shift := uint(64 - 8*n)
+			it.kind, it.i = kindInt, int64(v<<shift)>>shift
+		case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+			it, err = d.readExt(1 << (c - 0xd4))
+		case 0xd9, 0xda, 0xdb:
+			it.kind = kindStr
+			it.data, err = readData(readLen(1 << (c - 0xd9)))
+		case 0xdc, 0xdd:
+			it.kind = kindArray
+			it.n, err = readLen(2 << (c - 0xdc))
+		case 0xde, 0xdf:
+			it.kind = kindMap
+			it.n, err = readLen(2 << (c - 0xde))
+		default:
+			err = fmt.Errorf("msgpack: invalid format byte 0x%x", c)
+		}
+	}
+	if it.kind == kindUint && it.u <= math.MaxInt64 {
+		it.kind, it.i = kindInt, int64(it.u)
+	}
+	return it, err
+}
+
+func (d *msgpackDecoder) readExt(n int) (msgpackItem, error) {
+	it := msgpackItem{kind: kindExt}
+	p, err := d.next(1)
+	if err != nil {
+		return it, err
+	}
+	it.extType = int8(p[0])
+	it.data, err = d.next(n)
+	return it, err
+}
+
+// skip 跳过 it 之后的元素
+func (d *msgpackDecoder) skip(it msgpackItem) error {
+	n := it.n
+	if it.kind == kindMap {
+		n *= 2
+	} else if it.kind != kindArray {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		child, err := d.readItem()
+		if err != nil {
+			return err
+		}
+		if err := d.skip(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTimestamp 解码为 UTC 时间，零值的 time.Time 可以原样解码
+func decodeTimestamp(data []byte) (time.Time, error) {
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))).UTC(), nil
+	}
+	return time.Time{}, errors.New("msgpack: invalid timestamp")
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	it, err := d.readItem()
+	if err != nil {
+		return err
+	}
+	return d.decodeItem(it, v)
+}
+
+func typeError(it msgpackItem, t reflect.Type) error {
+	names := [...]string{"nil", "bool", "int", "uint", "float", "str", "bin", "array", "map", "ext"}
+	return fmt.Errorf("msgpack: cannot decode %s into %s", names[it.kind], t)
+}
+
+func (d *msgpackDecoder) decodeItem(it msgpackItem, v reflect.Value) error {
+	if it.kind == kindNil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if v.Type() == timeType {
+		if it.kind != kindExt || it.extType != msgpackTimestamp {
+			return typeError(it, v.Type())
+		}
+		t, err := decodeTimestamp(it.data)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeItem(it, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return typeError(it, v.Type())
+		}
+		x, err := d.decodeInterface(it)
+		if err != nil {
+			return err
+		}
+		if x != nil {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Bool:
+		if it.kind != kindBool {
+			return typeError(it, v.Type())
+		}
+		v.SetBool(it.b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if it.kind != kindInt || v.OverflowInt(it.i) {
+			return typeError(it, v.Type())
+		}
+		v.SetInt(it.i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := it.u
+		if it.kind == kindInt {
+			if it.i < 0 {
+				return typeError(it, v.Type())
+			}
+			u = uint64(it.i)
+		} else if it.kind != kindUint {
+			return typeError(it, v.Type())
+		}
+		if v.OverflowUint(u) {
+			return typeError(it, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch it.kind {
+		case kindFloat:
+			v.SetFloat(it.f)
+		case kindInt:
+			v.SetFloat(float64(it.i))
+		case kindUint:
+			v.SetFloat(float64(it.u))
+		default:
+			return typeError(it, v.Type())
+		}
+	case reflect.String:
+		if it.kind != kindStr && it.kind != kindBin {
+			return typeError(it, v.Type())
+		}
+		v.SetString(string(it.data))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (it.kind == kindBin || it.kind == kindStr) {
+			v.SetBytes(append([]byte(nil), it.data...))
+			return nil
+		}
+		if it.kind != kindArray {
+			return typeError(it, v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), 0, 0)
+		for i := 0; i < it.n; i++ {
+			s = reflect.Append(s, reflect.Zero(v.Type().Elem()))
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if it.kind != kindArray {
+			return typeError(it, v.Type())
+		}
+		for i := 0; i < it.n; i++ {
+			if i >= v.Len() {
+				child, err := d.readItem()
+				if err == nil {
+					err = d.skip(child)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if it.kind != kindMap {
+			return typeError(it, v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for i := 0; i < it.n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			val := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(val); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, val)
+		}
+	case reflect.Struct:
+		return d.decodeStruct(it, v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeStruct(it msgpackItem, v reflect.Value) error {
+	if it.kind != kindMap {
+		return typeError(it, v.Type())
+	}
+	fields := cachedFields(v.Type())
+	for i := 0; i < it.n; i++ {
+		var key string
+		if err := d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+			return err
+		}
+		index := -1
+		for _, f := range fields {
+			if f.name == key {
+				index = f.index
+				break
+			}
+		}
+		if index < 0 {
+			for _, f := range fields {
+				if strings.EqualFold(f.name, key) {
+					index = f.index
+					break
+				}
+			}
+		}
+		if index < 0 {
+			child, err := d.readItem()
+			if err == nil {
+				err = d.skip(child)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Field(index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeInterface(it msgpackItem) (interface{}, error) {
+	switch it.kind {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return it.b, nil
+	case kindInt:
+		return it.i, nil
+	case kindUint:
+		return it.u, nil
+	case kindFloat:
+		return it.f, nil
+	case kindStr:
+		return string(it.data), nil
+	case kindBin:
+		return append([]byte(nil), it.data...), nil
+	case kindExt:
+		if it.extType == msgpackTimestamp {
+			return decodeTimestamp(it.data)
+		}
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", it.extType)
+	case kindArray:
+		s := make([]interface{}, 0, minInt(it.n, len(d.b)))
+		for i := 0; i < it.n; i++ {
+			child, err := d.readItem()
+			if err != nil {
+				return nil, err
+			}
+			x, err := d.decodeInterface(child)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, x)
+		}
+		return s, nil
+	default:
+		keys := make([]interface{}, 0, minInt(it.n, len(d.b)))
+		vals := make([]interface{}, 0, minInt(it.n, len(d.b)))
+		allStrings := true
+		for i := 0; i < 2*it.n; i++ {
+			child, err := d.readItem()
+			if err != nil {
+				return nil, err
+			}
+			x, err := d.decodeInterface(child)
+			if err != nil {
+				return nil, err
+			}
+			if i%2 == 0 {
+				_, isString := x.(string)
+				allStrings = allStrings && isString
+				keys = append(keys, x)
+			} else {
+				vals = append(vals, x)
+			}
+		}
+		if allStrings {
+			m := make(map[string]interface{}, len(keys))
+			for i, k := range keys {
+				m[k.(string)] = vals[i]
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(keys))
+		for i, k := range keys {
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, errors.New("msgpack: unhashable map key")
+			}
+			m[k] = vals[i]
+		}
+		return m, nil
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// unmarshalMsgpack data 为一个完整的值，body 需为非 nil 指针
+func unmarshalMsgpack(data []byte, body interface{}) error {
+	v := reflect.ValueOf(body)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("msgpack: decode into non-pointer %T", body)
+	}
+	d := msgpackDecoder{b: data}
+	return d.decode(v.Elem())
+}
@@ -0,0 +1,174 @@
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+/*
+ProtoMessage
+能以 protobuf 编解码的消息：gogo/protobuf 等生成的代码带有这两个方法。
+默认的 ProtobufType 只接受实现了 ProtoMessage 的 body，其他类型以 *EncodeError、*BodyError 失败
+
+使用 google.golang.org/protobuf 时，生成的消息没有这两个方法，实现一个 ProtoAPI 适配 proto.Marshal、proto.Unmarshal，
+再以 SetProtoAPI 设置为 ProtobufType 的实现，Type 名称不变，与其他使用 ProtobufType 的服务互通：
+
+	type protoAPI struct{}
+
+	func (protoAPI) Marshal(body interface{}) ([]byte, error) {
+		m, ok := body.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a proto.Message", body)
+		}
+		return proto.Marshal(m)
+	}
+
+	func (protoAPI) Unmarshal(data []byte, body interface{}) error {
+		m, ok := body.(proto.Message)
+		if !ok {
+			return fmt.Errorf("%T is not a proto.Message", body)
+		}
+		return proto.Unmarshal(data, m)
+	}
+
+	codec.SetProtoAPI(protoAPI{})
+
+需要同时使用多种实现时，也可以通过 NewProtobufCodecFunc 注册为新的 Type
+*/
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// ProtoAPI 抽象出 protobuf 的编解码实现，默认使用 ProtoMessage 的方法
+type ProtoAPI interface {
+	Marshal(body interface{}) ([]byte, error)
+	Unmarshal(data []byte, body interface{}) error
+}
+
+type protoAPIHolder struct{ ProtoAPI }
+
+var protoAPI atomic.Value // protoAPIHolder
+
+func init() {
+	protoAPI.Store(protoAPIHolder{methodProtoAPI{}})
+}
+
+// SetProtoAPI 设置 ProtobufType（NewProtobufCodec）使用的实现，之后建立的连接生效；api 为 nil 时恢复为 ProtoMessage 的方法
+func SetProtoAPI(api ProtoAPI) {
+	if api == nil {
+		api = methodProtoAPI{}
+	}
+	protoAPI.Store(protoAPIHolder{api})
+}
+
+type methodProtoAPI struct{}
+
+func (methodProtoAPI) Marshal(body interface{}) ([]byte, error) {
+	m, ok := body.(ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("protobuf body %T does not implement codec.ProtoMessage", body)
+	}
+	return m.Marshal()
+}
+
+func (methodProtoAPI) Unmarshal(data []byte, body interface{}) error {
+	m, ok := body.(ProtoMessage)
+	if !ok {
+		return fmt.Errorf("protobuf body %T does not implement codec.ProtoMessage", body)
+	}
+	return m.Unmarshal(data)
+}
+
+/*
+ProtobufCodec
+与 RawCodec 相同的长度前缀分帧，header、body 都使用 protobuf 编码，header 的消息定义见 protobuf_header.go
+body 不是 protobuf 消息时，Write 返回 *EncodeError、ReadBody 返回 *BodyError，连接仍然可用；
+服务端回复错误时的空 body（struct{}{}）编码为空帧
+*/
+type ProtobufCodec struct {
+	RawCodec
+	api ProtoAPI
+}
+
+var _ Codec = (*ProtobufCodec)(nil)
+
+// NewProtobufCodec 使用 SetProtoAPI 设置的实现（默认为 ProtoMessage 的方法）编解码，注册为 ProtobufType
+func NewProtobufCodec(conn io.ReadWriteCloser) Codec {
+	return NewProtobufCodecFunc(protoAPI.Load().(protoAPIHolder).ProtoAPI)(conn)
+}
+
+// NewProtobufCodecFunc 根据 api 返回 NewCodecFunc，通过 Register 注册为新的 Type 即可更换 protobuf 实现
+func NewProtobufCodecFunc(api ProtoAPI) NewCodecFunc {
+	return func(conn io.ReadWriteCloser) Codec {
+		return &ProtobufCodec{
+			RawCodec: RawCodec{conn: conn, r: bufio.NewReader(conn), buf: bufio.NewWriter(conn)},
+			api:      api,
+		}
+	}
+}
+
+func (c *ProtobufCodec) ReadHeader(header *Header) error {
+	frame, err := c.readFrame(0)
+	if err != nil {
+		return err
+	}
+	return unmarshalProtoHeader(frame, header)
+}
+
+// ReadBody body 为 nil 时丢弃该帧
+func (c *ProtobufCodec) ReadBody(body interface{}) error {
+	frame, err := c.readFrame(c.maxBody)
+	if err != nil {
+		return err
+	}
+	switch body.(type) {
+	case nil, *struct{}:
+		return nil
+	}
+	// 帧已完整读取，解码失败不影响后续的帧
+	if err := c.api.Unmarshal(frame, body); err != nil {
+		return &BodyError{Err: err}
+	}
+	return nil
+}
+
+func (c *ProtobufCodec) marshalBody(body interface{}) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	if _, empty := body.(struct{}); empty {
+		return nil, nil
+	}
+	if b, err = c.api.Marshal(body); err != nil {
+		return nil, &EncodeError{Err: err}
+	}
+	return b, nil
+}
+
+// Write header、body 都编码成功后才写入连接；body 编码失败返回 *EncodeError 或 *PanicError，不关闭连接
+func (c *ProtobufCodec) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		if ferr := c.buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err != nil && !EncodeFailed(err) {
+			_ = c.Close()
+		}
+	}()
+	b, err := c.marshalBody(body)
+	if err == nil {
+		err = checkWriteSize(len(b), c.maxWrite)
+	}
+	if err != nil {
+		return err
+	}
+	if err = c.writeFrame(marshalProtoHeader(header)); err != nil {
+		return err
+	}
+	return c.writeFrame(b)
+}
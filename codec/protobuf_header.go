@@ -0,0 +1,184 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+/*
+ProtobufCodec 的 header 按以下 protobuf 消息编码，其他语言的客户端可以用同一份定义生成代码：
+
+	message Header {
+	  string service = 1;
+	  string method = 2;
+	  uint64 seq = 3;
+	  string error = 4;
+	  int64 timeout = 5;
+	  int64 error_code = 6;
+	  map<string, string> metadata = 7;
+	  int32 frame = 8;
+	  string error_detail = 9;
+	  bool error_retryable = 10;
+	}
+
+手工编码，不依赖 protobuf 库；解码时跳过未知字段，新增字段不影响旧的对端
+*/
+const (
+	wireVarint = 0
+	wire64bit  = 1
+	wireBytes  = 2
+	wire32bit  = 5
+)
+
+var errMalformedHeader = errors.New("codec: malformed protobuf header")
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return appendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func marshalProtoHeader(h *Header) []byte {
+	var b []byte
+	b = appendStringField(b, 1, h.Service)
+	b = appendStringField(b, 2, h.Method)
+	b = appendVarintField(b, 3, h.Seq)
+	b = appendStringField(b, 4, h.Error)
+	b = appendVarintField(b, 5, uint64(h.Timeout))
+	b = appendVarintField(b, 6, uint64(int64(h.ErrorCode)))
+	// 按键排序，相同的 header 编码结果相同
+	keys := make([]string, 0, len(h.Metadata))
+	for k := range h.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, k)
+		entry = appendStringField(entry, 2, h.Metadata[k])
+		b = appendTag(b, 7, wireBytes)
+		b = appendUvarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	b = appendVarintField(b, 8, uint64(int64(h.Frame)))
+	b = appendStringField(b, 9, h.ErrorDetail)
+	if h.ErrorRetryable {
+		b = appendVarintField(b, 10, 1)
+	}
+	return b
+}
+
+// protoField 依次读取消息中的字段
+type protoField struct {
+	num    int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+func nextProtoField(b []byte) (protoField, []byte, error) {
+	var f protoField
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return f, nil, errMalformedHeader
+	}
+	b = b[n:]
+	f.num, f.wire = int(tag>>3), int(tag&7)
+	switch f.wire {
+	case wireVarint:
+		if f.varint, n = binary.Uvarint(b); n <= 0 {
+			return f, nil, errMalformedHeader
+		}
+		return f, b[n:], nil
+	case wireBytes:
+		size, n := binary.Uvarint(b)
+		if n <= 0 || size > uint64(len(b)-n) {
+			return f, nil, errMalformedHeader
+		}
+		f.bytes = b[n : n+int(size)]
+		return f, b[n+int(size):], nil
+	case wire64bit:
+		if len(b) < 8 {
+			return f, nil, errMalformedHeader
+		}
+		return f, b[8:], nil
+	case wire32bit:
+		if len(b) < 4 {
+			return f, nil, errMalformedHeader
+		}
+		return f, b[4:], nil
+	default:
+		return f, nil, errMalformedHeader
+	}
+}
+
+func unmarshalProtoHeader(b []byte, h *Header) error {
+	*h = Header{}
+	for len(b) > 0 {
+		f, rest, err := nextProtoField(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		switch {
+		case f.num == 1 && f.wire == wireBytes:
+			h.Service = string(f.bytes)
+		case f.num == 2 && f.wire == wireBytes:
+			h.Method = string(f.bytes)
+		case f.num == 3 && f.wire == wireVarint:
+			h.Seq = f.varint
+		case f.num == 4 && f.wire == wireBytes:
+			h.Error = string(f.bytes)
+		case f.num == 5 && f.wire == wireVarint:
+			h.Timeout = int64(f.varint)
+		case f.num == 6 && f.wire == wireVarint:
+			h.ErrorCode = int(int64(f.varint))
+		case f.num == 7 && f.wire == wireBytes:
+			var k, v string
+			for entry := f.bytes; len(entry) > 0; {
+				ef, rest, err := nextProtoField(entry)
+				if err != nil {
+					return err
+				}
+				entry = rest
+				switch {
+				case ef.num == 1 && ef.wire == wireBytes:
+					k = string(ef.bytes)
+				case ef.num == 2 && ef.wire == wireBytes:
+					v = string(ef.bytes)
+				}
+			}
+			if h.Metadata == nil {
+				h.Metadata = make(map[string]string)
+			}
+			h.Metadata[k] = v
+		case f.num == 8 && f.wire == wireVarint:
+			h.Frame = int(int64(f.varint))
+		case f.num == 9 && f.wire == wireBytes:
+			h.ErrorDetail = string(f.bytes)
+		case f.num == 10 && f.wire == wireVarint:
+			h.ErrorRetryable = f.varint != 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testPoint 手写的 protobuf 消息：message Point { int64 x = 1; string label = 2; }
+type testPoint struct {
+	X     int64
+	Label string
+}
+
+func (p *testPoint) Marshal() ([]byte, error) {
+	var b []byte
+	if p.X != 0 {
+		b = appendUvarint(append(b, 1<<3|0), uint64(p.X))
+	}
+	if p.Label != "" {
+		b = append(appendUvarint(append(b, 2<<3|2), uint64(len(p.Label))), p.Label...)
+	}
+	return b, nil
+}
+
+func (p *testPoint) Unmarshal(b []byte) error {
+	*p = testPoint{}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad tag")
+		}
+		b = b[n:]
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad varint")
+		}
+		b = b[n:]
+		switch tag {
+		case 1<<3 | 0:
+			p.X = int64(v)
+		case 2<<3 | 2:
+			if uint64(len(b)) < v {
+				return errors.New("truncated string")
+			}
+			p.Label, b = string(b[:v]), b[v:]
+		default:
+			return errors.New("unknown field")
+		}
+	}
+	return nil
+}
+
+func TestProtobufCodec(t *testing.T) {
+	conn := new(bufferConn)
+	w, r := NewProtobufCodec(conn), NewProtobufCodec(conn)
+
+	if err := w.Write(&Header{Service: "Geo", Method: "Move", Seq: 1}, &testPoint{X: 42, Label: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	var h Header
+	var got testPoint
+	if err := r.ReadHeader(&h); err != nil || h.Method != "Move" || h.Seq != 1 {
+		t.Fatalf("read header: %v %+v", err, h)
+	}
+	if err := r.ReadBody(&got); err != nil || got != (testPoint{X: 42, Label: "a"}) {
+		t.Fatalf("read body: %v %+v", err, got)
+	}
+
+	// 非 protobuf 消息：不写入任何数据，连接仍然可用
+	before := conn.Len()
+	err := w.Write(&Header{Seq: 2}, 7)
+	if _, ok := err.(*EncodeError); !ok || conn.Len() != before {
+		t.Fatalf("expect *EncodeError without writing, got %v", err)
+	}
+	// 错误回复的空 body
+	if err := w.Write(&Header{Seq: 3, Error: "oops"}, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 3 || r.ReadBody(nil) != nil {
+		t.Fatalf("read error reply: %v %+v", err, h)
+	}
+	if err := w.Write(&Header{Seq: 4}, &testPoint{X: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	_ = r.ReadHeader(&h)
+	if err := r.ReadBody(&n); !errors.As(err, new(*BodyError)) {
+		t.Fatalf("expect *BodyError for a non-proto reply, got %v", err)
+	}
+}
+
+// plainPoint 没有 ProtoMessage 的方法，模拟 google.golang.org/protobuf 生成的消息
+type plainPoint struct {
+	X int64
+}
+
+// plainAPI 以 testPoint 的编码编解码 plainPoint，模拟适配 proto.Marshal、proto.Unmarshal 的 ProtoAPI
+type plainAPI struct{}
+
+func (plainAPI) Marshal(body interface{}) ([]byte, error) {
+	p, ok := body.(*plainPoint)
+	if !ok {
+		return nil, errors.New("not a plainPoint")
+	}
+	return (&testPoint{X: p.X}).Marshal()
+}
+
+func (plainAPI) Unmarshal(data []byte, body interface{}) error {
+	p, ok := body.(*plainPoint)
+	if !ok {
+		return errors.New("not a plainPoint")
+	}
+	var tp testPoint
+	err := tp.Unmarshal(data)
+	p.X = tp.X
+	return err
+}
+
+func TestSetProtoAPI(t *testing.T) {
+	SetProtoAPI(plainAPI{})
+	defer SetProtoAPI(nil)
+	conn := new(bufferConn)
+	f := Get(ProtobufType)
+	w, r := f(conn), f(conn)
+	if err := w.Write(&Header{Seq: 1}, &plainPoint{X: 7}); err != nil {
+		t.Fatal(err)
+	}
+	var h Header
+	var got plainPoint
+	if err := r.ReadHeader(&h); err != nil || r.ReadBody(&got) != nil || got.X != 7 {
+		t.Fatalf("round trip through the ProtobufType with a custom API failed: %v %+v", err, got)
+	}
+
+	// 恢复默认实现后 plainPoint 不再被接受
+	SetProtoAPI(nil)
+	if err := Get(ProtobufType)(conn).Write(&Header{Seq: 2}, &plainPoint{X: 7}); !errors.As(err, new(*EncodeError)) {
+		t.Fatalf("expect the default API to reject plainPoint, got %v", err)
+	}
+}
+
+func TestProtoHeader(t *testing.T) {
+	h := Header{Service: "Geo", Method: "Move", Seq: 1 << 40, Error: "oops", Timeout: 1500, ErrorCode: 3,
+		Metadata: map[string]string{"trace-id": "t-1", "empty": ""}, Frame: 2, ErrorDetail: `{"field":"name"}`, ErrorRetryable: true}
+	b := marshalProtoHeader(&h)
+	// 未知字段：varint、length-delimited、fixed64
+	b = appendUvarint(appendTag(b, 15, wireVarint), 9)
+	b = appendStringField(b, 16, "future")
+	b = append(appendTag(b, 17, wire64bit), 1, 2, 3, 4, 5, 6, 7, 8)
+
+	var got Header
+	if err := unmarshalProtoHeader(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Service != h.Service || got.Method != h.Method || got.Seq != h.Seq || got.Error != h.Error ||
+		got.Timeout != h.Timeout || got.ErrorCode != h.ErrorCode || len(got.Metadata) != 2 || got.Metadata["trace-id"] != "t-1" ||
+		got.Frame != h.Frame || got.ErrorDetail != h.ErrorDetail || !got.ErrorRetryable {
+		t.Fatalf("header mismatch: %+v", got)
+	}
+	if err := unmarshalProtoHeader(b[:len(b)-3], &got); err == nil {
+		t.Fatal("expect an error for a truncated header")
+	}
+	if len(marshalProtoHeader(&Header{})) != 0 {
+		t.Fatal("zero header should encode to an empty message")
+	}
+}
@@ -0,0 +1,153 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+/*
+RawCodec
+长度前缀分帧的 codec，每一帧为 4 字节大端长度 + 内容，header、body 各占一帧
+
+header 固定使用 JSON 编码，与 body 的编码无关，代理只解析 header 即可路由；
+body 为 []byte、*[]byte 时原样读写，不做任何解释，代理可以将读到的 body 原样转发给下游；
+其他类型的 body 使用 JSON 编解码，普通的客户端、服务端也可以直接使用 RawType 通信
+*/
+type RawCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  *bufio.Writer
+
+	maxBody  int // body 帧的长度上限，0 即为不限制
+	maxWrite int // 见 WriteLimiter
+}
+
+var _ Codec = (*RawCodec)(nil)
+
+func NewRawCodec(conn io.ReadWriteCloser) Codec {
+	return &RawCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+	}
+}
+
+func (c *RawCodec) Close() error {
+	return c.conn.Close()
+}
+
+// SetMaxBodySize 见 BodyLimiter，长度前缀超过上限时不读取该帧
+func (c *RawCodec) SetMaxBodySize(max int) {
+	c.maxBody = max
+}
+
+// SetMaxWriteSize 见 WriteLimiter
+func (c *RawCodec) SetMaxWriteSize(max int) {
+	c.maxWrite = max
+}
+
+// readFrame limit 大于 0 时，帧长度超过 limit 返回 *BodyTooLargeError
+func (c *RawCodec) readFrame(limit int) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if limit > 0 && uint64(n) > uint64(limit) {
+		return nil, &BodyTooLargeError{Limit: limit}
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *RawCodec) writeFrame(frame []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+	if _, err := c.buf.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(frame)
+	return err
+}
+
+func (c *RawCodec) ReadHeader(header *Header) error {
+	frame, err := c.readFrame(0)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(frame, header)
+}
+
+// ReadBody body 为 nil 时丢弃该帧，为 *[]byte 时得到原始字节
+func (c *RawCodec) ReadBody(body interface{}) error {
+	frame, err := c.readFrame(c.maxBody)
+	if err != nil {
+		return err
+	}
+	switch b := body.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*b = frame
+		return nil
+	default:
+		// 帧已完整读取，解码失败不影响后续的帧
+		if err := json.Unmarshal(frame, body); err != nil {
+			return &BodyError{Err: err}
+		}
+		return nil
+	}
+}
+
+func marshalRawBody(body interface{}) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r}
+		}
+	}()
+	switch b := body.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		if b == nil {
+			return nil, errors.New("nil *[]byte body")
+		}
+		return *b, nil
+	default:
+		return json.Marshal(body)
+	}
+}
+
+// Write header、body 都编码成功后才写入连接，编码失败不会写出半个请求；编码 body 时 panic 返回 *PanicError，不关闭连接
+func (c *RawCodec) Write(header *Header, body interface{}) (err error) {
+	defer func() {
+		// 写入连接失败（Flush 的错误）同样说明连接已不可用
+		if ferr := c.buf.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+		if err != nil && !EncodeFailed(err) {
+			_ = c.Close()
+		}
+	}()
+	h, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	b, err := marshalRawBody(body)
+	if err == nil {
+		err = checkWriteSize(len(b), c.maxWrite)
+	}
+	if err != nil {
+		return err
+	}
+	if err = c.writeFrame(h); err != nil {
+		return err
+	}
+	return c.writeFrame(b)
+}
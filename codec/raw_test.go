@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 代理以 []byte 读取 body 后原样转发，下游按普通类型解码
+func TestRawCodec_Passthrough(t *testing.T) {
+	upstream, downstream := new(bufferConn), new(bufferConn)
+	client, proxyIn := NewRawCodec(upstream), NewRawCodec(upstream)
+	proxyOut, server := NewRawCodec(downstream), NewRawCodec(downstream)
+
+	if err := client.Write(&Header{Service: "Foo", Method: "Sum", Seq: 7}, map[string]int{"Num1": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var h Header
+	var raw []byte
+	if err := proxyIn.ReadHeader(&h); err != nil || h.Service != "Foo" || h.Seq != 7 {
+		t.Fatalf("proxy read header: %v %+v", err, h)
+	}
+	if err := proxyIn.ReadBody(&raw); err != nil || !bytes.Equal(raw, []byte(`{"Num1":1}`)) {
+		t.Fatalf("proxy read raw body: %v %s", err, raw)
+	}
+	if err := proxyOut.Write(&h, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	var args struct{ Num1 int }
+	if err := server.ReadHeader(&h); err != nil || h.Method != "Sum" {
+		t.Fatalf("server read header: %v %+v", err, h)
+	}
+	if err := server.ReadBody(&args); err != nil || args.Num1 != 1 {
+		t.Fatalf("server read body: %v %+v", err, args)
+	}
+}
@@ -0,0 +1,277 @@
+package myGoRPC
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*
+压缩
+
+Option.Compression 指定连接使用的压缩算法，随 Option 发送给服务端，服务端不支持时在握手回复中拒绝，
+不会出现两端一方压缩、一方不压缩而破坏数据流的情况；需要 V1 及以上版本的握手。为空或 "none" 即为不压缩
+
+压缩作用在握手（以及 StartTLS）之后的整个数据流上，header 也一并压缩：
+每次写入后 Flush，保证一条完整的消息立即被对端读到，消息之间共享压缩字典，多条相似的消息压缩效果更好
+
+Option.CompressionThreshold 大于 0 时改为逐条消息压缩（见 framedConn）：小于该字节数的消息不压缩，
+避免为很小的请求、回复付出压缩的开销；双方各自独立压缩每条消息，不再共享压缩字典。需要服务端在握手回复中确认
+
+解压受本端的读取上限（MaxBodySize 与 MaxMessageSize 中较小的一个，见 messageCodec）约束，避免很小的压缩数据解出远超上限的内容：
+逐条压缩时声明的原始长度超过上限的帧直接拒绝；整个数据流压缩时，每读取一段压缩数据解出的字节数同样不能超过上限。
+两种情况下都额外留出 chunkHeaderAllowance 字节给消息头，0 即为不限制
+
+内置 gzip、snappy（见 snappy.go），可以通过 RegisterCompression 注册其他算法（两端都需要注册）
+*/
+
+const CompressionNone = "none"
+
+var errCompressionNeedsHandshake = errors.New("compression requires a versioned handshake")
+
+var errDecompressedTooLarge = errors.New("compression: decompressed data exceeds the message size limit")
+
+// CompressionFunc 包装握手之后的连接，返回的连接读取时解压、写入时压缩
+type CompressionFunc func(rwc io.ReadWriteCloser) io.ReadWriteCloser
+
+var (
+	compressionMu sync.RWMutex
+	compressions  = map[string]CompressionFunc{
+		"gzip":   newGzipConn,
+		"snappy": newSnappyConn,
+	}
+)
+
+// RegisterCompression 注册压缩算法，需在建立连接之前调用
+func RegisterCompression(name string, f CompressionFunc) {
+	compressionMu.Lock()
+	defer compressionMu.Unlock()
+	compressions[name] = f
+}
+
+func lookupCompression(name string) (CompressionFunc, error) {
+	if name == "" || name == CompressionNone {
+		return nil, nil
+	}
+	compressionMu.RLock()
+	defer compressionMu.RUnlock()
+	f := compressions[name]
+	if f == nil {
+		return nil, fmt.Errorf("unsupported compression %q", name)
+	}
+	return f, nil
+}
+
+/*
+compress
+按 Option.Compression、CompressionThreshold 包装连接，调用前已在握手中确认两端都支持；
+maxRead 为本端另外设置的读取上限（Server.MaxBodySize、Option.MaxBodySize），与 messageCodec 相同
+*/
+func compress(opt *Option, maxRead int, rwc io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	f, err := lookupCompression(opt.Compression)
+	if err != nil || f == nil {
+		return rwc, err
+	}
+	var max uint64
+	if limit := minLimit(maxRead, opt.MaxMessageSize); limit > 0 {
+		max = uint64(limit) + chunkHeaderAllowance
+	}
+	if opt.CompressionThreshold > 0 {
+		return &framedConn{rwc: rwc, r: bufio.NewReader(rwc), f: f, threshold: opt.CompressionThreshold, max: max}, nil
+	}
+	if max == 0 {
+		return f(rwc), nil
+	}
+	src := &countingReader{Reader: rwc}
+	return &boundedConn{ReadWriteCloser: f(struct {
+		io.Reader
+		io.WriteCloser
+	}{src, rwc}), src: src, max: max}, nil
+}
+
+/*
+boundedConn
+整个数据流压缩时限制解压的字节数：src 统计从连接读取的压缩数据，
+在两次读取新的压缩数据之间解出的字节数超过 max 时返回 errDecompressedTooLarge
+*/
+type boundedConn struct {
+	io.ReadWriteCloser
+	src      *countingReader
+	max      uint64
+	in       int64 // 上次 Read 时 src 已读取的字节数
+	produced uint64
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (c *boundedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if c.src.n != c.in {
+		c.in, c.produced = c.src.n, 0
+	}
+	if c.produced += uint64(n); c.produced > c.max {
+		return 0, errDecompressedTooLarge
+	}
+	return n, err
+}
+
+const (
+	frameRaw        = 0
+	frameCompressed = 1
+)
+
+var errCorruptFrame = errors.New("compression: corrupt frame")
+
+/*
+framedConn
+逐条消息压缩：codec 每次 Flush 的写入单独成帧，不足 threshold 字节、或压缩后没有变小的原样发送。
+帧格式为 1 字节标志（frameRaw、frameCompressed）+ uvarint 长度 + 数据，压缩帧在长度之前还有 uvarint 的原始长度；
+压缩帧的数据是以 f 单独压缩的一段完整数据流；max 大于 0 时，长度或原始长度超过 max 的帧不读取，
+解压时最多读取原始长度加一个字节，解出的数据与声明的原始长度不符的帧视为损坏
+*/
+type framedConn struct {
+	rwc       io.ReadWriteCloser
+	r         *bufio.Reader
+	f         CompressionFunc
+	threshold int
+	max       uint64
+	pending   []byte // 已解出、尚未读取的数据
+}
+
+// memConn 让 CompressionFunc 在内存中压缩、解压一帧
+type memConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (memConn) Close() error { return nil }
+
+func (c *framedConn) Write(p []byte) (int, error) {
+	frame := []byte{frameRaw}
+	data := p
+	if len(p) >= c.threshold {
+		var buf bytes.Buffer
+		if _, err := c.f(memConn{Writer: &buf}).Write(p); err == nil && buf.Len() < len(p) {
+			frame[0], data = frameCompressed, buf.Bytes()
+			frame = appendUvarint(frame, uint64(len(p)))
+		}
+	}
+	frame = appendUvarint(frame, uint64(len(data)))
+	if _, err := c.rwc.Write(append(frame, data...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (c *framedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *framedConn) readFrame() error {
+	flag, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	var rawSize uint64
+	if flag == frameCompressed {
+		if rawSize, err = binary.ReadUvarint(c.r); err != nil {
+			return unexpectedEOF(err)
+		}
+	} else if flag != frameRaw {
+		return errCorruptFrame
+	}
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if c.max > 0 && (size > c.max || rawSize > c.max) {
+		return errDecompressedTooLarge
+	}
+	// 按实际读到的数据增长，不按声明的长度预先分配
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, c.r, int64(size)); err != nil {
+		return unexpectedEOF(err)
+	}
+	if flag == frameRaw {
+		c.pending = buf.Bytes()
+		return nil
+	}
+	// 原始长度之后仍能解出数据的帧同样视为损坏（帧内的压缩流不一定有结尾，之后读取的错误忽略）
+	var raw bytes.Buffer
+	dec := c.f(memConn{Reader: &buf})
+	if _, err = io.CopyN(&raw, io.LimitReader(dec, int64(rawSize)), int64(rawSize)); err != nil {
+		return errCorruptFrame
+	}
+	if n, _ := dec.Read(make([]byte, 1)); n > 0 {
+		return errCorruptFrame
+	}
+	c.pending = raw.Bytes()
+	return nil
+}
+
+func (c *framedConn) Close() error {
+	return c.rwc.Close()
+}
+
+/*
+gzipConn
+gzip.NewReader 创建时就会读取 gzip 头，对端在第一次写入时才发送，因此 reader 在第一次 Read 时才创建
+*/
+type gzipConn struct {
+	rwc io.ReadWriteCloser
+	w   *gzip.Writer
+	r   *gzip.Reader
+}
+
+func newGzipConn(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	return &gzipConn{rwc: rwc, w: gzip.NewWriter(rwc)}
+}
+
+func (c *gzipConn) Read(p []byte) (int, error) {
+	if c.r == nil {
+		r, err := gzip.NewReader(c.rwc)
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+	return c.r.Read(p)
+}
+
+func (c *gzipConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	return n, err
+}
+
+func (c *gzipConn) Close() error {
+	return c.rwc.Close()
+}
@@ -0,0 +1,145 @@
+package myGoRPC
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+按服务限制并发
+
+LimitConcurrency 设置某个服务同时处理的请求数上限，达到上限后到达的请求在该服务的队列中等待，
+按到达顺序（FIFO）获得处理的机会：请求结束时直接把名额交给队首的请求，后到的请求不会插队，避免饥饿
+
+等待时间计入请求的处理时间，Option.HandleTimeout 或调用方的 deadline 到期时请求离开队列，回复超时
+*/
+
+// ServiceQueueStats 某个服务的并发与排队情况
+type ServiceQueueStats struct {
+	Limit     int
+	InUse     int           // 正在处理的请求数
+	Queued    int           // 正在排队的请求数
+	Admitted  uint64        // 累计获得名额的请求数
+	TotalWait time.Duration // 累计的排队时间，除以 Admitted 即为平均排队时间
+	MaxWait   time.Duration // 最长的一次排队时间
+}
+
+type fifoLimiter struct {
+	mu      sync.Mutex
+	stats   ServiceQueueStats
+	waiters *list.List // 元素为 chan struct{}，名额交给队首时关闭
+}
+
+/*
+LimitConcurrency
+max 小于等于 0 时取消限制；修改已有的限制时，正在处理、排队的请求不受影响，按新的上限放行之后的请求
+*/
+func (server *Server) LimitConcurrency(service string, max int) error {
+	if _, ok := server.ServiceMap.Load(service); !ok {
+		return errors.New("rpc server: can't find service " + service)
+	}
+	if max <= 0 {
+		server.limits.Delete(service)
+		return nil
+	}
+	li, loaded := server.limits.LoadOrStore(service, &fifoLimiter{stats: ServiceQueueStats{Limit: max}, waiters: list.New()})
+	if loaded {
+		l := li.(*fifoLimiter)
+		l.mu.Lock()
+		l.stats.Limit = max
+		l.handOff()
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// QueueStats 返回服务的并发与排队情况，没有设置限制时 ok 为 false
+func (server *Server) QueueStats(service string) (stats ServiceQueueStats, ok bool) {
+	li, ok := server.limits.Load(service)
+	if !ok {
+		return stats, false
+	}
+	l := li.(*fifoLimiter)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats = l.stats
+	stats.Queued = l.waiters.Len()
+	return stats, true
+}
+
+// limitedCall 受 LimitConcurrency 限制的服务，获得名额后才调用
+func (server *Server) limitedCall(req *request) error {
+	li, ok := server.limits.Load(req.header.Service)
+	if !ok {
+		return server.call(req)
+	}
+	l := li.(*fifoLimiter)
+	if err := l.acquire(req.ctx); err != nil {
+		return fmt.Errorf("rpc server: waiting in %s queue: %w", req.header.Service, err)
+	}
+	defer l.release()
+	return server.call(req)
+}
+
+func (l *fifoLimiter) acquire(ctx context.Context) error {
+	start := time.Now()
+	l.mu.Lock()
+	if l.stats.InUse < l.stats.Limit && l.waiters.Len() == 0 {
+		l.stats.InUse++
+		l.record(0)
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		l.mu.Lock()
+		l.record(time.Since(start))
+		l.mu.Unlock()
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// 名额已经交给了这个请求，转交给下一个
+			l.stats.InUse--
+			l.handOff()
+		default:
+			l.waiters.Remove(elem)
+		}
+		return ctx.Err()
+	}
+}
+
+// record 需持有 mu，记录一个获得名额的请求；被 handOff 唤醒的请求在 handOff 时已占用名额
+func (l *fifoLimiter) record(waited time.Duration) {
+	l.stats.Admitted++
+	l.stats.TotalWait += waited
+	if waited > l.stats.MaxWait {
+		l.stats.MaxWait = waited
+	}
+}
+
+func (l *fifoLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.InUse--
+	l.handOff()
+}
+
+// handOff 需持有 mu，把空出的名额按顺序交给队首的请求
+func (l *fifoLimiter) handOff() {
+	for l.stats.InUse < l.stats.Limit && l.waiters.Len() > 0 {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.stats.InUse++
+		close(ready)
+	}
+}
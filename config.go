@@ -0,0 +1,148 @@
+package myGoRPC
+
+import (
+	"encoding/json"
+	"myGoRPC/service"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+ServerConfig
+服务端当前生效的配置，用于核对运行中的服务端是否与预期一致，只读
+
+Option 由每个连接的客户端在握手时决定，服务端没有全局的 Option，各连接的情况见 Connections；
+敏感的内容不输出：TLSConfig（证书、私钥）只输出是否开启，RequestLog 的 Sink 只输出类型名，
+AuthFunc、AcceptFilter、Utilization 等回调只输出是否设置，会话令牌、连接标签的值不输出；
+HandshakeTimeout 为生效的时长，负数即为不限制
+*/
+type ServerConfig struct {
+	HandshakeVersion int
+	Codecs           []string
+	Services         []ServiceConfig
+	Shed             *ShedPolicy
+	CacheSize        int
+	FaultInjection   bool // 是否使用 -tags faultinject 编译
+	FaultRules       []FaultRule
+	TLS              bool
+	LabelKeys        []string
+	RequestLog       *RequestLogConfig
+	Limits           map[string]ServiceQueueStats
+	Connections      int
+	Sessions         int
+
+	HandshakeTimeout      time.Duration
+	MaxBodySize           int
+	Auth                  bool // 是否设置了 AuthFunc
+	AcceptFilter          bool // 是否设置了 AcceptFilter
+	MaxConcurrentRequests int
+	BusyPolicy            string // "queue" 或 "reject"
+	MaxQueuedRequests     int
+	IdleTimeout           time.Duration
+	MaxConcurrentStreams  int
+	ReportLoad            bool
+	Utilization           bool // 是否设置了 Utilization
+	Backpressure          *Backpressure
+}
+
+type ServiceConfig struct {
+	Name    string
+	Methods []MethodConfig
+}
+
+type MethodConfig struct {
+	Name      string
+	ArgType   string
+	ReplyType string
+	HasCtx    bool
+	CacheTTL  time.Duration
+}
+
+type RequestLogConfig struct {
+	Sink       string
+	SampleRate float64
+}
+
+// Config 返回服务端当前生效的配置
+func (server *Server) Config() *ServerConfig {
+	cfg := &ServerConfig{
+		HandshakeVersion: HandshakeVersion,
+		Codecs:           supportedCodecs(),
+		Shed:             server.Shed,
+		CacheSize:        server.CacheSize,
+		FaultInjection:   faultInjectionEnabled,
+		FaultRules:       server.Faults.snapshot(),
+		TLS:              server.TLSConfig != nil,
+		LabelKeys:        server.LabelKeys,
+		Limits:           make(map[string]ServiceQueueStats),
+		Connections:      len(server.Connections()),
+
+		HandshakeTimeout:      server.HandshakeTimeout,
+		MaxBodySize:           server.MaxBodySize,
+		Auth:                  server.AuthFunc != nil,
+		AcceptFilter:          server.AcceptFilter != nil,
+		MaxConcurrentRequests: server.MaxConcurrentRequests,
+		BusyPolicy:            "queue",
+		MaxQueuedRequests:     server.MaxQueuedRequests,
+		IdleTimeout:           server.IdleTimeout,
+		MaxConcurrentStreams:  server.MaxConcurrentStreams,
+		ReportLoad:            server.ReportLoad,
+		Utilization:           server.Utilization != nil,
+		Backpressure:          server.Backpressure,
+	}
+	if cfg.CacheSize == 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	if cfg.HandshakeTimeout == 0 {
+		cfg.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if server.BusyPolicy == BusyReject {
+		cfg.BusyPolicy = "reject"
+	}
+	if rl := server.RequestLog; rl != nil {
+		cfg.RequestLog = &RequestLogConfig{SampleRate: rl.SampleRate}
+		if rl.Sink != nil {
+			cfg.RequestLog.Sink = reflect.TypeOf(rl.Sink).String()
+		}
+	}
+	server.ServiceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service.Service)
+		sc := ServiceConfig{Name: namei.(string)}
+		for name, mtype := range svc.Method {
+			sc.Methods = append(sc.Methods, MethodConfig{
+				Name:      name,
+				ArgType:   mtype.ArgType.String(),
+				ReplyType: mtype.ReplyType.String(),
+				HasCtx:    mtype.HasCtx,
+				CacheTTL:  mtype.CacheTTL,
+			})
+		}
+		sort.Slice(sc.Methods, func(i, j int) bool { return sc.Methods[i].Name < sc.Methods[j].Name })
+		cfg.Services = append(cfg.Services, sc)
+		if stats, ok := server.QueueStats(sc.Name); ok {
+			cfg.Limits[sc.Name] = stats
+		}
+		return true
+	})
+	sort.Slice(cfg.Services, func(i, j int) bool { return cfg.Services[i].Name < cfg.Services[j].Name })
+	server.sessionMu.Lock()
+	cfg.Sessions = len(server.sessions)
+	server.sessionMu.Unlock()
+	return cfg
+}
+
+// ConfigHTTP 以 JSON 输出 Config，注册在 DefaultConfigPath
+type ConfigHTTP struct {
+	*Server
+}
+
+func (server ConfigHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(server.Config()); err != nil {
+		http.Error(w, "rpc: error encoding config: "+err.Error(), http.StatusInternalServerError)
+	}
+}
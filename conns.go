@@ -0,0 +1,140 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"io"
+	"myGoRPC/codec"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnQuiescing 连接正在被 Quiesce，既作为发给客户端的排空信号，也作为之后到达的请求的错误回复
+var ErrConnQuiescing = errors.New("rpc server: connection is quiescing")
+
+// ConnInfo 一个连接的概要信息
+type ConnInfo struct {
+	ID         uint64
+	RemoteAddr string
+	Labels     map[string]string
+	Since      time.Time
+	Quiescing  bool
+}
+
+/*
+serverConn
+服务端一个连接的状态，serveCodec 期间登记在 Server.conns 中
+mu 保护 quiescing 与 wg.Add，保证 Quiesce 之后不会再有新的请求进入 wg
+*/
+type serverConn struct {
+	info    ConnInfo
+	cc      codec.Codec
+	sending *sync.Mutex
+	wg      *sync.WaitGroup
+	session *session // Option.Resumable 时连接所属的会话
+
+	mu        sync.Mutex
+	quiescing bool
+
+	active     int64 // 已登记、尚未回复的请求数，见 idle.go
+	lastActive int64 // 最近一次收到请求或回复完成的时间，UnixNano
+
+	streams map[uint64]*Stream // 未结束的流，mu 保护，见 stream.go
+
+	ctx    context.Context // 连接断开时取消，请求的 ctx 由它派生，见 cancel.go
+	cancel context.CancelFunc
+	calls  map[uint64]context.CancelFunc // 正在处理的一元请求，mu 保护，见 cancel.go
+}
+
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(net.Conn); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
+}
+
+func (server *Server) trackConn(cc codec.Codec, opt *Option, remote string) *serverConn {
+	sc := &serverConn{
+		info: ConnInfo{
+			ID:         atomic.AddUint64(&server.connSeq, 1),
+			RemoteAddr: remote,
+			Labels:     opt.Labels,
+			Since:      time.Now(),
+		},
+		cc:      cc,
+		sending: new(sync.Mutex),
+		wg:      new(sync.WaitGroup),
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	sc.touch()
+	server.conns.Store(sc.info.ID, sc)
+	return sc
+}
+
+// admit 登记一个将要处理的请求，连接正在 Quiesce 时返回 false
+func (sc *serverConn) admit() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.quiescing {
+		return false
+	}
+	sc.wg.Add(1)
+	atomic.AddInt64(&sc.active, 1)
+	return true
+}
+
+// finish 与 admit 对应，请求回复（或放弃回复）后调用
+func (sc *serverConn) finish() {
+	atomic.AddInt64(&sc.active, -1)
+	sc.touch()
+	sc.wg.Done()
+}
+
+// Connections 返回当前所有连接的概要信息
+func (server *Server) Connections() []ConnInfo {
+	var conns []ConnInfo
+	server.conns.Range(func(_, sci interface{}) bool {
+		sc := sci.(*serverConn)
+		sc.mu.Lock()
+		info := sc.info
+		info.Quiescing = sc.quiescing
+		sc.mu.Unlock()
+		conns = append(conns, info)
+		return true
+	})
+	return conns
+}
+
+/*
+Quiesce
+优雅地关闭单个连接，不影响其他连接：
+ 1. 向客户端发送排空信号：Seq 为 0（不对应任何请求）、Error 为 ErrConnQuiescing 的响应，
+    客户端收到后不再发送新的请求（IsAvailable 返回 false），已发送的请求照常等待回复
+ 2. 之后读到的请求不再处理，直接回复 ErrConnQuiescing
+ 3. 等待正在处理的请求全部回复后关闭连接
+
+Quiesce 不等待连接关闭，立即返回；对同一个连接重复调用无副作用
+*/
+func (server *Server) Quiesce(id uint64) error {
+	sci, ok := server.conns.Load(id)
+	if !ok {
+		return errors.New("rpc server: no such connection")
+	}
+	sc := sci.(*serverConn)
+	sc.mu.Lock()
+	if sc.quiescing {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.quiescing = true
+	sc.mu.Unlock()
+
+	server.sendResponse(sc.cc, &codec.Header{Error: ErrConnQuiescing.Error()}, invalidRequest, sc.sending)
+	go func() {
+		sc.wg.Wait()
+		_ = sc.cc.Close()
+	}()
+	return nil
+}
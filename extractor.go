@@ -0,0 +1,53 @@
+package myGoRPC
+
+import "context"
+
+/*
+元数据提取器
+
+MetadataExtractor 在调用方法之前，把请求元数据中的字段（如租户、用户）转换为 ctx 中带类型的值，
+方法与拦截器直接从 ctx 读取，不必各自解析 MetadataFromContext：
+ 1. 通过 Server.UseExtractors 注册，按注册顺序依次执行，后面的提取器收到前面返回的 ctx，可以使用其中的值
+ 2. 在服务端拦截器之前执行，拦截器看到的 ctx 已经包含提取的值；流式调用的 handler 同样适用
+ 3. 返回错误时不再调用方法，该错误作为回复（可以用 *RPCError 指定 Code，如 CodeBadArgument）
+
+md 为请求的元数据，没有时为 nil，不应修改
+*/
+type MetadataExtractor func(ctx context.Context, md map[string]string) (context.Context, error)
+
+// UseExtractors 注册提取器，排在已注册的提取器之后；只影响之后到达的请求
+func (server *Server) UseExtractors(extractors ...MetadataExtractor) {
+	server.interceptorMu.Lock()
+	defer server.interceptorMu.Unlock()
+	// 复制而不是追加，进行中的请求持有的切片不受影响
+	chain := make([]MetadataExtractor, 0, len(server.extractors)+len(extractors))
+	chain = append(chain, server.extractors...)
+	server.extractors = append(chain, extractors...)
+}
+
+/*
+ExtractMetadata
+常用的提取器：元数据中存在 name 时，将其值（string）以 key 存入 ctx，handler 以 ctx.Value(key).(string) 读取
+*/
+func ExtractMetadata(name string, key interface{}) MetadataExtractor {
+	return func(ctx context.Context, md map[string]string) (context.Context, error) {
+		if v, ok := md[name]; ok {
+			ctx = context.WithValue(ctx, key, v)
+		}
+		return ctx, nil
+	}
+}
+
+// extract 依次执行提取器，返回第一个错误
+func (server *Server) extract(ctx context.Context, md map[string]string) (context.Context, error) {
+	server.interceptorMu.Lock()
+	chain := server.extractors
+	server.interceptorMu.Unlock()
+	for _, extractor := range chain {
+		var err error
+		if ctx, err = extractor(ctx, md); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
@@ -0,0 +1,107 @@
+package myGoRPC
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+故障注入，用于验证客户端的重试、超时、熔断等逻辑
+
+只有使用 -tags faultinject 编译时才会生效（见 fault_on.go），
+默认编译下 SetRules 返回 ErrFaultInjectionDisabled，注入逻辑被编译器消除，不会误用于生产环境
+*/
+
+var ErrFaultInjectionDisabled = errors.New("rpc server: fault injection disabled, build with -tags faultinject")
+
+// errDropResponse 注入“响应丢失”时返回，handleRequest 不回复该请求
+var errDropResponse = errors.New("rpc server: fault injected, response dropped")
+
+/*
+FaultRule
+Service、Method 为空时匹配全部；Fraction 为命中比例，取值 0~1
+命中后先等待 Delay，然后 Drop 为 true 时不回复，Error 非空时返回该错误而不调用方法
+*/
+type FaultRule struct {
+	Service  string
+	Method   string
+	Fraction float64
+	Delay    time.Duration
+	Error    string
+	Drop     bool
+}
+
+func (r *FaultRule) match(service, method string) bool {
+	return (r.Service == "" || r.Service == service) && (r.Method == "" || r.Method == method)
+}
+
+// FaultInjector 保存运行时可替换的故障规则，并发安全
+type FaultInjector struct {
+	mu    sync.Mutex
+	r     *rand.Rand
+	rules []FaultRule
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// SetRules 替换全部规则，按顺序匹配，第一个匹配且命中的规则生效；传入 nil 清除全部规则
+func (f *FaultInjector) SetRules(rules []FaultRule) error {
+	if !faultInjectionEnabled {
+		return ErrFaultInjectionDisabled
+	}
+	for _, rule := range rules {
+		if rule.Fraction < 0 || rule.Fraction > 1 {
+			return errors.New("rpc server: fault fraction must be in [0, 1]")
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append([]FaultRule(nil), rules...)
+	return nil
+}
+
+// snapshot 返回当前规则的副本，f 为 nil 时返回 nil
+func (f *FaultInjector) snapshot() []FaultRule {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FaultRule(nil), f.rules...)
+}
+
+/*
+inject
+在调用方法之前执行，返回非 nil 时不再调用方法：
+errDropResponse 表示不回复，其余 error 作为该请求的错误回复
+*/
+func (f *FaultInjector) inject(service, method string) error {
+	if !faultInjectionEnabled || f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	var hit *FaultRule
+	for i := range f.rules {
+		if f.rules[i].match(service, method) && f.r.Float64() < f.rules[i].Fraction {
+			rule := f.rules[i]
+			hit = &rule
+			break
+		}
+	}
+	f.mu.Unlock()
+	if hit == nil {
+		return nil
+	}
+	time.Sleep(hit.Delay)
+	switch {
+	case hit.Drop:
+		return errDropResponse
+	case hit.Error != "":
+		return errors.New(hit.Error)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !faultinject
+// +build !faultinject
+
+package myGoRPC
+
+const faultInjectionEnabled = false
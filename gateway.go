@@ -0,0 +1,217 @@
+package myGoRPC
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"myGoRPC/codec"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+HTTP/JSON 网关
+
+Gateway 将已注册的服务以普通 HTTP 暴露，便于用 curl 调试，或者让没有 RPC 客户端的系统接入：
+
+	curl -X POST -d '{"Num1":1,"Num2":2}' http://localhost:9999/rpc/Foo/Sum
+
+ 1. 请求：POST <Prefix><Service>/<Method>，body 为入参的 JSON（为空时即为 null）
+ 2. 回复：200 时 body 为返回值的 JSON；出错时为 {"code":..., "error":..., "detail":...}，状态码见 gatewayStatus
+ 3. 元数据：Authorization 与 Rpc-Metadata-<Key> 的请求头作为请求的元数据（键为小写），
+    trailer 以 Rpc-Trailer-<Key> 的响应头返回；Rpc-Timeout（如 "500ms"）限制调用的时长
+
+网关以 RawType 的进程内连接（见 NewInProcClient）调用 server，body 原样转发，由服务端按方法的类型解码，
+与其他客户端一样经过握手（Token 即为 Option.Token）、拦截器、过载保护与统计；流式方法与订阅不能通过网关调用
+*/
+
+const (
+	DefaultGatewayPath    = "/rpc/"
+	DefaultGatewayMaxBody = 4 << 20
+)
+
+const (
+	gatewayMetadataPrefix = "Rpc-Metadata-"
+	gatewayTrailerPrefix  = "Rpc-Trailer-"
+)
+
+type Gateway struct {
+	Prefix      string // 路径前缀，默认 DefaultGatewayPath
+	Token       string // 连接 server 时的 Option.Token
+	MaxBodySize int64  // 请求 body 的字节数上限，默认 DefaultGatewayMaxBody
+
+	server *Server
+	mu     sync.Mutex
+	client *Client
+}
+
+func NewGateway(server *Server) *Gateway {
+	return &Gateway{server: server}
+}
+
+// HandleGateway 在 http.DefaultServeMux 的 prefix 下挂载网关，prefix 为空时使用 DefaultGatewayPath
+func (server *Server) HandleGateway(prefix string) {
+	if prefix == "" {
+		prefix = DefaultGatewayPath
+	}
+	g := NewGateway(server)
+	g.Prefix = prefix
+	http.Handle(prefix, g)
+	server.logger().Log(LevelDebug, "rpc server: gateway path", F("path", prefix))
+}
+
+// gatewayError 出错时回复的 body
+type gatewayError struct {
+	Code   int    `json:"code"`
+	Error  string `json:"error"`
+	Detail string `json:"detail,omitempty"`
+	// 服务端标记为可以重试的错误，见 RPCError.Retryable
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+// gatewayStatus 错误分类对应的 HTTP 状态码
+func gatewayStatus(code int) int {
+	switch code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeBadArgument:
+		return http.StatusBadRequest
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayError(w, http.StatusMethodNotAllowed, NewError(CodeBadArgument, "rpc gateway: must POST"))
+		return
+	}
+	prefix := g.Prefix
+	if prefix == "" {
+		prefix = DefaultGatewayPath
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, prefix), "/")
+	if !strings.HasPrefix(req.URL.Path, prefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeGatewayError(w, http.StatusNotFound, NewError(CodeNotFound, "rpc gateway: expect %sService/Method, got %s", prefix, req.URL.Path))
+		return
+	}
+	if parts[0] == pubsubService {
+		writeGatewayError(w, http.StatusNotFound, NewError(CodeNotFound, "rpc gateway: can't call service %s", parts[0]))
+		return
+	}
+
+	maxBody := g.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = DefaultGatewayMaxBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBody))
+	if err != nil {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge, NewError(CodeBadArgument, "rpc gateway: read body: %v", err))
+		return
+	}
+	if len(body) == 0 {
+		body = []byte("null")
+	} else if !json.Valid(body) {
+		writeGatewayError(w, http.StatusBadRequest, NewError(CodeBadArgument, "rpc gateway: body is not valid JSON"))
+		return
+	}
+
+	ctx, cancel, err := gatewayContext(req)
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer cancel()
+	client, err := g.conn()
+	if err != nil {
+		writeGatewayError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	var trailer map[string]string
+	var reply []byte
+	err = client.Call(WithTrailer(ctx, &trailer), parts[0], parts[1], body, &reply)
+	for k, v := range trailer {
+		w.Header().Set(gatewayTrailerPrefix+k, v)
+	}
+	if err != nil {
+		writeGatewayError(w, gatewayStatus(Code(err)), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(reply)
+}
+
+// gatewayContext 请求的 ctx，附加请求头中的元数据与 Rpc-Timeout
+func gatewayContext(req *http.Request) (context.Context, context.CancelFunc, error) {
+	ctx := req.Context()
+	md := make(map[string]string)
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, gatewayMetadataPrefix) && len(name) > len(gatewayMetadataPrefix) {
+			md[strings.ToLower(name[len(gatewayMetadataPrefix):])] = values[0]
+		}
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		md[AuthMetadataKey] = auth
+	}
+	if len(md) > 0 {
+		ctx = WithMetadata(ctx, md)
+	}
+	t := req.Header.Get("Rpc-Timeout")
+	if t == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	d, err := time.ParseDuration(t)
+	if err != nil || d <= 0 {
+		return nil, nil, NewError(CodeBadArgument, "rpc gateway: invalid Rpc-Timeout %q", t)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, nil
+}
+
+// conn 到 server 的进程内连接，断开后重新建立
+func (g *Gateway) conn() (*Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil && g.client.IsAvailable() {
+		return g.client, nil
+	}
+	client, err := NewInProcClient(g.server, &Option{CodecType: codec.RawType, Token: g.Token})
+	if err != nil {
+		return nil, NewError(CodeUnavailable, "rpc gateway: %v", err)
+	}
+	g.client = client
+	return client, nil
+}
+
+// Close 关闭到 server 的连接，之后的请求重新建立
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client == nil {
+		return nil
+	}
+	err := g.client.Close()
+	g.client = nil
+	return err
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, err error) {
+	body := gatewayError{Code: Code(err), Error: err.Error()}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		body.Detail, body.Retryable = rpcErr.Detail, rpcErr.Retryable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
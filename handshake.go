@@ -0,0 +1,378 @@
+package myGoRPC
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myGoRPC/codec"
+	"net"
+)
+
+/*
+握手协议版本
+
+V0: 最初的协议，客户端 JSON 编码发送 Option（其中没有 Version 字段），服务端不回复，直接进入 codec 阶段
+V1: 客户端在 Option 中携带 Version，服务端校验后回复 handshakeReply，告知协商后的版本（双方支持的最高版本的较小值）或拒绝的原因，客户端收到回复后才进入 codec 阶段
+V2: 与 V1 相同，但 Option、handshakeReply 都分帧发送（见 writeHandshakeFrame）：魔数 + 版本 + 长度前缀的 JSON，不再依赖 JSON 解码器的边界，对端不是 myGoRPC 时在第一个字节即可识别
+
+兼容性：
+  - 服务端根据第一个字节区分分帧（V2）与 JSON（V0、V1）的握手，按客户端使用的格式回复；JSON 握手至多协商到 V1
+  - 新服务端 + 旧客户端：解码得到的 Version 为 0，按 V0 处理，不回复
+  - 旧服务端 + 新客户端：V1 服务端无法解析分帧的握手，会关闭连接，需要设置 Option.JSONHandshake，按 V1 握手；
+    V0 服务端忽略 Version 字段，也不会回复，客户端会一直等到 ConnectTimeout，需要设置 Option.LegacyHandshake，按 V0 握手
+*/
+const (
+	HandshakeV0      = 0
+	HandshakeV1      = 1
+	HandshakeV2      = 2
+	HandshakeVersion = HandshakeV2 // 当前实现支持的最高版本
+)
+
+// handshakeMagic 分帧握手的魔数，首字节不是 JSON 可能的开头
+var handshakeMagic = [4]byte{0x85, 'R', 'P', 'C'}
+
+// handshakeMaxPayload 分帧握手中 JSON 的字节数上限
+const handshakeMaxPayload = 1 << 16
+
+// ErrProtocolMismatch 对端不是 myGoRPC，或使用了无法识别的握手格式
+var ErrProtocolMismatch = errors.New("rpc: protocol mismatch")
+
+/*
+writeHandshakeFrame
+分帧握手的一帧：4 字节魔数 + 1 字节版本 + 4 字节长度（大端）+ JSON，一次写入
+*/
+func writeHandshakeFrame(w io.Writer, version int, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	frame := append(handshakeMagic[:], byte(version), 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[5:], uint32(len(payload)))
+	_, err = w.Write(append(frame, payload...))
+	return err
+}
+
+// readHandshakeFrame 读取 writeHandshakeFrame 写入的一帧，JSON 解码到 v，返回帧中的版本
+func readHandshakeFrame(r io.Reader, v interface{}) (int, error) {
+	var head [9]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(head[:4], handshakeMagic[:]) {
+		return 0, fmt.Errorf("%w: unexpected handshake bytes % x", ErrProtocolMismatch, head[:4])
+	}
+	size := binary.BigEndian.Uint32(head[5:])
+	if size > handshakeMaxPayload {
+		return 0, fmt.Errorf("%w: handshake payload of %d bytes exceeds %d", ErrProtocolMismatch, size, handshakeMaxPayload)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProtocolMismatch, err)
+	}
+	return int(head[4]), nil
+}
+
+// handshakeReply V1 及以上版本，服务端对 Option 的回复
+type handshakeReply struct {
+	Version  int
+	Error    string
+	StartTLS bool   // 服务端同意升级为 TLS，见 Option.StartTLS
+	Session  string // 会话令牌，见 session.go
+	// 服务端选定的编解码方式；不支持客户端请求的编解码方式时为空，Codecs 列出服务端支持的全部
+	CodecType codec.Type
+	Codecs    []string
+	// 服务端确认逐条消息压缩，与 Option.CompressionThreshold 相同，见 compress.go
+	CompressionThreshold int `json:",omitempty"`
+	// 服务端确认分块传输，与 Option.ChunkSize 相同，见 message.go
+	ChunkSize int `json:",omitempty"`
+	// 该连接上同时打开的流的上限，即 Server.MaxConcurrentStreams，0 即为不限制；客户端据此自行限制，见 stream.go
+	MaxConcurrentStreams int `json:",omitempty"`
+}
+
+// ErrUnsupportedCodec 客户端或服务端不支持 Option.CodecType
+var ErrUnsupportedCodec = errors.New("rpc client: unsupported codec type")
+
+func supportedCodecs() []string {
+	var types []string
+	for _, t := range codec.Types() {
+		types = append(types, string(t))
+	}
+	return types
+}
+
+/*
+clientHandshake
+发送 Option，按版本等待服务端的回复，返回供 codec 使用的连接，以及服务端的回复（会话令牌、流的上限等），V0 时为零值
+Option.StartTLS 时，收到服务端同意升级的回复后，在同一连接上完成 TLS 握手
+*/
+func clientHandshake(conn net.Conn, opt *Option) (io.ReadWriteCloser, handshakeReply, error) {
+	var reply handshakeReply
+	sent := *opt
+	if opt.JSONHandshake && sent.Version > HandshakeV1 {
+		sent.Version = HandshakeV1
+	}
+	if opt.LegacyHandshake {
+		sent.Version = HandshakeV0
+	}
+	if sent.StartTLS && (sent.Version == HandshakeV0 || opt.TLSConfig == nil) {
+		return nil, reply, errors.New("starttls requires a versioned handshake and Option.TLSConfig")
+	}
+	if _, err := lookupCompression(sent.Compression); err != nil {
+		return nil, reply, err
+	}
+	if sent.Version == HandshakeV0 && sent.Compression != "" && sent.Compression != CompressionNone {
+		return nil, reply, errCompressionNeedsHandshake
+	}
+	if sent.ChunkSize > 0 && sent.Version == HandshakeV0 {
+		return nil, reply, errors.New("Option.ChunkSize requires a versioned handshake")
+	}
+	if sent.ChunkSize > 0 && sent.CodecType == codec.ProtobufType {
+		return nil, reply, errChunkProtobuf
+	}
+	var rwc handshakeConn
+	if sent.Version >= HandshakeV2 {
+		if err := writeHandshakeFrame(conn, sent.Version, &sent); err != nil {
+			return nil, reply, err
+		}
+		r := bufio.NewReader(conn)
+		if _, err := readHandshakeFrame(r, &reply); err != nil {
+			if err == io.EOF {
+				// V1 及更早的服务端无法解析分帧的握手，直接关闭连接
+				err = fmt.Errorf("%w: server closed the connection, it may not support the framed handshake (Option.JSONHandshake)", ErrProtocolMismatch)
+			}
+			return nil, reply, fmt.Errorf("reading handshake reply: %w", err)
+		}
+		rwc = handshakeConn{r, conn}
+	} else {
+		if err := json.NewEncoder(conn).Encode(&sent); err != nil {
+			return nil, reply, err
+		}
+		if sent.Version == HandshakeV0 {
+			return conn, reply, nil
+		}
+		dec := json.NewDecoder(conn)
+		if err := dec.Decode(&reply); err != nil {
+			return nil, reply, fmt.Errorf("reading handshake reply: %v", err)
+		}
+		rwc = newHandshakeConn(dec, conn)
+	}
+	if len(reply.Codecs) > 0 {
+		return nil, reply, fmt.Errorf("%w %q, server supports %v", ErrUnsupportedCodec, sent.CodecType, reply.Codecs)
+	}
+	if reply.Error != "" {
+		if err := authError(reply.Error); err != nil {
+			return nil, reply, err
+		}
+		return nil, reply, errors.New(reply.Error)
+	}
+	if reply.Version < HandshakeV1 || reply.Version > sent.Version {
+		return nil, reply, fmt.Errorf("server negotiated unsupported handshake version %d", reply.Version)
+	}
+	// 不支持逐条压缩的旧服务端会按整个数据流压缩，两端的格式不一致
+	if compressed := sent.Compression != "" && sent.Compression != CompressionNone; compressed &&
+		sent.CompressionThreshold > 0 && reply.CompressionThreshold != sent.CompressionThreshold {
+		return nil, reply, errors.New("server does not support Option.CompressionThreshold")
+	}
+	if sent.ChunkSize > 0 && reply.ChunkSize != sent.ChunkSize {
+		return nil, reply, errors.New("server does not support Option.ChunkSize")
+	}
+	if !sent.StartTLS {
+		return rwc, reply, nil
+	}
+	// 不支持升级的服务端不会在回复中确认 StartTLS，此时不能发送 TLS 握手报文
+	if !reply.StartTLS {
+		return nil, reply, errors.New("server does not support starttls")
+	}
+	tlsConn := tls.Client(&peekedConn{Conn: conn, r: rwc.Reader}, opt.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, reply, fmt.Errorf("starttls handshake: %v", err)
+	}
+	return tlsConn, reply, nil
+}
+
+/*
+handshake
+读取并校验 Option，V1 及以上版本回复 handshakeReply，返回 Option、codec 的构造函数，以及供 codec 使用的连接
+RpcNumber 不匹配说明对方不是 myGoRPC 客户端，不做回复
+
+客户端请求 StartTLS 时，服务端需要配置 TLSConfig 且 conn 为 net.Conn，
+否则回复错误并关闭连接；同意升级时先回复，再在同一连接上完成 TLS 握手
+*/
+func (server *Server) handshake(conn io.ReadWriteCloser) (*Option, codec.NewCodecFunc, io.ReadWriteCloser, error) {
+	var opt Option
+	var rwc handshakeConn
+	br := bufio.NewReader(conn)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("options decode error: %v", err)
+	}
+	framed := first[0] == handshakeMagic[0]
+	if framed {
+		version, err := readHandshakeFrame(br, &opt)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// 以帧中的版本为准
+		opt.Version = version
+		rwc = handshakeConn{br, conn}
+	} else {
+		dec := json.NewDecoder(br)
+		if err := dec.Decode(&opt); err != nil {
+			return nil, nil, nil, fmt.Errorf("%w: options decode error: %v", ErrProtocolMismatch, err)
+		}
+		rwc = newHandshakeConn(dec, handshakeConn{br, conn})
+	}
+	if opt.RpcNumber != RpcNumber {
+		return nil, nil, nil, fmt.Errorf("%w: invalid rpc number %x", ErrProtocolMismatch, opt.RpcNumber)
+	}
+	opt.Labels = server.sanitizeLabels(opt.Labels)
+	if opt.Version < HandshakeV0 {
+		opt.Version = HandshakeV0
+	}
+	// JSON 握手至多为 V1，分帧握手至少为 V2
+	switch {
+	case !framed && opt.Version > HandshakeV1:
+		opt.Version = HandshakeV1
+	case framed && opt.Version < HandshakeV2:
+		opt.Version = HandshakeV2
+	case opt.Version > HandshakeVersion:
+		opt.Version = HandshakeVersion
+	}
+
+	f := codec.Get(opt.CodecType)
+	if f == nil {
+		err = fmt.Errorf("invalid codec type %s", opt.CodecType)
+	}
+	netConn, isNetConn := conn.(net.Conn)
+	if err == nil && opt.StartTLS && (server.TLSConfig == nil || !isNetConn) {
+		err = errors.New("starttls not supported")
+	}
+	if err == nil {
+		_, err = lookupCompression(opt.Compression)
+	}
+	if err == nil {
+		err = server.authenticate(conn, opt.Token)
+	}
+	// 凭证只在握手时使用，不随连接保留
+	opt.Token = ""
+	// V0 没有回复，无法下发会话令牌
+	switch {
+	case err != nil || opt.Version < HandshakeV1:
+		opt.Session = ""
+	case opt.Session != "":
+		if server.lookupSession(opt.Session) == nil {
+			err = errUnknownSession
+		}
+	case opt.Resumable:
+		opt.Session, err = server.createSession()
+	}
+	// 分块传输需要在回复中确认，protobuf 无法传输 []byte 的块
+	if opt.Version < HandshakeV1 || opt.CodecType == codec.ProtobufType {
+		opt.ChunkSize = 0
+	}
+	if opt.Version >= HandshakeV1 {
+		reply := handshakeReply{Version: opt.Version, StartTLS: err == nil && opt.StartTLS, CompressionThreshold: opt.CompressionThreshold,
+			ChunkSize: opt.ChunkSize, MaxConcurrentStreams: server.MaxConcurrentStreams}
+		if f == nil {
+			reply.Codecs = supportedCodecs()
+		} else {
+			reply.CodecType = opt.CodecType
+		}
+		if err != nil {
+			reply.Error = "rpc server: " + err.Error()
+		} else {
+			reply.Session = opt.Session
+		}
+		var werr error
+		if framed {
+			werr = writeHandshakeFrame(conn, opt.Version, &reply)
+		} else {
+			werr = json.NewEncoder(conn).Encode(&reply)
+		}
+		if werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !opt.StartTLS {
+		return &opt, f, rwc, nil
+	}
+	tlsConn := tls.Server(&peekedConn{Conn: netConn, r: rwc.Reader}, server.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, nil, fmt.Errorf("starttls handshake: %v", err)
+	}
+	return &opt, f, tlsConn, nil
+}
+
+/*
+handshakeConn
+json.Decoder 解码时可能多读取了紧随其后的 header/body 字节，
+将其缓冲区与原始连接拼接起来，交还给后续的 Codec；
+json.Encoder 在末尾追加的换行符需要跳过，否则会被当作 codec 的数据
+*/
+type handshakeConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c handshakeConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func newHandshakeConn(dec *json.Decoder, conn io.ReadWriteCloser) handshakeConn {
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	return handshakeConn{r, conn}
+}
+
+/*
+PeekOption
+供透明代理使用：读取连接开头的 Option，得到客户端协商的编解码方式（codec.Get(opt.CodecType)），
+但不消耗任何字节，返回的连接会先重放已读取的全部字节（Option 以及可能多读的后续数据），
+代理可以将其原样转发给下游服务端，由下游完成真正的握手
+
+不会回复客户端，V1 及以上版本的握手回复由下游服务端发出
+*/
+func PeekOption(conn net.Conn) (*Option, net.Conn, error) {
+	var buf bytes.Buffer
+	var opt Option
+	tee := io.TeeReader(conn, &buf)
+	var first [1]byte
+	if _, err := io.ReadFull(tee, first[:]); err != nil {
+		return nil, nil, fmt.Errorf("rpc proxy: options decode error: %v", err)
+	}
+	r := io.MultiReader(bytes.NewReader(first[:]), tee)
+	if first[0] == handshakeMagic[0] {
+		if _, err := readHandshakeFrame(r, &opt); err != nil {
+			return nil, nil, fmt.Errorf("rpc proxy: %w", err)
+		}
+	} else if err := json.NewDecoder(r).Decode(&opt); err != nil {
+		return nil, nil, fmt.Errorf("rpc proxy: options decode error: %v", err)
+	}
+	if opt.RpcNumber != RpcNumber {
+		return nil, nil, fmt.Errorf("rpc proxy: invalid rpc number %x", opt.RpcNumber)
+	}
+	return &opt, &peekedConn{Conn: conn, r: io.MultiReader(&buf, conn)}, nil
+}
+
+// peekedConn 读取时先返回 PeekOption 已读取的字节
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,86 @@
+package myGoRPC
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+/*
+心跳
+
+receive 只有在读取失败时才会发现连接断开，空闲的半开连接可能长时间不被发现。
+Option.HeartbeatInterval 大于 0 时，客户端每隔该时长发送一个 ping（保留的服务名 heartbeatService），
+HeartbeatTimeout（默认等于 HeartbeatInterval）内没有收到回复记为一次丢失，连续 HeartbeatMaxMisses（默认 1）次丢失即关闭连接，
+pending 中的请求以 ErrHeartbeatTimeout 失败，Client 变为不可用；开启了会话恢复（Option.Resumable）时改为尝试恢复
+
+ping 与普通请求一样经由 send 发送，持有 sending，不会与其他请求的报文交织；不触发 OnStart、OnFinish
+服务端直接回复 ping，不经过过载保护、并发限制；不认识 heartbeatService 的旧服务端会回复“找不到服务”的错误，同样视为存活
+
+收到任何请求的回复都说明这个连接是通的：最近一个 HeartbeatInterval 内收到过回复的连接跳过这一次 ping，
+繁忙的连接不产生额外的心跳流量；没有回复的连接（包括 pending 中的请求迟迟得不到回复的半开连接）照常 ping。
+DialPool 的连接可以由连接池统一调度心跳，见 Option.SharedHeartbeat 与 pool.go
+*/
+
+const heartbeatService = "_heartbeat"
+
+var ErrHeartbeatTimeout = errors.New("rpc client: heartbeat timeout")
+
+func (client *Client) heartbeat(interval, timeout time.Duration, maxMisses int) {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	if maxMisses <= 0 {
+		maxMisses = 1
+	}
+	misses := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.terminated:
+			return
+		case <-ticker.C:
+		}
+		if !client.quietFor(interval) || client.ping(timeout) {
+			misses = 0
+			continue
+		}
+		if misses++; misses < maxMisses {
+			continue
+		}
+		misses = 0
+		// 会话恢复会替换 cc，继续心跳
+		client.heartbeatFailed()
+	}
+}
+
+// quietFor 超过 d 没有收到任何请求的回复
+func (client *Client) quietFor(d time.Duration) bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&client.lastReply))) >= d
+}
+
+// ping 发送一个心跳并等待回复，timeout 内没有回复时返回 false；连接已经出错时由 receive 负责结束，返回 true
+func (client *Client) ping(timeout time.Duration) bool {
+	atomic.AddUint64(&client.pings, 1)
+	call := newCall(heartbeatService, "Ping", invalidRequest, nil, make(chan *Call, 1))
+	client.send(call)
+	select {
+	case <-call.Done:
+		return true
+	case <-client.terminated:
+		return true
+	case <-time.After(timeout):
+		call.Cancel()
+		return false
+	}
+}
+
+// heartbeatFailed 连续丢失心跳：关闭连接，pending 中的请求以 ErrHeartbeatTimeout 失败
+func (client *Client) heartbeatFailed() {
+	client.mu.Lock()
+	client.heartbeatErr = ErrHeartbeatTimeout
+	cc := client.cc
+	client.mu.Unlock()
+	_ = cc.Close()
+}
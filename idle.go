@@ -0,0 +1,46 @@
+package myGoRPC
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+空闲连接
+
+客户端异常退出、网络中断后，服务端的连接可能处于半开状态，readRequest 一直阻塞，连接与协程无法回收。
+Server.IdleTimeout 大于 0 时，连接没有正在处理的请求、且超过 IdleTimeout 没有收到任何请求时关闭连接；
+开启心跳（Option.HeartbeatInterval 小于 IdleTimeout）的客户端不会因空闲被关闭
+*/
+
+// touch 记录连接的最近活动时间
+func (sc *serverConn) touch() {
+	atomic.StoreInt64(&sc.lastActive, time.Now().UnixNano())
+}
+
+// idleFor 连接空闲的时长，有正在处理的请求时为 0
+func (sc *serverConn) idleFor(now time.Time) time.Duration {
+	if atomic.LoadInt64(&sc.active) > 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&sc.lastActive)))
+}
+
+// watchIdle 定期检查连接是否空闲，直到 stop 关闭（serveCodec 返回）
+func (server *Server) watchIdle(sc *serverConn, stop <-chan struct{}) {
+	timeout := server.IdleTimeout
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if sc.idleFor(now) >= timeout {
+				server.logger().Log(LevelInfo, "rpc server: closing idle connection", F("remote", sc.info.RemoteAddr))
+				_ = sc.cc.Close()
+				return
+			}
+		}
+	}
+}
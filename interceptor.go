@@ -0,0 +1,231 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+Invoker
+完成一次调用：发送请求并等待回复（或 ctx 结束），返回调用的错误
+*/
+type Invoker func(ctx context.Context, call *Call) error
+
+/*
+Interceptor
+客户端拦截器，包裹 Client.Call 的一次调用，通过 Client.Use 注册
+可以在调用 next 之前修改 call.Args、在 call.Metadata 中添加随请求头发送的元数据，
+在 next 返回之后检查 call.Reply；不调用 next 而直接返回错误即为短路，请求不会发送
+
+多个拦截器按注册顺序由外向内执行，返回时按相反顺序展开
+只作用于 Client.Call，Go 发起的异步请求与心跳不经过拦截器
+*/
+type Interceptor func(ctx context.Context, call *Call, next Invoker) error
+
+// Use 注册拦截器，排在已注册的拦截器之后；只影响之后发起的调用
+func (client *Client) Use(interceptors ...Interceptor) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	// 复制而不是追加，进行中的调用持有的切片不受影响
+	chain := make([]Interceptor, 0, len(client.interceptors)+len(interceptors))
+	chain = append(chain, client.interceptors...)
+	client.interceptors = append(chain, interceptors...)
+}
+
+// intercept 由外向内组装拦截器，最内层为 invoke
+func (client *Client) intercept(invoke Invoker) Invoker {
+	client.mu.Lock()
+	chain := client.interceptors
+	client.mu.Unlock()
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], invoke
+		invoke = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return invoke
+}
+
+// AuthMetadataKey AuthInterceptor 写入的元数据键
+const AuthMetadataKey = "authorization"
+
+/*
+AuthInterceptor
+示例拦截器：为每个请求附加认证令牌，服务端通过 MetadataFromContext(ctx)[AuthMetadataKey] 读取
+*/
+func AuthInterceptor(token string) Interceptor {
+	return func(ctx context.Context, call *Call, next Invoker) error {
+		if call.Metadata == nil {
+			call.Metadata = make(map[string]string)
+		}
+		call.Metadata[AuthMetadataKey] = token
+		return next(ctx, call)
+	}
+}
+
+type metadataKey struct{}
+
+type outgoingMetadataKey struct{}
+
+/*
+WithMetadata
+返回携带元数据的 ctx，以其发起的 Client.Call 将元数据随请求头发送；多次调用时合并，后设置的同名键覆盖之前的
+服务端收到的元数据（MetadataFromContext）不会自动传给下游调用，需要时显式传递
+*/
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string, len(md))
+	for k, v := range outgoingMetadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+// outgoingMetadata 返回 WithMetadata 设置的元数据的副本，拦截器修改 call.Metadata 不影响 ctx
+func outgoingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(map[string]string)
+	if len(md) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(md))
+	for k, v := range md {
+		copied[k] = v
+	}
+	return copied
+}
+
+/*
+MetadataFromContext
+服务端方法（第一个入参为 context.Context）中读取请求头携带的元数据，没有时返回 nil
+返回的 map 不应修改
+*/
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+/*
+trailer
+方法处理期间设置的 trailer，HandleTimeout 超时回复时方法可能仍在设置，mu 保护
+*/
+type trailer struct {
+	mu sync.Mutex
+	md map[string]string
+}
+
+type trailerKey struct{}
+
+type trailerTargetKey struct{}
+
+// get 返回设置的 trailer 的副本，t 为 nil 时返回 nil
+func (t *trailer) get() map[string]string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.md) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(t.md))
+	for k, v := range t.md {
+		copied[k] = v
+	}
+	return copied
+}
+
+/*
+SetTrailer
+服务端方法（第一个入参为 context.Context）中设置随回复返回的元数据（trailer），多次调用时合并，后设置的同名键覆盖之前的；
+成功与出错的回复都会携带，客户端通过 Call.Trailer 或 WithTrailer 读取。ctx 不是请求的 ctx（或其派生）时返回错误
+*/
+func SetTrailer(ctx context.Context, md map[string]string) error {
+	t, _ := ctx.Value(trailerKey{}).(*trailer)
+	if t == nil {
+		return errors.New("rpc server: SetTrailer called outside of a request")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.md == nil {
+		t.md = make(map[string]string, len(md))
+	}
+	for k, v := range md {
+		t.md[k] = v
+	}
+	return nil
+}
+
+// WithTrailer 以 ctx 发起的 Client.Call 收到回复后，将服务端设置的 trailer 写入 *md（没有时为 nil）
+func WithTrailer(ctx context.Context, md *map[string]string) context.Context {
+	return context.WithValue(ctx, trailerTargetKey{}, md)
+}
+
+// ServiceMethodInfo 服务端拦截器看到的一次请求，Args 为解码后的入参
+type ServiceMethodInfo struct {
+	Service    string
+	Method     string
+	Seq        uint64
+	Args       interface{}
+	RemoteAddr string
+}
+
+// Handler 调用服务端方法（经过缓存、并发限制），ctx 即为传给方法的 ctx
+type Handler func(ctx context.Context, info ServiceMethodInfo) error
+
+/*
+ServerInterceptor
+服务端拦截器，包裹一次方法调用，通过 Server.Use 注册；ctx 携带请求的 deadline 与元数据（MetadataFromContext）
+不调用 handler 而直接返回错误即为拒绝，该错误回复给客户端，返回 *RPCError 时按其 Code 分类；
+调用 handler 时可以传入派生的 ctx（如附加追踪信息），方法收到的即为该 ctx
+
+多个拦截器按注册顺序由外向内执行；拦截器内的 panic 与方法的 panic 相同，回复 CodePanic 的错误
+心跳、过载拒绝、解码失败的请求不经过拦截器
+*/
+type ServerInterceptor func(ctx context.Context, info ServiceMethodInfo, handler Handler) error
+
+// Use 注册服务端拦截器，排在已注册的拦截器之后；只影响之后开始处理的请求
+func (server *Server) Use(interceptors ...ServerInterceptor) {
+	server.interceptorMu.Lock()
+	defer server.interceptorMu.Unlock()
+	chain := make([]ServerInterceptor, 0, len(server.interceptors)+len(interceptors))
+	chain = append(chain, server.interceptors...)
+	server.interceptors = append(chain, interceptors...)
+}
+
+// intercept 执行元数据提取器，再经过拦截器链调用 limitedCall
+func (server *Server) intercept(req *request) error {
+	ctx, err := server.extract(req.ctx, req.md)
+	if err != nil {
+		return err
+	}
+	req.ctx = ctx
+	server.interceptorMu.Lock()
+	chain := server.interceptors
+	server.interceptorMu.Unlock()
+	if len(chain) == 0 {
+		return server.limitedCall(req)
+	}
+	handler := Handler(func(ctx context.Context, _ ServiceMethodInfo) error {
+		req.ctx = ctx
+		return server.limitedCall(req)
+	})
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], handler
+		handler = func(ctx context.Context, info ServiceMethodInfo) error {
+			return interceptor(ctx, info, next)
+		}
+	}
+	info := ServiceMethodInfo{
+		Service: req.header.Service,
+		Method:  req.header.Method,
+		Seq:     req.header.Seq,
+		Args:    req.argV.Interface(),
+	}
+	if req.sc != nil {
+		info.RemoteAddr = req.sc.info.RemoteAddr
+	}
+	return handler(req.ctx, info)
+}
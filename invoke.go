@@ -0,0 +1,29 @@
+package myGoRPC
+
+import (
+	"context"
+	"reflect"
+)
+
+/*
+Invoke
+以类型化的入参与返回值调用 service.method，省去调用方声明 reply 变量、传入指针：
+
+	n, err := Invoke[Args, int](ctx, client, "Arith", "Multiply", Args{A: 2, B: 3})
+
+Resp 为指针类型（如 *Reply）时分配其指向的值并直接作为 reply，否则以 &resp 作为 reply；
+出错时返回 Resp 的零值与 Call 的错误
+*/
+func Invoke[Req, Resp any](ctx context.Context, c *Client, service, method string, req Req) (Resp, error) {
+	var resp Resp
+	reply := interface{}(&resp)
+	if t := reflect.TypeOf(resp); t != nil && t.Kind() == reflect.Ptr {
+		resp = reflect.New(t.Elem()).Interface().(Resp)
+		reply = resp
+	}
+	if err := c.Call(ctx, service, method, req, reply); err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp, nil
+}
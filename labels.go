@@ -0,0 +1,68 @@
+package myGoRPC
+
+import (
+	"sort"
+	"strings"
+)
+
+/*
+连接标签
+客户端在 Option.Labels 中声明连接的逻辑属性（租户、环境、应用名等），服务端将其附加到该连接的日志上，
+便于按租户区分，而不是从地址去猜
+
+为避免指标、日志的维度爆炸，服务端在握手时裁剪标签：
+最多保留 maxConnLabels 个，键、值超过 maxLabelLength 的丢弃；
+配置了 Server.LabelKeys 时只保留其中列出的键
+*/
+const (
+	maxConnLabels  = 8
+	maxLabelLength = 64
+)
+
+func (server *Server) sanitizeLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if k == "" || len(k) > maxLabelLength || len(v) > maxLabelLength || !server.allowLabel(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	// 排序后截断，同样的标签总是保留同样的子集
+	sort.Strings(keys)
+	if len(keys) > maxConnLabels {
+		keys = keys[:maxConnLabels]
+	}
+	sanitized := make(map[string]string, len(keys))
+	for _, k := range keys {
+		sanitized[k] = labels[k]
+	}
+	return sanitized
+}
+
+func (server *Server) allowLabel(key string) bool {
+	if server.LabelKeys == nil {
+		return true
+	}
+	for _, k := range server.LabelKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// logPrefix 该连接的日志前缀，带有排序后的标签，如 "rpc server [app=foo tenant=a]"
+func logPrefix(opt *Option) string {
+	if len(opt.Labels) == 0 {
+		return "rpc server"
+	}
+	pairs := make([]string, 0, len(opt.Labels))
+	for k, v := range opt.Labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "rpc server [" + strings.Join(pairs, " ") + "]"
+}
@@ -0,0 +1,63 @@
+package myGoRPC
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+请求的最长存活时间（兜底）
+
+调用方没有设置超时、也没有读取 Done 时，没有回复的请求会一直留在 pending 中。
+Option.MaxCallLifetime 为兜底的上限，超过该时长仍未回复的请求以 ErrCallLifetimeExceeded 失败并从 pending 中移除；
+0 即为 DefaultMaxCallLifetime，小于 0 即为不限制
+
+序号按注册顺序递增，注册时间也随之递增，因此 sweeper 从上次停下的序号开始向后检查，
+遇到第一个未过期的请求即停止，每次的开销只与两次检查之间新分配的序号数有关，与 pending 的大小无关
+*/
+
+const DefaultMaxCallLifetime = 10 * time.Minute
+
+var ErrCallLifetimeExceeded = errors.New("rpc client: call exceeded max lifetime")
+
+func (client *Client) maxCallLifetime() time.Duration {
+	if client.option.MaxCallLifetime == 0 {
+		return DefaultMaxCallLifetime
+	}
+	return client.option.MaxCallLifetime
+}
+
+// sweepCalls 每隔 lifetime/4 检查一次，直到 client 终止
+func (client *Client) sweepCalls(lifetime time.Duration) {
+	ticker := time.NewTicker(lifetime / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.terminated:
+			return
+		case now := <-ticker.C:
+			client.expireCalls(now.Add(-lifetime))
+		}
+	}
+}
+
+// expireCalls 使 before 之前注册、仍未回复的请求失败
+func (client *Client) expireCalls(before time.Time) {
+	var expired []*Call
+	defer func() { failCalls(expired, ErrCallLifetimeExceeded) }()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	seq := client.sweepFrom
+	for ; seq < client.seq; seq++ {
+		call := client.pending[seq]
+		if call == nil {
+			continue
+		}
+		if call.registered.After(before) {
+			break
+		}
+		delete(client.pending, seq)
+		expired = append(expired, call)
+	}
+	client.sweepFrom = seq
+}
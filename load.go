@@ -0,0 +1,107 @@
+package myGoRPC
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+负载报告
+
+Server.ReportLoad 为 true 时，服务端在每个经过处理的请求的回复（含方法返回的错误）的 trailer 中附带 LoadTrailerKey，
+找不到方法、过载拒绝等没有经过处理的回复不附带；
+值为空格分隔的键值对，如 "inflight=3 queued=0 util=0.420"，解析时忽略未知的键，以便之后增加新的指标：
+ - inflight 正在处理的请求数，不含本次
+ - queued 等待 MaxConcurrentRequests 名额的请求数，见 workers.go
+ - util Server.Utilization 返回的利用率（0~1，如 CPU 使用率），未设置 Utilization 时不附带
+
+客户端收到带有负载报告的回复时记录下来，Client.LoadReport 返回最近一次的报告，
+xclient.LeastLoadSelect 据此与本地未结束的请求数一起选择实例；单个回复的报告也可以用 ParseLoadReport(call.Trailer) 读取
+*/
+
+// LoadTrailerKey 回复 trailer 中负载报告的键
+const LoadTrailerKey = "rpc-load"
+
+// LoadReport 服务端报告的负载
+type LoadReport struct {
+	Inflight    int64
+	Queued      int64
+	Utilization float64
+}
+
+func (r LoadReport) String() string {
+	s := "inflight=" + strconv.FormatInt(r.Inflight, 10) + " queued=" + strconv.FormatInt(r.Queued, 10)
+	if r.Utilization > 0 {
+		s += " util=" + strconv.FormatFloat(r.Utilization, 'f', 3, 64)
+	}
+	return s
+}
+
+// ParseLoadReport 从 trailer 中读取负载报告，没有报告或格式错误时返回 false
+func ParseLoadReport(md map[string]string) (LoadReport, bool) {
+	var r LoadReport
+	v, ok := md[LoadTrailerKey]
+	if !ok {
+		return r, false
+	}
+	for _, kv := range strings.Fields(v) {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return LoadReport{}, false
+		}
+		var err error
+		switch kv[:i] {
+		case "inflight":
+			r.Inflight, err = strconv.ParseInt(kv[i+1:], 10, 64)
+		case "queued":
+			r.Queued, err = strconv.ParseInt(kv[i+1:], 10, 64)
+		case "util":
+			r.Utilization, err = strconv.ParseFloat(kv[i+1:], 64)
+		}
+		if err != nil {
+			return LoadReport{}, false
+		}
+	}
+	return r, true
+}
+
+// loadReport 服务端当前的负载
+func (server *Server) loadReport() LoadReport {
+	r := LoadReport{Inflight: atomic.LoadInt64(&server.inflight), Queued: atomic.LoadInt64(&server.queued)}
+	if server.Utilization != nil {
+		r.Utilization = server.Utilization()
+	}
+	return r
+}
+
+// withLoad ReportLoad 时在回复的 trailer 中附带负载报告
+func (server *Server) withLoad(md map[string]string) map[string]string {
+	if !server.ReportLoad {
+		return md
+	}
+	if md == nil {
+		md = make(map[string]string, 1)
+	}
+	md[LoadTrailerKey] = server.loadReport().String()
+	return md
+}
+
+type loadSample struct {
+	report LoadReport
+	at     time.Time
+}
+
+// noteLoad 记录回复中的负载报告
+func (client *Client) noteLoad(md map[string]string) {
+	if r, ok := ParseLoadReport(md); ok {
+		client.load.Store(loadSample{report: r, at: time.Now()})
+	}
+}
+
+// LoadReport 最近一次收到的负载报告及其收到的时间，还没有收到过报告时时间为零值
+func (client *Client) LoadReport() (LoadReport, time.Time) {
+	s, _ := client.load.Load().(loadSample)
+	return s.report, s.at
+}
@@ -0,0 +1,133 @@
+package myGoRPC
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Logger
+客户端、服务端输出日志的接口，msg 为固定的描述（如 "rpc server: handshake error"），变化的内容放在 fields 中，
+便于接入结构化的日志系统；实现需要可以并发调用
+
+服务端使用 Server.Logger，客户端使用 Option.Logger，为 nil 时使用 SetLogger 设置的默认 Logger，
+初始为输出到标准库 log 的 StdLogger{}，即与之前相同地输出全部日志
+*/
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota // 注册服务、挂载路径等启动信息
+	LevelInfo               // 连接的正常变化，如关闭空闲连接
+	LevelWarn               // 可以自动恢复的问题，如丢弃消息、重连失败
+	LevelError              // 连接或请求失败，如握手失败、方法 panic
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// Field 日志的一个键值对
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F 构造 Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+/*
+StdLogger
+以标准库 log 输出，格式为 "msg key=value ..."，值含空白时加引号；低于 Level 的日志丢弃
+*/
+type StdLogger struct {
+	Logger *log.Logger // nil 即为 log 包的默认 Logger
+	Level  Level
+}
+
+func (l StdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.Level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if strings.ContainsAny(v, " \t\n\"") || v == "" {
+			v = fmt.Sprintf("%q", v)
+		}
+		b.WriteString(" " + f.Key + "=" + v)
+	}
+	if l.Logger != nil {
+		_ = l.Logger.Output(2, b.String())
+		return
+	}
+	_ = log.Output(2, b.String())
+}
+
+// NopLogger 丢弃全部日志
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+type loggerHolder struct{ Logger }
+
+var defaultLogger atomic.Value // loggerHolder
+
+func init() {
+	defaultLogger.Store(loggerHolder{StdLogger{}})
+}
+
+// SetLogger 设置默认 Logger，l 为 nil 时恢复为 StdLogger{}
+func SetLogger(l Logger) {
+	if l == nil {
+		l = StdLogger{}
+	}
+	defaultLogger.Store(loggerHolder{l})
+}
+
+func logger() Logger {
+	return defaultLogger.Load().(loggerHolder).Logger
+}
+
+// DefaultLogger 返回 SetLogger 设置的默认 Logger，供 xclient、registry 等包在未设置 Logger 时使用
+func DefaultLogger() Logger {
+	return logger()
+}
+
+func (server *Server) logger() Logger {
+	if server.Logger != nil {
+		return server.Logger
+	}
+	return logger()
+}
+
+func optionLogger(opt *Option) Logger {
+	if opt != nil && opt.Logger != nil {
+		return opt.Logger
+	}
+	return logger()
+}
+
+func (client *Client) logger() Logger {
+	return optionLogger(client.option)
+}
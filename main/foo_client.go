@@ -0,0 +1,47 @@
+// Code generated by mygorpc-gen -type Foo; DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"myGoRPC"
+	"myGoRPC/stub"
+)
+
+// FooClient Foo 服务的强类型客户端
+type FooClient struct {
+	stub.Client
+}
+
+// NewFooClient 以服务名 "Foo" 调用 conn
+func NewFooClient(conn stub.Conn) *FooClient {
+	return &FooClient{stub.Client{Conn: conn, Service: "Foo"}}
+}
+
+// Sleep 调用 Foo.Sleep
+func (c *FooClient) Sleep(ctx context.Context, args Args) (*int, error) {
+	reply := new(int)
+	if err := c.Client.Call(ctx, "Sleep", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// GoSleep 异步调用 Foo.Sleep，见 myGoRPC.Client.Go
+func (c *FooClient) GoSleep(args Args, reply *int, done chan *myGoRPC.Call) *myGoRPC.Call {
+	return c.Client.Go("Sleep", args, reply, done)
+}
+
+// Sum 调用 Foo.Sum
+func (c *FooClient) Sum(ctx context.Context, args Args) (*int, error) {
+	reply := new(int)
+	if err := c.Client.Call(ctx, "Sum", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// GoSum 异步调用 Foo.Sum，见 myGoRPC.Client.Go
+func (c *FooClient) GoSum(args Args, reply *int, done chan *myGoRPC.Call) *myGoRPC.Call {
+	return c.Client.Go("Sum", args, reply, done)
+}
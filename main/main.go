@@ -36,6 +36,8 @@ func main() {
 
 // ---------------- day 7 -----------------------------------
 
+//go:generate go run ../mygorpc-gen -type Foo
+
 type Foo int
 
 type Args struct{ Num1, Num2 int }
@@ -115,8 +117,9 @@ func broadcastDay7(registry string) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo", "Sum", &Args{Num1: i, Num2: i * i})
 			// expect 2 - 5 timeout
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			foo(xc, ctx, "broadcast", "Foo", "Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,148 @@
+package myGoRPC
+
+import (
+	"bytes"
+	"errors"
+	"myGoRPC/codec"
+	"sync"
+)
+
+/*
+消息大小的上限与分块传输
+
+Option.MaxMessageSize 限制连接上单个 body 编码后的字节数，随握手发给服务端，两端在读、写两个方向上都按它检查：
+ 1. 写入：超过上限的请求或回复不写入连接，Write 返回包装了 *codec.BodyTooLargeError 的 *codec.EncodeError，
+    只有这一次的调用失败；服务端改为回复 CodeResourceExhausted 的错误，客户端不会因为过大的回复而断开
+ 2. 读取：与 MaxBodySize 相同（两者取较小的一个），超过上限的 body 不分配内存，连接关闭
+
+Option.ChunkSize 大于 0 时开启分块传输（需要服务端支持，握手时确认）：编码后超过 ChunkSize 字节的消息，
+以独立的编解码器编码为字节后，拆成若干 FrameChunk 帧与最后一个 FrameChunkEnd 帧发送，body 为 []byte；
+接收方重组后再解码，对上层透明，重组的大小同样受读取上限的限制。
+一条消息的各帧连续写出；开启后每条消息需要多编码一次以得到其大小。需要编解码方式支持 []byte 的 body，protobuf 不支持
+*/
+
+// 分块传输的帧，见 codec.Header.Frame
+const (
+	FrameChunk    = FramePush + 1 + iota // 消息的一块，之后还有
+	FrameChunkEnd                        // 消息的最后一块
+)
+
+// chunkHeaderAllowance 重组时在读取上限之外为消息头留出的字节数
+const chunkHeaderAllowance = 64 << 10
+
+// chunkBuffers Write 计算消息大小用的缓冲区，超过 maxPooledChunkBuffer 的不放回
+var chunkBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+const maxPooledChunkBuffer = 1 << 20
+
+var errChunkProtobuf = errors.New("rpc: Option.ChunkSize is not supported with the protobuf codec")
+
+// minLimit 两个上限中较小的一个，0 即为不限制
+func minLimit(a, b int) int {
+	if a <= 0 || b > 0 && b < a {
+		return b
+	}
+	return a
+}
+
+// limitWrite codec 实现了 codec.WriteLimiter 时设置写入的上限，否则不限制
+func limitWrite(cc codec.Codec, max int) codec.Codec {
+	if l, ok := cc.(codec.WriteLimiter); ok && max > 0 {
+		l.SetMaxWriteSize(max)
+	}
+	return cc
+}
+
+/*
+messageCodec
+按 opt 为连接的 codec 设置读写的上限，ChunkSize 大于 0 时再包装为 chunkCodec；
+maxRead 为本端另外设置的读取上限（Server.MaxBodySize、Option.MaxBodySize）
+*/
+func messageCodec(f codec.NewCodecFunc, cc codec.Codec, opt *Option, maxRead int) codec.Codec {
+	maxRead = minLimit(maxRead, opt.MaxMessageSize)
+	cc = limitWrite(limitBody(cc, maxRead), opt.MaxMessageSize)
+	if opt.ChunkSize <= 0 {
+		return cc
+	}
+	return &chunkCodec{Codec: cc, newCodec: f, size: opt.ChunkSize, maxRead: maxRead, maxWrite: opt.MaxMessageSize}
+}
+
+/*
+chunkCodec
+Write 由调用方的发送锁串行化，ReadHeader、ReadBody 只在一个读取协程中调用；
+inner 为最近一次 ReadHeader 重组的消息，ReadBody 从中读取，nil 即为普通的消息
+*/
+type chunkCodec struct {
+	codec.Codec
+	newCodec          codec.NewCodecFunc
+	size              int
+	maxRead, maxWrite int
+
+	inner  codec.Codec
+	pieces bytes.Buffer
+}
+
+func (c *chunkCodec) Write(header *codec.Header, body interface{}) error {
+	buf := chunkBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledChunkBuffer {
+			chunkBuffers.Put(buf)
+		}
+	}()
+	if err := limitWrite(c.newCodec(memConn{Writer: buf}), c.maxWrite).Write(header, body); err != nil {
+		return err
+	}
+	if buf.Len() <= c.size {
+		return c.Codec.Write(header, body)
+	}
+	data := buf.Bytes()
+	for len(data) > 0 {
+		n, frame := c.size, FrameChunk
+		if n >= len(data) {
+			n, frame = len(data), FrameChunkEnd
+		}
+		if err := c.Codec.Write(&codec.Header{Seq: header.Seq, Frame: frame}, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (c *chunkCodec) ReadHeader(header *codec.Header) error {
+	c.inner = nil
+	c.pieces.Reset()
+	for {
+		if err := c.Codec.ReadHeader(header); err != nil {
+			return err
+		}
+		if header.Frame != FrameChunk && header.Frame != FrameChunkEnd {
+			if c.pieces.Len() > 0 {
+				return errors.New("rpc: chunked message interrupted by another frame")
+			}
+			return nil
+		}
+		var piece []byte
+		if err := c.Codec.ReadBody(&piece); err != nil {
+			return err
+		}
+		if c.maxRead > 0 && c.pieces.Len()+len(piece) > c.maxRead+chunkHeaderAllowance {
+			return &codec.BodyTooLargeError{Limit: c.maxRead}
+		}
+		c.pieces.Write(piece)
+		if header.Frame == FrameChunkEnd {
+			break
+		}
+	}
+	c.inner = limitBody(c.newCodec(memConn{Reader: bytes.NewReader(c.pieces.Bytes())}), c.maxRead)
+	*header = codec.Header{}
+	return c.inner.ReadHeader(header)
+}
+
+func (c *chunkCodec) ReadBody(body interface{}) error {
+	if c.inner != nil {
+		return c.inner.ReadBody(body)
+	}
+	return c.Codec.ReadBody(body)
+}
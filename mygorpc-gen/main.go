@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+mygorpc-gen
+读取服务的 Go 源码，为其生成强类型的客户端，运行时依赖 myGoRPC/stub：
+
+	//go:generate go run myGoRPC/mygorpc-gen -type Arith
+
+-type 可以是服务的结构体（取其导出的方法），也可以是描述服务的接口；
+只有符合 RPC 方法签名的方法（见 service.RegisterMethods）生成客户端方法，流式方法等其他方法跳过。
+每个方法 M(args A, reply *R) error 生成：
+ 1. M(ctx context.Context, args A) (*R, error)，经 stub.Client.Call 发起调用
+ 2. GoM(args A, reply *R, done chan *myGoRPC.Call) *myGoRPC.Call，经 stub.Client.Go 发起调用
+
+生成的文件与服务属于同一个包，默认为源码目录下的 <type>_client.go
+*/
+
+const (
+	rpcPkg  = "myGoRPC"
+	stubPkg = "myGoRPC/stub"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mygorpc-gen: ")
+	typeName := flag.String("type", "", "service type or interface name, required")
+	service := flag.String("service", "", "service name used by the server, default the type name")
+	output := flag.String("output", "", "output file, default <dir>/<type>_client.go")
+	flag.Parse()
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, strings.ToLower(*typeName)+"_client.go")
+	}
+	src, err := generate(dir, *typeName, *service, filepath.Base(out))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// method 一个 RPC 方法
+type method struct {
+	name  string
+	args  ast.Expr
+	reply ast.Expr // 去掉指针后的类型
+	file  *ast.File
+}
+
+/*
+generate
+解析 dir 下的源码（不含测试文件与 skip），生成 typeName 的客户端；service 为空时使用 typeName
+*/
+func generate(dir, typeName, service, skip string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != skip
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		methods, found := collectMethods(pkg, typeName)
+		if !found {
+			continue
+		}
+		if len(methods) == 0 {
+			return nil, fmt.Errorf("%s has no RPC methods", typeName)
+		}
+		if service == "" {
+			service = typeName
+		}
+		return render(pkg.Name, typeName, service, methods)
+	}
+	return nil, fmt.Errorf("type %s not found in %s", typeName, dir)
+}
+
+// collectMethods typeName 的 RPC 方法，按名称排序；found 为 false 时包中没有该类型
+func collectMethods(pkg *ast.Package, typeName string) (methods []method, found bool) {
+	add := func(name string, ft *ast.FuncType, file *ast.File) {
+		m, err := rpcMethod(ft)
+		if err != nil {
+			log.Printf("skip %s.%s: %v", typeName, name, err)
+			return
+		}
+		m.name, m.file = name, file
+		methods = append(methods, m)
+	}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok || ts.Name.Name != typeName {
+						continue
+					}
+					found = true
+					if iface, ok := ts.Type.(*ast.InterfaceType); ok {
+						for _, f := range iface.Methods.List {
+							if ft, ok := f.Type.(*ast.FuncType); ok && len(f.Names) == 1 {
+								add(f.Names[0].Name, ft, file)
+							}
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv != nil && d.Name.IsExported() && receiverName(d.Recv.List[0].Type) == typeName {
+					add(d.Name.Name, d.Type, file)
+				}
+			}
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+	return methods, found
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// rpcMethod 检查方法签名：(args A, reply *R) error，之前可以再有一个 context.Context 入参
+func rpcMethod(ft *ast.FuncType) (method, error) {
+	var params []ast.Expr
+	for _, f := range ft.Params.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, f.Type)
+		}
+	}
+	var m method
+	if len(params) == 3 {
+		if types.ExprString(params[0]) != "context.Context" {
+			return m, errors.New("the first of three arguments is not context.Context")
+		}
+		params = params[1:]
+	}
+	if len(params) != 2 {
+		return m, errors.New("not an RPC method signature")
+	}
+	if ft.Results == nil || len(ft.Results.List) != 1 || len(ft.Results.List[0].Names) > 1 ||
+		types.ExprString(ft.Results.List[0].Type) != "error" {
+		return m, errors.New("the only result must be error")
+	}
+	star, ok := params[1].(*ast.StarExpr)
+	if !ok {
+		return m, errors.New("reply is not a pointer")
+	}
+	m.args, m.reply = params[0], star.X
+	return m, nil
+}
+
+// imports 参数类型引用的包，取自方法所在文件的 import
+func imports(methods []method) map[string]string {
+	paths := make(map[string]string) // 包路径 -> 包名
+	for _, m := range methods {
+		for _, expr := range []ast.Expr{m.args, m.reply} {
+			ast.Inspect(expr, func(n ast.Node) bool {
+				sel, ok := n.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				if x, ok := sel.X.(*ast.Ident); ok {
+					if path := importPath(m.file, x.Name); path != "" {
+						paths[path] = x.Name
+					}
+				}
+				return false
+			})
+		}
+	}
+	return paths
+}
+
+func importPath(file *ast.File, name string) string {
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil && spec.Name.Name == name || spec.Name == nil && filepath.Base(path) == name {
+			return path
+		}
+	}
+	return ""
+}
+
+func render(pkgName, typeName, service string, methods []method) ([]byte, error) {
+	client := typeName + "Client"
+	paths := imports(methods)
+	paths["context"], paths[rpcPkg], paths[stubPkg] = "context", "myGoRPC", "stub"
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by mygorpc-gen -type %s; DO NOT EDIT.\n\npackage %s\n\nimport (\n", typeName, pkgName)
+	for _, path := range sorted {
+		if filepath.Base(path) != paths[path] {
+			fmt.Fprintf(&b, "\t%s %q\n", paths[path], path)
+		} else {
+			fmt.Fprintf(&b, "\t%q\n", path)
+		}
+	}
+	fmt.Fprintf(&b, ")\n\n// %s %s 服务的强类型客户端\ntype %s struct {\n\tstub.Client\n}\n\n", client, service, client)
+	fmt.Fprintf(&b, "// New%s 以服务名 %q 调用 conn\nfunc New%s(conn stub.Conn) *%s {\n\treturn &%s{stub.Client{Conn: conn, Service: %q}}\n}\n",
+		client, service, client, client, client, service)
+	for _, m := range methods {
+		args, reply := types.ExprString(m.args), types.ExprString(m.reply)
+		fmt.Fprintf(&b, "\n// %s 调用 %s.%s\nfunc (c *%s) %s(ctx context.Context, args %s) (*%s, error) {\n", m.name, service, m.name, client, m.name, args, reply)
+		fmt.Fprintf(&b, "\treply := new(%s)\n\tif err := c.Client.Call(ctx, %q, args, reply); err != nil {\n\t\treturn nil, err\n\t}\n\treturn reply, nil\n}\n", reply, m.name)
+		fmt.Fprintf(&b, "\n// Go%s 异步调用 %s.%s，见 myGoRPC.Client.Go\nfunc (c *%s) Go%s(args %s, reply *%s, done chan *myGoRPC.Call) *myGoRPC.Call {\n", m.name, service, m.name, client, m.name, args, reply)
+		fmt.Fprintf(&b, "\treturn c.Client.Go(%q, args, reply, done)\n}\n", m.name)
+	}
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const arithSrc = `package arith
+
+import (
+	"context"
+	"myGoRPC"
+	t "time"
+)
+
+type Args struct{ A, B int }
+
+type Arith int
+
+func (a *Arith) Mul(args *Args, reply *int) error { return nil }
+
+func (a *Arith) Wait(ctx context.Context, d t.Duration, reply *[]t.Time) error { return nil }
+
+func (a *Arith) Watch(stream *myGoRPC.Stream) error { return nil }
+
+func (a *Arith) helper(args Args, reply *int) error { return nil }
+
+type Geo interface {
+	Move(args Args, reply *Args) error
+	Bad(args Args, reply Args) error
+}
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "arith.go"), []byte(arithSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(dir, "Arith", "", "arith_client.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "arith_client.go", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v\n%s", err, src)
+	}
+	out := string(src)
+	for _, want := range []string{
+		"package arith",
+		`t "time"`,
+		`return &ArithClient{stub.Client{Conn: conn, Service: "Arith"}}`,
+		"func (c *ArithClient) Mul(ctx context.Context, args *Args) (*int, error)",
+		"func (c *ArithClient) GoMul(args *Args, reply *int, done chan *myGoRPC.Call) *myGoRPC.Call",
+		"func (c *ArithClient) Wait(ctx context.Context, args t.Duration) (*[]t.Time, error)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("generated code lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Watch") || strings.Contains(out, "helper") {
+		t.Fatalf("non-RPC methods should be skipped:\n%s", out)
+	}
+
+	src, err = generate(dir, "Geo", "Mover", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = string(src)
+	if !strings.Contains(out, `Service: "Mover"`) || !strings.Contains(out, "Move(ctx context.Context, args Args) (*Args, error)") ||
+		strings.Contains(out, "Bad") {
+		t.Fatalf("unexpected client for the Geo interface:\n%s", out)
+	}
+
+	if _, err := generate(dir, "Missing", "", ""); err == nil {
+		t.Fatal("expect an error for a missing type")
+	}
+	if _, err := generate(dir, "Args", "", ""); err == nil {
+		t.Fatal("expect an error for a type without RPC methods")
+	}
+}
@@ -0,0 +1,40 @@
+package myGoRPC
+
+/*
+单向调用（通知）
+
+Client.Notify 发送 Frame 为 FrameNotify 的请求：服务端与普通请求一样解码、经过拦截器与限流并执行方法，
+但不发送任何回复（包括错误），客户端也不在 pending 中登记，适合日志、埋点等不关心结果的调用。
+Notify 返回时请求只是写入了连接，不保证服务端收到或执行成功；过载、限流、方法不存在时请求被直接丢弃，
+只体现在服务端的统计与请求日志中
+*/
+
+// FrameNotify 单向调用的请求，见 Client.Notify
+const FrameNotify = FrameChunkEnd + 1
+
+/*
+Notify
+发送单向调用，返回写入连接的错误；client 已关闭时返回 ErrShutdown，重连中时返回 *ReconnectError。
+不经过客户端拦截器与重试，也不计入 MaxPending 与客户端统计
+*/
+func (client *Client) Notify(service, method string, args interface{}) error {
+	client.mu.Lock()
+	closed, reconnectErr := client.closing || client.shutdown, client.reconnectErr
+	client.mu.Unlock()
+	switch {
+	case closed:
+		return ErrShutdown
+	case reconnectErr != nil:
+		return reconnectErr
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.header.Service = service
+	client.header.Method = method
+	client.header.Seq = 0
+	client.header.Error = ""
+	client.header.Timeout = 0
+	client.header.Metadata = nil
+	client.header.Frame = FrameNotify
+	return client.cc.Write(&client.header, args)
+}
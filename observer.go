@@ -0,0 +1,86 @@
+package myGoRPC
+
+import (
+	"sync"
+	"time"
+)
+
+// CallInfo 一个请求结束时交给观察者的信息；Start 为注册到 pending 的时间，未能注册时与 End 相同
+type CallInfo struct {
+	Service string
+	Method  string
+	Seq     uint64
+	Start   time.Time
+	End     time.Time
+	Error   error
+}
+
+/*
+SetObserver
+设置请求的观察者，每个请求结束时（成功、服务端错误、连接错误、terminateCalls、取消、超时）恰好通知一次，fn 为 nil 即为取消
+
+done 可能在持有 client.mu 的情况下调用（terminateCalls 等），也可能在 receive 协程中调用，
+因此事件先放入无界队列，由单独的协程按顺序调用 fn：fn 再慢也不会阻塞 receive，也不会丢失事件，
+代价是 fn 持续慢于请求速率时队列会增长。通常在创建 Client 后、发出请求之前调用一次
+*/
+func (client *Client) SetObserver(fn func(info CallInfo)) {
+	if fn == nil {
+		client.observer.Store((*callObserver)(nil))
+		return
+	}
+	o := &callObserver{fn: fn, wake: make(chan struct{}, 1)}
+	client.observer.Store(o)
+	go o.run(client.terminated)
+}
+
+type callObserver struct {
+	fn    func(info CallInfo)
+	mu    sync.Mutex
+	queue []CallInfo
+	wake  chan struct{}
+}
+
+// observe 在 Call.done 中调用，不阻塞
+func (client *Client) observe(call *Call) {
+	o, _ := client.observer.Load().(*callObserver)
+	if o == nil || call.Service == heartbeatService {
+		return
+	}
+	info := CallInfo{Service: call.Service, Method: call.Method, Seq: call.Seq, Start: call.registered, End: time.Now(), Error: call.Error}
+	if info.Start.IsZero() {
+		info.Start = info.End
+	}
+	o.mu.Lock()
+	o.queue = append(o.queue, info)
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 依次通知队列中的事件，client 终止且队列为空后退出
+func (o *callObserver) run(terminated <-chan struct{}) {
+	for {
+		o.mu.Lock()
+		queue := o.queue
+		o.queue = nil
+		o.mu.Unlock()
+		for _, info := range queue {
+			o.fn(info)
+		}
+		if len(queue) > 0 {
+			continue
+		}
+		select {
+		case <-o.wake:
+		case <-terminated:
+			o.mu.Lock()
+			empty := len(o.queue) == 0
+			o.mu.Unlock()
+			if empty {
+				return
+			}
+		}
+	}
+}
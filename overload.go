@@ -0,0 +1,61 @@
+package myGoRPC
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerBusy 服务端过载时立即回复的错误，客户端可以据此退避，或者换一个服务实例重试
+var ErrServerBusy = errors.New("rpc server: server busy, retry later")
+
+/*
+ShedPolicy
+请求级别的过载保护（load shedding）策略
+
+服务端同时处理的请求数超过 MaxInflight，或堆内存使用量超过 MaxHeapInuse 时，
+新到达的请求不再交给 handleRequest，而是直接回复 ErrServerBusy，连接本身保持不变
+阈值为 0 即为不限制
+*/
+type ShedPolicy struct {
+	MaxInflight    int64         // 同时处理的请求数上限
+	MaxHeapInuse   uint64        // 堆内存使用量上限，单位字节
+	SampleInterval time.Duration // 堆内存采样间隔，默认 100ms
+}
+
+const defaultSampleInterval = time.Millisecond * 100
+
+/*
+overloaded
+判断是否需要拒绝新的请求，每个请求都会调用，需要足够廉价：
+请求数只是一次原子读；runtime.ReadMemStats 会 stop the world，
+因此只按 SampleInterval 采样，由抢到 CAS 的协程负责刷新，其余协程直接使用上一次的采样值
+*/
+func (server *Server) overloaded() bool {
+	p := server.Shed
+	if p == nil {
+		return false
+	}
+	if p.MaxInflight > 0 && atomic.LoadInt64(&server.inflight) >= p.MaxInflight {
+		return true
+	}
+	if p.MaxHeapInuse > 0 {
+		return server.sampleHeapInuse(p.SampleInterval) >= p.MaxHeapInuse
+	}
+	return false
+}
+
+func (server *Server) sampleHeapInuse(interval time.Duration) uint64 {
+	if interval == 0 {
+		interval = defaultSampleInterval
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&server.heapSampledAt)
+	if now-last >= int64(interval) && atomic.CompareAndSwapInt64(&server.heapSampledAt, last, now) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		atomic.StoreUint64(&server.heapInuse, ms.HeapInuse)
+	}
+	return atomic.LoadUint64(&server.heapInuse)
+}
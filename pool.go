@@ -0,0 +1,376 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPoolExhausted = errors.New("rpc client: pool exhausted")
+	ErrPoolClosed    = errors.New("rpc client: pool closed")
+	// ErrNotPooled Put 的 Client 不是该 Pool 的 GetClient 得到的，或者已经被 Pool 关闭
+	ErrNotPooled = errors.New("rpc client: client does not belong to the pool")
+	// ErrPoolDoublePut Put 的 Client 已经归还过，尚未被再次 GetClient
+	ErrPoolDoublePut = errors.New("rpc client: client already put back to the pool")
+)
+
+/*
+Pool
+按地址复用 Client，避免短时间的突发请求反复建立连接
+GetClient 优先返回空闲且可用（IsAvailable）的 Client，没有时调用 Dial 新建；用完后 Put 归还
+
+MaxIdle: 每个地址保留的空闲 Client 数，超出的在 Put 时关闭
+MaxActive: 每个地址同时存在（空闲 + 使用中）的 Client 数上限，达到上限时 GetClient 返回 ErrPoolExhausted，0 即为不限制
+
+同一地址的 Client 按第一次 GetClient 的 Option 建立，之后传入的 Option 只用于新建连接，调用方应对同一地址使用相同的 Option
+*/
+type Pool struct {
+	MaxIdle   int
+	MaxActive int
+
+	mu      sync.Mutex
+	idle    map[string][]*Client
+	active  map[string]int
+	clients map[*Client]bool // 属于该 Pool（计入 active）的 Client -> 是否空闲
+	closed  bool
+}
+
+var _ io.Closer = (*Pool)(nil)
+
+func NewPool(maxIdle, maxActive int) *Pool {
+	return &Pool{
+		MaxIdle:   maxIdle,
+		MaxActive: maxActive,
+		idle:      make(map[string][]*Client),
+		active:    make(map[string]int),
+		clients:   make(map[*Client]bool),
+	}
+}
+
+func (p *Pool) GetClient(network, address string, opts ...*Option) (*Client, error) {
+	key := network + "@" + address
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	for clients := p.idle[key]; len(clients) > 0; clients = p.idle[key] {
+		client := clients[len(clients)-1]
+		p.idle[key] = clients[:len(clients)-1]
+		if client.IsAvailable() {
+			p.clients[client] = false
+			p.mu.Unlock()
+			return client, nil
+		}
+		// 已断开的 Client 直接丢弃
+		p.release(key, client)
+	}
+	if p.MaxActive > 0 && p.active[key] >= p.MaxActive {
+		p.mu.Unlock()
+		return nil, ErrPoolExhausted
+	}
+	p.active[key]++
+	p.mu.Unlock()
+
+	client, err := Dial(network, address, opts...)
+	if err != nil {
+		p.mu.Lock()
+		p.active[key]--
+		p.mu.Unlock()
+		return nil, err
+	}
+	client.poolKey = key
+	p.mu.Lock()
+	p.clients[client] = false
+	p.mu.Unlock()
+	return client, nil
+}
+
+/*
+Put
+归还 GetClient 得到的 Client；不可用、空闲已满或 Pool 已关闭时关闭该 Client。
+不属于该 Pool（包括已经被关闭）的 Client 返回 ErrNotPooled，已经归还过的返回 ErrPoolDoublePut，两者都不影响 Pool 的计数
+*/
+func (p *Pool) Put(client *Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	idle, ok := p.clients[client]
+	if !ok {
+		return ErrNotPooled
+	}
+	if idle {
+		return ErrPoolDoublePut
+	}
+	key := client.poolKey
+	if !p.closed && client.IsAvailable() && len(p.idle[key]) < p.MaxIdle {
+		p.idle[key] = append(p.idle[key], client)
+		p.clients[client] = true
+		return nil
+	}
+	p.release(key, client)
+	return nil
+}
+
+// release 关闭 Client 并不再计入 Pool，需持有 p.mu
+func (p *Pool) release(key string, client *Client) {
+	p.active[key]--
+	delete(p.clients, client)
+	_ = client.Close()
+}
+
+// Close 关闭所有空闲的 Client，使用中的 Client 在 Put 时关闭
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for key, clients := range p.idle {
+		for _, client := range clients {
+			p.release(key, client)
+		}
+		delete(p.idle, key)
+	}
+	return nil
+}
+
+// DefaultPoolHealthInterval PooledClient 检查连接是否可用的间隔
+const DefaultPoolHealthInterval = time.Second
+
+/*
+PooledClient
+到同一地址的 size 个连接，调用按轮询分散到可用的连接上，避免单个连接的发送锁成为瓶颈；
+与 Pool 不同，调用方不需要取出、归还 Client，直接使用 Call、Go
+
+不可用的连接（断开、心跳超时等）在后台重新拨号替换：轮询时发现的立即替换，其余的由每 DefaultPoolHealthInterval 一次的检查发现；
+替换期间调用分散到其余的连接，全部不可用时返回包装了 ErrShutdown 的错误。
+已经发出的调用仍在原来的连接上完成，连接断开时以该连接的错误结束，不会转移到其他连接
+
+Option.SharedHeartbeat 且设置了 HeartbeatInterval 时，各连接不再各自发送心跳，改由连接池调度，见 heartbeat
+*/
+type PooledClient struct {
+	network, address string
+	opt              *Option
+
+	mu      sync.Mutex
+	members []*Client
+	dialing []bool // 正在重新拨号的位置
+	next    int
+	closed  bool
+	done    chan struct{}
+}
+
+// pingResult 连接池调度的一次心跳的结果
+type pingResult struct {
+	client *Client
+	ok     bool
+}
+
+// DialPool 建立到 address 的 size 个连接，任何一个失败时关闭已建立的连接并返回错误
+func DialPool(network, address string, size int, opts ...*Option) (*PooledClient, error) {
+	if size <= 0 {
+		return nil, errors.New("rpc client: pool size must be positive")
+	}
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	p := &PooledClient{
+		network: network,
+		address: address,
+		opt:     opt,
+		members: make([]*Client, size),
+		dialing: make([]bool, size),
+		done:    make(chan struct{}),
+	}
+	shared := opt.SharedHeartbeat && opt.HeartbeatInterval > 0
+	if shared {
+		memberOpt := *opt
+		memberOpt.HeartbeatInterval = 0
+		p.opt = &memberOpt
+	}
+	for i := range p.members {
+		if p.members[i], err = Dial(network, address, p.opt); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+	go p.healthCheck(DefaultPoolHealthInterval)
+	if shared {
+		go p.heartbeat(opt.HeartbeatInterval, opt.HeartbeatTimeout, opt.HeartbeatMaxMisses)
+	}
+	return p, nil
+}
+
+// Call 在一个可用的连接上调用，见 Client.Call
+func (p *PooledClient) Call(ctx context.Context, service, method string, args, reply interface{}) error {
+	client, err := p.pick()
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, service, method, args, reply)
+}
+
+// Go 在一个可用的连接上异步调用，见 Client.Go
+func (p *PooledClient) Go(service, method string, args, reply interface{}, done chan *Call) *Call {
+	client, err := p.pick()
+	if err != nil {
+		call := newCall(service, method, args, reply, done)
+		call.Error = err
+		call.done()
+		return call
+	}
+	return client.Go(service, method, args, reply, done)
+}
+
+// pick 轮询下一个可用的连接，途经的不可用连接开始替换
+func (p *PooledClient) pick() (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrShutdown
+	}
+	n := len(p.members)
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		if client := p.members[idx]; client != nil && client.IsAvailable() {
+			p.next = idx + 1
+			return client, nil
+		}
+		p.replace(idx)
+	}
+	return nil, fmt.Errorf("%w: no available connection to %s", ErrShutdown, p.address)
+}
+
+// replace 在后台重新拨号替换 idx 处的连接，调用方需持有 mu
+func (p *PooledClient) replace(idx int) {
+	if p.dialing[idx] || p.closed {
+		return
+	}
+	p.dialing[idx] = true
+	go func() {
+		client, err := Dial(p.network, p.address, p.opt)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.dialing[idx] = false
+		if err != nil {
+			optionLogger(p.opt).Log(LevelWarn, "rpc client: pool redial error", F("address", p.address), F("err", err))
+			return
+		}
+		if p.closed {
+			_ = client.Close()
+			return
+		}
+		if old := p.members[idx]; old != nil {
+			go closeDrained(old)
+		}
+		p.members[idx] = client
+	}()
+}
+
+// poolDrainTimeout 被替换的连接等待已发出的调用结束的时长
+const poolDrainTimeout = 10 * time.Second
+
+// closeDrained 等待 client 上已发出的调用结束后关闭，如服务端排空（Quiesce）中的连接
+func closeDrained(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), poolDrainTimeout)
+	defer cancel()
+	_ = client.CloseGracefully(ctx)
+}
+
+func (p *PooledClient) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		for idx, client := range p.members {
+			if client == nil || !client.IsAvailable() {
+				p.replace(idx)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+/*
+heartbeat
+Option.SharedHeartbeat 时代替各连接自己的心跳：每 interval/size 轮到一个连接，每个连接每个 interval 轮到一次，
+各连接的 ping 在时间上错开，不会同时发出。轮到时最近 interval 内收到过回复的连接跳过，
+其余的连接各自 ping，结果只说明这一个连接是否存活：连续 maxMisses 次丢失的连接关闭，
+其 pending 中的请求以 ErrHeartbeatTimeout 失败，之后由 replace 替换；timeout、maxMisses 的默认值与 Client 的心跳相同
+*/
+func (p *PooledClient) heartbeat(interval, timeout time.Duration, maxMisses int) {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	if maxMisses <= 0 {
+		maxMisses = 1
+	}
+	step := interval / time.Duration(len(p.members))
+	if step <= 0 {
+		step = interval
+	}
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	// 每个连接最多一个未结束的 ping
+	results := make(chan pingResult, len(p.members))
+	pinging := make(map[*Client]bool)
+	misses := make(map[*Client]int)
+	next := 0
+	for {
+		select {
+		case <-p.done:
+			return
+		case r := <-results:
+			delete(pinging, r.client)
+			if r.ok {
+				delete(misses, r.client)
+				continue
+			}
+			if misses[r.client]++; misses[r.client] >= maxMisses {
+				delete(misses, r.client)
+				r.client.heartbeatFailed()
+			}
+			continue
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		client := p.members[next%len(p.members)]
+		next++
+		p.mu.Unlock()
+		switch {
+		case client == nil || pinging[client]:
+		case !client.IsAvailable():
+			delete(misses, client)
+		case !client.quietFor(interval):
+			delete(misses, client)
+		default:
+			pinging[client] = true
+			go func() { results <- pingResult{client: client, ok: client.ping(timeout)} }()
+		}
+	}
+}
+
+// Close 关闭全部连接，之后的调用返回 ErrShutdown
+func (p *PooledClient) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrShutdown
+	}
+	p.closed = true
+	close(p.done)
+	for _, client := range p.members {
+		if client != nil {
+			_ = client.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package myGoRPC
+
+import (
+	"errors"
+	"myGoRPC/codec"
+	"reflect"
+)
+
+/*
+订阅与推送
+
+客户端以 Client.Subscribe 订阅一个主题，服务端代码以 Server.Publish 向该主题的全部订阅者推送消息：
+ 1. Subscribe、Unsubscribe 是发往保留服务名 pubsubService 的普通请求，body 为主题名，
+    服务端在 serveCodec 中直接处理，不经过过载保护、并发限制与拦截器
+ 2. 推送是服务端主动发送的帧：Frame 为 FramePush、Service 为主题名、Seq 为 0，body 为 []byte，
+    只会发给订阅了该主题的连接，旧版本的客户端不会收到
+
+消息的内容为 []byte，由双方约定编码方式；body 不是 protobuf 消息，ProtobufCodec 的连接无法推送。
+客户端的每个订阅有 SubscriptionBuffer 条缓冲，已满时丢弃新的消息，避免阻塞同一连接上的回复；
+连接断开（含 Option.Reconnect 重连、会话恢复）时关闭所有订阅的 channel，需要时重新订阅
+*/
+
+// FramePush 服务端推送的帧，见 pubsub.go
+const FramePush = FrameError + 1
+
+const pubsubService = "_pubsub"
+
+// SubscriptionBuffer 每个订阅的 channel 的缓冲条数
+const SubscriptionBuffer = 64
+
+// Message 推送到订阅者的一条消息
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// ----------------- client --------------
+
+/*
+Subscribe
+订阅 topic，返回的 channel 在 Unsubscribe 或连接断开时关闭；同一主题可以订阅多次，每次得到各自的 channel
+*/
+func (client *Client) Subscribe(topic string) (<-chan Message, error) {
+	ch := make(chan Message, SubscriptionBuffer)
+	// 先登记，服务端登记订阅之后立即推送的消息不会丢失
+	client.mu.Lock()
+	if client.subs == nil {
+		client.subs = make(map[string][]chan Message)
+	}
+	client.subs[topic] = append(client.subs[topic], ch)
+	client.mu.Unlock()
+	call := <-client.Go(pubsubService, "Subscribe", topic, nil, nil).Done
+	if call.Error != nil {
+		client.mu.Lock()
+		client.removeSub(topic, ch)
+		client.mu.Unlock()
+		return nil, call.Error
+	}
+	return ch, nil
+}
+
+// Unsubscribe 取消 topic 的全部订阅，关闭对应的 channel
+func (client *Client) Unsubscribe(topic string) error {
+	client.mu.Lock()
+	subs := client.subs[topic]
+	delete(client.subs, topic)
+	for _, ch := range subs {
+		close(ch)
+	}
+	client.mu.Unlock()
+	return (<-client.Go(pubsubService, "Unsubscribe", topic, nil, nil).Done).Error
+}
+
+// removeSub 移除并关闭订阅 ch，调用方需持有 mu
+func (client *Client) removeSub(topic string, ch chan Message) {
+	subs := client.subs[topic]
+	for i, c := range subs {
+		if c == ch {
+			close(ch)
+			client.subs[topic] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(client.subs[topic]) == 0 {
+		delete(client.subs, topic)
+	}
+}
+
+// push receive 读到推送的帧，返回值与 ReadBody 相同，非 nil 时连接不可用
+func (client *Client) push(header *codec.Header) error {
+	var data []byte
+	if err := client.cc.ReadBody(&data); err != nil {
+		if _, ok := err.(*codec.BodyError); ok {
+			client.logger().Log(LevelWarn, "rpc client: dropping undecodable message", F("topic", header.Service), F("err", err))
+			return nil
+		}
+		return err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, ch := range client.subs[header.Service] {
+		select {
+		case ch <- Message{Topic: header.Service, Data: data}:
+		default:
+			client.logger().Log(LevelWarn, "rpc client: subscription buffer full, dropping message", F("topic", header.Service))
+		}
+	}
+	return nil
+}
+
+// closeSubs 连接断开时关闭所有订阅
+func (client *Client) closeSubs() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, subs := range client.subs {
+		for _, ch := range subs {
+			close(ch)
+		}
+	}
+	client.subs = nil
+}
+
+// ----------------- server --------------
+
+/*
+Publish
+向订阅了 topic 的全部连接推送 data，返回成功写入的连接数；
+逐个连接写入，持有各连接的发送锁，写入缓慢的连接会推迟之后的连接
+*/
+func (server *Server) Publish(topic string, data []byte) int {
+	server.topicMu.Lock()
+	conns := make([]*serverConn, 0, len(server.topics[topic]))
+	for _, sc := range server.topics[topic] {
+		conns = append(conns, sc)
+	}
+	server.topicMu.Unlock()
+	sent := 0
+	for _, sc := range conns {
+		sc.sending.Lock()
+		err := sc.cc.Write(&codec.Header{Service: topic, Frame: FramePush}, data)
+		sc.sending.Unlock()
+		if err != nil {
+			server.logger().Log(LevelWarn, "rpc server: publish error", F("topic", topic), F("err", err))
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// readTopic 读取 Subscribe、Unsubscribe 请求的主题名，返回值与 readRequest 相同
+func (server *Server) readTopic(cc codec.Codec, req *request) (*request, error) {
+	var topic string
+	req.argV = reflect.ValueOf(&topic).Elem()
+	if err := cc.ReadBody(&topic); err != nil {
+		if _, ok := err.(*codec.BodyError); !ok {
+			return nil, err
+		}
+		return req, err
+	}
+	return req, nil
+}
+
+// subscription 处理 Subscribe、Unsubscribe 请求并回复
+func (server *Server) subscription(sc *serverConn, req *request) {
+	topic := req.argV.String()
+	server.topicMu.Lock()
+	switch req.header.Method {
+	case "Subscribe":
+		if server.topics == nil {
+			server.topics = make(map[string]map[uint64]*serverConn)
+		}
+		if server.topics[topic] == nil {
+			server.topics[topic] = make(map[uint64]*serverConn)
+		}
+		server.topics[topic][sc.info.ID] = sc
+	case "Unsubscribe":
+		server.unsubscribe(topic, sc)
+	default:
+		setError(req.header, errors.New("rpc server: can't find method "+req.header.Method), CodeNotFound)
+	}
+	server.topicMu.Unlock()
+	server.sendResponse(sc.cc, req.header, invalidRequest, sc.sending)
+}
+
+// unsubscribe 调用方需持有 topicMu
+func (server *Server) unsubscribe(topic string, sc *serverConn) {
+	delete(server.topics[topic], sc.info.ID)
+	if len(server.topics[topic]) == 0 {
+		delete(server.topics, topic)
+	}
+}
+
+// unsubscribeAll 连接关闭时取消其全部订阅
+func (server *Server) unsubscribeAll(sc *serverConn) {
+	server.topicMu.Lock()
+	defer server.topicMu.Unlock()
+	for topic, conns := range server.topics {
+		if conns[sc.info.ID] != nil {
+			server.unsubscribe(topic, sc)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package myGoRPC
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+按方法限流
+
+SetRateLimit 为 "Service.Method"（或整个服务 "Service"，方法上的限制优先）设置令牌桶：
+ 1. Rate、Burst：所有客户端共享的桶
+ 2. ClientRate、ClientBurst：每个客户端一个桶，客户端默认按连接的对端 IP 区分，ClientKey 可以改为按元数据中的身份等区分
+
+两者都设置时请求需要同时从两个桶中取得令牌。取不到令牌的请求不排队、不经过拦截器，
+直接回复 CodeResourceExhausted 的错误，客户端可以据此退避；只限制一元调用，流与订阅不受影响。
+限流器的状态见 ServerStats.RateLimits
+*/
+type RateLimit struct {
+	Rate        float64 // 共享桶每秒补充的令牌数，0 即为不限制
+	Burst       int     // 共享桶的容量，默认为 Rate 向上取整
+	ClientRate  float64 // 每个客户端的桶每秒补充的令牌数，0 即为不限制
+	ClientBurst int     // 每个客户端的桶的容量，默认为 ClientRate 向上取整
+	// 客户端的标识，md 为请求的元数据；nil 或返回空串时使用对端 IP
+	ClientKey func(conn ConnInfo, md map[string]string) string
+}
+
+// RateLimitStats 一个限流器的状态
+type RateLimitStats struct {
+	Rate        float64
+	Burst       int
+	Tokens      float64 // 共享桶当前的令牌数，没有共享桶时为 0
+	ClientRate  float64
+	ClientBurst int
+	Clients     int // 正在跟踪的客户端桶数，桶满的客户端会被清理
+	Allowed     uint64
+	Rejected    uint64
+}
+
+// minRateLimitSweep 客户端桶数达到该值后才清理已满的桶
+const minRateLimitSweep = 1024
+
+// tokenBucket 需持有 rateLimiter.mu
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(rate, burst float64, now time.Time) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+type rateLimiter struct {
+	limit  RateLimit
+	burst  float64
+	cburst float64
+
+	mu       sync.Mutex
+	shared   *tokenBucket
+	clients  map[string]*tokenBucket
+	sweepAt  int
+	allowed  uint64
+	rejected uint64
+}
+
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	now := time.Now()
+	l := &rateLimiter{
+		limit:   limit,
+		burst:   burstOf(limit.Rate, limit.Burst),
+		cburst:  burstOf(limit.ClientRate, limit.ClientBurst),
+		clients: make(map[string]*tokenBucket),
+		sweepAt: minRateLimitSweep,
+	}
+	if limit.Rate > 0 {
+		l.shared = newTokenBucket(l.burst, now)
+	}
+	return l
+}
+
+/*
+SetRateLimit
+name 为 "Service.Method" 或 "Service"；limit 为 nil 时取消限制。
+修改已有的限制时重新开始计数
+*/
+func (server *Server) SetRateLimit(name string, limit *RateLimit) error {
+	service, method := name, ""
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		service, method = name[:i], name[i+1:]
+	}
+	if method != "" {
+		if _, _, err := server.findServiceMethod(service, method); err != nil {
+			return err
+		}
+	} else if _, ok := server.ServiceMap.Load(service); !ok {
+		return errors.New("rpc server: can't find service " + service)
+	}
+	if limit == nil {
+		server.rateLimits.Delete(name)
+		return nil
+	}
+	if limit.Rate < 0 || limit.ClientRate < 0 || limit.Rate == 0 && limit.ClientRate == 0 {
+		return errors.New("rpc server: rate limit for " + name + " needs a positive Rate or ClientRate")
+	}
+	server.rateLimits.Store(name, newRateLimiter(*limit))
+	return nil
+}
+
+// rateLimited 请求超过限流时返回回复给客户端的错误
+func (server *Server) rateLimited(req *request) error {
+	name := req.header.Service + "." + req.header.Method
+	li, ok := server.rateLimits.Load(name)
+	if !ok {
+		if li, ok = server.rateLimits.Load(req.header.Service); !ok {
+			return nil
+		}
+	}
+	l := li.(*rateLimiter)
+	var key string
+	if l.limit.ClientRate > 0 {
+		key = l.clientKey(req)
+	}
+	if l.take(key, time.Now()) {
+		return nil
+	}
+	return NewError(CodeResourceExhausted, "rpc server: rate limit exceeded for %s", name)
+}
+
+func (l *rateLimiter) clientKey(req *request) string {
+	var info ConnInfo
+	if req.sc != nil {
+		info = req.sc.info
+	}
+	if l.limit.ClientKey != nil {
+		if key := l.limit.ClientKey(info, req.md); key != "" {
+			return key
+		}
+	}
+	if host, _, err := net.SplitHostPort(info.RemoteAddr); err == nil {
+		return host
+	}
+	return info.RemoteAddr
+}
+
+// take 从共享桶与 key 的桶中各取一个令牌，key 为空时只使用共享桶
+func (l *rateLimiter) take(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shared != nil {
+		l.shared.refill(l.limit.Rate, l.burst, now)
+	}
+	var client *tokenBucket
+	if l.limit.ClientRate > 0 {
+		if client = l.clients[key]; client == nil {
+			l.sweep(now)
+			client = newTokenBucket(l.cburst, now)
+			l.clients[key] = client
+		} else {
+			client.refill(l.limit.ClientRate, l.cburst, now)
+		}
+	}
+	if l.shared != nil && l.shared.tokens < 1 || client != nil && client.tokens < 1 {
+		l.rejected++
+		return false
+	}
+	if l.shared != nil {
+		l.shared.tokens--
+	}
+	if client != nil {
+		client.tokens--
+	}
+	l.allowed++
+	return true
+}
+
+// sweep 需持有 mu，客户端桶过多时清理已经补满的桶，它们与新建的桶没有区别
+func (l *rateLimiter) sweep(now time.Time) {
+	if len(l.clients) < l.sweepAt {
+		return
+	}
+	for key, b := range l.clients {
+		if b.refill(l.limit.ClientRate, l.cburst, now); b.tokens >= l.cburst {
+			delete(l.clients, key)
+		}
+	}
+	if l.sweepAt = 2 * len(l.clients); l.sweepAt < minRateLimitSweep {
+		l.sweepAt = minRateLimitSweep
+	}
+}
+
+func (l *rateLimiter) stats() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := RateLimitStats{
+		Rate:       l.limit.Rate,
+		ClientRate: l.limit.ClientRate,
+		Clients:    len(l.clients),
+		Allowed:    l.allowed,
+		Rejected:   l.rejected,
+	}
+	if l.shared != nil {
+		l.shared.refill(l.limit.Rate, l.burst, time.Now())
+		s.Burst, s.Tokens = int(l.burst), l.shared.tokens
+	}
+	if l.limit.ClientRate > 0 {
+		s.ClientBurst = int(l.cburst)
+	}
+	return s
+}
+
+// rateLimitStats 全部限流器的状态，键为 SetRateLimit 的 name
+func (server *Server) rateLimitStats() map[string]RateLimitStats {
+	var stats map[string]RateLimitStats
+	server.rateLimits.Range(func(name, li interface{}) bool {
+		if stats == nil {
+			stats = make(map[string]RateLimitStats)
+		}
+		stats[name.(string)] = li.(*rateLimiter).stats()
+		return true
+	})
+	return stats
+}
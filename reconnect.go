@@ -0,0 +1,124 @@
+package myGoRPC
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+ReconnectClient
+Deprecated: 使用 Option.Reconnect，Client 在连接断开后原地重连。ReconnectClient 只是开启了 Option.Reconnect 的 Client，
+行为与其相同：断开时未回复的请求、以及重连期间发起的请求以 *ReconnectError 失败，重连成功后新的请求照常发送
+*/
+type ReconnectClient struct {
+	*Client
+}
+
+/*
+DialReconnect
+Deprecated: 使用 Dial 并设置 Option.Reconnect
+*/
+func DialReconnect(network, address string, opts ...*Option) (*ReconnectClient, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// 不修改调用方的 Option
+	reconnecting := *opt
+	reconnecting.Reconnect = true
+	client, err := Dial(network, address, &reconnecting)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectClient{Client: client}, nil
+}
+
+const (
+	defaultReconnectBackoff    = 50 * time.Millisecond
+	defaultReconnectMaxBackoff = 5 * time.Second
+)
+
+/*
+ReconnectError
+Option.Reconnect 时，连接断开时仍未回复的请求、以及重连期间发起的请求以该错误失败，Err 为连接断开的原因；
+断开时已发送的请求可能执行过，也可能没有，幂等的请求可以直接重试，重连成功后新的请求照常发送
+*/
+type ReconnectError struct {
+	Err error
+}
+
+func (e *ReconnectError) Error() string {
+	return "rpc client: connection lost, reconnecting: " + e.Err.Error()
+}
+
+func (e *ReconnectError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary 该错误是暂时的，可以重试
+func (e *ReconnectError) Temporary() bool {
+	return true
+}
+
+/*
+reconnect
+Option.Reconnect 时由 receive 在读取出错后调用：未回复的请求以 *ReconnectError 失败，之后按退避间隔重新拨号、握手，
+成功后替换 client.cc，receive 继续接收；Close 或服务端排空后放弃，返回 ErrShutdown
+与 resume 不同，重连期间不持有 sending，新的请求不会等待，直接以 *ReconnectError 失败
+只支持 Dial/NewClient 建立的连接，重新拨号的地址为原连接的 RemoteAddr
+*/
+func (client *Client) reconnect(cause error) error {
+	rerr := &ReconnectError{Err: cause}
+	client.mu.Lock()
+	if client.closing || client.shutdown {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.reconnectErr = rerr
+	calls := make([]*Call, 0, len(client.pending))
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		calls = append(calls, call)
+	}
+	client.mu.Unlock()
+	failCalls(calls, rerr)
+	_ = client.cc.Close()
+
+	backoff, max := client.option.ReconnectBackoff, client.option.ReconnectMaxBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	if max <= 0 {
+		max = defaultReconnectMaxBackoff
+	}
+	for {
+		if !client.IsAvailable() {
+			return ErrShutdown
+		}
+		cc, reply, err := client.redial("")
+		if err == nil {
+			client.sending.Lock()
+			defer client.sending.Unlock()
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			if client.closing || client.shutdown {
+				_ = cc.Close()
+				return ErrShutdown
+			}
+			client.cc, client.session, client.maxStreams = cc, reply.Session, reply.MaxConcurrentStreams
+			client.reconnectErr, client.heartbeatErr = nil, nil
+			atomic.AddUint64(&client.reconnects, 1)
+			return nil
+		}
+		client.logger().Log(LevelWarn, "rpc client: reconnect error", F("err", err))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// Reconnects 返回 Option.Reconnect 重连成功的次数
+func (client *Client) Reconnects() uint64 {
+	return atomic.LoadUint64(&client.reconnects)
+}
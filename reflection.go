@@ -0,0 +1,107 @@
+package myGoRPC
+
+import (
+	"myGoRPC/service"
+	"sort"
+	"strings"
+)
+
+/*
+服务发现
+
+内置服务 reflectionService 供客户端在运行时查询服务端注册的服务与方法：
+
+	var services []ServiceDesc
+	err := client.Call(ctx, "_rpc", "ListServices", "", &services)
+	var sig MethodSignature
+	err = client.Call(ctx, "_rpc", "MethodSignature", "Arith.Multiply", &sig)
+
+类型以 reflect.Type 的字符串表示，如 "main.Args"、"*int"，只用于展示与调试，不是可移植的类型描述；
+该服务不在 ServiceMap 中，不出现在调试页面、配置输出与 ListServices 的结果里
+*/
+
+const reflectionService = "_rpc"
+
+// ServiceDesc ListServices 返回的一个服务
+type ServiceDesc struct {
+	Name    string
+	Methods []string // 普通方法，按名称排序
+	Streams []string // 流式方法，按名称排序
+}
+
+// MethodSignature MethodSignature 返回的方法签名
+type MethodSignature struct {
+	Service   string
+	Method    string
+	ArgType   string // 流式方法为空
+	ReplyType string // 流式方法为空
+	Context   bool   // 方法的第一个入参为 context.Context
+	Stream    bool   // 流式方法，见 stream.go
+	Cacheable bool   // 见 RegisterCacheable
+}
+
+// reflectionHandler reflectionService 的方法
+type reflectionHandler struct {
+	server *Server
+}
+
+func (server *Server) reflection() *service.Service {
+	server.reflectionOnce.Do(func() {
+		server.reflectionSvc, _ = service.New(reflectionService, &reflectionHandler{server: server})
+	})
+	return server.reflectionSvc
+}
+
+// ListServices 已注册的全部服务，按名称排序；入参不使用
+func (r *reflectionHandler) ListServices(_ string, reply *[]ServiceDesc) error {
+	byName := make(map[string]*ServiceDesc)
+	r.server.ServiceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service.Service)
+		desc := &ServiceDesc{Name: svc.Name, Methods: []string{}, Streams: []string{}}
+		for name := range svc.Method {
+			desc.Methods = append(desc.Methods, name)
+		}
+		sort.Strings(desc.Methods)
+		byName[svc.Name] = desc
+		return true
+	})
+	r.server.streams.Range(func(key, _ interface{}) bool {
+		parts := strings.SplitN(key.(string), ".", 2)
+		if desc := byName[parts[0]]; desc != nil {
+			desc.Streams = append(desc.Streams, parts[1])
+		}
+		return true
+	})
+	services := make([]ServiceDesc, 0, len(byName))
+	for _, desc := range byName {
+		sort.Strings(desc.Streams)
+		services = append(services, *desc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	*reply = services
+	return nil
+}
+
+// MethodSignature name 为 "服务名.方法名"，方法不存在时返回 CodeNotFound 的错误
+func (r *reflectionHandler) MethodSignature(name string, reply *MethodSignature) error {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return NewError(CodeBadArgument, "rpc server: method name must be Service.Method: %q", name)
+	}
+	sig := MethodSignature{Service: parts[0], Method: parts[1]}
+	if _, ok := r.server.streams.Load(name); ok {
+		sig.Stream = true
+		*reply = sig
+		return nil
+	}
+	_, mtype, err := r.server.findServiceMethod(parts[0], parts[1])
+	if err != nil {
+		return &RPCError{Code: CodeNotFound, Message: err.Error()}
+	}
+	sig.ArgType = mtype.ArgType.String()
+	sig.ReplyType = mtype.ReplyType.String()
+	sig.Context = mtype.HasCtx
+	sig.Cacheable = mtype.CacheTTL > 0
+	*reply = sig
+	return nil
+}
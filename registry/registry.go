@@ -1,7 +1,7 @@
 package registry
 
 import (
-	"log"
+	"myGoRPC"
 	"net/http"
 	"sort"
 	"strings"
@@ -12,11 +12,13 @@ import (
 /*
 GoRegistry
 添加服务、心跳保活、返回所有存活服务、清理失效服务
+Logger 输出注册中心的日志，nil 即为 myGoRPC.DefaultLogger()
 */
 type GoRegistry struct {
 	timeout time.Duration
 	mu      sync.Mutex
 	servers map[string]*ServerItem
+	Logger  myGoRPC.Logger
 }
 
 type ServerItem struct {
@@ -85,13 +87,21 @@ func (r *GoRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (r *GoRegistry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
-	log.Println("rpc registry path: ", registryPath)
+	r.logger().Log(myGoRPC.LevelDebug, "rpc registry: handle http", myGoRPC.F("path", registryPath))
+}
+
+func (r *GoRegistry) logger() myGoRPC.Logger {
+	if r.Logger != nil {
+		return r.Logger
+	}
+	return myGoRPC.DefaultLogger()
 }
 
 func HandleHTTP() {
 	DefaultGoRegister.HandleHTTP(defaultPath)
 }
 
+// Heartbeat 服务端定时向注册中心发送心跳，日志输出到 myGoRPC.DefaultLogger()
 func Heartbeat(registry, addr string, duration time.Duration) {
 	if duration == 0 {
 		duration = defaultTimeout - time.Duration(1)*time.Minute
@@ -109,12 +119,12 @@ func Heartbeat(registry, addr string, duration time.Duration) {
 }
 
 func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, " send heart beat to registry ", registry)
+	myGoRPC.DefaultLogger().Log(myGoRPC.LevelDebug, "rpc server: send heartbeat to registry", myGoRPC.F("addr", addr), myGoRPC.F("registry", registry))
 	httpClient := &http.Client{}
 	req, _ := http.NewRequest("POST", registry, nil)
 	req.Header.Set("GoRPC-Server", addr)
 	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err: ", err)
+		myGoRPC.DefaultLogger().Log(myGoRPC.LevelError, "rpc server: heartbeat error", myGoRPC.F("registry", registry), myGoRPC.F("err", err))
 		return err
 	}
 	return nil
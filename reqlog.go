@@ -0,0 +1,102 @@
+package myGoRPC
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+RequestRecord
+一次请求的结构化摘要（不包含 body），用于审计日志，与调试用的 log 输出无关
+Status 为 "ok" 或错误信息，Labels 为该连接的标签
+*/
+type RequestRecord struct {
+	Time    time.Time
+	Peer    string
+	Service string
+	Method  string
+	Seq     uint64
+	Latency time.Duration
+	Status  string
+	Labels  map[string]string `json:",omitempty"`
+}
+
+// RecordSink 接收请求摘要，会被多个协程并发调用，实现需要自行保证并发安全，且不应阻塞
+type RecordSink interface {
+	Record(rec *RequestRecord)
+}
+
+// RecordSinkFunc 将普通函数适配为 RecordSink
+type RecordSinkFunc func(rec *RequestRecord)
+
+func (f RecordSinkFunc) Record(rec *RequestRecord) {
+	f(rec)
+}
+
+type jsonLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesSink 每条记录编码为一行 JSON 写入 w
+func NewJSONLinesSink(w io.Writer) RecordSink {
+	return &jsonLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonLinesSink) Record(rec *RequestRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(rec); err != nil {
+		logger().Log(LevelWarn, "rpc server: request log error", F("err", err))
+	}
+}
+
+// ChanSink 将记录发送到 channel，channel 已满时丢弃，不阻塞请求的处理
+type ChanSink chan<- *RequestRecord
+
+func (c ChanSink) Record(rec *RequestRecord) {
+	select {
+	case c <- rec:
+	default:
+	}
+}
+
+/*
+RequestLog
+开启结构化请求日志，nil 即为不开启
+SampleRate 为采样比例，取值 (0, 1]，0 即为全部记录
+*/
+type RequestLog struct {
+	Sink       RecordSink
+	SampleRate float64
+}
+
+// logRequest 请求回复后调用，errMsg 为空表示成功
+func (server *Server) logRequest(req *request, start time.Time, errMsg string) {
+	rl := server.RequestLog
+	if rl == nil || rl.Sink == nil {
+		return
+	}
+	if rl.SampleRate > 0 && rl.SampleRate < 1 && rand.Float64() >= rl.SampleRate {
+		return
+	}
+	rec := &RequestRecord{
+		Time:    start,
+		Service: req.header.Service,
+		Method:  req.header.Method,
+		Seq:     req.header.Seq,
+		Latency: time.Since(start),
+		Status:  "ok",
+	}
+	if errMsg != "" {
+		rec.Status = errMsg
+	}
+	if req.sc != nil {
+		rec.Peer = req.sc.info.RemoteAddr
+		rec.Labels = req.sc.info.Labels
+	}
+	rl.Sink.Record(rec)
+}
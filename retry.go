@@ -0,0 +1,137 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+RetryPolicy
+Client.Call 的重试策略，通过 Option.Retry（整个 Client）或 WithRetryPolicy（单次调用）设置
+
+重试只在安全时进行：
+  - 请求确定没有被服务端执行的错误（ErrServerBusy、ErrConnQuiescing、ErrTooManyPending）总是可以重试
+  - 其他可重试的错误（连接断开、超时等）下，请求可能已经执行过，只有幂等的调用才重试：
+    方法列在 Idempotent 中（"Service.Method"），或以 WithIdempotent 的 ctx 发起
+
+重试在拦截器链的最内层进行，每次重试发送新的 Call，拦截器只执行一次、看到的是第一次尝试的 Call；ctx 结束后不再重试
+*/
+type RetryPolicy struct {
+	MaxAttempts int           // 最多尝试的次数（含第一次），小于等于 1 即为不重试
+	Backoff     time.Duration // 第一次重试前的等待，之后逐次翻倍，默认 50ms
+	MaxBackoff  time.Duration // 等待的上限，默认 1s
+	// 判断错误是否可以重试，nil 即为 DefaultRetryable
+	Retryable  func(err error) bool
+	Idempotent []string // 幂等的方法，"Service.Method"
+}
+
+const (
+	defaultRetryBackoff    = 50 * time.Millisecond
+	defaultRetryMaxBackoff = time.Second
+)
+
+/*
+DefaultRetryable
+连接层面的暂时错误：服务端过载或排空、未结束的请求过多、Option.Reconnect 重连中、会话恢复丢失的请求、心跳超时，
+以及 ctx 之外的处理超时（CodeTimeout）、服务暂时不可用（CodeUnavailable）与服务端标记为 Retryable 的 *RPCError；
+方法本身返回的其他错误不重试
+*/
+func DefaultRetryable(err error) bool {
+	var rerr *ReconnectError
+	var rpcErr *RPCError
+	switch {
+	case notExecuted(err), errors.As(err, &rerr),
+		errors.Is(err, ErrSessionLost), errors.Is(err, ErrHeartbeatTimeout):
+		return true
+	case errors.As(err, &rpcErr):
+		return rpcErr.Retryable || rpcErr.Code == CodeTimeout || rpcErr.Code == CodeUnavailable
+	}
+	return false
+}
+
+// notExecuted 请求确定没有被服务端执行的错误，非幂等的调用也可以重试
+func notExecuted(err error) bool {
+	return errors.Is(err, ErrServerBusy) || errors.Is(err, ErrConnQuiescing) || errors.Is(err, ErrTooManyPending)
+}
+
+type retryPolicyKey struct{}
+
+type idempotentKey struct{}
+
+// WithRetryPolicy 以 ctx 发起的 Client.Call 使用 policy，覆盖 Option.Retry；policy 为 nil 即为不重试
+func WithRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// WithIdempotent 以 ctx 发起的 Client.Call 视为幂等，任何可重试的错误都会重试
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// IsIdempotent ctx 是否由 WithIdempotent 标记，供 xclient 的对冲请求等使用
+func IsIdempotent(ctx context.Context) bool {
+	v, _ := ctx.Value(idempotentKey{}).(bool)
+	return v
+}
+
+func (p *RetryPolicy) idempotent(ctx context.Context, call *Call) bool {
+	if IsIdempotent(ctx) {
+		return true
+	}
+	name := call.Service + "." + call.Method
+	for _, m := range p.Idempotent {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// retry 按策略包裹 invoke；没有策略时原样返回
+func (client *Client) retry(invoke Invoker) Invoker {
+	return func(ctx context.Context, call *Call) error {
+		policy := client.option.Retry
+		if p, ok := ctx.Value(retryPolicyKey{}).(*RetryPolicy); ok {
+			policy = p
+		}
+		if policy == nil || policy.MaxAttempts <= 1 {
+			return invoke(ctx, call)
+		}
+		retryable := policy.Retryable
+		if retryable == nil {
+			retryable = DefaultRetryable
+		}
+		backoff, max := policy.Backoff, policy.MaxBackoff
+		if backoff <= 0 {
+			backoff = defaultRetryBackoff
+		}
+		if max <= 0 {
+			max = defaultRetryMaxBackoff
+		}
+		for attempt := 1; ; attempt++ {
+			err := invoke(ctx, call)
+			if err == nil || attempt >= policy.MaxAttempts || !retryable(err) ||
+				!(notExecuted(err) || policy.idempotent(ctx, call)) {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > max {
+				backoff = max
+			}
+			// 上一次的 Call 送入 Done 后仍可能被 Client 内部访问，重试使用新的 Call
+			call = &Call{
+				Service:  call.Service,
+				Method:   call.Method,
+				Args:     call.Args,
+				Reply:    call.Reply,
+				Done:     make(chan *Call, 1),
+				Metadata: call.Metadata,
+			}
+		}
+	}
+}
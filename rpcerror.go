@@ -0,0 +1,137 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myGoRPC/codec"
+	"runtime"
+)
+
+/*
+错误分类
+
+服务端回复错误时在 Header.ErrorCode 中附带分类，客户端还原为 *RPCError，调用方用 errors.As 取得 Code：
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == CodeNotFound { ... }
+
+ErrorCode 为 0（旧版本服务端，或未分类的错误）时仍还原为普通的 error；
+ErrServerBusy、ErrConnQuiescing 不附带分类，仍还原为原来的哨兵错误
+
+更简单的写法是 Code(err)，本地的错误（ErrShutdown、ctx 超时等）也有对应的分类；
+errors.Is(err, &RPCError{Code: CodeNotFound}) 按 Code 匹配，CodeTimeout 的错误还匹配 context.DeadlineExceeded。
+方法返回 *RPCError 即可指定分类，Detail 为可选的附加信息（如 JSON 编码的结构化内容），随 Header.ErrorDetail 传给客户端；
+Retryable 表示服务端认为稍后重试可能成功（如暂时的依赖故障），随 Header.ErrorRetryable 传给客户端，DefaultRetryable 据此重试。
+旧版本的对端忽略这两个字段，只看到 Header.Error 中的字符串
+*/
+const (
+	CodeUnknown           = iota // 未分类
+	CodeNotFound                 // 服务或方法不存在
+	CodeBadArgument              // 入参无法解码
+	CodeHandler                  // 方法返回了错误
+	CodePanic                    // 方法发生 panic
+	CodeTimeout                  // 处理超时（HandleTimeout 或调用方的 deadline）
+	CodeUnavailable              // 服务暂时不可用，可以稍后重试
+	CodeInternal                 // 服务端内部错误
+	CodeResourceExhausted        // 超过限流或配额，见 ratelimit.go
+)
+
+type RPCError struct {
+	Code      int
+	Message   string
+	Detail    string
+	Retryable bool
+}
+
+// NewError 指定分类的错误，方法返回它即可让客户端得到相同的 Code
+func NewError(code int, format string, args ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// Is target 为 *RPCError 时按 Code 匹配（target 的 Message 非空时还需相同）
+func (e *RPCError) Is(target error) bool {
+	if t, ok := target.(*RPCError); ok {
+		return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+	}
+	return target == context.DeadlineExceeded && e.Code == CodeTimeout
+}
+
+// Code err 的分类，客户端与服务端都可以使用；err 为 nil 时返回 CodeUnknown
+func Code(err error) int {
+	var rpcErr *RPCError
+	switch {
+	case err == nil:
+		return CodeUnknown
+	case errors.As(err, &rpcErr):
+		return rpcErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.As(err, new(*codec.BodyTooLargeError)):
+		return CodeResourceExhausted
+	case errors.Is(err, ErrServerBusy), errors.Is(err, ErrConnQuiescing), errors.Is(err, ErrShutdown),
+		errors.Is(err, ErrTooManyPending):
+		return CodeUnavailable
+	}
+	return CodeUnknown
+}
+
+// handlerPanic 方法发生 panic 时 safeCall 返回
+type handlerPanic struct {
+	value interface{}
+}
+
+func (p *handlerPanic) Error() string {
+	return fmt.Sprintf("rpc server: handler panic: %v", p.value)
+}
+
+// safeCall 方法 panic 时回复 CodePanic 的错误，不影响其他请求；panic 与调用栈以 LevelError 记录到 Server.Logger
+func (server *Server) safeCall(req *request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = server.recovered(req.header.Service+"."+req.header.Method, r)
+		}
+	}()
+	return server.intercept(req)
+}
+
+func (server *Server) recovered(method string, r interface{}) error {
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	server.logger().Log(LevelError, "rpc server: handler panic", F("method", method), F("panic", r), F("stack", string(stack)))
+	return &handlerPanic{value: r}
+}
+
+// errorCode 处理请求时的错误对应的分类
+func errorCode(err error) int {
+	var bodyErr *codec.BodyError
+	var panicErr *handlerPanic
+	var rpcErr *RPCError
+	switch {
+	case errors.As(err, &bodyErr):
+		return CodeBadArgument
+	case errors.As(err, &panicErr):
+		return CodePanic
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.As(err, &rpcErr):
+		// 拦截器或方法返回的已分类的错误
+		return rpcErr.Code
+	}
+	return CodeHandler
+}
+
+// setError 将 err 写入 header，附带分类，err 为 *RPCError 时还附带其 Detail、Retryable
+func setError(header *codec.Header, err error, code int) {
+	header.Error = err.Error()
+	header.ErrorCode = code
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		header.ErrorDetail = rpcErr.Detail
+		header.ErrorRetryable = rpcErr.Retryable
+	}
+}
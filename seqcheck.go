@@ -0,0 +1,77 @@
+package myGoRPC
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+seqMonitor
+诊断用：检查响应的序号是否异常。TCP 上本不应出现，用于排查实现中的 bug
+
+服务端并发处理请求，响应本身就是乱序的，所以只认为以下情况是异常：
+- 重复：最近 window 个已收到的序号中再次出现
+- 未知：序号为 0（非法）或大于等于尚未分配的序号
+- 跳跃：与上一个响应的序号相差超过 window
+
+只记录最近 window 个序号，内存占用有上限；只在 receive 协程中调用，除 anomalies 外无需加锁
+*/
+type seqMonitor struct {
+	window    int
+	ring      []uint64
+	next      int
+	seen      map[uint64]struct{}
+	last      uint64
+	anomalies uint64
+	logger    Logger // nil 即为默认 Logger
+}
+
+func newSeqMonitor(window int) *seqMonitor {
+	return &seqMonitor{
+		window: window,
+		ring:   make([]uint64, 0, window),
+		seen:   make(map[uint64]struct{}, window),
+	}
+}
+
+// observe 检查一个响应序号，issued 为下一个将要分配的序号
+func (m *seqMonitor) observe(seq, issued uint64) {
+	switch {
+	case seq == 0 || seq >= issued:
+		m.flag("unknown seq %d, next seq to issue is %d", seq, issued)
+	case m.has(seq):
+		m.flag("duplicate response for seq %d", seq)
+	case m.last != 0 && seq > m.last && seq-m.last > uint64(m.window):
+		m.flag("seq jumped from %d to %d", m.last, seq)
+	}
+	m.remember(seq)
+	m.last = seq
+}
+
+func (m *seqMonitor) has(seq uint64) bool {
+	_, ok := m.seen[seq]
+	return ok
+}
+
+func (m *seqMonitor) remember(seq uint64) {
+	if m.has(seq) {
+		return
+	}
+	if len(m.ring) < m.window {
+		m.ring = append(m.ring, seq)
+	} else {
+		delete(m.seen, m.ring[m.next])
+		m.ring[m.next] = seq
+		m.next = (m.next + 1) % m.window
+	}
+	m.seen[seq] = struct{}{}
+}
+
+func (m *seqMonitor) flag(format string, v ...interface{}) {
+	atomic.AddUint64(&m.anomalies, 1)
+	l := m.logger
+	if l == nil {
+		l = logger()
+	}
+	l.Log(LevelWarn, "rpc client: seq anomaly", F("detail", fmt.Sprintf(format, v...)))
+}
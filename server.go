@@ -1,17 +1,19 @@
 package myGoRPC
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
 	"io"
-	"log"
 	"myGoRPC/codec"
 	"myGoRPC/service"
 	"net"
 	"net/http"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,10 +29,81 @@ type Option struct {
 	CodecType      codec.Type
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	SeqCheckWindow int // 客户端使用，大于 0 时检查响应序号是否重复、跳跃，数值为记录的最近序号个数
+	Version        int // 握手协议版本，由 parseOptions 填写，见 handshake.go
+	// 客户端使用，连接不支持版本协商的旧服务端时置为 true，此时不发送 Version，也不等待服务端回复
+	LegacyHandshake bool `json:"-"`
+	// 客户端使用，连接不支持分帧握手（V2）的 V1 服务端时置为 true，此时按 V1 以 JSON 发送 Option
+	JSONHandshake bool `json:"-"`
+	// 握手之后在同一连接上升级为 TLS（STARTTLS），需要 V1 及以上版本的握手，客户端需设置 TLSConfig
+	StartTLS  bool
+	TLSConfig *tls.Config `json:"-"`
+	// 服务端按请求到达的顺序逐个处理（FIFO），前一个请求回复后才处理下一个；
+	// 只影响本连接，其他连接仍然并发处理。本连接的吞吐降为单个请求的处理速度，慢请求会阻塞后续请求
+	Serial bool
+	Labels map[string]string // 连接标签，服务端附加到该连接的日志上，见 labels.go
+	Token  string            `json:",omitempty"` // 客户端的凭证，握手时由 Server.AuthFunc 校验，见 auth.go
+	// 会话恢复，见 session.go：Resumable 时服务端在握手回复中返回会话令牌，连接断开后客户端重新拨号，
+	// 以 Session 携带令牌恢复会话；ResumeTimeout 为客户端重试恢复的总时长，默认 30s
+	Resumable     bool
+	Session       string
+	ResumeTimeout time.Duration `json:"-"`
+	// 客户端使用，自动重连，见 reconnect.go：连接断开后重新拨号、握手，断开时未回复的请求以 *ReconnectError 失败；
+	// 重试间隔从 ReconnectBackoff（默认 50ms）开始逐次翻倍，不超过 ReconnectMaxBackoff（默认 5s），直到连接成功或 Close
+	Reconnect           bool          `json:"-"`
+	ReconnectBackoff    time.Duration `json:"-"`
+	ReconnectMaxBackoff time.Duration `json:"-"`
+	// 客户端使用，请求的最长存活时间，超过后仍未回复即失败，见 lifetime.go
+	MaxCallLifetime time.Duration `json:"-"`
+	// 客户端使用，单独限制 Option 握手（含 TLS、压缩协商）的时长，0 即为只受 ConnectTimeout 限制
+	HandshakeTimeout time.Duration `json:"-"`
+	// 客户端使用，DialHTTP 发起 CONNECT 的路径，需与服务端挂载 Server 的路径一致，默认 DefaultRPCPath
+	RPCPath string `json:"-"`
+	// 客户端使用，心跳间隔与等待回复的超时，0 即为不发送心跳；连续 HeartbeatMaxMisses 次（默认 1）超时后关闭连接，见 heartbeat.go
+	HeartbeatInterval  time.Duration `json:"-"`
+	HeartbeatTimeout   time.Duration `json:"-"`
+	HeartbeatMaxMisses int           `json:"-"`
+	// 客户端使用，DialPool 时由连接池统一调度各连接的心跳：错开发送，最近收到过回复的连接跳过，见 pool.go；单独的 Client 忽略
+	SharedHeartbeat bool `json:"-"`
+	// 客户端使用，未结束的请求数上限，达到后新的请求以 ErrTooManyPending 失败，0 即为不限制；
+	// PendingWait 时改为等待名额：Call 等到 ctx 结束，Go 一直等待（背压），client 关闭时以 ErrShutdown 失败。心跳不受限制
+	MaxPending  int  `json:"-"`
+	PendingWait bool `json:"-"`
+	// 客户端使用，为 true 时忽略服务端回复中的背压信号，不限速，见 backpressure.go
+	IgnoreBackpressure bool `json:"-"`
+	// 连接使用的压缩算法，为空或 "none" 即为不压缩；CompressionThreshold 大于 0 时逐条消息压缩，
+	// 小于该字节数的消息不压缩，需要服务端支持，见 compress.go
+	Compression          string
+	CompressionThreshold int `json:",omitempty"`
+	// 单个 body 编码后的字节数上限，随握手发给服务端，两端的读写都按它检查，0 即为不限制；
+	// ChunkSize 大于 0 时超过该字节数的消息分块传输，需要服务端支持，见 message.go
+	MaxMessageSize int `json:",omitempty"`
+	ChunkSize      int `json:",omitempty"`
+	// 客户端使用，轻量的观测回调：每个请求发送前调用一次 OnStart（此时尚未分配 Seq），
+	// 结束时（成功、出错、超时或 ctx 取消）调用一次 OnFinish，在 Call 送入 Done 之前；
+	// latency 从注册到 pending 起计算，未能注册（如 client 已关闭）时为 0。回调在 Client 的内部协程中同步执行，不应阻塞；
+	// 调用时不持有 Client 的锁，可以调用 PendingCount、IsAvailable、Stats 等方法
+	OnStart  func(call *Call)                        `json:"-"`
+	OnFinish func(call *Call, latency time.Duration) `json:"-"`
+	// 客户端使用，响应 body 的字节数上限，超过时该请求以 *codec.BodyTooLargeError 失败并关闭连接，0 即为不限制；
+	// 服务端对应 Server.MaxBodySize
+	MaxBodySize int `json:"-"`
+	// 客户端使用，Client.Call 的重试策略，nil 即为不重试；单次调用可以用 WithRetryPolicy 覆盖，见 retry.go
+	Retry *RetryPolicy `json:"-"`
+	// 客户端使用，输出日志的 Logger，nil 即为 SetLogger 设置的默认 Logger，见 logger.go
+	Logger Logger `json:"-"`
+
+	dialTLS *tls.Config // 由 DialTLS 填写，恢复会话重新拨号时同样先完成 TLS 握手，见 tls.go
+	// 由 NewInProcClient、DialWebsocket 填写，重新拨号时代替 net.DialTimeout，见 transport.go、websocket.go
+	dialConn func(timeout time.Duration) (net.Conn, error)
 }
 
+// DefaultHandshakeTimeout 服务端等待握手的默认时长，避免只建立连接、不发送 Option 的客户端一直占用连接
+const DefaultHandshakeTimeout = 10 * time.Second
+
 var DefaultOption = &Option{
 	RpcNumber:      RpcNumber,
+	Version:        HandshakeVersion,
 	CodecType:      codec.GobType,
 	ConnectTimeout: time.Second * 10,
 }
@@ -41,6 +114,73 @@ Server
 */
 type Server struct {
 	ServiceMap sync.Map
+	Shed       *ShedPolicy    // 过载保护策略，nil 即为不启用
+	CacheSize  int            // 响应缓存的最大条目数，默认 1024，需在 RegisterCacheable 之前设置
+	Faults     *FaultInjector // 故障注入，仅在 -tags faultinject 编译时生效
+	TLSConfig  *tls.Config    // 非 nil 时允许客户端通过 Option.StartTLS 升级连接
+	LabelKeys  []string       // 允许的连接标签键，nil 即为不限制
+	RequestLog *RequestLog    // 结构化请求日志（审计），nil 即为不开启
+	// Accept 之后、握手之前过滤连接，返回非 nil 时直接关闭，见 acceptfilter.go
+	AcceptFilter AcceptFilter
+	// 等待客户端完成握手（TLS 握手与读取 Option）的时长，0 即为 DefaultHandshakeTimeout，负数即为不限制
+	HandshakeTimeout time.Duration
+	// 请求 body 的字节数上限，超过时回复错误并关闭连接，0 即为不限制；客户端对应 Option.MaxBodySize
+	MaxBodySize int
+	// 握手时校验 Option.Token，拒绝时关闭连接，nil 即为不校验，见 auth.go
+	AuthFunc AuthFunc
+	// 全部连接同时处理的请求数上限，0 即为不限制；达到上限后按 BusyPolicy 排队或拒绝，见 workers.go
+	MaxConcurrentRequests int
+	BusyPolicy            BusyPolicy
+	MaxQueuedRequests     int // BusyQueue 时等待名额的请求数上限，0 即为不限制
+	// 连接没有正在处理的请求、且超过该时长没有收到任何请求（含心跳）时关闭连接，0 即为不限制，见 idle.go
+	IdleTimeout time.Duration
+	// 每个连接上同时打开的流的上限，0 即为不限制；握手时告知客户端，超过时以 ErrTooManyStreams 拒绝，见 stream.go
+	MaxConcurrentStreams int
+	// 输出日志的 Logger，nil 即为 SetLogger 设置的默认 Logger，见 logger.go
+	Logger Logger
+	// 为 true 时在每个回复的 trailer 中附带负载报告，Utilization 为报告的利用率（0~1），nil 即为不报告利用率，见 load.go
+	ReportLoad  bool
+	Utilization func() float64
+	// 负载达到阈值时在回复的 trailer 中告知客户端放慢发送，nil 即为不发出信号，见 backpressure.go
+	Backpressure *Backpressure
+
+	inflight      int64  // 正在处理的请求数
+	heapInuse     uint64 // 最近一次采样的堆内存使用量
+	heapSampledAt int64  // 最近一次采样的时间，UnixNano
+
+	cacheOnce sync.Once
+	cache     *responseCache
+
+	connSeq uint64   // 连接编号
+	conns   sync.Map // 连接编号 -> *serverConn
+
+	sessionMu sync.Mutex
+	sessions  map[string]*session // 会话令牌 -> *session
+
+	limits     sync.Map // 服务名 -> *fifoLimiter，见 concurrency.go
+	rateLimits sync.Map // "服务名.方法名" 或服务名 -> *rateLimiter，见 ratelimit.go
+
+	stats statsRegistry // 按方法的调用统计，见 stats.go
+
+	streams sync.Map // "服务名.方法名" -> *streamMethod，见 stream.go
+
+	topicMu sync.Mutex
+	topics  map[string]map[uint64]*serverConn // 主题 -> 连接编号 -> 订阅的连接，见 pubsub.go
+
+	reflectionOnce sync.Once
+	reflectionSvc  *service.Service // 内置的 reflectionService，见 reflection.go
+
+	workersOnce sync.Once
+	workers     chan struct{} // MaxConcurrentRequests 的名额
+	queued      int64         // 正在等待名额的请求数
+
+	interceptorMu sync.Mutex
+	interceptors  []ServerInterceptor // 服务端拦截器，见 interceptor.go
+	extractors    []MetadataExtractor // 元数据提取器，interceptorMu 保护，见 extractor.go
+
+	closed     int32 // Shutdown 或 Close 之后置为 1，原子操作，见 shutdown.go
+	listenerMu sync.Mutex
+	listeners  map[net.Listener]struct{}
 }
 
 func NewServer() *Server {
@@ -52,22 +192,39 @@ Accept
 实现了 Accept 方式，net.Listener 作为参数，
 for 循环等待 socket 连接建立，
 并开启子协程处理，处理过程交给了 ServerConn 方法
+Shutdown、Close 时关闭 listen 并返回，之后调用 Accept 直接关闭 listen
 */
 func (server *Server) Accept(listen net.Listener) {
+	if !server.trackListener(listen, true) {
+		_ = listen.Close()
+		server.logger().Log(LevelError, "rpc server: accept error", F("err", ErrServerClosed))
+		return
+	}
+	defer server.trackListener(listen, false)
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error: ", err)
+			if server.isClosed() {
+				err = ErrServerClosed
+			}
+			server.logger().Log(LevelError, "rpc server: accept error", F("err", err))
 			return
 		}
+		if server.AcceptFilter != nil {
+			if err := server.AcceptFilter(conn.RemoteAddr()); err != nil {
+				server.logger().Log(LevelInfo, "rpc server: reject connection", F("remote", conn.RemoteAddr()), F("err", err))
+				_ = conn.Close()
+				continue
+			}
+		}
 		go server.ServeConn(conn)
 	}
 }
 
 /*
 ServeConn
-首先使用 json.NewDecoder 反序列化得到 Option 实例，
-检查 RpcNumber 和 CodeType 的值是否正确。
+首先完成握手（见 handshake.go）：反序列化得到 Option 实例，
+检查 RpcNumber 和 CodeType 的值是否正确，按协议版本决定是否回复客户端。
 然后根据 CodeType 得到对应的消息编解码器，
 接下来的处理交给 serverCodec
 */
@@ -76,21 +233,51 @@ func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 		_ = conn.Close()
 	}()
 
-	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options decode error: ", err)
-		return
+	// 握手阶段的超时，握手完成后清除，之后的读写不受限制
+	dc, hasDeadline := conn.(deadlineConn)
+	if timeout := server.handshakeTimeout(); hasDeadline && timeout > 0 {
+		_ = dc.SetDeadline(time.Now().Add(timeout))
 	}
-	if opt.RpcNumber != RpcNumber {
-		log.Printf("rpc server: invalid rpc number %x", opt.RpcNumber)
+	// 由 AcceptTLS 接受的连接，先完成 TLS 握手，证书或协议不匹配时直接关闭连接
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			server.logger().Log(LevelError, "rpc server: tls handshake error", F("remote", remoteAddr(conn)), F("err", err))
+			return
+		}
+	}
+	opt, f, rwc, err := server.handshake(conn)
+	if err != nil {
+		server.logger().Log(LevelError, "rpc server: handshake error", F("remote", remoteAddr(conn)), F("err", err))
 		return
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	counted := &byteCounter{ReadWriteCloser: rwc}
+	if rwc, err = compress(opt, server.MaxBodySize, counted); err != nil {
+		server.logger().Log(LevelError, "rpc server: compression error", F("remote", remoteAddr(conn)), F("err", err))
 		return
 	}
-	server.serveCodec(f(conn), &opt)
+	if hasDeadline {
+		_ = dc.SetDeadline(time.Time{})
+	}
+	server.serveCodec(server.countStats(messageCodec(f, f(rwc), opt, server.MaxBodySize), counted), opt, remoteAddr(conn))
+}
+
+type deadlineConn interface {
+	SetDeadline(t time.Time) error
+}
+
+func (server *Server) handshakeTimeout() time.Duration {
+	if server.HandshakeTimeout == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return server.HandshakeTimeout
+}
+
+// limitBody codec 实现了 codec.BodyLimiter 时设置 body 上限，否则不限制
+func limitBody(cc codec.Codec, max int) codec.Codec {
+	if l, ok := cc.(codec.BodyLimiter); ok && max > 0 {
+		l.SetMaxBodySize(max)
+	}
+	return cc
 }
 
 // 定义非法请求的回应
@@ -103,63 +290,174 @@ serveCodec
 在一次连接中，允许接收多个请求，即多个 request header 和 request body，
 因此这里使用了 for 无限制地等待请求的到来，直到发生错误（例如连接被关闭，接收到的报文有问题等）
 
-handleRequest 使用了协程并发执行请求
+handleRequest 使用了协程并发执行请求，Option.Serial 时按顺序逐个执行
 
 处理请求是并发的，但是回复请求的报文必须是逐个发送的，并发容易导致多个回复报文交织在一起，客户端无法解析。在这里使用锁(sending)保证
 
 只有在 header 解析失败时，才终止循环
 */
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
-	sending := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
+func (server *Server) serveCodec(cc codec.Codec, opt *Option, remote string) {
+	sc := server.trackConn(cc, opt, remote)
+	defer server.conns.Delete(sc.info.ID)
+	// 登记之后再检查，Shutdown 要么能遍历到该连接，要么在这里看到服务端已关闭
+	if server.isClosed() {
+		_ = server.Quiesce(sc.info.ID)
+	}
+	if sess := server.lookupSession(opt.Session); sess != nil {
+		server.attach(sess, sc)
+	}
+	if server.IdleTimeout > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go server.watchIdle(sc, stop)
+	}
+	sending, wg := sc.sending, sc.wg
 	for {
 		// 读取请求
-		req, err := server.readRequest(cc)
+		req, err := server.readRequest(cc, opt)
+		if req != nil {
+			sc.touch()
+			req.md, req.header.Metadata = req.header.Metadata, nil
+		}
+		// 客户端放弃的请求，见 cancel.go
+		if err == nil && req.header.Frame == FrameCancel {
+			if err = cc.ReadBody(nil); err != nil {
+				break
+			}
+			sc.cancelCall(req.header.Seq)
+			continue
+		}
+		// 流的帧，见 stream.go
+		if err == nil && req.header.Frame != FrameUnary && req.header.Frame != FrameNotify {
+			req.header.Metadata = req.md
+			if err = server.serveStream(sc, req.header, opt); err != nil {
+				break
+			}
+			continue
+		}
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.header.Error = err.Error()
+			code := CodeNotFound
+			if req.argV.IsValid() {
+				code = CodeBadArgument
+			}
+			setError(req.header, err, code)
+			server.sendResponse(cc, req.header, invalidRequest, sending)
+			if _, tooLarge := err.(*codec.BodyTooLargeError); tooLarge {
+				break
+			}
+			continue
+		}
+		req.sc = sc
+		// 心跳直接回复
+		if req.header.Service == heartbeatService {
+			server.sendResponse(cc, req.header, invalidRequest, sending)
+			continue
+		}
+		// 订阅直接处理，见 pubsub.go
+		if req.header.Service == pubsubService {
+			server.subscription(sc, req)
+			continue
+		}
+		// 过载时直接拒绝，body 已经读取完毕，不影响后续请求的解析
+		if server.overloaded() {
+			req.header.Error = ErrServerBusy.Error()
+			server.sendResponse(cc, req.header, invalidRequest, sending)
+			continue
+		}
+		// 超过限流时直接拒绝，不排队
+		if err := server.rateLimited(req); err != nil {
+			setError(req.header, err, CodeResourceExhausted)
+			server.sendResponse(cc, req.header, invalidRequest, sending)
+			continue
+		}
+		// 达到并发上限，等待名额或拒绝
+		if !server.acquireWorker(req) {
+			req.header.Error = ErrServerBusy.Error()
+			server.sendResponse(cc, req.header, invalidRequest, sending)
+			continue
+		}
+		// 连接正在 Quiesce，不再处理新的请求
+		if !sc.admit() {
+			server.releaseWorker()
+			req.header.Error = ErrConnQuiescing.Error()
 			server.sendResponse(cc, req.header, invalidRequest, sending)
 			continue
 		}
 		// 处理请求
-		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		atomic.AddInt64(&server.inflight, 1)
+		if opt.Serial {
+			server.handleRequest(req, opt.HandleTimeout)
+			continue
+		}
+		go server.handleRequest(req, opt.HandleTimeout)
+	}
+	sc.endStreams()
+	server.unsubscribeAll(sc)
+	if sc.session != nil {
+		// 之后的回复暂存在会话中，等待客户端恢复
+		sc.session.detach(sc)
+	} else {
+		// 连接断开，取消未结束的请求
+		sc.cancel()
 	}
 	wg.Wait()
+	sc.cancel()
 	cc.Close()
 }
 
 type request struct {
-	header *codec.Header
-	argV   reflect.Value
-	replyV reflect.Value
-	mtype  *service.MethodType
-	svc    *service.Service
+	header  *codec.Header
+	argV    reflect.Value
+	replyV  reflect.Value
+	mtype   *service.MethodType
+	svc     *service.Service
+	ctx     context.Context   // 携带本次处理的 deadline，传给第一个入参为 context.Context 的方法
+	sc      *serverConn       // 请求所属的连接
+	md      map[string]string // 请求头携带的元数据，从 header 中取出，回复不回传
+	trailer *trailer          // 方法通过 SetTrailer 设置的、随回复返回的元数据
 }
 
-func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
+func (server *Server) readRequestHeader(cc codec.Codec, opt *Option) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error: ", err)
+			server.logger().Log(LevelError, logPrefix(opt)+": read header error", F("err", err))
 		}
 		return nil, err
 	}
 	return &h, nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
-	h, err := server.readRequestHeader(cc)
+func (server *Server) readRequest(cc codec.Codec, opt *Option) (*request, error) {
+	h, err := server.readRequestHeader(cc, opt)
 	if err != nil {
 		return nil, err
 	}
 	req := &request{header: h}
+	// 流的帧由 serveStream 读取 body
+	if h.Frame != FrameUnary && h.Frame != FrameNotify {
+		return req, nil
+	}
+	if h.Service == heartbeatService {
+		if err = cc.ReadBody(nil); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+	if h.Service == pubsubService {
+		return server.readTopic(cc, req)
+	}
 	//  请求参数尚未确定，假定为string
 
 	req.svc, req.mtype, err = server.findServiceMethod(h.Service, h.Method)
 	if err != nil {
+		// 丢弃 body，后续的请求才能正确解析
+		if rerr := cc.ReadBody(nil); rerr != nil {
+			return nil, rerr
+		}
 		return req, err
 	}
 
@@ -173,30 +471,81 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	}
 
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read argV err: ", err)
+		server.logger().Log(LevelError, logPrefix(opt)+": read argv error", F("method", h.Service+"."+h.Method), F("err", err))
+		// 只有 body 被完整读取（*codec.BodyError）时才回复错误并继续，否则数据流的位置未知，关闭连接；
+		// body 超过上限时先回复错误，再由 serveCodec 关闭连接
+		_, tooLarge := err.(*codec.BodyTooLargeError)
+		if _, ok := err.(*codec.BodyError); !ok && !tooLarge {
+			return nil, err
+		}
 		return req, err
 	}
 	return req, nil
 }
 
+// sendResponse 写入一个回复，单向调用（FrameNotify）不回复
 func (server *Server) sendResponse(cc codec.Codec, header *codec.Header, body interface{}, sending *sync.Mutex) {
+	if header.Frame == FrameNotify {
+		return
+	}
 	sending.Lock()
 	defer sending.Unlock()
 	if err := cc.Write(header, body); err != nil {
-		log.Println("rpc server: write response error: ", err)
+		server.logger().Log(LevelError, "rpc server: write response error", F("seq", header.Seq), F("err", err))
+		// 编码 reply 时 panic、无法编码或超过 MaxMessageSize，连接仍然可用，改为回复错误，避免客户端一直等待
+		if codec.EncodeFailed(err) {
+			header.Error, header.ErrorCode = "rpc server: "+err.Error(), CodeInternal
+			var tooLarge *codec.BodyTooLargeError
+			if errors.As(err, &tooLarge) {
+				header.ErrorCode = CodeResourceExhausted
+			}
+			_ = cc.Write(header, invalidRequest)
+		}
 	}
 }
 
+// respond 回复 handleRequest 的结果，附带方法设置的 trailer，可恢复的连接经由会话发送
+func (server *Server) respond(req *request, body interface{}) {
+	if req.header.Frame == FrameNotify {
+		return
+	}
+	req.header.Metadata = server.withBackpressure(server.withLoad(req.trailer.get()))
+	if req.sc.session != nil {
+		req.sc.session.send(server, req.header, body)
+		return
+	}
+	server.sendResponse(req.sc.cc, req.header, body, req.sc.sending)
+}
+
 /*
 handleRequest
 调用相应 rpc 方法，写入 req.replyV
 而后调用 sendResponse
 
-加入超时处理
+加入超时处理：取 Option.HandleTimeout 与调用方剩余时间预算（header.Timeout）中较小的一个
 */
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+func (server *Server) handleRequest(req *request, timeout time.Duration) {
 	// 应调用相应rpc方法，获取replyV，暂时只print参数
-	defer wg.Done()
+	defer req.sc.finish()
+	start := time.Now()
+	if budget := time.Duration(req.header.Timeout); budget > 0 && (timeout == 0 || budget < timeout) {
+		timeout = budget
+	}
+	// 请求的 ctx 在客户端发送 FrameCancel 或连接断开时取消，见 cancel.go
+	var cancel context.CancelFunc
+	req.ctx, cancel = context.WithCancel(req.sc.ctx)
+	defer cancel()
+	defer req.sc.trackCall(req.header.Seq, cancel)()
+	if len(req.md) > 0 {
+		req.ctx = context.WithValue(req.ctx, metadataKey{}, req.md)
+	}
+	req.trailer = new(trailer)
+	req.ctx = context.WithValue(req.ctx, trailerKey{}, req.trailer)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		req.ctx, cancel = context.WithTimeout(req.ctx, timeout)
+		defer cancel()
+	}
 	called := make(chan struct{})
 	sent := make(chan struct{})
 
@@ -210,7 +559,7 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	//
 	//	if err != nil {
 	//		req.header.Error = err.Error()
-	//		server.sendResponse(cc, req.header, invalidRequest, sending)
+	//		server.respond(req, invalidRequest)
 	//		sent <- struct{}{}
 	//		return
 	//	}
@@ -224,36 +573,56 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	//}(ctx)
 
 	go func() {
-		err := req.svc.Call(req.mtype, req.argV, req.replyV)
+		err := server.Faults.inject(req.header.Service, req.header.Method)
+		if err == nil {
+			err = server.safeCall(req)
+		}
+		server.releaseWorker()
+		atomic.AddInt64(&server.inflight, -1)
 		called <- struct{}{}
 
+		if err == errDropResponse {
+			sent <- struct{}{}
+			return
+		}
+
 		if err != nil {
-			req.header.Error = err.Error()
-			server.sendResponse(cc, req.header, invalidRequest, sending)
+			setError(req.header, err, errorCode(err))
+			server.respond(req, invalidRequest)
 			sent <- struct{}{}
 			return
 		}
 
-		server.sendResponse(cc, req.header, req.replyV.Interface(), sending)
+		server.respond(req, req.replyV.Interface())
 		sent <- struct{}{}
 	}()
 
 	if timeout == 0 {
 		<-called
 		<-sent
+		server.finishRequest(req, start)
 		return
 	}
 
 	select {
 	case <-time.After(timeout):
 		// 如果在timeout后call才调用结束，但已经超时，直接返回，将不会接受called，存在goroutines泄露
-		req.header.Error = fmt.Sprintf("rpc server: request handle timeout")
-		server.sendResponse(cc, req.header, invalidRequest, sending)
+		setError(req.header, errors.New("rpc server: request handle timeout"), CodeTimeout)
+		server.respond(req, invalidRequest)
+		server.finishRequest(req, start)
 	case <-called:
 		<-sent
+		server.finishRequest(req, start)
 	}
 }
 
+// finishRequest 请求回复之后记录请求日志与调用统计
+func (server *Server) finishRequest(req *request, start time.Time) {
+	errMsg := req.header.Error
+	server.logRequest(req, start, errMsg)
+	server.stats.finish(req.header.Service+"."+req.header.Method, time.Since(start), errMsg != "")
+}
+
 // ------------------ 构建默认 server ----------------
 
 //var DefaultServer = NewServer()
@@ -262,11 +631,64 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 
 // ------------------ 服务注册、服务发现 ---------------
 
+/*
+Register
+以结构体名称注册服务，方法签名不符合要求（见 service.New）、名称重复时返回错误
+*/
 func (server *Server) Register(rcvr interface{}) error {
-	s := service.NewService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+// RegisterName 以 name 注册服务，name 为空时使用结构体名称；以 "_" 开头的名称保留给内置服务
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	if strings.HasPrefix(name, "_") {
+		return errors.New("rpc server: service names starting with \"_\" are reserved: " + name)
+	}
+	s, err := service.New(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return server.addService(s, rcvr)
+}
+
+func (server *Server) addService(s *service.Service, rcvr interface{}) error {
 	if _, dup := server.ServiceMap.LoadOrStore(s.Name, s); dup {
 		return errors.New("rpc: service already defined: " + s.Name)
 	}
+	methods := make([]string, 0, len(s.Method))
+	for name := range s.Method {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	for _, name := range methods {
+		server.logger().Log(LevelDebug, "rpc server: register", F("method", s.Name+"."+name))
+	}
+	server.registerStreams(s.Name, rcvr)
+	return nil
+}
+
+/*
+Unregister
+移除服务及其流式方法、响应缓存与限流；之后的请求回复 CodeNotFound，已经开始处理的请求不受影响
+*/
+func (server *Server) Unregister(name string) error {
+	if _, ok := server.ServiceMap.LoadAndDelete(name); !ok {
+		return errors.New("rpc server: can't find service " + name)
+	}
+	server.streams.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), name+".") {
+			server.streams.Delete(key)
+		}
+		return true
+	})
+	server.rateLimits.Range(func(key, _ interface{}) bool {
+		if k := key.(string); k == name || strings.HasPrefix(k, name+".") {
+			server.rateLimits.Delete(key)
+		}
+		return true
+	})
+	server.InvalidateCache(name, "")
+	server.logger().Log(LevelInfo, "rpc server: unregister", F("service", name))
 	return nil
 }
 
@@ -277,6 +699,9 @@ func (server *Server) findServiceMethod(serviceName, methodName string) (svc *se
 	}
 
 	svci, ok := server.ServiceMap.Load(serviceName)
+	if serviceName == reflectionService {
+		svci, ok = server.reflection(), true
+	}
 
 	if !ok {
 		err = errors.New("rpc server: can't find service " + serviceName)
@@ -297,6 +722,8 @@ const (
 	Connected        = "200 Connected to myGoRPC"
 	DefaultRPCPath   = "/myGoRPC"
 	DefaultDebugPath = "/debug/myGoRPC"
+	// DefaultConfigPath 输出服务端当前生效的配置，见 config.go
+	DefaultConfigPath = "/debug/myGoRPC/config"
 )
 
 // ServeHTTP 实现了 http.Handler 响应 RPC 请求
@@ -309,16 +736,20 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		server.logger().Log(LevelError, "rpc server: hijacking error", F("remote", req.RemoteAddr), F("err", err))
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+Connected+"\n\n")
 	server.ServeConn(conn)
 }
 
-// HandleHTTP 注册了 DefaultRPCPath 路径的 HTTP handler
+/*
+HandleHTTP 注册了 DefaultRPCPath 路径的 HTTP handler
+Server 本身就是 http.Handler，也可以挂载到任意 mux 的其他路径上，客户端通过 Option.RPCPath 指定该路径
+*/
 func (server *Server) HandleHTTP() {
 	http.Handle(DefaultRPCPath, server)
 	http.Handle(DefaultDebugPath, DebugHTTP{server})
-	log.Println("rpc server debug path:", DefaultDebugPath)
+	http.Handle(DefaultConfigPath, ConfigHTTP{server})
+	server.logger().Log(LevelDebug, "rpc server: debug path", F("path", DefaultDebugPath))
 }
@@ -0,0 +1,2136 @@
+package myGoRPC
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"myGoRPC/codec"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+测试过载保护。MaxInflight 为 1，第一个请求处理中（Bar.Timeout 耗时 2s），
+第二个请求应立即收到 ErrServerBusy
+*/
+func TestServer_Shed(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	server.Shed = &ShedPolicy{MaxInflight: 1}
+	_ = server.Register(&b)
+	addr := startServer(t, server)
+
+	client := dial(t, addr)
+
+	var reply int
+	first := client.Go("Bar", "Timeout", 1, &reply, nil)
+	time.Sleep(time.Millisecond * 100)
+
+	start := time.Now()
+	err := client.Call(context.Background(), "Bar", "Timeout", 1, &reply)
+	_assert(errors.Is(err, ErrServerBusy), "expect ErrServerBusy, got %v", err)
+	_assert(time.Since(start) < time.Second, "shedding should reply immediately")
+
+	<-first.Done
+	_assert(first.Error == nil, "first call should succeed, got %v", first.Error)
+}
+
+type Counter struct{ n int }
+
+func (c *Counter) Incr(delta int, reply *int) error {
+	c.n += delta
+	*reply = c.n
+	return nil
+}
+
+func TestServer_RegisterCacheable(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.RegisterCacheable(&Counter{}, time.Minute, "Incr") == nil, "failed to register")
+	addr := startServer(t, server)
+
+	client := dial(t, addr)
+
+	var first, second, other int
+	_ = client.Call(context.Background(), "Counter", "Incr", 1, &first)
+	_ = client.Call(context.Background(), "Counter", "Incr", 1, &second)
+	_assert(first == 1 && second == 1, "expect a cache hit, got %d and %d", first, second)
+
+	_ = client.Call(context.Background(), "Counter", "Incr", 2, &other)
+	_assert(other == 3, "different args should miss the cache, got %d", other)
+
+	server.InvalidateCache("Counter", "Incr")
+	_ = client.Call(context.Background(), "Counter", "Incr", 1, &second)
+	_assert(second == 4, "expect a cache miss after invalidation, got %d", second)
+}
+
+// 默认编译下故障注入不可用；go test -tags faultinject 时验证注入的错误会回复给客户端
+func TestServer_Faults(t *testing.T) {
+	t.Parallel()
+	faults := NewFaultInjector()
+	err := faults.SetRules([]FaultRule{{Service: "Counter", Method: "Incr", Fraction: 1, Error: "injected"}})
+	if !faultInjectionEnabled {
+		_assert(errors.Is(err, ErrFaultInjectionDisabled), "fault injection must be disabled by default")
+		return
+	}
+	_assert(err == nil, "failed to set rules: %v", err)
+
+	server := NewServer()
+	server.Faults = faults
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var reply int
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+	_assert(err != nil && err.Error() == "injected", "expect the injected error, got %v", err)
+
+	_ = faults.SetRules(nil)
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+	_assert(err == nil && reply == 1, "expect a normal call after clearing rules, got %v", err)
+}
+
+// 代理读取 Option 之后原样转发，客户端与下游服务端的握手不受影响
+func TestPeekOption(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	backendAddr := startServer(t, server)
+
+	proxy, _ := net.Listen("tcp", ":0")
+	peeked := make(chan codec.Type, 1)
+	go func() {
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		opt, conn, err := PeekOption(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		peeked <- opt.CodecType
+		down, _ := net.Dial("tcp", backendAddr)
+		go func() { _, _ = io.Copy(down, conn) }()
+		_, _ = io.Copy(conn, down)
+	}()
+
+	client := dial(t, proxy.Addr().String(), &Option{CodecType: codec.JsonType})
+	_assert(<-peeked == codec.JsonType, "proxy should see the json codec")
+
+	var reply int
+	err := client.Call(context.Background(), "Counter", "Incr", 2, &reply)
+	_assert(err == nil && reply == 2, "call through proxy failed: %v", err)
+}
+
+// testTLSConfigs 生成自签名证书，返回服务端、客户端的 TLS 配置
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "myGoRPC test"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	clientCfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	return serverCfg, clientCfg
+}
+
+func TestServer_StartTLS(t *testing.T) {
+	t.Parallel()
+	serverCfg, clientCfg := testTLSConfigs(t)
+	server := NewServer()
+	server.TLSConfig = serverCfg
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{StartTLS: true, TLSConfig: clientCfg})
+	var reply int
+	err := client.Call(context.Background(), "Counter", "Incr", 3, &reply)
+	_assert(err == nil && reply == 3, "call over starttls failed: %v", err)
+
+	plain := NewServer()
+	plAddr := startServer(t, plain)
+	_, err = Dial("tcp", plAddr, &Option{StartTLS: true, TLSConfig: clientCfg})
+	_assert(err != nil && strings.Contains(err.Error(), "starttls not supported"), "expect starttls rejection, got %v", err)
+}
+
+func TestServer_HandshakeTimeout(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.HandshakeTimeout = time.Millisecond * 50
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+
+	// 只建立连接、不发送 Option 的客户端被断开
+	conn, _ := net.Dial("tcp", addr)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect the server to close an idle handshake, got %v", err)
+
+	// 握手完成后不再受限制
+	client := dial(t, addr)
+	time.Sleep(time.Millisecond * 100)
+	var reply int
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+	_assert(err == nil && reply == 1, "call after the handshake timeout failed: %v", err)
+}
+
+func TestServer_AcceptTLS(t *testing.T) {
+	t.Parallel()
+	serverCfg, clientCfg := testTLSConfigs(t)
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	l, _ := net.Listen("tcp", ":0")
+	go server.AcceptTLS(l, serverCfg)
+	addr := l.Addr().String()
+
+	client, err := DialTLS("tcp", addr, clientCfg)
+	_assert(err == nil, "tls dial failed: %v", err)
+	var reply int
+	err = client.Call(context.Background(), "Counter", "Incr", 3, &reply)
+	_assert(err == nil && reply == 3, "call over tls failed: %v", err)
+	_assert(client.Close() == nil, "close over tls failed")
+	_assert(!client.IsAvailable(), "client should be unavailable after close")
+
+	// 未信任服务端的自签名证书
+	_, err = DialTLS("tcp", addr, &tls.Config{ServerName: "localhost"})
+	_assert(err != nil && strings.Contains(err.Error(), "tls handshake"), "expect certificate error, got %v", err)
+	// 明文客户端连接 TLS 监听
+	_, err = Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "plain dial to a tls listener should fail")
+}
+
+// 双向 TLS：从文件加载证书，并要求客户端出示受信任的证书
+func TestServer_ServeTLS(t *testing.T) {
+	t.Parallel()
+	serverCfg, clientCfg := testTLSConfigs(t)
+	cert := serverCfg.Certificates[0]
+	keyDER, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	server := NewServer()
+	server.TLSConfig = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCfg.RootCAs}
+	_ = server.Register(&Counter{})
+	_assert(server.ServeTLS(nil, filepath.Join(dir, "missing.pem"), keyFile) != nil, "expect a certificate load error")
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = server.ServeTLS(l, certFile, keyFile) }()
+	addr := l.Addr().String()
+
+	mutual := clientCfg.Clone()
+	mutual.Certificates = []tls.Certificate{cert}
+	client, err := DialTLS("tcp", addr, mutual)
+	_assert(err == nil, "mutual tls dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Counter", "Incr", 2, &reply)
+	_assert(err == nil && reply == 2, "call over mutual tls failed: %v", err)
+
+	// 没有客户端证书，握手或之后的读取失败
+	anonymous, err := DialTLS("tcp", addr, clientCfg)
+	if err == nil {
+		err = anonymous.Call(context.Background(), "Counter", "Incr", 1, &reply)
+	}
+	_assert(err != nil, "client without a certificate should be rejected")
+}
+
+type Recorder struct {
+	mu    sync.Mutex
+	order []int
+}
+
+// Record 序号越小睡得越久，并发处理时记录的顺序会被打乱
+func (r *Recorder) Record(n int, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(10-n))
+	r.mu.Lock()
+	r.order = append(r.order, n)
+	r.mu.Unlock()
+	*reply = n
+	return nil
+}
+
+func TestServer_SerialDispatch(t *testing.T) {
+	t.Parallel()
+	rec := &Recorder{}
+	server := NewServer()
+	_ = server.Register(rec)
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{Serial: true})
+	calls := make([]*Call, 10)
+	for i := range calls {
+		var reply int
+		calls[i] = client.Go("Recorder", "Record", i, &reply, nil)
+	}
+	for _, call := range calls {
+		<-call.Done
+	}
+	for i, n := range rec.order {
+		_assert(i == n, "handlers must run in the order sent, got %v", rec.order)
+	}
+}
+
+// Hop 返回方法收到的 ctx 剩余时间（毫秒），next 非 nil 时先睡 100ms 再调用下一跳，返回下一跳看到的值
+type Hop struct{ next *Client }
+
+func (h *Hop) Remaining(ctx context.Context, _ int, reply *int64) error {
+	if h.next != nil {
+		time.Sleep(time.Millisecond * 100)
+		return h.next.Call(ctx, "Hop", "Remaining", 0, reply)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		*reply = -1
+		return nil
+	}
+	*reply = time.Until(deadline).Milliseconds()
+	return nil
+}
+
+func TestServer_DeadlinePropagation(t *testing.T) {
+	t.Parallel()
+	startHop := func(next *Client) string {
+		server := NewServer()
+		_ = server.Register(&Hop{next: next})
+		return startServer(t, server)
+	}
+	last := dial(t, startHop(nil))
+	first := dial(t, startHop(last))
+	defer func() { _, _ = first.Close(), last.Close() }()
+
+	var remaining int64
+	_ = first.Call(context.Background(), "Hop", "Remaining", 0, &remaining)
+	_assert(remaining == -1, "no deadline should be propagated without one, got %d", remaining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := first.Call(ctx, "Hop", "Remaining", 0, &remaining)
+	_assert(err == nil, "call failed: %v", err)
+	_assert(remaining > 0 && remaining <= 900, "deadline should shrink across hops, got %dms", remaining)
+}
+
+func TestServer_sanitizeLabels(t *testing.T) {
+	server := NewServer()
+	labels := map[string]string{"tenant": "a", "app": "foo", "": "empty", "long": strings.Repeat("x", maxLabelLength+1)}
+	for i := 0; i < maxConnLabels; i++ {
+		labels["k"+strconv.Itoa(i)] = "v"
+	}
+	got := server.sanitizeLabels(labels)
+	_assert(len(got) == maxConnLabels, "labels should be capped at %d, got %d", maxConnLabels, len(got))
+	_assert(got["app"] == "foo" && got[""] == "" && got["long"] == "", "invalid labels should be dropped: %v", got)
+
+	server.LabelKeys = []string{"tenant"}
+	got = server.sanitizeLabels(labels)
+	_assert(len(got) == 1 && got["tenant"] == "a", "only allowed keys should be kept: %v", got)
+	_assert(logPrefix(&Option{Labels: got}) == "rpc server [tenant=a]", "unexpected log prefix %q", logPrefix(&Option{Labels: got}))
+}
+
+func TestServer_Quiesce(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{Labels: map[string]string{"app": "test"}})
+	var reply int
+	slow := client.Go("Bar", "Timeout", 1, &reply, nil)
+	time.Sleep(time.Millisecond * 100)
+
+	conns := server.Connections()
+	_assert(len(conns) == 1 && conns[0].Labels["app"] == "test", "expect one labelled connection, got %+v", conns)
+	_assert(server.Quiesce(conns[0].ID) == nil, "quiesce failed")
+	time.Sleep(time.Millisecond * 100)
+	_assert(!client.IsAvailable(), "client should stop sending after the drain signal")
+	err := client.Call(context.Background(), "Bar", "Timeout", 1, &reply)
+	_assert(errors.Is(err, ErrShutdown), "new calls should fail fast, got %v", err)
+
+	<-slow.Done
+	_assert(slow.Error == nil, "in-flight call should finish, got %v", slow.Error)
+	time.Sleep(time.Millisecond * 100)
+	_assert(len(server.Connections()) == 0, "connection should be closed after draining")
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	l, _ := net.Listen("tcp", ":0")
+	accepted := make(chan struct{})
+	go func() {
+		server.Accept(l)
+		close(accepted)
+	}()
+
+	client := dial(t, l.Addr().String())
+	var reply int
+	slow := client.Go("Echo", "Sleep", 200, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	err := server.Shutdown(context.Background())
+	_assert(err == nil, "shutdown failed: %v", err)
+	<-slow.Done
+	_assert(slow.Error == nil && reply == 200, "in-flight call should finish before shutdown returns, got %v", slow.Error)
+	<-accepted
+	_assert(len(server.Connections()) == 0, "all connections should be closed")
+	_, err = Dial("tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "listener should be closed")
+
+	// ctx 结束时强制关闭
+	server = NewServer()
+	_ = server.Register(new(Echo))
+	client = dial(t, startServer(t, server))
+	stuck := client.Go("Echo", "Sleep", 2000, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	err = server.Shutdown(ctx)
+	_assert(errors.Is(err, context.DeadlineExceeded) && time.Since(start) < time.Second, "expect the ctx error, got %v", err)
+	<-stuck.Done
+	_assert(stuck.Error != nil, "forcibly closed call should fail")
+	_assert(server.Close() == nil, "close after shutdown failed")
+}
+
+func TestServer_RequestLog(t *testing.T) {
+	t.Parallel()
+	records := make(chan *RequestRecord, 4)
+	server := NewServer()
+	server.RequestLog = &RequestLog{Sink: ChanSink(records)}
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{Labels: map[string]string{"tenant": "a"}})
+	var reply int
+	_ = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+
+	rec := <-records
+	_assert(rec.Service == "Counter" && rec.Method == "Incr" && rec.Seq == 1, "unexpected record %+v", rec)
+	_assert(rec.Status == "ok" && rec.Peer != "" && rec.Labels["tenant"] == "a", "unexpected record %+v", rec)
+
+	var buf strings.Builder
+	NewJSONLinesSink(&buf).Record(rec)
+	_assert(strings.HasSuffix(buf.String(), "}\n") && strings.Contains(buf.String(), `"Method":"Incr"`), "unexpected json line %q", buf.String())
+}
+
+// flakyProxy 转发到 backend，cut 断开当前所有连接，模拟客户端网络切换
+type flakyProxy struct {
+	net.Listener
+	backend string
+	mu      sync.Mutex
+	conns   []net.Conn
+}
+
+func (p *flakyProxy) serve() {
+	for {
+		conn, err := p.Accept()
+		if err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", p.backend)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, conn, upstream)
+		p.mu.Unlock()
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		go func() { _, _ = io.Copy(conn, upstream) }()
+	}
+}
+
+func (p *flakyProxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func TestServer_ResumeSession(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+	pl, _ := net.Listen("tcp", ":0")
+	proxy := &flakyProxy{Listener: pl, backend: addr}
+	go proxy.serve()
+
+	client, err := Dial("tcp", pl.Addr().String(), &Option{Resumable: true})
+	_assert(err == nil && client.session != "", "expect a session token, got err %v", err)
+	var slowReply int
+	slow := client.Go("Bar", "Timeout", 1, &slowReply, nil)
+	time.Sleep(time.Millisecond * 100)
+	proxy.cut()
+	time.Sleep(time.Millisecond * 200)
+
+	var n int
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &n)
+	_assert(err == nil && n == 1, "call after resume should succeed, got %v", err)
+	<-slow.Done
+	_assert(slow.Error == nil, "in-flight call should survive the network change, got %v", slow.Error)
+
+	_, err = Dial("tcp", addr, &Option{Session: "bogus"})
+	_assert(err != nil && strings.Contains(err.Error(), "unknown session"), "expect unknown session error, got %v", err)
+}
+
+func TestServer_Warmup(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(new(Echo))
+	_ = server.Register(&Hop{})
+	_assert(server.Warmup() == nil, "warmup failed")
+
+	addr := startServer(t, server)
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.RawType} {
+		client := dial(t, addr, &Option{CodecType: ct})
+		var reply Fragile
+		err := client.Call(context.Background(), "Echo", "Fragile", Fragile{N: 7}, &reply)
+		_assert(err == nil && reply.N == 7, "%s call after warmup failed: %v", ct, err)
+		_ = client.Close()
+	}
+}
+
+// 同一连接上夹杂一个入参类型不匹配的请求，只有它失败，前后的请求照常处理
+func TestServer_malformedBody(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType, codec.RawType} {
+		client := dial(t, addr, &Option{CodecType: ct})
+		var reply int
+		for i, args := range []interface{}{1, 1, "not a number", 1, 1} {
+			err := client.Call(context.Background(), "Counter", "Incr", args, &reply)
+			if i == 2 {
+				_assert(err != nil && strings.Contains(err.Error(), "malformed body"), "%s: expect a decode error, got %v", ct, err)
+				continue
+			}
+			_assert(err == nil, "%s: call %d failed: %v", ct, i, err)
+		}
+		// 未知方法的 body 同样需要丢弃
+		err := client.Call(context.Background(), "Counter", "Missing", 1, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "%s: expect unknown method, got %v", ct, err)
+		err = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+		_assert(err == nil, "%s: call after unknown method failed: %v", ct, err)
+		_assert(client.IsAvailable(), "%s: connection should survive a malformed body", ct)
+		_ = client.Close()
+	}
+}
+
+func TestServer_LimitConcurrency(t *testing.T) {
+	t.Parallel()
+	recorder := &Recorder{}
+	server := NewServer()
+	_ = server.Register(recorder)
+	_assert(server.LimitConcurrency("Recorder", 1) == nil, "limit failed")
+	_assert(server.LimitConcurrency("Missing", 1) != nil, "expect an error for an unknown service")
+	addr := startServer(t, server)
+
+	client := dial(t, addr)
+	calls := make([]*Call, 6)
+	for i := range calls {
+		var reply int
+		calls[i] = client.Go("Recorder", "Record", i, &reply, nil)
+		time.Sleep(time.Millisecond * 2)
+	}
+	stats, ok := server.QueueStats("Recorder")
+	_assert(ok && stats.InUse == 1 && stats.Queued > 0, "expect queued requests, got %+v", stats)
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "call failed: %v", call.Error)
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for i, n := range recorder.order {
+		_assert(n == i, "expect FIFO order, got %v", recorder.order)
+	}
+	stats, _ = server.QueueStats("Recorder")
+	_assert(stats.InUse == 0 && stats.Queued == 0 && stats.Admitted == 6 && stats.MaxWait > 0, "unexpected stats %+v", stats)
+}
+
+func TestServer_Config(t *testing.T) {
+	t.Parallel()
+	serverTLS, _ := testTLSConfigs(t)
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.LimitConcurrency("Counter", 4)
+	server.TLSConfig = serverTLS
+	server.RequestLog = &RequestLog{Sink: make(ChanSink, 1), SampleRate: 0.5}
+	server.MaxBodySize = 1 << 20
+	server.AuthFunc = func(token string, remote net.Addr) error { return nil }
+	server.MaxConcurrentRequests, server.BusyPolicy, server.MaxQueuedRequests = 8, BusyReject, 16
+	server.IdleTimeout = time.Minute
+	server.MaxConcurrentStreams = 32
+	server.AcceptFilter = PerIPRate(10, time.Second)
+	server.ReportLoad = true
+	server.Utilization = func() float64 { return 0 }
+	server.Backpressure = &Backpressure{Threshold: 6, Rate: 50}
+
+	rec := httptest.NewRecorder()
+	ConfigHTTP{server}.ServeHTTP(rec, httptest.NewRequest("GET", DefaultConfigPath, nil))
+	var cfg ServerConfig
+	_assert(json.Unmarshal(rec.Body.Bytes(), &cfg) == nil, "config is not valid JSON: %s", rec.Body.String())
+	_assert(cfg.TLS && !strings.Contains(rec.Body.String(), "Certificate"), "TLS should be reported without its contents")
+	_assert(cfg.RequestLog != nil && cfg.RequestLog.Sink == "myGoRPC.ChanSink", "unexpected request log %+v", cfg.RequestLog)
+	_assert(len(cfg.Services) == 1 && cfg.Services[0].Methods[0].Name == "Incr", "unexpected services %+v", cfg.Services)
+	_assert(cfg.Limits["Counter"].Limit == 4, "unexpected limits %+v", cfg.Limits)
+	_assert(cfg.HandshakeTimeout == DefaultHandshakeTimeout && cfg.MaxBodySize == 1<<20 && cfg.Auth && cfg.AcceptFilter,
+		"unexpected connection settings %+v", cfg)
+	_assert(cfg.MaxConcurrentRequests == 8 && cfg.BusyPolicy == "reject" && cfg.MaxQueuedRequests == 16,
+		"unexpected concurrency settings %+v", cfg)
+	_assert(cfg.IdleTimeout == time.Minute && cfg.MaxConcurrentStreams == 32, "unexpected limits %+v", cfg)
+	_assert(cfg.ReportLoad && cfg.Utilization && cfg.Backpressure != nil && *cfg.Backpressure == Backpressure{Threshold: 6, Rate: 50},
+		"unexpected load settings %+v", cfg)
+	_assert(NewServer().Config().BusyPolicy == "queue" && !NewServer().Config().Auth, "unexpected defaults")
+}
+
+func TestServer_AcceptFilter(t *testing.T) {
+	t.Parallel()
+	deny, err := IPFilter(nil, []string{"127.0.0.0/8"})
+	_assert(err == nil, "ip filter: %v", err)
+	allow, _ := IPFilter([]string{"10.0.0.1"}, nil)
+	_, err = IPFilter([]string{"not-an-ip"}, nil)
+	_assert(err != nil, "expect an invalid network error")
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1}
+	_assert(deny(local) == ErrConnRejected && allow(local) == ErrConnRejected, "loopback should be rejected")
+	_assert(allow(&net.TCPAddr{IP: net.ParseIP("10.0.0.1")}) == nil, "allowed ip should pass")
+
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	server.AcceptFilter = PerIPRate(1, time.Minute)
+	addr := startServer(t, server)
+	client := dial(t, addr)
+	var reply int
+	_assert(client.Call(context.Background(), "Counter", "Incr", 1, &reply) == nil, "call on accepted connection failed")
+	_, err = Dial("tcp", addr)
+	_assert(err != nil, "second connection within the window should be rejected")
+}
+
+type Faulty int
+
+func (f Faulty) Fail(_ int, _ *int) error {
+	return errors.New("boom")
+}
+
+func (f Faulty) Panic(_ int, _ *int) error {
+	panic("handler exploded")
+}
+
+func (f Faulty) Invalid(_ int, _ *int) error {
+	err := NewError(CodeBadArgument, "rpc: field %q is required", "name")
+	err.Detail = `{"field":"name"}`
+	return err
+}
+
+func (f Faulty) Throttled(_ int, _ *int) error {
+	err := NewError(CodeHandler, "rpc: backend throttled")
+	err.Retryable = true
+	return err
+}
+
+func TestServer_errorCodes(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(new(Faulty))
+	_ = server.Register(&b)
+	addr := startServer(t, server)
+	client := dial(t, addr, &Option{HandleTimeout: time.Millisecond * 50})
+
+	cases := []struct {
+		service, method string
+		args            interface{}
+		code            int
+	}{
+		{"Missing", "Fail", 1, CodeNotFound},
+		{"Faulty", "Missing", 1, CodeNotFound},
+		{"Faulty", "Fail", "not a number", CodeBadArgument},
+		{"Faulty", "Fail", 1, CodeHandler},
+		{"Faulty", "Panic", 1, CodePanic},
+		{"Bar", "Timeout", 1, CodeTimeout},
+		{"Faulty", "Invalid", 1, CodeBadArgument},
+	}
+	for _, c := range cases {
+		var reply int
+		err := client.Call(context.Background(), c.service, c.method, c.args, &reply)
+		var rpcErr *RPCError
+		_assert(errors.As(err, &rpcErr) && rpcErr.Code == c.code, "%s.%s: expect code %d, got %v", c.service, c.method, c.code, err)
+	}
+	_assert(client.IsAvailable(), "a handler panic should not break the connection")
+
+	// 方法返回的 *RPCError：Code、Detail 原样传给客户端，errors.Is 按 Code 匹配
+	err := client.Call(context.Background(), "Faulty", "Invalid", 1, nil)
+	var invalid *RPCError
+	_assert(errors.As(err, &invalid) && invalid.Detail == `{"field":"name"}`, "expect the error detail, got %#v", err)
+	_assert(errors.Is(err, &RPCError{Code: CodeBadArgument}) && !errors.Is(err, &RPCError{Code: CodeNotFound}),
+		"errors.Is should match by code, got %v", err)
+	timeoutErr := client.Call(context.Background(), "Bar", "Timeout", 1, nil)
+	_assert(errors.Is(timeoutErr, context.DeadlineExceeded), "a remote timeout should match DeadlineExceeded, got %v", timeoutErr)
+	_assert(Code(nil) == CodeUnknown && Code(errors.New("x")) == CodeUnknown && Code(fmt.Errorf("wrap: %w", err)) == CodeBadArgument &&
+		Code(ErrServerBusy) == CodeUnavailable && Code(ErrShutdown) == CodeUnavailable, "unexpected Code results")
+
+	// Retryable 在各编码下都原样传给客户端，DefaultRetryable 据此重试
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		c := dial(t, addr, &Option{CodecType: typ})
+		err = c.Call(context.Background(), "Faulty", "Throttled", 1, nil)
+		var throttled *RPCError
+		_assert(errors.As(err, &throttled) && throttled.Retryable && throttled.Code == CodeHandler && DefaultRetryable(err),
+			"%s: expect a retryable error, got %#v", typ, err)
+		_ = c.Close()
+	}
+	_assert(!DefaultRetryable(client.Call(context.Background(), "Faulty", "Fail", 1, nil)), "a plain handler error should not be retried")
+
+	// 旧版本服务端不附带分类
+	err = serverError(&codec.Header{Error: "plain"})
+	var rpcErr *RPCError
+	_assert(err.Error() == "plain" && !errors.As(err, &rpcErr) && !DefaultRetryable(err), "expect a plain error, got %#v", err)
+}
+
+func TestReconnectClient(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	pl, _ := net.Listen("tcp", ":0")
+	proxy := &flakyProxy{Listener: pl, backend: addr}
+	go proxy.serve()
+
+	// DialReconnect 只是开启了 Option.Reconnect 的 Client，不修改调用方的 Option
+	opt := &Option{ReconnectBackoff: time.Millisecond * 10}
+	rc, err := DialReconnect("tcp", pl.Addr().String(), opt)
+	_assert(err == nil, "dial failed: %v", err)
+	_assert(rc.option.Reconnect && !opt.Reconnect, "expect Option.Reconnect on a copy of the option")
+	var slowReply int
+	slow := rc.Go("Bar", "Timeout", 1, &slowReply, nil)
+	time.Sleep(time.Millisecond * 100)
+	proxy.cut()
+	<-slow.Done
+	var rerr *ReconnectError
+	_assert(errors.As(slow.Error, &rerr), "calls pending at disconnect should fail with *ReconnectError, got %v", slow.Error)
+
+	var reply Fragile
+	for i := 0; i < 50; i++ {
+		if err = rc.Call(context.Background(), "Echo", "Fragile", Fragile{N: 1}, &reply); !errors.As(err, &rerr) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	_assert(err == nil && rc.Reconnects() == 1, "call after reconnect failed: %v, reconnects %d", err, rc.Reconnects())
+	_ = rc.Close()
+	_assert(rc.Call(context.Background(), "Echo", "Fragile", Fragile{N: 1}, &reply) == ErrShutdown, "closed client should not reconnect")
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	server := NewServer()
+	_ = server.Register(&b)
+	_ = server.Register(&Counter{})
+	addr := startServer(t, server)
+	pl, _ := net.Listen("tcp", ":0")
+	proxy := &flakyProxy{Listener: pl, backend: addr}
+	go proxy.serve()
+
+	client := dial(t, pl.Addr().String(), &Option{Reconnect: true, ReconnectBackoff: time.Millisecond * 10})
+	var slowReply int
+	slow := client.Go("Bar", "Timeout", 1, &slowReply, nil)
+	time.Sleep(time.Millisecond * 100)
+	proxy.cut()
+	<-slow.Done
+	var rerr *ReconnectError
+	_assert(errors.As(slow.Error, &rerr) && rerr.Temporary(), "expect a *ReconnectError, got %v", slow.Error)
+
+	var n int
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = client.Call(context.Background(), "Counter", "Incr", 1, &n); !errors.As(err, &rerr) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	_assert(err == nil && n == 1, "call after reconnect should succeed, got %v", err)
+	_assert(client.IsAvailable() && client.Reconnects() == 1, "expect one reconnect, got %d", client.Reconnects())
+
+	_ = client.Close()
+	_assert(client.Call(context.Background(), "Counter", "Incr", 1, &n) == ErrShutdown, "closed client should not reconnect")
+}
+
+type Blob int
+
+type BlobItem struct {
+	ID          int
+	Name        string
+	Description string
+	Tags        []string
+}
+
+func (b Blob) Echo(args []BlobItem, reply *[]BlobItem) error {
+	*reply = args
+	return nil
+}
+
+func blobPayload(n int) []BlobItem {
+	items := make([]BlobItem, n)
+	for i := range items {
+		items[i] = BlobItem{
+			ID:          i,
+			Name:        "item-" + strconv.Itoa(i),
+			Description: strings.Repeat("a fairly repetitive description ", 4),
+			Tags:        []string{"alpha", "beta", "gamma"},
+		}
+	}
+	return items
+}
+
+// countingConn 统计写入连接的字节数
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	*c.written += int64(n)
+	return n, err
+}
+
+func TestServer_Compression(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Blob))
+	addr := startServer(t, server)
+
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		for _, opt := range []*Option{
+			{CodecType: ct, Compression: "gzip"},
+			{CodecType: ct, Compression: "snappy"},
+			{CodecType: ct, Compression: "gzip", CompressionThreshold: 1024},
+			{CodecType: ct, Compression: "snappy", CompressionThreshold: 1024},
+		} {
+			client, err := Dial("tcp", addr, opt)
+			_assert(err == nil, "%s %s: dial failed: %v", ct, opt.Compression, err)
+			// 大于、小于 CompressionThreshold 的消息交替
+			for _, n := range []int{100, 1, 100, 1} {
+				var reply []BlobItem
+				err = client.Call(context.Background(), "Blob", "Echo", blobPayload(n), &reply)
+				_assert(err == nil && len(reply) == n && reply[n-1].Name == "item-"+strconv.Itoa(n-1), "%s %+v: round trip failed: %v", ct, opt, err)
+			}
+			_ = client.Close()
+		}
+	}
+
+	_, err := Dial("tcp", addr, &Option{Compression: "zstd"})
+	_assert(err != nil && strings.Contains(err.Error(), "unsupported compression"), "expect the client to refuse, got %v", err)
+	conn, _ := net.Dial("tcp", addr)
+	_ = json.NewEncoder(conn).Encode(&Option{RpcNumber: RpcNumber, CodecType: codec.GobType, Version: HandshakeVersion, Compression: "zstd"})
+	var reply handshakeReply
+	_ = json.NewDecoder(conn).Decode(&reply)
+	_assert(strings.Contains(reply.Error, "unsupported compression"), "expect the server to reject, got %+v", reply)
+
+	// 不确认 CompressionThreshold 的旧服务端
+	old, _ := net.Listen("tcp", ":0")
+	go func() {
+		conn, err := old.Accept()
+		if err != nil {
+			return
+		}
+		_ = json.NewDecoder(conn).Decode(new(Option))
+		_ = json.NewEncoder(conn).Encode(&handshakeReply{Version: HandshakeV1, CodecType: codec.GobType})
+	}()
+	_, err = Dial("tcp", old.Addr().String(), &Option{JSONHandshake: true, Compression: "gzip", CompressionThreshold: 1024})
+	_assert(err != nil && strings.Contains(err.Error(), "CompressionThreshold"), "expect the client to refuse an old server, got %v", err)
+}
+
+// 解压受读取上限约束：声明的原始长度超过上限、与实际不符的帧被拒绝，整个数据流压缩时同样不能解出超过上限的数据
+func TestServer_CompressionLimit(t *testing.T) {
+	t.Parallel()
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	_, _ = gz.Write(make([]byte, 8<<20))
+	_ = gz.Close()
+	frame := func(rawSize int) io.ReadWriteCloser {
+		b := appendUvarint([]byte{frameCompressed}, uint64(rawSize))
+		b = appendUvarint(b, uint64(bomb.Len()))
+		return memConn{Reader: bytes.NewReader(append(b, bomb.Bytes()...))}
+	}
+	opt := &Option{Compression: "gzip", CompressionThreshold: 1024, MaxMessageSize: 1 << 20}
+
+	rwc, _ := compress(opt, 0, frame(8<<20))
+	_, err := rwc.Read(make([]byte, 1))
+	_assert(err == errDecompressedTooLarge, "expect an oversized rawSize to be rejected, got %v", err)
+	rwc, _ = compress(opt, 0, frame(1024))
+	_, err = rwc.Read(make([]byte, 1))
+	_assert(err == errCorruptFrame, "expect a lying rawSize to be rejected, got %v", err)
+	// Server.MaxBodySize 同样作为上限，未设置上限时不限制
+	rwc, _ = compress(&Option{Compression: "gzip", CompressionThreshold: 1024}, 1<<20, frame(8<<20))
+	_, err = rwc.Read(make([]byte, 1))
+	_assert(err == errDecompressedTooLarge, "expect MaxBodySize to bound the frame, got %v", err)
+	rwc, _ = compress(&Option{Compression: "gzip", CompressionThreshold: 1024}, 0, frame(8<<20))
+	n, err := io.Copy(io.Discard, rwc)
+	_assert(err == nil && n == 8<<20, "expect an unlimited connection to decompress the frame, got %d %v", n, err)
+
+	// 整个数据流压缩
+	rwc, _ = compress(&Option{Compression: "gzip", MaxMessageSize: 1 << 20}, 0, memConn{Reader: bytes.NewReader(bomb.Bytes())})
+	_, err = io.Copy(io.Discard, rwc)
+	_assert(err == errDecompressedTooLarge, "expect the stream to stop at the limit, got %v", err)
+}
+
+func TestSnappy(t *testing.T) {
+	t.Parallel()
+	// 按格式手工构造的块：字面量 "abcd"，2 字节偏移的 copy（长度 8），1 字节偏移的 copy（长度 4）
+	block := []byte{16, 3 << 2, 'a', 'b', 'c', 'd', 7<<2 | 2, 4, 0, 1, 4}
+	got, err := snappyDecode(block, snappyMaxBlock)
+	_assert(err == nil && string(got) == "abcdabcdabcdabcd", "decode failed: %q %v", got, err)
+	_, err = snappyDecode(block[:len(block)-1], snappyMaxBlock)
+	_assert(err != nil, "expect an error for a truncated block")
+
+	repeated := bytes.Repeat([]byte("myGoRPC snappy "), 1000)
+	random := make([]byte, 5000)
+	_, _ = rand.Read(random)
+	for _, src := range [][]byte{nil, []byte("ab"), repeated, random, append(repeated[:300:300], random...)} {
+		encoded := snappyEncode(src)
+		decoded, err := snappyDecode(encoded, snappyMaxBlock)
+		_assert(err == nil && bytes.Equal(decoded, src), "round trip of %d bytes failed: %v", len(src), err)
+	}
+	_assert(len(snappyEncode(repeated)) < len(repeated)/10, "repetitive input should compress well")
+
+	// 流格式：超过一个 chunk 的写入
+	var buf bytes.Buffer
+	w := newSnappyConn(memConn{Writer: &buf})
+	large := bytes.Repeat(random, 30)
+	_, _ = w.Write(large)
+	_, _ = w.Write([]byte("tail"))
+	r := newSnappyConn(memConn{Reader: &buf})
+	out, err := io.ReadAll(r)
+	_assert(err == nil && bytes.Equal(out, append(large, "tail"...)), "stream round trip failed: %v", err)
+}
+
+func TestServer_MaxBodySize(t *testing.T) {
+	t.Parallel()
+	limited := NewServer()
+	limited.MaxBodySize = 4096
+	_ = limited.Register(new(Blob))
+	llAddr := startServer(t, limited)
+	server := NewServer()
+	_ = server.Register(new(Blob))
+	addr := startServer(t, server)
+
+	for _, ct := range []codec.Type{codec.GobType, codec.JsonType} {
+		// 服务端读取请求时超过上限：回复错误后关闭连接
+		client := dial(t, llAddr, &Option{CodecType: ct})
+		var reply []BlobItem
+		err := client.Call(context.Background(), "Blob", "Echo", blobPayload(1), &reply)
+		_assert(err == nil && len(reply) == 1, "%s: small request failed: %v", ct, err)
+		err = client.Call(context.Background(), "Blob", "Echo", blobPayload(200), &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "exceeds the limit"), "%s: expect the server to reject the body, got %v", ct, err)
+		time.Sleep(time.Millisecond * 50)
+		_assert(!client.IsAvailable(), "%s: server should close the connection", ct)
+		_ = client.Close()
+
+		// 客户端读取回复时超过上限：该请求失败，连接关闭
+		client = dial(t, addr, &Option{CodecType: ct, MaxBodySize: 4096})
+		err = client.Call(context.Background(), "Blob", "Echo", blobPayload(1), &reply)
+		_assert(err == nil && len(reply) == 1, "%s: small reply failed: %v", ct, err)
+		err = client.Call(context.Background(), "Blob", "Echo", blobPayload(200), &reply)
+		var tooLarge *codec.BodyTooLargeError
+		_assert(errors.As(err, &tooLarge) && tooLarge.Limit == 4096, "%s: expect *codec.BodyTooLargeError, got %v", ct, err)
+		time.Sleep(time.Millisecond * 50)
+		_assert(!client.IsAvailable(), "%s: client should be torn down", ct)
+		_ = client.Close()
+	}
+}
+
+// 大负载往返，wire-B/op 为客户端写入连接的字节数
+func BenchmarkCompression(b *testing.B) {
+	server := NewServer()
+	_ = server.Register(new(Blob))
+	addr := startServer(b, server)
+	payload := blobPayload(1000)
+
+	for _, compression := range []string{CompressionNone, "gzip", "snappy"} {
+		b.Run(compression, func(b *testing.B) {
+			conn, _ := net.Dial("tcp", addr)
+			var written int64
+			client, err := NewClient(countingConn{Conn: conn, written: &written}, &Option{
+				RpcNumber: RpcNumber, CodecType: codec.JsonType, Version: HandshakeVersion, Compression: compression,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer client.Close()
+			b.ResetTimer()
+			written = 0
+			for i := 0; i < b.N; i++ {
+				var reply []BlobItem
+				if err := client.Call(context.Background(), "Blob", "Echo", payload, &reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(written)/float64(b.N), "wire-B/op")
+		})
+	}
+}
+
+// Note 手写的 protobuf 消息：message Note { string text = 1; }，text 不超过 127 字节
+type Note struct {
+	Text string
+}
+
+func (n *Note) Marshal() ([]byte, error) {
+	if n.Text == "" {
+		return nil, nil
+	}
+	return append([]byte{1<<3 | 2, byte(len(n.Text))}, n.Text...), nil
+}
+
+func (n *Note) Unmarshal(b []byte) error {
+	n.Text = ""
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) < 2 || b[0] != 1<<3|2 || int(b[1]) != len(b)-2 {
+		return errors.New("malformed note")
+	}
+	n.Text = string(b[2:])
+	return nil
+}
+
+type Notes int
+
+func (Notes) Upper(args *Note, reply *Note) error {
+	reply.Text = strings.ToUpper(args.Text)
+	return nil
+}
+
+func TestServer_ProtobufCodec(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Notes))
+	_assert(server.Warmup() == nil, "warmup should skip codecs a method cannot use")
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{CodecType: codec.ProtobufType})
+	var reply Note
+	err := client.Call(context.Background(), "Notes", "Upper", &Note{Text: "hi"}, &reply)
+	_assert(err == nil && reply.Text == "HI", "protobuf round trip failed: %q, %v", reply.Text, err)
+	err = client.Call(context.Background(), "Notes", "Upper", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "does not implement"), "expect a non-proto error, got %v", err)
+	err = client.Call(context.Background(), "Notes", "Upper", &Note{Text: "again"}, &reply)
+	_assert(err == nil && reply.Text == "AGAIN", "connection should survive a non-proto argument: %v", err)
+}
+
+func TestServer_MsgpackCodec(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Counter))
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{CodecType: codec.MsgpackType})
+	var reply int
+	ctx := WithMetadata(context.Background(), map[string]string{"trace": "t1"})
+	err := client.Call(ctx, "Counter", "Incr", 2, &reply)
+	_assert(err == nil && reply == 2, "msgpack round trip failed: %d, %v", reply, err)
+	err = client.Call(context.Background(), "Counter", "Incr", "two", &reply)
+	_assert(err != nil, "expect a decode error for a string argument")
+	err = client.Call(context.Background(), "Counter", "Incr", 3, &reply)
+	_assert(err == nil && reply == 5, "connection should survive a bad argument: %d, %v", reply, err)
+}
+
+var registeredCodecs int64
+
+// 第三方编解码方式注册后，客户端、服务端都可以按名称使用
+func TestServer_registeredCodec(t *testing.T) {
+	t.Parallel()
+	// 每次运行使用不同的名称，-count 大于 1 时不会重复注册
+	custom := codec.Type(fmt.Sprintf("application/x-test-json-%d", atomic.AddInt64(&registeredCodecs, 1)))
+	codec.Register(custom, codec.NewJsonCodecFunc(codec.JsonOptions{Indent: "  "}))
+	server := NewServer()
+	_ = server.Register(new(Counter))
+	addr := startServer(t, server)
+
+	client := dial(t, addr, &Option{CodecType: custom})
+	var reply int
+	err := client.Call(context.Background(), "Counter", "Incr", 4, &reply)
+	_assert(err == nil && reply == 4, "custom codec round trip failed: %d, %v", reply, err)
+}
+
+func TestServer_AuthFunc(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.AuthFunc = func(token string, remote net.Addr) error {
+		if remote == nil || token != "good" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+
+	_, err := Dial("tcp", addr, &Option{Token: "bad"})
+	_assert(errors.Is(err, ErrUnauthenticated) && strings.Contains(err.Error(), "bad token"), "expect ErrUnauthenticated, got %v", err)
+	_, err = Dial("tcp", addr)
+	_assert(errors.Is(err, ErrUnauthenticated), "missing token should be rejected, got %v", err)
+
+	client := dial(t, addr, &Option{Token: "good"})
+	// 连接建立之后通过元数据刷新凭证
+	client.Use(AuthInterceptor("refreshed"))
+	var reply string
+	err = client.Call(context.Background(), "Echo", "Token", 1, &reply)
+	_assert(err == nil && reply == "refreshed", "expect the refreshed token, got %q, %v", reply, err)
+}
+
+func TestServer_Use(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	counter := &Counter{}
+	_ = server.Register(counter)
+	var mu sync.Mutex
+	var order []string
+	trace := func(name string) ServerInterceptor {
+		return func(ctx context.Context, info ServiceMethodInfo, handler Handler) error {
+			mu.Lock()
+			order = append(order, name+">"+info.Method)
+			mu.Unlock()
+			return handler(ctx, info)
+		}
+	}
+	server.Use(trace("a"), trace("b"))
+	server.Use(func(ctx context.Context, info ServiceMethodInfo, handler Handler) error {
+		if delta, _ := info.Args.(int); delta < 0 {
+			return &RPCError{Code: CodeBadArgument, Message: "negative delta"}
+		}
+		if info.Args.(int) == 99 {
+			panic("boom")
+		}
+		return handler(ctx, info)
+	})
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var reply int
+	err := client.Call(context.Background(), "Counter", "Incr", 2, &reply)
+	_assert(err == nil && reply == 2, "intercepted call failed: %d, %v", reply, err)
+	_assert(strings.Join(order, " ") == "a>Incr b>Incr", "unexpected interceptor order %v", order)
+
+	var rpcErr *RPCError
+	err = client.Call(context.Background(), "Counter", "Incr", -1, &reply)
+	_assert(errors.As(err, &rpcErr) && rpcErr.Code == CodeBadArgument, "expect the interceptor's error code, got %v", err)
+	err = client.Call(context.Background(), "Counter", "Incr", 99, &reply)
+	_assert(errors.As(err, &rpcErr) && rpcErr.Code == CodePanic, "interceptor panic should be recovered, got %v", err)
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &reply)
+	_assert(err == nil && reply == 3, "rejected calls should not reach the method, got %d, %v", reply, err)
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var spans []SpanData
+	tracer := NewTracer(func(s SpanData) {
+		mu.Lock()
+		spans = append(spans, s)
+		mu.Unlock()
+	})
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	server.Use(TracingServerInterceptor(tracer))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+	client.Use(TracingInterceptor(tracer))
+
+	var reply string
+	err := client.Call(context.Background(), "Echo", "Tenant", -1, &reply)
+	_assert(err != nil, "expect the handler error")
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(spans) == 2, "expect a server and a client span, got %+v", spans)
+	srv, cli := spans[0], spans[1]
+	_assert(srv.Kind == SpanServer && cli.Kind == SpanClient && cli.Name == "Echo.Tenant", "unexpected spans %+v", spans)
+	_assert(srv.TraceID == cli.TraceID && srv.ParentID == cli.SpanID && cli.ParentID == "", "server span should link to the client span: %+v", spans)
+	_assert(srv.Err != nil && cli.Err != nil, "both spans should record the error")
+
+	_, ok := parseTraceParent("00-" + cli.TraceID + "-" + cli.SpanID + "-01")
+	_assert(ok, "expect a valid traceparent")
+	_, ok = parseTraceParent("00-" + strings.Repeat("0", 32) + "-" + cli.SpanID + "-01")
+	_assert(!ok, "all-zero trace id is invalid")
+}
+
+// 方法内的下游调用嵌套在其 server 跨度之下，导出为 Chrome trace JSON
+func TestChromeTrace(t *testing.T) {
+	t.Parallel()
+	trace := new(ChromeTrace)
+	tracer := NewTracer(trace.Export)
+	startHop := func(next *Client) string {
+		server := NewServer()
+		server.Use(TracingServerInterceptor(tracer))
+		_ = server.Register(&Hop{next: next})
+		return startServer(t, server)
+	}
+	last := dial(t, startHop(nil))
+	last.Use(TracingInterceptor(tracer))
+	first := dial(t, startHop(last))
+	first.Use(TracingInterceptor(tracer))
+	defer func() { _, _ = first.Close(), last.Close() }()
+	var remaining int64
+	_assert(first.Call(context.Background(), "Hop", "Remaining", 0, &remaining) == nil, "call failed")
+
+	var buf bytes.Buffer
+	_, err := trace.WriteTo(&buf)
+	_assert(err == nil, "write failed: %v", err)
+	var file struct {
+		TraceEvents []chromeEvent `json:"traceEvents"`
+	}
+	_assert(json.Unmarshal(buf.Bytes(), &file) == nil, "invalid trace JSON: %s", buf.String())
+	events := file.TraceEvents
+	_assert(len(events) == 4, "expect 4 spans, got %+v", events)
+	// 依次为 client、server、下游 client、下游 server，每一个都是前一个的子跨度，画在同一行中
+	for i, cat := range []string{"client", "server", "client", "server"} {
+		e := events[i]
+		_assert(e.Cat == cat && e.Ph == "X" && e.Name == "Hop.Remaining" && e.Pid == 1 && e.Tid == 1, "unexpected event %d: %+v", i, e)
+		if i == 0 {
+			continue
+		}
+		parent := events[i-1]
+		_assert(e.Args["parent_id"] == parent.Args["span_id"], "event %d should link to its parent: %+v", i, events)
+		_assert(e.Ts >= parent.Ts && e.Ts+e.Dur <= parent.Ts+parent.Dur, "event %d should nest inside its parent: %+v", i, events)
+	}
+
+	// 并发的兄弟跨度放在不同的行
+	start := time.Now()
+	spans := []SpanData{
+		{Name: "root", TraceID: "t", SpanID: "a", Start: start, Duration: 100 * time.Millisecond},
+		{Name: "left", TraceID: "t", SpanID: "b", ParentID: "a", Start: start.Add(10 * time.Millisecond), Duration: 40 * time.Millisecond},
+		{Name: "right", TraceID: "t", SpanID: "c", ParentID: "a", Start: start.Add(20 * time.Millisecond), Duration: 40 * time.Millisecond},
+		{Name: "child", TraceID: "t", SpanID: "d", ParentID: "c", Start: start.Add(30 * time.Millisecond), Duration: 10 * time.Millisecond},
+		{Name: "other", TraceID: "u", SpanID: "e", Start: start.Add(5 * time.Millisecond), Duration: time.Millisecond},
+	}
+	tids := make(map[string][2]int)
+	for _, e := range chromeEvents(spans) {
+		tids[e.Name] = [2]int{e.Pid, e.Tid}
+	}
+	_assert(tids["root"] == [2]int{1, 1} && tids["left"] == [2]int{1, 1}, "left should nest under root: %v", tids)
+	_assert(tids["right"] == [2]int{1, 2} && tids["child"] == [2]int{1, 2}, "overlapping siblings need their own row: %v", tids)
+	_assert(tids["other"] == [2]int{2, 1}, "another trace should get its own pid: %v", tids)
+}
+
+func TestServer_Stats(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var n int
+	var s string
+	for i := 0; i < 3; i++ {
+		_ = client.Call(context.Background(), "Counter", "Incr", 1, &n)
+	}
+	_ = client.Call(context.Background(), "Echo", "Tenant", -1, &s)
+	_ = client.Call(context.Background(), "Nope", "Missing", 1, &n)
+
+	for side, methods := range map[string]map[string]MethodStats{"server": server.Stats().Methods, "client": client.Stats().Methods} {
+		incr := methods["Counter.Incr"]
+		var bucketed uint64
+		for _, c := range incr.LatencyBuckets {
+			bucketed += c
+		}
+		_assert(incr.Calls == 3 && incr.Errors == 0 && bucketed == 3, "%s: unexpected Counter.Incr stats %+v", side, incr)
+		_assert(incr.BytesIn > 0 && incr.BytesOut > 0, "%s: expect byte counts, got %+v", side, incr)
+		_assert(methods["Echo.Tenant"].Errors == 1, "%s: expect one Echo.Tenant error, got %+v", side, methods["Echo.Tenant"])
+	}
+	_, tracked := server.Stats().Methods["Nope.Missing"]
+	_assert(!tracked, "server should not track unknown methods")
+	_assert(client.Stats().Methods["Nope.Missing"].Errors == 1, "client should count the failed call")
+
+	rec := httptest.NewRecorder()
+	server.StatsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	_assert(strings.Contains(body, `myGoRPC_server_calls_total{service="Counter",method="Incr"} 3`), "missing calls counter in\n%s", body)
+	_assert(strings.Contains(body, `myGoRPC_server_latency_seconds_bucket{service="Counter",method="Incr",le="+Inf"} 3`), "missing histogram in\n%s", body)
+}
+
+func TestServer_MaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+	for _, policy := range []BusyPolicy{BusyReject, BusyQueue} {
+		server := NewServer()
+		server.MaxConcurrentRequests, server.BusyPolicy = 1, policy
+		_ = server.Register(new(Echo))
+		addr := startServer(t, server)
+		client := dial(t, addr)
+		other := dial(t, addr)
+
+		var r1, r2 int
+		slow := client.Go("Echo", "Sleep", 200, &r1, nil)
+		time.Sleep(time.Millisecond * 50)
+		start := time.Now()
+		second := other.Go("Echo", "Sleep", 1, &r2, nil)
+		if policy == BusyQueue {
+			time.Sleep(time.Millisecond * 50)
+			_assert(server.Stats().Queued == 1, "expect one queued request, got %+v", server.Stats())
+		}
+		<-second.Done
+		if policy == BusyReject {
+			_assert(errors.Is(second.Error, ErrServerBusy) && time.Since(start) < time.Millisecond*100, "expect an immediate ErrServerBusy, got %v", second.Error)
+		} else {
+			_assert(second.Error == nil && time.Since(start) >= time.Millisecond*100, "queued request should wait for the slot, got %v after %v", second.Error, time.Since(start))
+		}
+		<-slow.Done
+		_assert(slow.Error == nil, "first request failed: %v", slow.Error)
+		_ = client.Close()
+		_ = other.Close()
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.IdleTimeout = time.Millisecond * 100
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	idle := dial(t, addr)
+	alive := dial(t, addr, &Option{HeartbeatInterval: time.Millisecond * 30})
+	busy := dial(t, addr)
+
+	// 正在处理的请求不算空闲
+	var reply int
+	err := busy.Call(context.Background(), "Echo", "Sleep", 300, &reply)
+	_assert(err == nil, "a long request should outlive the idle timeout, got %v", err)
+	_assert(!idle.IsAvailable(), "idle connection should be closed")
+	_assert(alive.IsAvailable(), "heartbeats should keep the connection open")
+}
+
+type Tail struct{}
+
+// Double 将收到的每个数翻倍发回，客户端 CloseSend 后返回
+func (t *Tail) Double(stream *Stream) error {
+	for {
+		var n int
+		if err := stream.Recv(&n); err == io.EOF {
+			SetTrailer(stream.Context(), map[string]string{"done": "true"})
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("negative input")
+		}
+		if err := stream.Send(n * 2); err != nil {
+			return err
+		}
+	}
+}
+
+// Follow 持续发送递增的数，直到流结束
+func (t *Tail) Follow(stream *Stream) error {
+	for i := 0; ; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Tail))
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+
+		stream, err := client.Stream(context.Background(), "Tail", "Double")
+		_assert(err == nil, "open stream failed: %v", err)
+		for i := 1; i <= 3; i++ {
+			_assert(stream.Send(i) == nil, "send failed")
+			var n int
+			err = stream.Recv(&n)
+			_assert(err == nil && n == i*2, "%s: expect %d, got %d, %v", typ, i*2, n, err)
+			// 流与普通请求复用同一连接
+			var reply int
+			err = client.Call(context.Background(), "Echo", "Sleep", 1, &reply)
+			_assert(err == nil, "%s: unary call during a stream failed: %v", typ, err)
+		}
+		_ = stream.CloseSend()
+		var n int
+		err = stream.Recv(&n)
+		_assert(err == io.EOF && stream.Trailer()["done"] == "true", "%s: expect io.EOF with a trailer, got %v %v", typ, err, stream.Trailer())
+		_assert(stream.Send(1) == ErrStreamClosed, "send after the stream ended should fail")
+
+		failing, _ := client.Stream(context.Background(), "Tail", "Double")
+		_ = failing.Send(-1)
+		err = failing.Recv(&n)
+		_assert(err != nil && strings.Contains(err.Error(), "negative input"), "expect the handler error, got %v", err)
+
+		missing, _ := client.Stream(context.Background(), "Tail", "Missing")
+		err = missing.Recv(&n)
+		var rpcErr *RPCError
+		_assert(errors.As(err, &rpcErr) && rpcErr.Code == CodeNotFound, "expect a not found error, got %v", err)
+
+		// 客户端放弃持续发送的流，连接仍然可用
+		follow, _ := client.Stream(context.Background(), "Tail", "Follow")
+		for i := 0; i < 5; i++ {
+			err = follow.Recv(&n)
+			_assert(err == nil && n == i, "expect %d, got %d, %v", i, n, err)
+		}
+		_ = follow.Close()
+		_assert(follow.Recv(&n) == ErrStreamClosed, "recv after Close should fail")
+		var reply int
+		err = client.Call(context.Background(), "Echo", "Sleep", 1, &reply)
+		_assert(err == nil, "%s: connection should stay usable after Close, got %v", typ, err)
+
+		// 连接断开时流以错误结束
+		open, _ := client.Stream(context.Background(), "Tail", "Double")
+		_ = client.Close()
+		_assert(open.Recv(&n) == ErrShutdown, "expect ErrShutdown after Close")
+	}
+}
+
+type Ledger struct{}
+
+// Rows 发送 n 行后以 trailer 返回行数与校验和，n 为负数时先设置 trailer 再返回错误
+func (l *Ledger) Rows(stream *Stream) error {
+	var n int
+	if err := stream.Recv(&n); err != nil {
+		return err
+	}
+	if n < 0 {
+		SetTrailer(stream.Context(), map[string]string{"status": "rejected"})
+		return errors.New("negative row count")
+	}
+	sum := 0
+	for i := 1; i <= n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+		sum += i
+	}
+	SetTrailer(stream.Context(), map[string]string{"rows": strconv.Itoa(n), "checksum": strconv.Itoa(sum)})
+	return nil
+}
+
+// trailer 在最后一条消息之后随 END_STREAM 送达，客户端读完流（Recv 返回 io.EOF）后由 Stream.Trailer 读取
+func TestServer_StreamTrailer(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Ledger))
+	addr := startServer(t, server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+		stream, _ := client.Stream(context.Background(), "Ledger", "Rows")
+		_ = stream.Send(4)
+		sum := 0
+		for {
+			var row int
+			err := stream.Recv(&row)
+			if err == io.EOF {
+				break
+			}
+			_assert(err == nil, "%s: recv failed: %v", typ, err)
+			_assert(stream.Trailer() == nil, "%s: the trailer should arrive after the last message", typ)
+			sum += row
+		}
+		trailer := stream.Trailer()
+		_assert(trailer["rows"] == "4" && trailer["checksum"] == strconv.Itoa(sum), "%s: unexpected trailer %v", typ, trailer)
+
+		// handler 返回错误时 trailer 同样送达
+		failing, _ := client.Stream(context.Background(), "Ledger", "Rows")
+		_ = failing.Send(-1)
+		err := failing.Recv(new(int))
+		_assert(err != nil && strings.Contains(err.Error(), "negative row count"), "%s: expect the handler error, got %v", typ, err)
+		_assert(failing.Trailer()["status"] == "rejected", "%s: error trailers should be delivered, got %v", typ, failing.Trailer())
+		_ = client.Close()
+	}
+}
+
+// 服务端在流进行中断开：Recv 读完已送达的消息后返回 ErrStreamAborted 而不是 io.EOF，流从 client.streams 中移除且 ctx 结束
+func TestServer_StreamAborted(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Tail))
+	l, _ := net.Listen("tcp", ":0")
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns <- conn
+		server.ServeConn(conn)
+	}()
+
+	client := dial(t, l.Addr().String())
+	stream, err := client.Stream(context.Background(), "Tail", "Follow")
+	_assert(err == nil, "open stream failed: %v", err)
+	var n int
+	_assert(stream.Recv(&n) == nil && n == 0, "first recv failed")
+	_ = (<-conns).Close()
+
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if err := stream.Recv(&n); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("recv should not hang after the server is gone")
+	}
+	_assert(err != io.EOF && errors.Is(err, ErrStreamAborted), "expect ErrStreamAborted, got %v", err)
+	_assert(stream.Context().Err() != nil, "stream ctx should end with the connection")
+	client.mu.Lock()
+	left := len(client.streams)
+	client.mu.Unlock()
+	_assert(left == 0, "aborted streams should be removed, %d left", left)
+}
+
+func TestServer_MaxConcurrentStreams(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxConcurrentStreams = 2
+	_ = server.Register(new(Tail))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+	_assert(client.maxStreams == 2, "the limit should be advertised in the handshake, got %d", client.maxStreams)
+
+	first, _ := client.Stream(context.Background(), "Tail", "Double")
+	_, err := client.Stream(context.Background(), "Tail", "Double")
+	_assert(err == nil, "open stream failed: %v", err)
+	_, err = client.Stream(context.Background(), "Tail", "Double")
+	_assert(err == ErrTooManyStreams, "expect ErrTooManyStreams from the client, got %v", err)
+
+	// 不遵守上限的客户端由服务端拒绝
+	client.mu.Lock()
+	client.maxStreams = 0
+	client.mu.Unlock()
+	extra, err := client.Stream(context.Background(), "Tail", "Double")
+	_assert(err == nil, "open stream failed: %v", err)
+	var n int
+	err = extra.Recv(&n)
+	var rpcErr *RPCError
+	_assert(errors.As(err, &rpcErr) && rpcErr.Code == CodeResourceExhausted && strings.Contains(err.Error(), ErrTooManyStreams.Error()),
+		"expect the server to reject the stream, got %v", err)
+
+	// 结束一个流之后可以再打开
+	_ = first.Close()
+	again, _ := client.Stream(context.Background(), "Tail", "Double")
+	_assert(again.Send(4) == nil && again.Recv(&n) == nil && n == 8, "stream after Close failed: %d", n)
+}
+
+func TestServer_Publish(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dial(t, addr, &Option{CodecType: typ})
+		other := dial(t, addr, &Option{CodecType: typ})
+		news, err := client.Subscribe("news-" + string(typ))
+		_assert(err == nil, "subscribe failed: %v", err)
+		_, _ = other.Subscribe("sports-" + string(typ))
+
+		n := server.Publish("news-"+string(typ), []byte("hello"))
+		_assert(n == 1, "expect one subscriber, got %d", n)
+		msg := <-news
+		_assert(msg.Topic == "news-"+string(typ) && string(msg.Data) == "hello", "%s: unexpected message %+v", typ, msg)
+		// 推送不影响普通请求
+		var reply int
+		err = client.Call(context.Background(), "Echo", "Sleep", 1, &reply)
+		_assert(err == nil, "%s: call after a push failed: %v", typ, err)
+
+		_ = client.Unsubscribe("news-" + string(typ))
+		_, open := <-news
+		_assert(!open, "Unsubscribe should close the channel")
+		_assert(server.Publish("news-"+string(typ), []byte("late")) == 0, "no subscriber should be left")
+
+		sports, _ := other.Subscribe("sports-" + string(typ))
+		_ = other.Close()
+		_, open = <-sports
+		_assert(!open, "Close should close the subscription")
+		_ = client.Close()
+	}
+}
+
+type badReply int
+
+func (b badReply) Get(_ int, reply int) error {
+	return nil
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.RegisterName("Calc", &Counter{}) == nil, "RegisterName failed")
+	_assert(server.Register(&Tail{}) == nil, "Register failed")
+	err := server.RegisterName("Calc", &Counter{})
+	_assert(err != nil && strings.Contains(err.Error(), "already defined"), "expect a duplicate error, got %v", err)
+	err = server.RegisterName("_mine", &Counter{})
+	_assert(err != nil && strings.Contains(err.Error(), "reserved"), "expect a reserved name error, got %v", err)
+	err = server.RegisterName("Bad", new(badReply))
+	_assert(err != nil && strings.Contains(err.Error(), "Bad.Get: reply type int is not a pointer"), "expect a signature error, got %v", err)
+	err = server.Register(new(badReply))
+	_assert(err != nil && strings.Contains(err.Error(), "not exported"), "expect an unexported type error, got %v", err)
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var n int
+	_assert(client.Call(context.Background(), "Calc", "Incr", 2, &n) == nil && n == 2, "call to a named service failed")
+
+	var services []ServiceDesc
+	err = client.Call(context.Background(), "_rpc", "ListServices", "", &services)
+	_assert(err == nil && len(services) == 2, "ListServices failed: %v %+v", err, services)
+	_assert(services[0].Name == "Calc" && len(services[0].Methods) == 1 && services[0].Methods[0] == "Incr", "unexpected Calc desc %+v", services[0])
+	_assert(services[1].Name == "Tail" && len(services[1].Streams) == 2 && services[1].Streams[0] == "Double", "unexpected Tail desc %+v", services[1])
+
+	var sig MethodSignature
+	err = client.Call(context.Background(), "_rpc", "MethodSignature", "Calc.Incr", &sig)
+	_assert(err == nil && sig.ArgType == "int" && sig.ReplyType == "*int" && !sig.Context && !sig.Stream, "unexpected signature %v %+v", err, sig)
+	var streamSig MethodSignature
+	err = client.Call(context.Background(), "_rpc", "MethodSignature", "Tail.Follow", &streamSig)
+	_assert(err == nil && streamSig.Stream, "expect a stream signature, got %v %+v", err, streamSig)
+	err = client.Call(context.Background(), "_rpc", "MethodSignature", "Calc.Missing", &sig)
+	_assert(Code(err) == CodeNotFound, "expect a not found error, got %v", err)
+	err = client.Call(context.Background(), "_rpc", "MethodSignature", "Calc", &sig)
+	_assert(Code(err) == CodeBadArgument, "expect a bad argument error, got %v", err)
+
+	_assert(server.Unregister("Calc") == nil, "Unregister failed")
+	_assert(server.Unregister("Calc") != nil, "Unregister of a missing service should fail")
+	err = client.Call(context.Background(), "Calc", "Incr", 2, &n)
+	_assert(Code(err) == CodeNotFound, "expect a not found error after Unregister, got %v", err)
+	_assert(server.Unregister("Tail") == nil, "Unregister failed")
+	stream, _ := client.Stream(context.Background(), "Tail", "Double")
+	err = stream.Recv(&n)
+	_assert(Code(err) == CodeNotFound, "expect a removed stream method, got %v", err)
+	_assert(server.RegisterName("Calc", &Counter{}) == nil, "register again after Unregister failed")
+}
+
+func TestServer_ListenAndServe(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	// 进程异常退出后残留的 socket 文件
+	stale, err := net.Listen("unix", path)
+	_assert(err == nil, "listen failed: %v", err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe("unix", path) }()
+	var client *Client
+	for i := 0; i < 100 && client == nil; i++ {
+		if client, err = XDial("unix@" + path); err != nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	_assert(client != nil, "dial unix socket failed: %v", err)
+	var n int
+	err = client.Call(context.Background(), "Counter", "Incr", 4, &n)
+	_assert(err == nil && n == 4, "call over unix socket failed: %v", err)
+
+	err = NewServer().ListenAndServe("unix", path)
+	_assert(err != nil && strings.Contains(err.Error(), "in use"), "expect a socket in use error, got %v", err)
+	_ = client.Close()
+	_ = server.Close()
+	_assert(<-served == nil, "ListenAndServe should return nil after Close")
+	_, err = os.Stat(path)
+	_assert(os.IsNotExist(err), "socket file should be removed, got %v", err)
+}
+
+func TestServer_Websocket(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(new(Blob))
+	_ = server.Register(&Tail{})
+	ts := httptest.NewServer(WebsocketHTTP{server})
+	defer ts.Close()
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/rpc"
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := DialWebsocket(wsURL, &Option{CodecType: typ})
+		_assert(err == nil, "%s: websocket dial failed: %v", typ, err)
+		var n int
+		err = client.Call(context.Background(), "Counter", "Incr", 2, &n)
+		_assert(err == nil && n > 0, "%s: call over websocket failed: %v", typ, err)
+		// 超过 64KB 的消息使用 8 字节的长度
+		big := []BlobItem{{Name: strings.Repeat("x", 100<<10)}}
+		var echo []BlobItem
+		err = client.Call(context.Background(), "Blob", "Echo", big, &echo)
+		_assert(err == nil && len(echo) == 1 && echo[0].Name == big[0].Name, "%s: large message over websocket failed: %v", typ, err)
+		stream, err := client.Stream(context.Background(), "Tail", "Double")
+		_assert(err == nil && stream.Send(4) == nil && stream.Recv(&n) == nil && n == 8, "%s: stream over websocket failed: %v", typ, err)
+		_ = stream.Close()
+		_ = client.Close()
+	}
+
+	resp, err := http.Get(ts.URL + "/rpc")
+	_assert(err == nil && resp.StatusCode == http.StatusBadRequest, "a plain GET should be rejected, got %v %v", resp, err)
+	_ = resp.Body.Close()
+	_, err = DialWebsocket("http://" + ts.Listener.Addr().String())
+	_assert(err != nil, "a non-websocket url should be rejected")
+	_, err = DialWebsocket(ts.URL[len("http"):])
+	_assert(err != nil, "a url without a scheme should be rejected")
+
+	serverCfg, clientCfg := testTLSConfigs(t)
+	tlsServer := httptest.NewUnstartedServer(WebsocketHTTP{server})
+	tlsServer.TLS = serverCfg
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	_, port, _ := net.SplitHostPort(tlsServer.Listener.Addr().String())
+	client, err := DialWebsocket("wss://localhost:"+port+"/rpc", &Option{TLSConfig: clientCfg})
+	_assert(err == nil, "wss dial failed: %v", err)
+	var n int
+	err = client.Call(context.Background(), "Counter", "Incr", 1, &n)
+	_assert(err == nil && n > 0, "call over wss failed: %v", err)
+	_ = client.Close()
+}
+
+func TestGateway(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_ = server.Register(new(Faulty))
+	_ = server.Register(new(Echo))
+	gateway := NewGateway(server)
+	defer func() { _ = gateway.Close() }()
+	ts := httptest.NewServer(gateway)
+	defer ts.Close()
+
+	post := func(path, body string, header map[string]string) (int, http.Header, string) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "POST %s failed: %v", path, err)
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, strings.TrimSpace(string(b))
+	}
+
+	status, _, body := post("/rpc/Counter/Incr", "3", nil)
+	_assert(status == http.StatusOK && body == "3", "unexpected reply %d %s", status, body)
+	status, header, body := post("/rpc/Echo/Tenant", "2", map[string]string{"Rpc-Metadata-Tenant": "acme"})
+	_assert(status == http.StatusOK && body == `"acme"` && header.Get("Rpc-Trailer-Cost") == "2", "unexpected reply %d %s %v", status, body, header)
+
+	status, _, body = post("/rpc/Faulty/Invalid", "1", nil)
+	var gwErr gatewayError
+	_ = json.Unmarshal([]byte(body), &gwErr)
+	_assert(status == http.StatusBadRequest && gwErr.Code == CodeBadArgument && gwErr.Detail == `{"field":"name"}`, "unexpected error reply %d %s", status, body)
+	cases := []struct {
+		path, body string
+		header     map[string]string
+		status     int
+	}{
+		{"/rpc/Missing/Incr", "1", nil, http.StatusNotFound},
+		{"/rpc/Counter", "1", nil, http.StatusNotFound},
+		{"/rpc/_pubsub/Subscribe", `"t"`, nil, http.StatusNotFound},
+		{"/rpc/Counter/Incr", "{", nil, http.StatusBadRequest},
+		{"/rpc/Counter/Incr", `"not a number"`, nil, http.StatusBadRequest},
+		{"/rpc/Faulty/Fail", "1", nil, http.StatusInternalServerError},
+		{"/rpc/Echo/Sleep", "500", map[string]string{"Rpc-Timeout": "50ms"}, http.StatusGatewayTimeout},
+		{"/rpc/Echo/Sleep", "1", map[string]string{"Rpc-Timeout": "soon"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		status, _, body = post(c.path, c.body, c.header)
+		_assert(status == c.status, "%s %s: expect status %d, got %d %s", c.path, c.body, c.status, status, body)
+	}
+	resp, err := http.Get(ts.URL + "/rpc/Counter/Incr")
+	_assert(err == nil && resp.StatusCode == http.StatusMethodNotAllowed, "GET should be rejected, got %v %v", resp, err)
+	_ = resp.Body.Close()
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(&Counter{})
+	_assert(server.SetRateLimit("Counter.Missing", &RateLimit{Rate: 1}) != nil, "expect an error for an unknown method")
+	_assert(server.SetRateLimit("Counter", &RateLimit{}) != nil, "expect an error for a limit without rates")
+	_assert(server.SetRateLimit("Counter.Incr", &RateLimit{Rate: 1, Burst: 2}) == nil, "set rate limit failed")
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var n int
+	for i := 0; i < 2; i++ {
+		_assert(client.Call(context.Background(), "Counter", "Incr", 1, &n) == nil, "call within the burst failed")
+	}
+	err := client.Call(context.Background(), "Counter", "Incr", 1, &n)
+	_assert(Code(err) == CodeResourceExhausted, "expect CodeResourceExhausted, got %v", err)
+	stats := server.Stats().RateLimits["Counter.Incr"]
+	_assert(stats.Allowed == 2 && stats.Rejected == 1 && stats.Burst == 2 && stats.Tokens < 1, "unexpected stats %+v", stats)
+
+	// 按元数据中的租户分别限流
+	_ = server.SetRateLimit("Counter.Incr", nil)
+	_ = server.SetRateLimit("Counter", &RateLimit{ClientRate: 0.001, ClientKey: func(_ ConnInfo, md map[string]string) string {
+		return md["tenant"]
+	}})
+	a := WithMetadata(context.Background(), map[string]string{"tenant": "a"})
+	b := WithMetadata(context.Background(), map[string]string{"tenant": "b"})
+	_assert(client.Call(a, "Counter", "Incr", 1, &n) == nil, "first call of tenant a failed")
+	_assert(Code(client.Call(a, "Counter", "Incr", 1, &n)) == CodeResourceExhausted, "tenant a should be limited")
+	_assert(client.Call(b, "Counter", "Incr", 1, &n) == nil, "tenant b should have its own bucket")
+	stats = server.Stats().RateLimits["Counter"]
+	_assert(stats.Clients == 2 && stats.Rejected == 1, "unexpected per-client stats %+v", stats)
+
+	_ = server.Unregister("Counter")
+	_assert(server.Stats().RateLimits == nil, "unregister should drop the rate limits")
+}
+
+// recordLogger 记录全部日志，供测试检查
+type recordLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) Log(level Level, msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := level.String() + " " + msg
+	for _, f := range fields {
+		entry += fmt.Sprintf(" %s=%v", f.Key, f.Value)
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func (l *recordLogger) count(prefix string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, e := range l.entries {
+		if strings.HasPrefix(e, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *recordLogger) find(prefix string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if strings.HasPrefix(e, prefix) {
+			return e
+		}
+	}
+	return ""
+}
+
+func TestServer_Logger(t *testing.T) {
+	t.Parallel()
+	logs := &recordLogger{}
+	server := NewServer()
+	server.Logger = logs
+	_ = server.Register(new(Faulty))
+	_ = server.Register(new(Bulk))
+	_assert(logs.find("DEBUG rpc server: register method=Faulty.Panic") != "", "expect registration logs, got %v", logs.entries)
+	addr := startServer(t, server)
+	clientLogs := &recordLogger{}
+	client := dial(t, addr, &Option{Logger: clientLogs})
+
+	var reply int
+	err := client.Call(context.Background(), "Faulty", "Panic", 1, &reply)
+	_assert(Code(err) == CodePanic && client.IsAvailable(), "expect a recovered panic, got %v", err)
+	entry := logs.find("ERROR rpc server: handler panic")
+	_assert(strings.Contains(entry, "method=Faulty.Panic") && strings.Contains(entry, "panic=handler exploded") &&
+		strings.Contains(entry, "Faulty.Panic("), "expect the panic with its stack, got %q", entry)
+
+	// 无法编码的回复只由服务端记录一次，codec 不再另外输出
+	limited := dial(t, addr, &Option{MaxMessageSize: 1024})
+	err = limited.Call(context.Background(), "Bulk", "Make", 4096, new([]byte))
+	_assert(Code(err) == CodeResourceExhausted, "expect the oversized reply to be rejected, got %v", err)
+	_assert(logs.count("ERROR rpc server: write response error") == 1, "expect the encode error logged once, got %v", logs.entries)
+	_ = limited.Close()
+
+	_, err = Dial("tcp", addr, &Option{CodecType: "application/unknown", Logger: clientLogs})
+	_assert(err != nil && clientLogs.find("ERROR rpc client: codec error") != "", "expect a client log, got %v", clientLogs.entries)
+
+	var buf bytes.Buffer
+	std := StdLogger{Logger: log.New(&buf, "", 0), Level: LevelWarn}
+	std.Log(LevelInfo, "dropped")
+	std.Log(LevelError, "rpc server: handshake error", F("remote", "1.2.3.4:5"), F("err", errors.New("bad magic")))
+	_assert(buf.String() == "rpc server: handshake error remote=1.2.3.4:5 err=\"bad magic\"\n", "unexpected std log %q", buf.String())
+}
+
+type Bulk int
+
+func (Bulk) Make(n int, reply *[]byte) error {
+	*reply = bytes.Repeat([]byte{'b'}, n)
+	return nil
+}
+
+func (Bulk) Len(b []byte, n *int) error {
+	*n = len(b)
+	return nil
+}
+
+func TestServer_MaxMessageSize(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Bulk))
+	addr := startServer(t, server)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dial(t, addr, &Option{CodecType: typ, MaxMessageSize: 1024})
+		var n int
+		var b []byte
+		err := client.Call(context.Background(), "Bulk", "Len", make([]byte, 4096), &n)
+		_assert(Code(err) == CodeResourceExhausted && errors.As(err, new(*codec.BodyTooLargeError)), "%s: expect an oversized request error, got %v", typ, err)
+		err = client.Call(context.Background(), "Bulk", "Make", 4096, &b)
+		_assert(Code(err) == CodeResourceExhausted, "%s: expect an oversized reply error, got %v", typ, err)
+		_assert(client.IsAvailable(), "%s: oversized messages should not break the connection", typ)
+		_assert(client.Call(context.Background(), "Bulk", "Make", 100, &b) == nil && len(b) == 100, "%s: small call failed", typ)
+		_ = client.Close()
+	}
+}
+
+func TestServer_ChunkedTransfer(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Bulk))
+	addr := startServer(t, server)
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType, codec.RawType} {
+		client := dial(t, addr, &Option{CodecType: typ, ChunkSize: 512, MaxMessageSize: 64 << 10})
+		var n int
+		var b []byte
+		for _, size := range []int{10, 5000, 40000} {
+			_assert(client.Call(context.Background(), "Bulk", "Len", bytes.Repeat([]byte{'a'}, size), &n) == nil && n == size,
+				"%s: chunked request of %d bytes failed, got %d", typ, size, n)
+			err := client.Call(context.Background(), "Bulk", "Make", size, &b)
+			_assert(err == nil && len(b) == size && bytes.Count(b, []byte{'b'}) == size, "%s: chunked reply of %d bytes failed: %v", typ, size, err)
+		}
+		err := client.Call(context.Background(), "Bulk", "Make", 100<<10, &b)
+		_assert(Code(err) == CodeResourceExhausted && client.IsAvailable(), "%s: expect an oversized reply error, got %v", typ, err)
+		_ = client.Close()
+	}
+	_, err := Dial("tcp", addr, &Option{CodecType: codec.ProtobufType, ChunkSize: 512})
+	_assert(err != nil, "protobuf should not support chunked transfer")
+}
+
+// Waiter 阻塞到 ctx 结束，把 ctx.Err() 发到 done
+type Waiter struct{ done chan error }
+
+func (w *Waiter) Wait(ctx context.Context, _ int, _ *int) error {
+	<-ctx.Done()
+	w.done <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestServer_RemoteCancel(t *testing.T) {
+	t.Parallel()
+	w := &Waiter{done: make(chan error, 1)}
+	server := NewServer()
+	_ = server.Register(w)
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var reply int
+	err := client.Call(ctx, "Waiter", "Wait", 0, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded) || Code(err) == CodeTimeout, "expect a timeout, got %v", err)
+	select {
+	case err = <-w.done:
+		_assert(err != nil, "handler ctx should be done")
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx should be cancelled after the caller gives up")
+	}
+
+	call := client.Go("Waiter", "Wait", 0, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	call.Cancel()
+	select {
+	case err = <-w.done:
+		_assert(errors.Is(err, context.Canceled), "expect Canceled from Call.Cancel, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx should be cancelled by Call.Cancel")
+	}
+
+	client.Go("Waiter", "Wait", 0, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	_ = client.Close()
+	select {
+	case err = <-w.done:
+		_assert(errors.Is(err, context.Canceled), "expect Canceled after disconnect, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler ctx should be cancelled when the connection drops")
+	}
+}
+
+type tenantKey struct{}
+
+type userKey struct{}
+
+// Whoami 回复提取器放入 ctx 的租户与用户
+type Whoami struct{}
+
+func (Whoami) Get(ctx context.Context, _ int, reply *string) error {
+	*reply = fmt.Sprintf("%v/%v", ctx.Value(tenantKey{}), ctx.Value(userKey{}))
+	return nil
+}
+
+// ReportLoad 时每个回复的 trailer 都附带负载报告，客户端记录最近一次的报告
+func TestServer_ReportLoad(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.ReportLoad = true
+	server.Utilization = func() float64 { return 0.25 }
+	_ = server.Register(new(Echo))
+	_ = server.Register(new(Faulty))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	_, at := client.LoadReport()
+	_assert(at.IsZero(), "no report should be recorded before any reply")
+	call := <-client.Go("Echo", "Sleep", 1, new(int), nil).Done
+	report, ok := ParseLoadReport(call.Trailer)
+	_assert(call.Error == nil && ok && report.Utilization == 0.25 && report.Inflight == 0, "unexpected load report %v %v %+v", call.Error, ok, call.Trailer)
+	recorded, at := client.LoadReport()
+	_assert(!at.IsZero() && recorded == report, "expect the client to record the report, got %+v", recorded)
+	// 方法出错的回复同样附带
+	call = <-client.Go("Faulty", "Panic", 1, new(int), nil).Done
+	_, ok = ParseLoadReport(call.Trailer)
+	_assert(call.Error != nil && ok, "error replies should carry the load report, got %+v", call.Trailer)
+
+	report, ok = ParseLoadReport(map[string]string{LoadTrailerKey: "inflight=3 queued=2 future=x"})
+	_assert(ok && report == LoadReport{Inflight: 3, Queued: 2}, "unknown keys should be ignored, got %+v %v", report, ok)
+	_, ok = ParseLoadReport(map[string]string{LoadTrailerKey: "inflight=three"})
+	_assert(!ok, "malformed reports should be rejected")
+}
+
+func TestServer_Backpressure(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Backpressure = &Backpressure{Rate: 20, TTL: time.Millisecond * 300}
+	_ = server.Register(new(Echo))
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var reply int
+	_assert(client.Backpressure() == 0, "no limit before any signal")
+	_assert(client.Call(context.Background(), "Echo", "Sleep", 1, &reply) == nil, "first call failed")
+	_assert(client.Backpressure() == 20, "expect the signalled rate, got %v", client.Backpressure())
+	// 20 次/秒：之后的 6 个请求至少需要约 250ms
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		_assert(client.Call(context.Background(), "Echo", "Sleep", 1, &reply) == nil, "paced call failed")
+	}
+	_assert(time.Since(start) >= time.Millisecond*200, "calls should be paced, took %v", time.Since(start))
+	// 等待令牌时 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_ = client.Call(context.Background(), "Echo", "Sleep", 1, &reply)
+	err := client.Call(ctx, "Echo", "Sleep", 1, &reply)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect the ctx error while paced, got %v", err)
+	// ttl 内没有收到新的信号即解除限速
+	time.Sleep(time.Millisecond * 350)
+	_assert(client.Backpressure() == 0, "the limit should expire, got %v", client.Backpressure())
+
+	ignoring := dial(t, addr, &Option{IgnoreBackpressure: true})
+	start = time.Now()
+	for i := 0; i < 6; i++ {
+		_assert(ignoring.Call(context.Background(), "Echo", "Sleep", 1, &reply) == nil, "call failed")
+	}
+	_assert(ignoring.Backpressure() == 0 && time.Since(start) < time.Millisecond*200, "IgnoreBackpressure should not pace, took %v", time.Since(start))
+
+	// 负载低于 Threshold 时不发出信号
+	quiet := NewServer()
+	quiet.Backpressure = &Backpressure{Threshold: 100, Rate: 1}
+	_ = quiet.Register(new(Echo))
+	qlAddr := startServer(t, quiet)
+	qc := dial(t, qlAddr)
+	call := <-qc.Go("Echo", "Sleep", 1, new(int), nil).Done
+	_, ok := ParseBackpressure(call.Trailer)
+	_assert(call.Error == nil && !ok && qc.Backpressure() == 0, "expect no signal below the threshold, got %+v", call.Trailer)
+
+	sig, ok := ParseBackpressure(map[string]string{BackpressureTrailerKey: "rate=5 future=x"})
+	_assert(ok && sig == BackpressureSignal{Rate: 5, TTL: DefaultBackpressureTTL}, "unknown keys should be ignored, got %+v %v", sig, ok)
+	_, ok = ParseBackpressure(map[string]string{BackpressureTrailerKey: "rate=0 ttl=1s"})
+	_assert(!ok, "a non-positive rate should be rejected")
+}
+
+func TestServer_MetadataExtractors(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Whoami))
+	_ = server.Register(new(Tail))
+	order := make(chan string, 2)
+	server.UseExtractors(ExtractMetadata("tenant", tenantKey{}), func(ctx context.Context, md map[string]string) (context.Context, error) {
+		// 排在后面的提取器可以使用前面提取的值
+		order <- fmt.Sprint(ctx.Value(tenantKey{}))
+		id, err := strconv.Atoi(md["user"])
+		if err != nil {
+			return ctx, NewError(CodeBadArgument, "bad user id %q", md["user"])
+		}
+		return context.WithValue(ctx, userKey{}, id), nil
+	})
+	server.Use(func(ctx context.Context, info ServiceMethodInfo, handler Handler) error {
+		_assert(ctx.Value(userKey{}) != nil, "interceptors should see the extracted values")
+		return handler(ctx, info)
+	})
+	addr := startServer(t, server)
+	client := dial(t, addr)
+
+	var reply string
+	ctx := WithMetadata(context.Background(), map[string]string{"tenant": "acme", "user": "42"})
+	err := client.Call(ctx, "Whoami", "Get", 0, &reply)
+	_assert(err == nil && reply == "acme/42", "expect acme/42, got %q, %v", reply, err)
+	err = client.Call(WithMetadata(context.Background(), map[string]string{"user": "x"}), "Whoami", "Get", 0, &reply)
+	_assert(Code(err) == CodeBadArgument, "expect the extractor's error, got %v", err)
+	first, second := <-order, <-order
+	_assert(first == "acme" && second == "<nil>", "later extractors should see earlier values, got %s, %s", first, second)
+	stream, _ := client.Stream(context.Background(), "Tail", "Double")
+	_assert(Code(stream.Recv(new(int))) == CodeBadArgument, "extractors should reject streams too")
+}
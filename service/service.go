@@ -1,10 +1,14 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 type MethodType struct {
@@ -12,6 +16,8 @@ type MethodType struct {
 	ArgType   reflect.Type   // 入参类型
 	ReplyType reflect.Type   // 返回类型
 	NumCall   uint64         // 统计方法调用次数
+	CacheTTL  time.Duration  // 响应缓存时间，0 即为不缓存
+	HasCtx    bool           // 方法的第一个入参为 context.Context
 }
 
 func (m *MethodType) NumCalls() uint64 {
@@ -54,7 +60,7 @@ type Service struct {
 /*
 NewService
 
-Rcvr: 任意需要映射为服务的结构体实例
+Rcvr: 任意需要映射为服务的结构体实例；结构体名称不是导出的名称时 panic，需要返回错误时使用 New
 */
 func NewService(rcvr interface{}) *Service {
 	s := new(Service)
@@ -64,36 +70,80 @@ func NewService(rcvr interface{}) *Service {
 
 	// ast Abstract Syntax Tree, 抽象语法树
 	if !ast.IsExported(s.Name) {
-		log.Fatalf("rpc server: %s is not a valid Service Name", s.Name)
+		panic(fmt.Sprintf("rpc server: %s is not a valid Service Name", s.Name))
 	}
 	s.RegisterMethods()
 	return s
 }
 
+/*
+New
+与 NewService 相同，但以返回错误代替退出进程，并严格检查方法签名：
+形如 RPC 方法（返回 error、两个入参，可以再有一个 context.Context 入参）但类型不符合要求的导出方法返回错误，
+其他形状的方法（如流式方法 func(*Stream) error）仍然跳过
+
+name 为空时使用结构体名称，自定义的名称不能为空、不能包含 "."
+*/
+func New(name string, rcvr interface{}) (*Service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: service receiver is nil")
+	}
+	s := new(Service)
+	s.Rcvr = reflect.ValueOf(rcvr)
+	s.Typ = reflect.TypeOf(rcvr)
+	s.Name = name
+	if name == "" {
+		s.Name = reflect.Indirect(s.Rcvr).Type().Name()
+		if !ast.IsExported(s.Name) {
+			return nil, fmt.Errorf("rpc server: type %s is not exported, use RegisterName to give it a name", s.Typ)
+		}
+	} else if strings.Contains(name, ".") {
+		return nil, fmt.Errorf("rpc server: service name %q must not contain \".\"", name)
+	}
+	if err := s.registerMethods(true); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 /*
 RegisterMethods
 
 过滤出了符合条件的方法：
-1. 两个导出或内置类型的入参
+1. 两个导出或内置类型的入参，之前可以再有一个 context.Context 入参
 2. 返回值有且只有 1 个，类型为 error
+
+即 func (t *T) Method(args Args, reply *Reply) error
+或 func (t *T) Method(ctx context.Context, args Args, reply *Reply) error
+ctx 携带调用方剩余的时间预算，方法内发起的下游调用应继续传递该 ctx，使整条调用链不超过最初调用方的 deadline
 */
 func (s *Service) RegisterMethods() {
+	_ = s.registerMethods(false)
+}
+
+// registerMethods strict 为 true 时，类型不符合要求的 RPC 方法返回错误而不是跳过
+func (s *Service) registerMethods(strict bool) error {
 	s.Method = make(map[string]*MethodType)
 
 	for i := 0; i < s.Typ.NumMethod(); i++ {
 		method := s.Typ.Method(i)
 		mType := method.Type
 
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		numIn := mType.NumIn()
+		hasCtx := numIn == 4 && mType.In(1) == typeOfContext
+		if (numIn != 3 && !hasCtx) || mType.NumOut() != 1 {
 			continue
 		}
 		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
 			continue
 		}
 
-		argType, replyType := mType.In(1), mType.In(2)
+		argType, replyType := mType.In(numIn-2), mType.In(numIn-1)
 
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if err := checkTypes(argType, replyType); err != nil {
+			if strict {
+				return fmt.Errorf("rpc server: method %s.%s: %v", s.Name, method.Name, err)
+			}
 			continue
 		}
 
@@ -101,19 +151,47 @@ func (s *Service) RegisterMethods() {
 			Method:    method,
 			ArgType:   argType,
 			ReplyType: replyType,
+			HasCtx:    hasCtx,
 		}
-		log.Printf("rpc server: register %s.%s\n", s.Name, method.Name)
 	}
+	return nil
+}
+
+// checkTypes 入参、返回值的类型是否可以用于 RPC 方法
+func checkTypes(argType, replyType reflect.Type) error {
+	switch {
+	case !isExportedOrBuiltinType(argType):
+		return fmt.Errorf("argument type %s is not exported", argType)
+	case !isExportedOrBuiltinType(replyType):
+		return fmt.Errorf("reply type %s is not exported", replyType)
+	case replyType.Kind() != reflect.Ptr:
+		return fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	return nil
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 func (s *Service) Call(m *MethodType, argv, replyv reflect.Value) error {
+	return s.CallContext(context.Background(), m, argv, replyv)
+}
+
+// CallContext 调用方法，方法的第一个入参为 context.Context 时传入 ctx
+func (s *Service) CallContext(ctx context.Context, m *MethodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.NumCall, 1)
 	f := m.Method.Func
-	returnValues := f.Call([]reflect.Value{s.Rcvr, argv, replyv})
+	in := []reflect.Value{s.Rcvr, argv, replyv}
+	if m.HasCtx {
+		in = []reflect.Value{s.Rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
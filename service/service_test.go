@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +54,59 @@ func TestMethodType_Call(t *testing.T) {
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
 
+
+type Bar int
+
+func (b Bar) Sum(ctx context.Context, args Args, reply *int) error {
+	if ctx == nil {
+		return errors.New("nil ctx")
+	}
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestService_CallContext(t *testing.T) {
+	var bar Bar
+	s := NewService(&bar)
+	mType := s.Method["Sum"]
+	_assert(mType != nil && mType.HasCtx, "method with a context.Context first argument should be registered")
+
+	argv := mType.NewArgv()
+	replyv := mType.NewReplyv()
+	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+	err := s.CallContext(context.Background(), mType, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*int) == 4, "failed to call Bar.Sum")
+}
+
+type args struct{ Num int }
+
+type Strict int
+
+func (s Strict) Sum(args Args, reply int) error {
+	return nil
+}
+
+type Hidden int
+
+func (h Hidden) Sum(args *args, reply *int) error {
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	var foo Foo
+	s, err := New("", &foo)
+	_assert(err == nil && s.Name == "Foo" && len(s.Method) == 1, "expect Foo with 1 method, got %v", err)
+	s, err = New("Calc", &foo)
+	_assert(err == nil && s.Name == "Calc", "expect a custom name, got %v", err)
+
+	_, err = New("", new(Strict))
+	_assert(err != nil && strings.Contains(err.Error(), "Strict.Sum: reply type int is not a pointer"), "expect a reply type error, got %v", err)
+	_, err = New("", new(Hidden))
+	_assert(err != nil && strings.Contains(err.Error(), "argument type *service.args is not exported"), "expect an argument type error, got %v", err)
+	_, err = New("", new(args))
+	_assert(err != nil && strings.Contains(err.Error(), "not exported"), "expect an unexported type error, got %v", err)
+	_, err = New("a.b", &foo)
+	_assert(err != nil, "a name with \".\" should be rejected")
+	_, err = New("", nil)
+	_assert(err != nil, "a nil receiver should be rejected")
+}
@@ -0,0 +1,265 @@
+package myGoRPC
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"myGoRPC/codec"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+会话恢复（连接迁移），用于 IP 会变化的移动端：网络切换后 TCP 连接断开，客户端重新拨号并出示会话令牌，
+继续原来的逻辑会话，已发出的请求不必全部失败
+
+握手：
+1. 客户端设置 Option.Resumable，V1 握手时服务端创建会话，在 handshakeReply.Session 中返回令牌
+2. 连接断开后，客户端拨号同一服务端地址，Option.Session 携带令牌再次握手，
+   令牌未知或已过期时服务端回复错误，客户端放弃恢复，按连接断开处理
+3. 恢复成功后服务端先发送暂存的回复，待旧连接上仍在处理的请求全部回复后，
+   发送恢复完成信号：Seq 为 0、Error 为 errSessionResumed 的响应
+
+能恢复的状态：
+- 连接断开时服务端仍在处理、或断开后才处理完的请求，其回复暂存在会话中（最多 maxSessionOutbox 个），恢复后送达
+- 已经写入旧连接、但没有到达客户端的回复，以及没有到达服务端的请求，无法恢复；
+  客户端收到恢复完成信号后，这些请求以 ErrSessionLost 失败，它们可能执行过，也可能没有
+- 断开超过 sessionTTL 没有恢复的会话会被清除
+*/
+
+const (
+	maxSessionOutbox = 1024
+	sessionTTL       = time.Minute
+)
+
+var (
+	errSessionResumed = errors.New("rpc server: session resumed")
+	errUnknownSession = errors.New("unknown session")
+)
+
+// ErrSessionLost 会话恢复后仍没有回复的请求，可能执行过，也可能没有
+var ErrSessionLost = errors.New("rpc client: call lost while resuming the session")
+
+type sessionReply struct {
+	header codec.Header
+	body   interface{}
+}
+
+/*
+session
+current 为会话当前的连接，断开后为 nil；断开期间产生的回复暂存在 outbox 中
+last 为最近一次绑定的连接，断开后仍然保留，恢复时等待其上的请求全部回复
+mu 保证回复按顺序写入或暂存，恢复时 outbox 的回复先于之后的回复送达
+*/
+type session struct {
+	token string
+
+	mu         sync.Mutex
+	current    *serverConn
+	last       *serverConn
+	outbox     []sessionReply
+	detachedAt time.Time
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createSession 创建会话，同时清除过期的会话
+func (server *Server) createSession() (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	server.sessionMu.Lock()
+	defer server.sessionMu.Unlock()
+	if server.sessions == nil {
+		server.sessions = make(map[string]*session)
+	}
+	for t, sess := range server.sessions {
+		if sess.expired() {
+			delete(server.sessions, t)
+		}
+	}
+	server.sessions[token] = &session{token: token}
+	return token, nil
+}
+
+func (server *Server) lookupSession(token string) *session {
+	server.sessionMu.Lock()
+	defer server.sessionMu.Unlock()
+	sess := server.sessions[token]
+	if sess == nil || sess.expired() {
+		return nil
+	}
+	return sess
+}
+
+func (sess *session) expired() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.current == nil && !sess.detachedAt.IsZero() && time.Since(sess.detachedAt) > sessionTTL
+}
+
+/*
+attach
+将连接绑定到会话；恢复时先发送暂存的回复，再等旧连接上的请求全部回复后发送恢复完成信号
+*/
+func (server *Server) attach(sess *session, sc *serverConn) {
+	sess.mu.Lock()
+	prev := sess.last
+	sess.current, sess.last = sc, sc
+	outbox := sess.outbox
+	sess.outbox = nil
+	for i := range outbox {
+		server.sendResponse(sc.cc, &outbox[i].header, outbox[i].body, sc.sending)
+	}
+	sess.mu.Unlock()
+	sc.session = sess
+	if prev == nil {
+		return
+	}
+	go func() {
+		// 客户端可能在服务端发现旧连接断开之前就完成了恢复，旧连接上的请求回复后才能发送完成信号
+		_ = prev.cc.Close()
+		prev.wg.Wait()
+		sess.send(server, &codec.Header{Error: errSessionResumed.Error()}, invalidRequest)
+	}()
+}
+
+// detach 连接断开，之后的回复暂存在 outbox 中
+func (sess *session) detach(sc *serverConn) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.current == sc {
+		sess.current = nil
+		sess.detachedAt = time.Now()
+	}
+}
+
+// send 写入会话当前的连接，连接已断开或写入失败时暂存
+func (sess *session) send(server *Server, header *codec.Header, body interface{}) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sc := sess.current; sc != nil {
+		sc.sending.Lock()
+		err := sc.cc.Write(header, body)
+		sc.sending.Unlock()
+		if err == nil {
+			return
+		}
+		if codec.EncodeFailed(err) {
+			server.sendResponse(sc.cc, header, body, sc.sending)
+			return
+		}
+		sess.current = nil
+		sess.detachedAt = time.Now()
+	}
+	if len(sess.outbox) >= maxSessionOutbox {
+		server.logger().Log(LevelWarn, "rpc server: session outbox full, dropping reply", F("session", sess.token), F("seq", header.Seq))
+		return
+	}
+	sess.outbox = append(sess.outbox, sessionReply{header: *header, body: body})
+}
+
+const defaultResumeTimeout = 30 * time.Second
+
+/*
+resume
+客户端：连接断开后重新拨号，携带会话令牌握手，成功后替换 client.cc
+持有 sending，恢复期间新的请求等待，不会写入已断开的连接；令牌被拒绝或超过 ResumeTimeout 时放弃
+只支持 Dial/NewClient 建立的连接，重新拨号的地址为原连接的 RemoteAddr
+*/
+func (client *Client) resume() error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	_ = client.cc.Close()
+	timeout := client.option.ResumeTimeout
+	if timeout == 0 {
+		timeout = defaultResumeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	backoff := 50 * time.Millisecond
+	for {
+		// 主动关闭或服务端排空的连接，不再恢复
+		if !client.IsAvailable() {
+			return ErrShutdown
+		}
+		cc, reply, err := client.redial(client.session)
+		if err == nil {
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			if client.closing {
+				_ = cc.Close()
+				return ErrShutdown
+			}
+			client.cc, client.maxStreams = cc, reply.MaxConcurrentStreams
+			client.resumeSeq = client.seq
+			return nil
+		}
+		if err.Error() == "rpc server: "+errUnknownSession.Error() || time.Now().Add(backoff).After(deadline) {
+			client.logger().Log(LevelWarn, "rpc client: resume session error", F("err", err))
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > time.Second {
+			backoff = time.Second
+		}
+	}
+}
+
+// redial 重新拨号并握手，session 非空时恢复该会话；返回服务端的握手回复
+func (client *Client) redial(session string) (codec.Codec, handshakeReply, error) {
+	opt := *client.option
+	opt.Session = session
+	var conn net.Conn
+	var err error
+	if opt.dialConn != nil {
+		conn, err = opt.dialConn(opt.ConnectTimeout)
+	} else {
+		conn, err = net.DialTimeout(client.addr.Network(), client.addr.String(), opt.ConnectTimeout)
+	}
+	if err != nil {
+		return nil, handshakeReply{}, err
+	}
+	if opt.ConnectTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(opt.ConnectTimeout))
+	}
+	if opt.dialTLS != nil {
+		if conn, err = tlsHandshake(conn, opt.dialTLS); err != nil {
+			return nil, handshakeReply{}, err
+		}
+	}
+	rwc, reply, err := clientHandshake(conn, &opt)
+	var counted *byteCounter
+	if err == nil {
+		counted = &byteCounter{ReadWriteCloser: newWriteCoalescer(rwc)}
+		rwc, err = compress(&opt, opt.MaxBodySize, counted)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, handshakeReply{}, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	f := codec.Get(opt.CodecType)
+	return client.countStats(messageCodec(f, f(rwc), &opt, opt.MaxBodySize), counted), reply, nil
+}
+
+// failLostCalls 收到恢复完成信号，恢复之前发出、仍没有回复的请求已经无法送达
+func (client *Client) failLostCalls() {
+	var lost []*Call
+	client.mu.Lock()
+	for seq, call := range client.pending {
+		if seq < client.resumeSeq {
+			delete(client.pending, seq)
+			lost = append(lost, call)
+		}
+	}
+	client.mu.Unlock()
+	failCalls(lost, ErrSessionLost)
+}
@@ -0,0 +1,97 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed Shutdown 或 Close 之后，Accept 立即返回
+var ErrServerClosed = errors.New("rpc server: server closed")
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (server *Server) isClosed() bool {
+	return atomic.LoadInt32(&server.closed) == 1
+}
+
+// trackListener 登记 Accept 正在使用的 listener，Shutdown、Close 时关闭；服务端已关闭时返回 false
+func (server *Server) trackListener(l net.Listener, add bool) bool {
+	server.listenerMu.Lock()
+	defer server.listenerMu.Unlock()
+	if !add {
+		delete(server.listeners, l)
+		return true
+	}
+	if server.isClosed() {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[l] = struct{}{}
+	return true
+}
+
+// closeListeners 标记服务端已关闭，关闭所有 listener，返回第一个关闭错误
+func (server *Server) closeListeners() error {
+	server.listenerMu.Lock()
+	defer server.listenerMu.Unlock()
+	atomic.StoreInt32(&server.closed, 1)
+	var first error
+	for l := range server.listeners {
+		if err := l.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(server.listeners, l)
+	}
+	return first
+}
+
+/*
+Shutdown
+优雅地关闭服务端：
+ 1. 关闭所有 Accept 的 listener，不再接受新的连接，之后调用的 Accept 立即返回
+ 2. 对每个连接执行 Quiesce：客户端收到排空信号后不再发送新的请求，之后到达的请求回复 ErrConnQuiescing
+ 3. 等待正在处理的请求全部回复、连接全部关闭
+
+ctx 结束时仍有连接未关闭，则强制关闭这些连接（未回复的请求不再回复），返回 ctx.Err()
+握手中的连接在握手完成后立即关闭；会话（Option.Resumable）中暂存的回复随之丢弃
+*/
+func (server *Server) Shutdown(ctx context.Context) error {
+	lerr := server.closeListeners()
+	server.conns.Range(func(id, _ interface{}) bool {
+		_ = server.Quiesce(id.(uint64))
+		return true
+	})
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if len(server.Connections()) == 0 {
+			return lerr
+		}
+		select {
+		case <-ctx.Done():
+			server.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close 立即关闭所有 listener 与连接，不等待正在处理的请求；返回关闭 listener 的第一个错误
+func (server *Server) Close() error {
+	err := server.closeListeners()
+	server.closeConns()
+	return err
+}
+
+func (server *Server) closeConns() {
+	server.conns.Range(func(_, sci interface{}) bool {
+		_ = sci.(*serverConn).cc.Close()
+		return true
+	})
+}
@@ -0,0 +1,270 @@
+package myGoRPC
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+/*
+snappy
+
+手工实现 snappy 的块格式与流格式（framing format），不依赖第三方库，可以与其他 snappy 实现互通：
+流以 stream identifier 开始，之后每个 chunk 为 1 字节类型 + 3 字节长度（小端）+ 4 字节校验和（CRC-32C，掩码）+ 数据，
+每个 chunk 至多 64KB 的原始数据，压缩后没有变小的 chunk 原样发送
+
+压缩率低于 gzip；每个 chunk 独立压缩，不在连接上保留压缩状态
+*/
+
+const (
+	snappyChunkCompressed   = 0x00
+	snappyChunkUncompressed = 0x01
+	snappyChunkStreamID     = 0xff
+	snappyMaxBlock          = 65536
+	snappyStreamID          = "sNaPpY"
+)
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func snappyChecksum(b []byte) uint32 {
+	c := crc32.Checksum(b, crc32c)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// snappyEncode 压缩一个块，src 不超过 snappyMaxBlock 字节，偏移量都可以用 2 字节表示
+func snappyEncode(src []byte) []byte {
+	dst := appendUvarint(nil, uint64(len(src)))
+	if len(src) < 4 {
+		return snappyLiteral(dst, src)
+	}
+	// 以 4 字节为键的哈希表，值为最近一次出现的位置
+	var table [1 << 14]int32
+	hash := func(u uint32) uint32 { return (u * 0x1e35a7bd) >> 18 }
+	load := func(i int) uint32 { return binary.LittleEndian.Uint32(src[i:]) }
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		h := hash(load(i))
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || load(cand) != load(i) {
+			i++
+			continue
+		}
+		dst = snappyLiteral(dst, src[lit:i])
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = snappyCopy(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+	return snappyLiteral(dst, src[lit:])
+}
+
+func snappyLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	default:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(dst, lit...)
+}
+
+// snappyCopy 每个 copy 至多 64 字节，较长的匹配拆成多个，每个不少于 4 字节
+func snappyCopy(dst []byte, offset, n int) []byte {
+	for n > 0 {
+		l := n
+		switch {
+		case l > 64 && l < 68:
+			l = 60
+		case l > 64:
+			l = 64
+		}
+		dst = append(dst, byte(l-1)<<2|2, byte(offset), byte(offset>>8))
+		n -= l
+	}
+	return dst
+}
+
+// snappyDecode 解压一个块，解压后的长度超过 max 时返回错误
+func snappyDecode(src []byte, max int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(max) {
+		return nil, errSnappyCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag>>2) + 1
+			src = src[1:]
+			if extra := length - 60; extra > 0 {
+				if extra > 4 || len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				length++
+				src = src[extra:]
+			}
+			if length > len(src) || len(dst)+length > int(size) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errSnappyCorrupt
+		}
+		// 逐字节复制，允许与输出重叠
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(size) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+// snappyConn snappy 流格式的连接，每次 Write 立即写出完整的 chunk
+type snappyConn struct {
+	rwc     io.ReadWriteCloser
+	r       *bufio.Reader
+	wroteID bool
+	pending []byte // 已解出、尚未读取的数据
+}
+
+func newSnappyConn(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	return &snappyConn{rwc: rwc, r: bufio.NewReader(rwc)}
+}
+
+func (c *snappyConn) Write(p []byte) (int, error) {
+	var out []byte
+	if !c.wroteID {
+		out = append(out, snappyChunkStreamID, byte(len(snappyStreamID)), 0, 0)
+		out = append(out, snappyStreamID...)
+	}
+	for rest := p; len(rest) > 0; {
+		block := rest
+		if len(block) > snappyMaxBlock {
+			block = block[:snappyMaxBlock]
+		}
+		rest = rest[len(block):]
+		chunkType, data := byte(snappyChunkCompressed), snappyEncode(block)
+		if len(data) >= len(block)-len(block)/8 {
+			chunkType, data = snappyChunkUncompressed, block
+		}
+		size := len(data) + 4
+		out = append(out, chunkType, byte(size), byte(size>>8), byte(size>>16))
+		out = appendUint32LE(out, snappyChecksum(block))
+		out = append(out, data...)
+	}
+	if _, err := c.rwc.Write(out); err != nil {
+		return 0, err
+	}
+	c.wroteID = true
+	return len(p), nil
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (c *snappyConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *snappyConn) readChunk() error {
+	var head [4]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	size := int(head[1]) | int(head[2])<<8 | int(head[3])<<16
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(c.r, chunk); err != nil {
+		return unexpectedEOF(err)
+	}
+	switch t := head[0]; {
+	case t == snappyChunkStreamID:
+		if string(chunk) != snappyStreamID {
+			return errSnappyCorrupt
+		}
+	case t == snappyChunkCompressed || t == snappyChunkUncompressed:
+		if size < 4 {
+			return errSnappyCorrupt
+		}
+		data := chunk[4:]
+		if t == snappyChunkCompressed {
+			var err error
+			if data, err = snappyDecode(data, snappyMaxBlock); err != nil {
+				return err
+			}
+		}
+		if snappyChecksum(data) != binary.LittleEndian.Uint32(chunk) {
+			return errors.New("snappy: checksum mismatch")
+		}
+		c.pending = data
+	case t < 0x80:
+		// 保留的、不可跳过的 chunk
+		return errSnappyCorrupt
+	}
+	// padding 与可跳过的 chunk 直接丢弃
+	return nil
+}
+
+func (c *snappyConn) Close() error {
+	return c.rwc.Close()
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,293 @@
+package myGoRPC
+
+import (
+	"fmt"
+	"io"
+	"myGoRPC/codec"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+按 Service.Method 统计的调用指标，客户端、服务端都默认开启，计数均为原子操作
+
+- Calls、Errors、延迟：服务端为每个经过 handleRequest 处理的请求（不含心跳、过载或排空时拒绝的请求），
+  延迟从开始处理到回复；客户端为每个结束的 Call，延迟从注册到 pending 到结束
+- BytesIn、BytesOut：连接上读写的字节数（压缩之后，即实际传输的字节），按消息头中的 Service.Method 归属；
+  写入是精确的，读取按每次 ReadHeader、ReadBody 前后的差值计算，缓冲预读的字节可能计入相邻的消息
+- 服务端只统计已注册的方法，客户端请求不存在的方法不会产生新的统计项
+
+通过 Server.Stats、Client.Stats 取得快照，Server.StatsHandler 以 Prometheus 文本格式输出
+*/
+
+// LatencyBounds 延迟分桶的上边界，不应修改
+var LatencyBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// MethodStats 一个方法的统计快照
+type MethodStats struct {
+	Calls      uint64
+	Errors     uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	LatencySum time.Duration
+	// 按 LatencyBounds 分桶的调用数（不累计），长度为 len(LatencyBounds)+1，最后一个桶为超过最大边界的调用
+	LatencyBuckets []uint64
+}
+
+// ServerStats 服务端统计的快照，键为 "Service.Method"
+type ServerStats struct {
+	Methods  map[string]MethodStats
+	Inflight int64 // 正在处理的请求数
+	Queued   int64 // 等待 MaxConcurrentRequests 名额的请求数，见 workers.go
+	// 限流器的状态，键为 SetRateLimit 的 name，没有设置限流时为 nil，见 ratelimit.go
+	RateLimits map[string]RateLimitStats
+}
+
+type methodCounters struct {
+	calls, errors     uint64
+	bytesIn, bytesOut uint64
+	latencySum        int64
+	buckets           []uint64
+}
+
+// statsRegistry 零值可用
+type statsRegistry struct {
+	methods sync.Map // "Service.Method" -> *methodCounters
+}
+
+func (r *statsRegistry) counters(name string) *methodCounters {
+	if m, ok := r.methods.Load(name); ok {
+		return m.(*methodCounters)
+	}
+	m, _ := r.methods.LoadOrStore(name, &methodCounters{buckets: make([]uint64, len(LatencyBounds)+1)})
+	return m.(*methodCounters)
+}
+
+func (r *statsRegistry) finish(name string, latency time.Duration, failed bool) {
+	m := r.counters(name)
+	atomic.AddUint64(&m.calls, 1)
+	if failed {
+		atomic.AddUint64(&m.errors, 1)
+	}
+	atomic.AddInt64(&m.latencySum, int64(latency))
+	i := sort.Search(len(LatencyBounds), func(i int) bool { return latency <= LatencyBounds[i] })
+	atomic.AddUint64(&m.buckets[i], 1)
+}
+
+func (r *statsRegistry) snapshot() map[string]MethodStats {
+	methods := make(map[string]MethodStats)
+	r.methods.Range(func(name, mi interface{}) bool {
+		m := mi.(*methodCounters)
+		s := MethodStats{
+			Calls:          atomic.LoadUint64(&m.calls),
+			Errors:         atomic.LoadUint64(&m.errors),
+			BytesIn:        atomic.LoadUint64(&m.bytesIn),
+			BytesOut:       atomic.LoadUint64(&m.bytesOut),
+			LatencySum:     time.Duration(atomic.LoadInt64(&m.latencySum)),
+			LatencyBuckets: make([]uint64, len(m.buckets)),
+		}
+		for i := range m.buckets {
+			s.LatencyBuckets[i] = atomic.LoadUint64(&m.buckets[i])
+		}
+		methods[name.(string)] = s
+		return true
+	})
+	return methods
+}
+
+// Stats 返回服务端按方法统计的快照
+func (server *Server) Stats() ServerStats {
+	return ServerStats{
+		Methods:    server.stats.snapshot(),
+		Inflight:   atomic.LoadInt64(&server.inflight),
+		Queued:     atomic.LoadInt64(&server.queued),
+		RateLimits: server.rateLimitStats(),
+	}
+}
+
+// ClientStats 客户端运行状态的快照
+type ClientStats struct {
+	SeqAnomalies uint64 // 响应序号异常的次数，未开启 Option.SeqCheckWindow 时恒为 0，见 seqcheck.go
+	Heartbeats   uint64 // 发送的心跳数，见 heartbeat.go
+	// 按 "Service.Method" 统计的调用
+	Methods map[string]MethodStats
+}
+
+// Stats 返回客户端运行状态的快照
+func (client *Client) Stats() ClientStats {
+	stats := ClientStats{Methods: client.stats.snapshot(), Heartbeats: atomic.LoadUint64(&client.pings)}
+	if client.seqMon != nil {
+		stats.SeqAnomalies = atomic.LoadUint64(&client.seqMon.anomalies)
+	}
+	return stats
+}
+
+func (server *Server) countStats(cc codec.Codec, conn *byteCounter) codec.Codec {
+	return &statsCodec{Codec: cc, conn: conn, stats: &server.stats, known: func(service, method string) bool {
+		_, _, err := server.findServiceMethod(service, method)
+		return err == nil
+	}}
+}
+
+// countStats conn 为 nil 时不统计字节数，原样返回 cc
+func (client *Client) countStats(cc codec.Codec, conn *byteCounter) codec.Codec {
+	if conn == nil {
+		return cc
+	}
+	return &statsCodec{Codec: cc, conn: conn, stats: &client.stats}
+}
+
+// recordCall 在 Call.done 中调用，心跳不统计
+func (client *Client) recordCall(call *Call) {
+	if call.Service == heartbeatService {
+		return
+	}
+	var latency time.Duration
+	if !call.registered.IsZero() {
+		latency = time.Since(call.registered)
+	}
+	client.stats.finish(call.Service+"."+call.Method, latency, call.Error != nil)
+}
+
+// byteCounter 统计连接读写的字节数
+type byteCounter struct {
+	io.ReadWriteCloser
+	in, out uint64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddUint64(&c.in, uint64(n))
+	return n, err
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddUint64(&c.out, uint64(n))
+	return n, err
+}
+
+/*
+statsCodec
+将 conn 读写的字节数按消息头归属到方法；读取只在 receive/serveCodec 一个协程中进行，写入由 sending 串行化
+known 为 nil 时统计全部方法
+*/
+type statsCodec struct {
+	codec.Codec
+	conn    *byteCounter
+	stats   *statsRegistry
+	known   func(service, method string) bool
+	reading *methodCounters // 最近一次 ReadHeader 所属的方法，ReadBody 的字节同样计入
+	mark    uint64          // 上一次读取结束时 conn.in 的值
+}
+
+func (c *statsCodec) lookup(h *codec.Header) *methodCounters {
+	if h.Service == "" || h.Service == heartbeatService || (c.known != nil && !c.known(h.Service, h.Method)) {
+		return nil
+	}
+	return c.stats.counters(h.Service + "." + h.Method)
+}
+
+func (c *statsCodec) countRead() {
+	now := atomic.LoadUint64(&c.conn.in)
+	if c.reading != nil {
+		atomic.AddUint64(&c.reading.bytesIn, now-c.mark)
+	}
+	c.mark = now
+}
+
+func (c *statsCodec) ReadHeader(h *codec.Header) error {
+	err := c.Codec.ReadHeader(h)
+	c.reading = nil
+	if err == nil {
+		c.reading = c.lookup(h)
+	}
+	c.countRead()
+	return err
+}
+
+func (c *statsCodec) ReadBody(body interface{}) error {
+	err := c.Codec.ReadBody(body)
+	c.countRead()
+	return err
+}
+
+func (c *statsCodec) Write(h *codec.Header, body interface{}) error {
+	before := atomic.LoadUint64(&c.conn.out)
+	err := c.Codec.Write(h, body)
+	if m := c.lookup(h); m != nil {
+		atomic.AddUint64(&m.bytesOut, atomic.LoadUint64(&c.conn.out)-before)
+	}
+	return err
+}
+
+/*
+StatsHandler
+以 Prometheus 文本格式输出 Server.Stats，指标名以 myGoRPC_server_ 开头，标签为 service、method；
+客户端的统计可以用 WriteStatsPrometheus 自行输出
+*/
+func (server *Server) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = WriteStatsPrometheus(w, "myGoRPC_server", server.Stats().Methods)
+	})
+}
+
+// WriteStatsPrometheus 以 Prometheus 文本格式写出 methods，prefix 为指标名前缀
+func WriteStatsPrometheus(w io.Writer, prefix string, methods map[string]MethodStats) error {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make(map[string]string, len(names))
+	for _, name := range names {
+		service, method := name, ""
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			service, method = name[:i], name[i+1:]
+		}
+		labels[name] = fmt.Sprintf(`service="%s",method="%s"`, promEscape(service), promEscape(method))
+	}
+
+	var b strings.Builder
+	counter := func(metric, help string, value func(MethodStats) uint64) {
+		fmt.Fprintf(&b, "# HELP %s_%s %s\n# TYPE %s_%s counter\n", prefix, metric, help, prefix, metric)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s_%s{%s} %d\n", prefix, metric, labels[name], value(methods[name]))
+		}
+	}
+	counter("calls_total", "Calls finished.", func(s MethodStats) uint64 { return s.Calls })
+	counter("errors_total", "Calls finished with an error.", func(s MethodStats) uint64 { return s.Errors })
+	counter("received_bytes_total", "Bytes read for the method.", func(s MethodStats) uint64 { return s.BytesIn })
+	counter("sent_bytes_total", "Bytes written for the method.", func(s MethodStats) uint64 { return s.BytesOut })
+
+	fmt.Fprintf(&b, "# HELP %s_latency_seconds Call latency.\n# TYPE %s_latency_seconds histogram\n", prefix, prefix)
+	for _, name := range names {
+		s := methods[name]
+		var cumulative uint64
+		for i, count := range s.LatencyBuckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(LatencyBounds) {
+				le = fmt.Sprint(LatencyBounds[i].Seconds())
+			}
+			fmt.Fprintf(&b, "%s_latency_seconds_bucket{%s,le=\"%s\"} %d\n", prefix, labels[name], le, cumulative)
+		}
+		fmt.Fprintf(&b, "%s_latency_seconds_sum{%s} %g\n", prefix, labels[name], s.LatencySum.Seconds())
+		fmt.Fprintf(&b, "%s_latency_seconds_count{%s} %d\n", prefix, labels[name], cumulative)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
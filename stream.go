@@ -0,0 +1,495 @@
+package myGoRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"myGoRPC/codec"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+流式调用
+
+一次流式调用是同一连接上、同一 Seq 的一组帧，与普通的请求/回复复用同一个 codec，帧类型见 codec.Header.Frame：
+ 1. 客户端以 Client.Stream 发送 FrameOpen，服务端为其启动对应的 handler：func (t *T) Method(stream *Stream) error
+ 2. 双方以 Send 发送 FrameData，对端以 Recv 读取；客户端 CloseSend 发送 FrameEnd，服务端的 Recv 随后返回 io.EOF
+ 3. handler 返回时服务端发送 FrameEnd（附带 SetTrailer 设置的 trailer），返回错误时发送 FrameError，流结束；
+    客户端的 Recv 依次读完之前的消息后返回 io.EOF 或该错误
+ 4. 客户端 Close 或 ctx 结束时发送 FrameError，服务端 handler 的 ctx 随之结束
+
+FrameData 的 body 类型只有 Recv 的调用方知道，读取协程（客户端的 receive、服务端的 serveCodec）收到后等待 Recv 读取，
+期间同一连接上的其他回复、请求也在等待：接收方应持续 Recv，不再读取时 Close（或结束 ctx、handler 返回），之后的消息被丢弃
+
+流不经过拦截器、过载保护与并发限制，不计入 MaxPending；连接断开时所有流以该错误结束，不参与会话恢复与自动重连
+
+Server.MaxConcurrentStreams 限制每个连接上同时打开的流（与 HTTP/2 的 SETTINGS_MAX_CONCURRENT_STREAMS 相同），
+随握手回复告知客户端：客户端打开的流达到上限时 Client.Stream 直接返回 ErrTooManyStreams，不发送 FrameOpen；
+服务端仍会检查（不遵守上限的客户端、尚未处理的 FrameError 等），超过时以 CodeResourceExhausted 的 FrameError 拒绝
+*/
+
+// 帧类型，见 codec.Header.Frame
+const (
+	FrameUnary = iota // 普通的请求/回复
+	FrameOpen         // 客户端打开流，header 携带 Service、Method、Timeout、Metadata，body 为空
+	FrameData         // 流中的一条消息
+	FrameEnd          // END_STREAM：客户端不再发送；服务端的 handler 正常返回，流结束，Metadata 为 trailer
+	FrameError        // 流异常结束：服务端的 handler 返回错误，或客户端放弃该流，Error 为错误信息
+)
+
+// ErrStreamClosed 在已经 CloseSend、Close 或已经结束的流上发送，或 Close 之后 Recv
+var ErrStreamClosed = errors.New("rpc: stream closed")
+
+// ErrTooManyStreams 连接上打开的流达到 Server.MaxConcurrentStreams
+var ErrTooManyStreams = errors.New("rpc: too many concurrent streams on the connection")
+
+// ErrStreamAborted 连接在流结束之前断开，Recv 不会把断开误报为正常结束的 io.EOF
+var ErrStreamAborted = errors.New("rpc client: stream aborted, connection lost")
+
+var errServerStream = errors.New("rpc server: CloseSend and Close are not used on server streams, return from the handler instead")
+
+/*
+Stream
+双向的流式调用，客户端由 Client.Stream 创建，服务端传给 handler；
+Send、Recv 可以在两个协程中同时调用，但不应有多个协程同时 Send 或同时 Recv
+*/
+type Stream struct {
+	seq    uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 持有发送锁写入一帧
+	write func(header *codec.Header, body interface{}) error
+	// 客户端使用，本端放弃该流：发送 FrameError 并从 client.streams 中移除；服务端为 nil
+	abandon func(err error)
+
+	data       chan streamData
+	recvClosed chan struct{} // 之后不会再有 FrameData
+
+	mu         sync.Mutex
+	recvErr    error // Recv 在 recvClosed 之后返回的错误，对端正常结束时为 io.EOF
+	sendClosed bool
+	err        error // 流结束的原因，nil 即为尚未结束
+	trailer    map[string]string
+}
+
+// streamData 读取协程交给 Recv 的一条消息，Recv 读取 body 后将结果送回 read
+type streamData struct {
+	cc   codec.Codec
+	read chan error
+}
+
+func newStream(ctx context.Context, seq uint64, write func(*codec.Header, interface{}) error) *Stream {
+	s := &Stream{seq: seq, write: write, data: make(chan streamData), recvClosed: make(chan struct{})}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	return s
+}
+
+// Context 客户端为 Client.Stream 的 ctx；服务端携带元数据、trailer 与超时，流结束时结束
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send 发送一条消息；body 无法编码时返回 *codec.EncodeError，流仍然可用
+func (s *Stream) Send(m interface{}) error {
+	s.mu.Lock()
+	closed, err := s.sendClosed, s.err
+	s.mu.Unlock()
+	if closed {
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return ErrStreamClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return fmt.Errorf("rpc: stream send: %w", err)
+	}
+	return s.write(&codec.Header{Seq: s.seq, Frame: FrameData}, m)
+}
+
+// Recv 读取下一条消息写入 m，对端不再发送时返回 io.EOF，流异常结束时返回对应的错误
+func (s *Stream) Recv(m interface{}) error {
+	select {
+	case d := <-s.data:
+		err := d.cc.ReadBody(m)
+		d.read <- err
+		return err
+	case <-s.recvClosed:
+		return s.recvError()
+	case <-s.ctx.Done():
+		// 流结束时 ctx 随之结束，此时优先返回结束的原因
+		if err := s.recvError(); err != nil {
+			return err
+		}
+		return fmt.Errorf("rpc: stream recv: %w", s.ctx.Err())
+	}
+}
+
+// CloseSend 客户端使用，通知服务端不再发送消息，之后仍可 Recv
+func (s *Stream) CloseSend() error {
+	if s.abandon == nil {
+		return errServerStream
+	}
+	s.mu.Lock()
+	if s.sendClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.mu.Unlock()
+	return s.write(&codec.Header{Seq: s.seq, Frame: FrameEnd}, invalidRequest)
+}
+
+// Close 客户端使用，放弃该流：未结束时通知服务端结束 handler，之后的 Recv 返回 ErrStreamClosed
+func (s *Stream) Close() error {
+	if s.abandon == nil {
+		return errServerStream
+	}
+	s.abandon(ErrStreamClosed)
+	return nil
+}
+
+// Trailer 客户端使用，Recv 返回 io.EOF 之后为 handler 设置的 trailer
+func (s *Stream) Trailer() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trailer
+}
+
+func (s *Stream) recvError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recvErr
+}
+
+// closeRecv 之后不会再有消息，Recv 返回 err
+func (s *Stream) closeRecv(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recvErr == nil {
+		s.recvErr = err
+		close(s.recvClosed)
+	}
+}
+
+// end 结束该流，只有第一次调用生效并返回 true
+func (s *Stream) end(err error, trailer map[string]string) bool {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return false
+	}
+	s.err, s.trailer, s.sendClosed = err, trailer, true
+	s.mu.Unlock()
+	s.closeRecv(err)
+	s.cancel()
+	return true
+}
+
+/*
+deliver
+读取协程收到 FrameData 时调用，等待 Recv 读取 body；
+该流不再接收（对端已结束发送、本端已放弃）时丢弃 body。返回读取 body 的错误
+*/
+func (s *Stream) deliver(cc codec.Codec) error {
+	read := make(chan error, 1)
+	select {
+	case s.data <- streamData{cc: cc, read: read}:
+		return <-read
+	case <-s.recvClosed:
+	case <-s.ctx.Done():
+	}
+	return cc.ReadBody(nil)
+}
+
+// ----------------- client --------------
+
+/*
+Stream
+打开一个流式调用，ctx 的 deadline、WithMetadata 设置的元数据随 FrameOpen 发送；ctx 结束时流以对应的错误结束。
+服务端不存在该方法时，之后的 Recv 返回服务端的错误
+*/
+func (client *Client) Stream(ctx context.Context, service, method string) (*Stream, error) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	s, err := client.registerStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := &codec.Header{Service: service, Method: method, Seq: s.seq, Frame: FrameOpen, Metadata: outgoingMetadata(ctx)}
+	if deadline, ok := ctx.Deadline(); ok {
+		if header.Timeout = int64(time.Until(deadline)); header.Timeout <= 0 {
+			header.Timeout = 1
+		}
+	}
+	if err = client.cc.Write(header, invalidRequest); err != nil {
+		client.removeStream(s.seq)
+		s.end(err, nil)
+		return nil, err
+	}
+	go func() {
+		<-s.ctx.Done()
+		s.abandon(fmt.Errorf("rpc client: stream failed: %w", ctx.Err()))
+	}()
+	return s, nil
+}
+
+func (client *Client) registerStream(ctx context.Context) (*Stream, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closing || client.shutdown {
+		return nil, ErrShutdown
+	}
+	if client.reconnectErr != nil {
+		return nil, client.reconnectErr
+	}
+	if client.maxStreams > 0 && len(client.streams) >= client.maxStreams {
+		return nil, ErrTooManyStreams
+	}
+	s := newStream(ctx, client.takeSeq(), func(header *codec.Header, body interface{}) error {
+		client.sending.Lock()
+		defer client.sending.Unlock()
+		return client.cc.Write(header, body)
+	})
+	s.abandon = func(err error) {
+		if client.removeStream(s.seq) != nil && s.end(err, nil) {
+			_ = s.write(&codec.Header{Seq: s.seq, Frame: FrameError, Error: err.Error()}, invalidRequest)
+		}
+	}
+	if client.streams == nil {
+		client.streams = make(map[uint64]*Stream)
+	}
+	client.streams[s.seq] = s
+	return s, nil
+}
+
+func (client *Client) lookupStream(seq uint64) *Stream {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.streams[seq]
+}
+
+func (client *Client) removeStream(seq uint64) *Stream {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	s := client.streams[seq]
+	delete(client.streams, seq)
+	return s
+}
+
+// streamFrame receive 读到属于流 s 的帧，s 为 nil（已经结束的流）时丢弃；返回值与 ReadBody 相同，非 nil 时连接不可用
+func (client *Client) streamFrame(s *Stream, header *codec.Header) error {
+	if s == nil {
+		return client.cc.ReadBody(nil)
+	}
+	if header.Frame == FrameData {
+		err := s.deliver(client.cc)
+		// 只有这一条消息无法解码，已交给 Recv 返回
+		if _, ok := err.(*codec.BodyError); ok {
+			return nil
+		}
+		return err
+	}
+	client.removeStream(header.Seq)
+	switch {
+	case header.Frame == FrameEnd && header.Error == "":
+		s.end(io.EOF, header.Metadata)
+	case header.Error != "":
+		// FrameError，或不支持流的旧服务端回复的普通错误
+		s.end(serverError(header), header.Metadata)
+	default:
+		s.end(fmt.Errorf("rpc client: unexpected frame %d on stream %d", header.Frame, header.Seq), nil)
+	}
+	return client.cc.ReadBody(nil)
+}
+
+// endStreams 连接断开时结束所有流：主动 Close 时为 ErrShutdown，否则为包装了 err 的 ErrStreamAborted
+func (client *Client) endStreams(err error) {
+	if client.isClosing() {
+		err = ErrShutdown
+	} else {
+		err = fmt.Errorf("%w: %v", ErrStreamAborted, err)
+	}
+	client.mu.Lock()
+	streams := client.streams
+	client.streams = nil
+	client.mu.Unlock()
+	for _, s := range streams {
+		s.end(err, nil)
+	}
+}
+
+// ----------------- server --------------
+
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+// streamMethod 服务端注册的 handler：func (t *T) Method(stream *Stream) error
+type streamMethod struct {
+	rcvr reflect.Value
+	fn   reflect.Value
+}
+
+// registerStreams 注册 rcvr 中签名为 func(*Stream) error 的方法
+func (server *Server) registerStreams(name string, rcvr interface{}) {
+	typ := reflect.TypeOf(rcvr)
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		mType := method.Type
+		if mType.NumIn() != 2 || mType.In(1) != typeOfStream || mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+			continue
+		}
+		server.streams.Store(name+"."+method.Name, &streamMethod{rcvr: reflect.ValueOf(rcvr), fn: method.Func})
+		server.logger().Log(LevelDebug, "rpc server: register stream", F("method", name+"."+method.Name))
+	}
+}
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+// serveStream serveCodec 读到流的帧，body 尚未读取；返回非 nil 时连接不可用
+func (server *Server) serveStream(sc *serverConn, h *codec.Header, opt *Option) error {
+	cc := sc.cc
+	if h.Frame == FrameData {
+		s := sc.lookupStream(h.Seq)
+		if s == nil {
+			// 已经结束的流
+			return cc.ReadBody(nil)
+		}
+		err := s.deliver(cc)
+		if _, ok := err.(*codec.BodyError); ok {
+			return nil
+		}
+		return err
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		return err
+	}
+	switch h.Frame {
+	case FrameOpen:
+		server.openStream(sc, h, opt)
+	case FrameEnd:
+		if s := sc.lookupStream(h.Seq); s != nil {
+			s.closeRecv(io.EOF)
+		}
+	case FrameError:
+		if s := sc.lookupStream(h.Seq); s != nil {
+			sc.removeStream(h.Seq)
+			s.end(errors.New(h.Error), nil)
+		}
+	}
+	return nil
+}
+
+func (server *Server) openStream(sc *serverConn, h *codec.Header, opt *Option) {
+	reply := &codec.Header{Seq: h.Seq, Frame: FrameError}
+	mi, ok := server.streams.Load(h.Service + "." + h.Method)
+	if !ok {
+		setError(reply, errors.New("rpc server: can't find stream "+h.Service+"."+h.Method), CodeNotFound)
+		server.sendResponse(sc.cc, reply, invalidRequest, sc.sending)
+		return
+	}
+	// 只有 serveCodec 打开流，检查之后到 addStream 之间流的个数不会增加
+	if max := server.MaxConcurrentStreams; max > 0 && sc.streamCount() >= max {
+		setError(reply, ErrTooManyStreams, CodeResourceExhausted)
+		server.sendResponse(sc.cc, reply, invalidRequest, sc.sending)
+		return
+	}
+	if !sc.admit() {
+		reply.Error = ErrConnQuiescing.Error()
+		server.sendResponse(sc.cc, reply, invalidRequest, sc.sending)
+		return
+	}
+	ctx := context.Background()
+	if len(h.Metadata) > 0 {
+		ctx = context.WithValue(ctx, metadataKey{}, h.Metadata)
+	}
+	ctx, err := server.extract(ctx, h.Metadata)
+	if err != nil {
+		sc.finish()
+		setError(reply, err, errorCode(err))
+		server.sendResponse(sc.cc, reply, invalidRequest, sc.sending)
+		return
+	}
+	tr := new(trailer)
+	ctx = context.WithValue(ctx, trailerKey{}, tr)
+	timeout := opt.HandleTimeout
+	if budget := time.Duration(h.Timeout); budget > 0 && (timeout == 0 || budget < timeout) {
+		timeout = budget
+	}
+	var cancel context.CancelFunc = func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	s := newStream(ctx, h.Seq, func(header *codec.Header, body interface{}) error {
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		return sc.cc.Write(header, body)
+	})
+	sc.addStream(s)
+	go func() {
+		defer sc.finish()
+		defer cancel()
+		err := server.callStream(h.Service+"."+h.Method, mi.(*streamMethod), s)
+		// 先移除，之后到达的消息直接丢弃
+		sc.removeStream(s.seq)
+		s.end(io.EOF, nil)
+		reply.Frame, reply.Metadata = FrameEnd, tr.get()
+		if err != nil {
+			reply.Frame = FrameError
+			setError(reply, err, errorCode(err))
+		}
+		server.sendResponse(sc.cc, reply, invalidRequest, sc.sending)
+	}()
+}
+
+// callStream handler panic 时返回 CodePanic 的错误，不影响其他请求，同 safeCall
+func (server *Server) callStream(name string, m *streamMethod, s *Stream) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = server.recovered(name, r)
+		}
+	}()
+	if errInter := m.fn.Call([]reflect.Value{m.rcvr, reflect.ValueOf(s)})[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
+
+func (sc *serverConn) addStream(s *Stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.streams == nil {
+		sc.streams = make(map[uint64]*Stream)
+	}
+	sc.streams[s.seq] = s
+}
+
+func (sc *serverConn) lookupStream(seq uint64) *Stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[seq]
+}
+
+func (sc *serverConn) streamCount() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.streams)
+}
+
+func (sc *serverConn) removeStream(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.streams, seq)
+}
+
+// endStreams 连接断开时结束所有流，handler 的 ctx 随之结束
+func (sc *serverConn) endStreams() {
+	sc.mu.Lock()
+	streams := sc.streams
+	sc.streams = nil
+	sc.mu.Unlock()
+	for _, s := range streams {
+		s.end(errors.New("rpc server: connection closed"), nil)
+	}
+}
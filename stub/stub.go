@@ -0,0 +1,40 @@
+package stub
+
+import (
+	"context"
+	"myGoRPC"
+)
+
+/*
+stub
+mygorpc-gen 生成的强类型客户端所依赖的运行时支持
+
+生成的 XxxClient 嵌入 Client，每个方法对应一个以 ctx 调用的同步方法与一个以 Client.Go 发起的异步方法：
+
+	arith := NewArithClient(client)
+	reply, err := arith.Mul(ctx, &Args{A: 2, B: 3})
+
+Conn 可以是 *myGoRPC.Client，也可以是 *xclient.XClient
+*/
+
+// Conn 生成的客户端发起调用所需的方法
+type Conn interface {
+	Call(ctx context.Context, service, method string, args, reply interface{}) error
+	Go(service, method string, args, reply interface{}, done chan *myGoRPC.Call) *myGoRPC.Call
+}
+
+// Client 生成的客户端的公共部分，Service 默认为类型名，以 Server.RegisterName 注册的服务需要修改
+type Client struct {
+	Conn    Conn
+	Service string
+}
+
+// Call 同步调用 Service.method，ctx 的 deadline 与元数据随请求发送
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	return c.Conn.Call(ctx, c.Service, method, args, reply)
+}
+
+// Go 异步调用 Service.method，见 myGoRPC.Client.Go
+func (c *Client) Go(method string, args, reply interface{}, done chan *myGoRPC.Call) *myGoRPC.Call {
+	return c.Conn.Go(c.Service, method, args, reply, done)
+}
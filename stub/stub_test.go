@@ -0,0 +1,41 @@
+package stub
+
+import (
+	"context"
+	"myGoRPC"
+	"net"
+	"testing"
+)
+
+type Arith int
+
+type Args struct{ A, B int }
+
+func (a *Arith) Mul(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	server := myGoRPC.NewServer()
+	if err := server.RegisterName("Calc", new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	conn, err := myGoRPC.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	c := &Client{Conn: conn, Service: "Calc"}
+	var reply int
+	if err := c.Call(context.Background(), "Mul", &Args{A: 2, B: 3}, &reply); err != nil || reply != 6 {
+		t.Fatalf("Call: got %d, %v", reply, err)
+	}
+	call := <-c.Go("Mul", &Args{A: 4, B: 5}, &reply, nil).Done
+	if call.Error != nil || reply != 20 {
+		t.Fatalf("Go: got %d, %v", reply, call.Error)
+	}
+}
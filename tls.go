@@ -0,0 +1,82 @@
+package myGoRPC
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+)
+
+/*
+DialTLS
+先在连接上完成 TLS 握手，再进行 Option 握手，之后的请求与响应都经过 TLS 加密
+与 Option.StartTLS 不同，连接从第一个字节起就是 TLS，服务端需使用 AcceptTLS（或自行 tls.NewListener）接受连接
+
+config.ServerName 为空时取 address 中的主机名；握手失败（证书校验失败、协议不匹配等）返回错误并关闭连接
+ConnectTimeout 同时限制 TCP 连接、TLS 握手与 Option 握手的总时长
+*/
+func DialTLS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	if config == nil {
+		return nil, errors.New("rpc client: DialTLS requires a tls config")
+	}
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn, err := tlsHandshake(conn, config)
+		if err != nil {
+			return nil, err
+		}
+		o := *opt
+		o.dialTLS = config
+		return NewClient(tlsConn, &o)
+	}, network, address, opts...)
+}
+
+// tlsHandshake 以客户端身份完成 TLS 握手，失败时关闭连接
+func tlsHandshake(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("rpc client: tls handshake: %w", err)
+	}
+	return tlsConn, nil
+}
+
+/*
+AcceptTLS
+与 Accept 相同，但接受的每个连接都包装为 TLS 服务端连接，由 ServeConn 在 Option 握手之前完成 TLS 握手
+*/
+func (server *Server) AcceptTLS(listen net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(listen, config))
+}
+
+/*
+ServeTLS
+与 AcceptTLS 相同，TLS 配置以 Server.TLSConfig 为基础（为 nil 时使用空配置），certFile、keyFile 非空时从文件加载服务端证书；
+Server.TLSConfig 中的 ClientAuth、ClientCAs 即为校验客户端证书的选项（双向 TLS），同样作用于 Option.StartTLS 的连接
+只有证书无法加载或没有证书时返回错误，否则阻塞直到 l 关闭，与 Accept 相同
+*/
+func (server *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if server.TLSConfig != nil {
+		config = server.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("rpc server: load tls certificate: %w", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return errors.New("rpc server: ServeTLS requires a certificate")
+	}
+	server.AcceptTLS(l, config)
+	return nil
+}
@@ -0,0 +1,195 @@
+package myGoRPC
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+链路追踪
+
+TracingInterceptor（客户端）为每次 Client.Call 开始一个 client 跨度，并将追踪上下文注入请求的元数据；
+TracingServerInterceptor（服务端，Server.Use）从元数据中提取追踪上下文，开始一个以客户端跨度为父跨度的 server 跨度，
+方法收到的 ctx 携带该跨度，方法内以该 ctx 发起的下游调用继续同一条链路
+
+不依赖任何追踪库：Tracer 接口很小，NewTracer 提供一个按 W3C Trace Context（traceparent）传播的实现；
+使用 OpenTelemetry 时实现一个适配器即可，不使用的程序不会引入该依赖：
+
+	type otelTracer struct{ t trace.Tracer }
+
+	func (o otelTracer) Start(ctx context.Context, name string, kind myGoRPC.SpanKind) (context.Context, myGoRPC.Span) {
+		k := trace.SpanKindClient
+		if kind == myGoRPC.SpanServer {
+			k = trace.SpanKindServer
+		}
+		ctx, span := o.t.Start(ctx, name, trace.WithSpanKind(k))
+		return ctx, otelSpan{span}
+	}
+
+	func (o otelTracer) Inject(ctx context.Context, md map[string]string) {
+		propagation.TraceContext{}.Inject(ctx, propagation.MapCarrier(md))
+	}
+
+	func (o otelTracer) Extract(ctx context.Context, md map[string]string) context.Context {
+		return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier(md))
+	}
+
+	type otelSpan struct{ trace.Span }
+
+	func (s otelSpan) End(err error) {
+		if err != nil {
+			s.RecordError(err)
+			s.SetStatus(codes.Error, err.Error())
+		}
+		s.Span.End()
+	}
+
+Go 发起的异步请求不经过拦截器，不会被追踪；NewTracer 的跨度可以用 ChromeTrace 导出为火焰图，见 chrometrace.go
+*/
+
+// SpanKind 跨度的类型
+type SpanKind int
+
+const (
+	SpanClient SpanKind = iota
+	SpanServer
+)
+
+// Span 一个进行中的跨度，调用结束时 End 一次，err 为调用的错误
+type Span interface {
+	End(err error)
+}
+
+/*
+Tracer
+Start 开始一个跨度，返回携带该跨度的 ctx；ctx 中已有跨度（或 Extract 得到的远端追踪上下文）时作为父跨度
+Inject 将 ctx 的追踪上下文写入请求的元数据，Extract 从元数据中取出追踪上下文放入 ctx
+*/
+type Tracer interface {
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+	Inject(ctx context.Context, md map[string]string)
+	Extract(ctx context.Context, md map[string]string) context.Context
+}
+
+// TracingInterceptor 客户端拦截器，为每次 Client.Call 开始一个 client 跨度，名称为 "Service.Method"
+func TracingInterceptor(tracer Tracer) Interceptor {
+	return func(ctx context.Context, call *Call, next Invoker) error {
+		ctx, span := tracer.Start(ctx, call.Service+"."+call.Method, SpanClient)
+		if call.Metadata == nil {
+			call.Metadata = make(map[string]string)
+		}
+		tracer.Inject(ctx, call.Metadata)
+		err := next(ctx, call)
+		span.End(err)
+		return err
+	}
+}
+
+// TracingServerInterceptor 服务端拦截器，为每次方法调用开始一个 server 跨度，父跨度来自请求元数据中的追踪上下文
+func TracingServerInterceptor(tracer Tracer) ServerInterceptor {
+	return func(ctx context.Context, info ServiceMethodInfo, handler Handler) error {
+		ctx = tracer.Extract(ctx, MetadataFromContext(ctx))
+		ctx, span := tracer.Start(ctx, info.Service+"."+info.Method, SpanServer)
+		err := handler(ctx, info)
+		span.End(err)
+		return err
+	}
+}
+
+// TraceParentKey W3C Trace Context 在元数据中的键
+const TraceParentKey = "traceparent"
+
+// SpanData NewTracer 的跨度结束后交给 export 的数据，ID 均为十六进制，根跨度的 ParentID 为空
+type SpanData struct {
+	Name     string
+	Kind     SpanKind
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+/*
+NewTracer
+按 W3C Trace Context 传播的 Tracer，跨度结束时同步调用 export（可以为 nil），export 可能被并发调用
+只传播 traceparent，不支持 tracestate；收到的 traceparent 无法解析时开始新的链路
+*/
+func NewTracer(export func(SpanData)) Tracer {
+	return w3cTracer{export: export}
+}
+
+type w3cTracer struct {
+	export func(SpanData)
+}
+
+type spanContextKey struct{}
+
+// spanContext 追踪上下文，十六进制的 trace-id（32 位）与 parent-id（16 位）
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type w3cSpan struct {
+	tracer w3cTracer
+	data   SpanData
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (t w3cTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	span := &w3cSpan{tracer: t, data: SpanData{Name: name, Kind: kind, SpanID: randomHex(8), Start: time.Now()}}
+	if parent, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		span.data.TraceID, span.data.ParentID = parent.traceID, parent.spanID
+	} else {
+		span.data.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, spanContext{traceID: span.data.TraceID, spanID: span.data.SpanID}), span
+}
+
+func (t w3cTracer) Inject(ctx context.Context, md map[string]string) {
+	if sc, ok := ctx.Value(spanContextKey{}).(spanContext); ok {
+		md[TraceParentKey] = fmt.Sprintf("00-%s-%s-01", sc.traceID, sc.spanID)
+	}
+}
+
+func (t w3cTracer) Extract(ctx context.Context, md map[string]string) context.Context {
+	if sc, ok := parseTraceParent(md[TraceParentKey]); ok {
+		return context.WithValue(ctx, spanContextKey{}, sc)
+	}
+	return ctx
+}
+
+// parseTraceParent 解析 "version-traceid-parentid-flags"，全零的 ID 无效
+func parseTraceParent(s string) (spanContext, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return spanContext{}, false
+	}
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || p != strings.ToLower(p) {
+			return spanContext{}, false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return spanContext{}, false
+	}
+	return spanContext{traceID: parts[1], spanID: parts[2]}, true
+}
+
+func (s *w3cSpan) End(err error) {
+	s.data.Duration, s.data.Err = time.Since(s.data.Start), err
+	if s.tracer.export != nil {
+		s.tracer.export(s.data)
+	}
+}
@@ -0,0 +1,84 @@
+package myGoRPC
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+/*
+传输方式
+
+除 TCP 之外：
+ 1. unix socket：Dial("unix", path)、XDial("unix@"+path)，服务端使用 ListenAndServe("unix", path)
+ 2. 进程内：NewInProcClient(server) 以 net.Pipe 连接同一进程中的 server，用于测试或同进程的组件之间，
+    不经过网络栈，但与 TCP 一样完成握手、使用相同的编解码器；Option.Reconnect、ResumeTimeout 重新拨号时建立新的内存连接
+
+net.Pipe 没有缓冲，一端写入时阻塞直到另一端读出，对调用方没有可见的差别
+*/
+
+/*
+ListenAndServe
+在 address 上监听并 Accept，Shutdown、Close 之前一直阻塞，只有监听失败时返回错误
+network 为 unix 时，address 上残留的 socket 文件（进程异常退出后没有删除）没有进程监听时先删除，正在使用时返回错误；
+监听关闭时删除 socket 文件
+*/
+func (server *Server) ListenAndServe(network, address string) error {
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("rpc server: listen: %w", err)
+	}
+	server.Accept(l)
+	return nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		// 不存在，或者不是 socket 文件，交给 Listen 报错
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return errors.New("rpc server: unix socket is in use: " + path)
+	}
+	return os.Remove(path)
+}
+
+/*
+NewInProcClient
+以内存连接（net.Pipe）连接 server，server 无需监听；ConnectTimeout 限制握手的时长
+*/
+func NewInProcClient(server *Server, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	o := *opt
+	o.dialConn = func(time.Duration) (net.Conn, error) { return server.pipe(), nil }
+	conn := server.pipe()
+	if o.ConnectTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(o.ConnectTimeout))
+	}
+	client, err := NewClient(conn, &o)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return client, nil
+}
+
+// pipe 由 server 处理另一端的内存连接
+func (server *Server) pipe() net.Conn {
+	c, s := net.Pipe()
+	go server.ServeConn(s)
+	return c
+}
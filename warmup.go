@@ -0,0 +1,73 @@
+package myGoRPC
+
+import (
+	"bytes"
+	"fmt"
+	"myGoRPC/codec"
+	"myGoRPC/service"
+	"reflect"
+)
+
+/*
+Warmup
+启动后、开始 Accept 之前调用：对已注册的每个方法，用每种编解码方式把入参、返回值的零值
+在内存中编码再解码一遍，预先完成反射的类型分析，避免每个方法的第一次调用出现延迟尖刺
+
+gob 的类型信息、编码引擎以及 encoding/json 的编解码函数都是进程内全局缓存的，预热后对所有连接生效；
+gob 的解码引擎按 Decoder 缓存，每个连接第一次解码某个类型时仍会编译一次，这部分开销较小
+
+返回第一个失败的方法的错误，其余方法仍会预热
+*/
+func (server *Server) Warmup() error {
+	types := supportedCodecs()
+	var first error
+	server.ServiceMap.Range(func(_, svci interface{}) bool {
+		svc := svci.(*service.Service)
+		for name, mtype := range svc.Method {
+			for _, t := range types {
+				err := warmupMethod(codec.Get(codec.Type(t)), mtype)
+				if err != nil && first == nil {
+					first = fmt.Errorf("rpc server: warmup %s.%s with %s: %v", svc.Name, name, t, err)
+				}
+			}
+		}
+		return true
+	})
+	return first
+}
+
+// loopbackConn 写入的数据随后由同一个连接读出
+type loopbackConn struct {
+	bytes.Buffer
+}
+
+func (c *loopbackConn) Close() error {
+	return nil
+}
+
+// warmupMethod 模拟一次请求与回复：编码、解码入参，再编码、解码返回值
+func warmupMethod(f codec.NewCodecFunc, mtype *service.MethodType) error {
+	cc := f(new(loopbackConn))
+	argv, replyv := mtype.NewArgv(), mtype.NewReplyv()
+	argvi := argv.Interface()
+	if argv.Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	for _, body := range []interface{}{argvi, replyv.Interface()} {
+		var header codec.Header
+		if err := cc.Write(&header, body); err != nil {
+			// 该编解码方式不支持这个类型（如 protobuf 之于非 protobuf 消息），该方法不会以这种方式调用
+			if _, unsupported := err.(*codec.EncodeError); unsupported {
+				return nil
+			}
+			return err
+		}
+		if err := cc.ReadHeader(&header); err != nil {
+			return err
+		}
+		if err := cc.ReadBody(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
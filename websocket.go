@@ -0,0 +1,343 @@
+package myGoRPC
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+WebSocket 传输
+
+RPC 连接承载在 WebSocket（RFC 6455）之上，可以穿过只转发 HTTP 的负载均衡、代理，之后也可以服务浏览器中的 JS 客户端：
+ 1. 服务端：HandleWebsocket(path) 在 http.DefaultServeMux 上注册 WebsocketHTTP，也可以挂载到任意 mux
+ 2. 客户端：DialWebsocket("ws://host:port/path")，wss 使用 Option.TLSConfig（为 nil 时使用默认配置）
+
+升级之后与 TCP 相同：先完成握手（见 handshake.go），再交换编解码器的消息；
+连接上的每次写入（编解码器每条消息 flush 一次）作为一条 binary 帧发送，接收方按字节流读取，不依赖帧的边界。
+不支持 text 帧与扩展（如 permessage-deflate），需要压缩时使用 Option.Compression；
+自动回复 ping，Option.Reconnect、ResumeTimeout 重新拨号时重新升级
+*/
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWebsocketText = errors.New("rpc: websocket text frames are not supported")
+
+// wsConn WebSocket 连接，Read、Write 只处理 binary 帧的数据
+type wsConn struct {
+	net.Conn
+	r      *bufio.Reader
+	client bool // 客户端发送的帧需要掩码，服务端发送的帧不能有掩码
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+
+	remaining int64 // 当前帧尚未读取的数据
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+}
+
+func newWSConn(conn net.Conn, r *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, r: r, client: client}
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, unexpectedEOF(err)
+}
+
+// nextFrame 读取下一个数据帧的头部，处理其间的控制帧；对端关闭时返回 io.EOF
+func (c *wsConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	opcode, masked := head[0]&0x0f, head[1]&0x80 != 0
+	if masked == c.client {
+		return errors.New("rpc: websocket frame masking violates the protocol")
+	}
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		if length = int64(binary.BigEndian.Uint64(ext[:])); length < 0 {
+			return errors.New("rpc: websocket frame too large")
+		}
+	}
+	c.masked, c.maskPos = masked, 0
+	if masked {
+		if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	switch opcode {
+	case wsContinuation, wsBinary:
+		c.remaining = length
+		return nil
+	case wsText:
+		return errWebsocketText
+	case wsClose, wsPing, wsPong:
+		if length > 125 {
+			return errors.New("rpc: websocket control frame too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return unexpectedEOF(err)
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= c.mask[i&3]
+			}
+		}
+		switch opcode {
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		case wsClose:
+			// 回复关闭帧，之后连接不再可用
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(wsClose, payload)
+			return io.EOF
+		}
+		return nil
+	}
+	return fmt.Errorf("rpc: unknown websocket opcode %d", opcode)
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, p []byte) error {
+	b := make([]byte, 0, 14+len(p))
+	b = append(b, 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(p); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xffff:
+		b = append(b, maskBit|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		b = append(append(b, maskBit|127), ext[:]...)
+	}
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		b = append(b, key[:]...)
+		start := len(b)
+		b = append(b, p...)
+		for i := range p {
+			b[start+i] ^= key[i&3]
+		}
+	} else {
+		b = append(b, p...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// Close 发送关闭帧后关闭连接；另一个写入阻塞时不再等待，直接关闭
+func (c *wsConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.wmu.TryLock() {
+			c.wmu.Unlock()
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_ = c.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000，正常关闭
+		}
+	})
+	return c.Conn.Close()
+}
+
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains 逗号分隔的 header 中是否有 token，不区分大小写
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ----------------- server --------------
+
+// WebsocketHTTP 将 WebSocket 升级请求交给 Server 处理的 http.Handler
+type WebsocketHTTP struct {
+	*Server
+}
+
+// HandleWebsocket 在 http.DefaultServeMux 的 path 上接受 WebSocket 连接
+func (server *Server) HandleWebsocket(path string) {
+	http.Handle(path, WebsocketHTTP{server})
+	server.logger().Log(LevelDebug, "rpc server: websocket path", F("path", path))
+}
+
+func (h WebsocketHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet:
+		http.Error(w, "405 must GET", http.StatusMethodNotAllowed)
+		return
+	case !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") || key == "":
+		http.Error(w, "400 expect a websocket upgrade", http.StatusBadRequest)
+		return
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "426 unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		h.Server.logger().Log(LevelError, "rpc server: websocket hijacking error", F("remote", req.RemoteAddr), F("err", err))
+		return
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+websocketAccept(key)+"\r\n\r\n")
+	h.Server.ServeConn(newWSConn(conn, rw.Reader, false))
+}
+
+// ----------------- client --------------
+
+/*
+DialWebsocket
+连接 ws:// 或 wss:// 的地址，完成 WebSocket 升级后与 Dial 相同；
+ConnectTimeout 限制 TCP 连接、TLS 握手、升级与 Option 握手的总时长
+*/
+func DialWebsocket(rawURL string, opts ...*Option) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: websocket url: %w", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, errors.New("rpc client: websocket url must be ws:// or wss://, got " + rawURL)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	return dialTimeout(func(conn net.Conn, opt *Option) (*Client, error) {
+		ws, err := websocketUpgrade(conn, u, opt.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		o := *opt
+		o.dialConn = func(timeout time.Duration) (net.Conn, error) {
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				return nil, err
+			}
+			if timeout > 0 {
+				_ = conn.SetDeadline(time.Now().Add(timeout))
+			}
+			return websocketUpgrade(conn, u, opt.TLSConfig)
+		}
+		return NewClient(ws, &o)
+	}, "tcp", address, opts...)
+}
+
+// websocketUpgrade 以客户端身份完成 TLS 握手（wss）与 WebSocket 升级，失败时关闭连接
+func websocketUpgrade(conn net.Conn, u *url.URL, config *tls.Config) (net.Conn, error) {
+	if u.Scheme == "wss" {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" && !config.InsecureSkipVerify {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		var err error
+		if conn, err = tlsHandshake(conn, config); err != nil {
+			return nil, err
+		}
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	path := u.RequestURI()
+	_, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+u.Host+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err == nil {
+		r := bufio.NewReader(conn)
+		var resp *http.Response
+		if resp, err = http.ReadResponse(r, &http.Request{Method: http.MethodGet}); err == nil {
+			if resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Accept") == websocketAccept(key) {
+				return newWSConn(conn, r, true), nil
+			}
+			err = errors.New("rpc client: websocket upgrade failed: " + resp.Status)
+		}
+	}
+	_ = conn.Close()
+	return nil, err
+}
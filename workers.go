@@ -0,0 +1,77 @@
+package myGoRPC
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+服务端整体的并发上限
+
+Server.MaxConcurrentRequests 限制全部连接同时处理的请求数，每个请求占用一个名额，方法返回后归还
+（HandleTimeout 超时回复后方法仍在执行，名额在方法真正返回后才归还）
+
+达到上限后按 Server.BusyPolicy 处理：
+  - BusyQueue（默认）：读取该请求的连接停止读取，等待名额；等待的请求不另起协程，
+    每个连接至多一个请求在等待，其余请求留在连接的缓冲区与 TCP 窗口中，由传输层对客户端形成背压。
+    正在等待的请求超过 MaxQueuedRequests（0 即为不限制）、或等待超过请求的时间预算时，回复 ErrServerBusy
+  - BusyReject：立即回复 ErrServerBusy，连接继续读取之后的请求
+
+与 ShedPolicy.MaxInflight、LimitConcurrency 相互独立，可以同时使用；需在开始接受连接之前设置
+*/
+
+// BusyPolicy 达到 Server.MaxConcurrentRequests 后的处理方式
+type BusyPolicy int
+
+const (
+	BusyQueue BusyPolicy = iota
+	BusyReject
+)
+
+func (server *Server) workerSlots() chan struct{} {
+	server.workersOnce.Do(func() {
+		if server.MaxConcurrentRequests > 0 {
+			server.workers = make(chan struct{}, server.MaxConcurrentRequests)
+		}
+	})
+	return server.workers
+}
+
+// acquireWorker 为请求取得一个名额，返回 false 时应回复 ErrServerBusy
+func (server *Server) acquireWorker(req *request) bool {
+	slots := server.workerSlots()
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if server.BusyPolicy == BusyReject {
+		return false
+	}
+	queued := atomic.AddInt64(&server.queued, 1)
+	defer atomic.AddInt64(&server.queued, -1)
+	if max := server.MaxQueuedRequests; max > 0 && queued > int64(max) {
+		return false
+	}
+	var expired <-chan time.Time
+	if budget := time.Duration(req.header.Timeout); budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-expired:
+		return false
+	}
+}
+
+func (server *Server) releaseWorker() {
+	if slots := server.workerSlots(); slots != nil {
+		<-slots
+	}
+}
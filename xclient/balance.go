@@ -0,0 +1,141 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"myGoRPC"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+由 XClient 而不是 Discovery 完成选择的负载均衡模式，需要请求或连接的信息：
+
+ConsistentHashSelect: 一致性哈希，按 WithHashKey 设置的键选择实例，同一个键总是落在同一个实例上，
+实例增减时只有少部分键改变归属；没有设置键时退化为 RoundRobinSelect
+LeastPendingSelect: 选择未结束请求最少的实例，尚未建立连接的实例计为 0，数量相同时轮流选择
+LeastLoadSelect: 在 LeastPendingSelect 的基础上计入服务端报告的负载（需要服务端开启 Server.ReportLoad，见 myGoRPC.LoadReport），
+实例的负载为 (1 + 未结束的请求数 + 报告的 inflight + queued) * (1 + util) - 1，选择负载最小的实例；
+超过 loadReportTTL 没有更新的报告不再计入，没有报告的实例只按未结束的请求数计算
+*/
+const (
+	ConsistentHashSelect SelectMode = iota + 100
+	LeastPendingSelect
+	LeastLoadSelect
+)
+
+// loadReportTTL 负载报告的有效期，流量转移到其他实例后，旧的报告不会让该实例一直得不到请求
+const loadReportTTL = 5 * time.Second
+
+// hashReplicas 一致性哈希中每个实例的虚拟节点数
+const hashReplicas = 64
+
+type hashKey struct{}
+
+// WithHashKey 设置 ConsistentHashSelect 使用的请求键，如用户 ID
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// hashRing 按实例列表构建的哈希环，列表不变时复用
+type hashRing struct {
+	servers string // 排序后拼接的实例列表
+	hashes  []uint32
+	owners  map[uint32]string
+}
+
+// newHashRing sorted 为排序后的实例列表
+func newHashRing(sorted []string) *hashRing {
+	ring := &hashRing{servers: strings.Join(sorted, ","), owners: make(map[uint32]string)}
+	for _, server := range sorted {
+		for i := 0; i < hashReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + server))
+			ring.hashes = append(ring.hashes, h)
+			ring.owners[h] = server
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+func (r *hashRing) get(key string) string {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	return r.owners[r.hashes[i%len(r.hashes)]]
+}
+
+/*
+selectServer
+ConsistentHashSelect、LeastPendingSelect 由 XClient 从 GetAll 的结果中选择，其他模式交给 Discovery.Get
+*/
+func (xc *XClient) selectServer(ctx context.Context) (string, error) {
+	switch xc.mode {
+	case ConsistentHashSelect:
+		key, ok := ctx.Value(hashKey{}).(string)
+		if !ok {
+			return xc.d.Get(RoundRobinSelect)
+		}
+		servers, err := xc.d.GetAll()
+		if err != nil {
+			return "", err
+		}
+		if len(servers) == 0 {
+			return "", errors.New("rpc discovery: no available servers")
+		}
+		sorted := append([]string(nil), servers...)
+		sort.Strings(sorted)
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		if xc.ring == nil || xc.ring.servers != strings.Join(sorted, ",") {
+			xc.ring = newHashRing(sorted)
+		}
+		return xc.ring.get(key), nil
+	case LeastPendingSelect:
+		return xc.selectLeast(func(client *myGoRPC.Client) float64 {
+			return float64(client.PendingCount())
+		})
+	case LeastLoadSelect:
+		return xc.selectLeast(loadScore)
+	default:
+		return xc.d.Get(xc.mode)
+	}
+}
+
+// selectLeast 选择 score 最小的实例，尚未建立连接的实例计为 0，相同时轮流选择
+func (xc *XClient) selectLeast(score func(client *myGoRPC.Client) float64) (string, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errors.New("rpc discovery: no available servers")
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.next++
+	best, least := "", -1.0
+	for i := range servers {
+		server := servers[(xc.next+i)%len(servers)]
+		s := 0.0
+		if client, ok := xc.clients[server]; ok {
+			s = score(client)
+		}
+		if least < 0 || s < least {
+			best, least = server, s
+		}
+	}
+	return best, nil
+}
+
+// loadScore LeastLoadSelect 的负载，见 LeastLoadSelect；尚未建立连接的实例为 0，与没有报告、没有请求的实例相同
+func loadScore(client *myGoRPC.Client) float64 {
+	pending := float64(client.PendingCount())
+	report, at := client.LoadReport()
+	if at.IsZero() || time.Since(at) > loadReportTTL {
+		return pending
+	}
+	return (1+pending+float64(report.Inflight+report.Queued))*(1+report.Utilization) - 1
+}
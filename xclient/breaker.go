@@ -0,0 +1,262 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myGoRPC"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 服务实例的熔断器打开，调用直接失败，没有发出请求
+var ErrCircuitOpen = errors.New("rpc xclient: circuit open")
+
+/*
+BreakerPolicy
+按服务实例熔断：窗口内的请求数不少于 MinRequests、且失败率达到 ErrorRate 时打开，之后的调用直接返回 ErrCircuitOpen；
+经过 OpenTimeout 后半开，放行 HalfOpenProbes 个探测调用，全部成功则关闭，任何一个失败则重新打开
+
+失败指连接失败、超时、服务端过载等实例本身的问题；方法返回的错误（CodeHandler）、
+服务或方法不存在、参数错误的调用不计为失败；被取消的调用（调用方取消、Broadcast 与对冲中落败的调用）不计入统计，
+半开时归还其占用的探测名额。
+选择实例时跳过熔断中的实例，全部熔断时返回 ErrCircuitOpen；Broadcast 中熔断的实例以 ErrCircuitOpen 记入 BroadcastError
+
+零值的字段使用默认值：Window 10s，MinRequests 10，ErrorRate 0.5，OpenTimeout 5s，HalfOpenProbes 1
+*/
+type BreakerPolicy struct {
+	Window         time.Duration
+	MinRequests    int
+	ErrorRate      float64
+	OpenTimeout    time.Duration
+	HalfOpenProbes int
+}
+
+func (p BreakerPolicy) withDefaults() BreakerPolicy {
+	if p.Window <= 0 {
+		p.Window = 10 * time.Second
+	}
+	if p.MinRequests <= 0 {
+		p.MinRequests = 10
+	}
+	if p.ErrorRate <= 0 {
+		p.ErrorRate = 0.5
+	}
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = 5 * time.Second
+	}
+	if p.HalfOpenProbes <= 0 {
+		p.HalfOpenProbes = 1
+	}
+	return p
+}
+
+// BreakerState 熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// breaker 一个服务实例的熔断器，窗口为固定窗口，到期后清零
+type breaker struct {
+	policy BreakerPolicy
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // 半开后放行的探测调用数
+	successes   int // 半开后成功的调用数
+}
+
+// ready 是否可以选择该实例，不占用探测名额
+func (b *breaker) ready(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return now.Sub(b.openedAt) >= b.policy.OpenTimeout
+	case BreakerHalfOpen:
+		return b.probes < b.policy.HalfOpenProbes
+	}
+	return true
+}
+
+// allow 是否放行一个调用，半开时占用一个探测名额
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.state, b.probes, b.successes = BreakerHalfOpen, 0, 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.policy.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *breaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.state, b.openedAt = BreakerOpen, now
+			return
+		}
+		if b.successes++; b.successes >= b.policy.HalfOpenProbes {
+			b.state, b.windowStart, b.requests, b.failures = BreakerClosed, now, 0, 0
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.policy.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.policy.MinRequests && float64(b.failures) >= b.policy.ErrorRate*float64(b.requests) {
+			b.state, b.openedAt = BreakerOpen, now
+		}
+	}
+}
+
+// release 被取消的调用不说明实例的状况，半开时归还其探测名额
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// breakerFailure err 是否说明实例本身有问题
+func breakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch myGoRPC.Code(err) {
+	case myGoRPC.CodeHandler, myGoRPC.CodeNotFound, myGoRPC.CodeBadArgument:
+		return false
+	}
+	return true
+}
+
+// SetBreakerPolicy 为每个服务实例启用熔断，nil 即为关闭；应在发起调用之前设置
+func (xc *XClient) SetBreakerPolicy(policy *BreakerPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.breakerPolicy = policy
+	xc.breakers = make(map[string]*breaker)
+}
+
+// BreakerState rpcAddr 的熔断器状态，没有启用熔断时为 BreakerClosed
+func (xc *XClient) BreakerState(rpcAddr string) BreakerState {
+	b := xc.breaker(rpcAddr)
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.policy.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// breaker rpcAddr 的熔断器，没有启用熔断时为 nil
+func (xc *XClient) breaker(rpcAddr string) *breaker {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.breakerPolicy == nil {
+		return nil
+	}
+	b := xc.breakers[rpcAddr]
+	if b == nil {
+		b = &breaker{policy: xc.breakerPolicy.withDefaults(), windowStart: time.Now()}
+		xc.breakers[rpcAddr] = b
+	}
+	return b
+}
+
+// allow 放行发往 rpcAddr 的调用，熔断时返回包装了 ErrCircuitOpen 的错误
+func (xc *XClient) allow(rpcAddr string) error {
+	if b := xc.breaker(rpcAddr); b != nil && !b.allow(time.Now()) {
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, rpcAddr)
+	}
+	return nil
+}
+
+func (xc *XClient) record(rpcAddr string, err error) {
+	b := xc.breaker(rpcAddr)
+	switch {
+	case b == nil:
+	case errors.Is(err, context.Canceled):
+		b.release()
+	default:
+		b.record(breakerFailure(err), time.Now())
+	}
+}
+
+// ready 选择实例时是否可以选择 rpcAddr
+func (xc *XClient) ready(rpcAddr string) bool {
+	b := xc.breaker(rpcAddr)
+	return b == nil || b.ready(time.Now())
+}
+
+// selectReady 按负载均衡策略选择实例，选中的实例熔断时依次改选其他未熔断的实例
+func (xc *XClient) selectReady(ctx context.Context) (string, error) {
+	rpcAddr, err := xc.selectServer(ctx)
+	if err != nil || xc.ready(rpcAddr) {
+		return rpcAddr, err
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	for _, server := range servers {
+		if server != rpcAddr && xc.ready(server) {
+			return server, nil
+		}
+	}
+	return "", fmt.Errorf("%w: all servers", ErrCircuitOpen)
+}
+
+// breakerOption 在 opt 的 OnFinish 中记录发往 rpcAddr 的调用的结果
+func (xc *XClient) breakerOption(rpcAddr string, opt *myGoRPC.Option) *myGoRPC.Option {
+	var o myGoRPC.Option
+	if opt != nil {
+		o = *opt
+	} else {
+		o = *myGoRPC.DefaultOption
+	}
+	onFinish := o.OnFinish
+	o.OnFinish = func(call *myGoRPC.Call, latency time.Duration) {
+		xc.record(rpcAddr, call.Error)
+		if onFinish != nil {
+			onFinish(call, latency)
+		}
+	}
+	return &o
+}
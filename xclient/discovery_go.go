@@ -1,7 +1,7 @@
 package xclient
 
 import (
-	"log"
+	"myGoRPC"
 	"net/http"
 	"strings"
 	"time"
@@ -13,12 +13,14 @@ GoRegistryDiscovery
 registry 注册中心的地址
 timeout 服务列表的过期时间
 lastUpdate 是代表最后从注册中心更新服务列表的时间，默认 10s 过期，即 10s 之后，需要从注册中心更新新的列表
+Logger 输出刷新的日志，nil 即为 myGoRPC.DefaultLogger()
 */
 type GoRegistryDiscovery struct {
 	*MultiServerDiscovery
 	registry   string
 	timeout    time.Duration
 	lastUpdate time.Time
+	Logger     myGoRPC.Logger
 }
 
 const defaultUpdateTimeout = time.Second * 10
@@ -48,10 +50,10 @@ func (d *GoRegistryDiscovery) Refresh() error {
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
 		return nil
 	}
-	log.Println("rpc registry: refresh servers from registry ", d.registry)
+	d.logger().Log(myGoRPC.LevelDebug, "rpc registry: refresh servers", myGoRPC.F("registry", d.registry))
 	resp, err := http.Get(d.registry)
 	if err != nil {
-		log.Println("rpc registry refresh err: ", err)
+		d.logger().Log(myGoRPC.LevelError, "rpc registry: refresh error", myGoRPC.F("registry", d.registry), myGoRPC.F("err", err))
 		return err
 	}
 	servers := strings.Split(resp.Header.Get("GoRPC-Servers"), ",")
@@ -65,6 +67,13 @@ func (d *GoRegistryDiscovery) Refresh() error {
 	return nil
 }
 
+func (d *GoRegistryDiscovery) logger() myGoRPC.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return myGoRPC.DefaultLogger()
+}
+
 func (d *GoRegistryDiscovery) Get(mode SelectMode) (string, error) {
 	if err := d.Refresh(); err != nil {
 		return "", err
@@ -0,0 +1,140 @@
+package xclient
+
+import (
+	"context"
+	"myGoRPC"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+/*
+HedgePolicy
+对冲请求：调用在 Delay 之内没有结束时，向另一个服务实例发出相同的请求，采用最先成功的回复，并取消其余的调用；
+某次调用失败时立即发出下一个对冲请求（不再等待 Delay），全部失败时返回最后一个错误
+
+只对幂等的调用生效：以 myGoRPC.WithIdempotent 标记的 ctx，或者 Idempotent 中列出的 "服务名.方法名"；
+被取消的调用在客户端放弃等待，服务端可能仍会处理完成。对冲请求发往尚未尝试过的实例，没有其他实例时不再对冲
+
+Delay: 发出对冲请求之前等待的时长，通常取该方法的 P95 延迟
+MaxHedges: 每次调用最多额外发出的请求数，0 即为 1
+*/
+type HedgePolicy struct {
+	Delay      time.Duration
+	MaxHedges  int
+	Idempotent []string
+}
+
+// HedgeStats 对冲请求的计数
+type HedgeStats struct {
+	Hedged uint64 // 额外发出的请求数
+	Won    uint64 // 由对冲请求而不是第一个请求得到回复的调用数
+}
+
+// SetHedgePolicy 设置对冲策略，nil 即为关闭；应在发起调用之前设置
+func (xc *XClient) SetHedgePolicy(policy *HedgePolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.hedge = policy
+}
+
+// HedgeStats 当前的对冲计数
+func (xc *XClient) HedgeStats() HedgeStats {
+	return HedgeStats{Hedged: atomic.LoadUint64(&xc.hedged), Won: atomic.LoadUint64(&xc.hedgeWon)}
+}
+
+func (p *HedgePolicy) applies(ctx context.Context, service, method string) bool {
+	if myGoRPC.IsIdempotent(ctx) {
+		return true
+	}
+	name := service + "." + method
+	for _, m := range p.Idempotent {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+type hedgeResult struct {
+	attempt int
+	reply   interface{}
+	err     error
+}
+
+// hedgedCall 第一个请求发往 rpcAddr，之后的对冲请求依次发往其他实例
+func (xc *XClient) hedgedCall(rpcAddr string, policy *HedgePolicy, ctx context.Context, service, method string, args, reply interface{}) error {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	// 依次尝试的实例：rpcAddr 在前，其余保持 Discovery 的顺序
+	targets := []string{rpcAddr}
+	for _, server := range servers {
+		if server != rpcAddr && xc.ready(server) {
+			targets = append(targets, server)
+		}
+	}
+	maxHedges := policy.MaxHedges
+	if maxHedges <= 0 {
+		maxHedges = 1
+	}
+	if len(targets) > maxHedges+1 {
+		targets = targets[:maxHedges+1]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan hedgeResult, len(targets))
+	launch := func(attempt int) {
+		var clonedReply interface{}
+		if reply != nil {
+			// 每个请求写入各自的 reply 副本，避免并发写入同一个 reply
+			clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+		}
+		go func() {
+			err := xc.call(targets[attempt], ctx, service, method, args, clonedReply)
+			results <- hedgeResult{attempt: attempt, reply: clonedReply, err: err}
+		}()
+	}
+
+	launch(0)
+	launched, finished := 1, 0
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if launched < len(targets) {
+				launch(launched)
+				launched++
+				atomic.AddUint64(&xc.hedged, 1)
+				timer.Reset(policy.Delay)
+			}
+		case r := <-results:
+			finished++
+			if r.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				if r.attempt > 0 {
+					atomic.AddUint64(&xc.hedgeWon, 1)
+				}
+				return nil
+			}
+			err = r.err
+			if ctx.Err() != nil {
+				// 调用方的 ctx 已结束，不再对冲
+				return err
+			}
+			if launched < len(targets) {
+				launch(launched)
+				launched++
+				atomic.AddUint64(&xc.hedged, 1)
+				timer.Reset(policy.Delay)
+			} else if finished == launched {
+				return err
+			}
+		}
+	}
+}
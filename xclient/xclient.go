@@ -5,6 +5,8 @@ import (
 	"io"
 	"myGoRPC"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -14,11 +16,20 @@ type XClient struct {
 	opt     *myGoRPC.Option
 	mu      sync.Mutex
 	clients map[string]*myGoRPC.Client
+	ring    *hashRing // ConsistentHashSelect 使用，见 balance.go
+	next    int       // LeastPendingSelect 数量相同时轮流选择的起点
+
+	hedge    *HedgePolicy // 见 hedge.go
+	hedged   uint64
+	hedgeWon uint64
+
+	breakerPolicy *BreakerPolicy      // 见 breaker.go
+	breakers      map[string]*breaker // 服务实例 -> 熔断器
 }
 
 var _ io.Closer = (*XClient)(nil)
 
-func (xc XClient) Close() error {
+func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
 	for key, client := range xc.clients {
@@ -59,8 +70,12 @@ func (xc *XClient) dial(rpcAddr string) (*myGoRPC.Client, error) {
 		client = nil
 	}
 	if client == nil {
+		opt := xc.opt
+		if xc.breakerPolicy != nil {
+			opt = xc.breakerOption(rpcAddr, opt)
+		}
 		var err error
-		client, err = myGoRPC.XDial(rpcAddr, xc.opt)
+		client, err = myGoRPC.XDial(rpcAddr, opt)
 		if err != nil {
 			return nil, err
 		}
@@ -70,38 +85,72 @@ func (xc *XClient) dial(rpcAddr string) (*myGoRPC.Client, error) {
 }
 
 func (xc *XClient) call(rpcAddr string, ctx context.Context, service, method string, args, reply interface{}) error {
+	if err := xc.allow(rpcAddr); err != nil {
+		return err
+	}
 	client, err := xc.dial(rpcAddr)
 	if err != nil {
+		xc.record(rpcAddr, err)
 		return err
 	}
 	return client.Call(ctx, service, method, args, reply)
 }
 
 func (xc *XClient) Call(ctx context.Context, service, method string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	rpcAddr, err := xc.selectReady(ctx)
 	if err != nil {
 		return err
 	}
+	xc.mu.Lock()
+	policy := xc.hedge
+	xc.mu.Unlock()
+	if policy != nil && policy.applies(ctx, service, method) {
+		return xc.hedgedCall(rpcAddr, policy, ctx, service, method, args, reply)
+	}
 	return xc.call(rpcAddr, ctx, service, method, args, reply)
 }
 
+// Go 与 Client.Go 相同，按负载均衡策略选择服务实例；选择或连接失败时返回的 Call 已带有该错误
+func (xc *XClient) Go(service, method string, args, reply interface{}, done chan *myGoRPC.Call) *myGoRPC.Call {
+	rpcAddr, err := xc.selectReady(context.Background())
+	var client *myGoRPC.Client
+	if err == nil {
+		err = xc.allow(rpcAddr)
+	}
+	if err == nil {
+		if client, err = xc.dial(rpcAddr); err != nil {
+			xc.record(rpcAddr, err)
+		}
+	}
+	if err != nil {
+		if done == nil {
+			done = make(chan *myGoRPC.Call, 1)
+		}
+		call := &myGoRPC.Call{Service: service, Method: method, Args: args, Reply: reply, Error: err, Done: done}
+		done <- call
+		return call
+	}
+	return client.Go(service, method, args, reply, done)
+}
+
 /*
 Broadcast
 将请求广播到所有的服务实例
-如果任意一个实例发生错误，则返回其中一个错误；
-如果调用成功，则返回其中一个的结果。
- */
+只要有一个实例调用成功就返回 nil，reply 为最先成功的结果；
+全部失败时返回 *BroadcastError，包含每个实例的错误
+第一个成功的结果出现后，取消其余仍在等待的调用，不再等待它们的回复；已经发出的请求服务端仍会处理
+*/
 func (xc *XClient) Broadcast(ctx context.Context, service, method string, args, reply interface{}) error {
 	servers, err := xc.d.GetAll()
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	var e error
-
-	replyDone := (reply == nil)
-	ctx, cancel := context.WithCancel(ctx)
+	errs := make(map[string]error)
+	replyDone := false
 
 	for _, rpcAddr := range servers {
 		wg.Add(1)
@@ -109,22 +158,44 @@ func (xc *XClient) Broadcast(ctx context.Context, service, method string, args,
 			defer wg.Done()
 			var clonedReply interface{}
 			if reply != nil {
-				// todo: note
+				// 每个实例写入各自的 reply 副本，避免并发写入同一个 reply
 				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
 			err := xc.call(rpcAddr, ctx, service, method, args, clonedReply)
 			mu.Lock()
-			if err != nil && e == nil {
-				e = err
-				cancel()
+			defer mu.Unlock()
+			if err != nil {
+				errs[rpcAddr] = err
+				return
 			}
-			if err == nil && !replyDone {
+			if !replyDone && reply != nil {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(clonedReply).Elem())
-				replyDone = true
 			}
-			mu.Unlock()
+			replyDone = true
+			cancel()
 		}(rpcAddr)
 	}
 	wg.Wait()
-	return e
+	if replyDone || len(servers) == 0 {
+		return nil
+	}
+	return &BroadcastError{Errors: errs}
+}
+
+// BroadcastError Broadcast 全部失败时返回，键为服务实例的地址
+type BroadcastError struct {
+	Errors map[string]error
+}
+
+func (e *BroadcastError) Error() string {
+	addrs := make([]string, 0, len(e.Errors))
+	for addr := range e.Errors {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts[i] = addr + ": " + e.Errors[addr].Error()
+	}
+	return "rpc xclient: broadcast failed on all servers: " + strings.Join(parts, "; ")
 }
@@ -0,0 +1,370 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"myGoRPC"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type Sum int
+
+func (s Sum) Add(args [2]int, reply *int) error {
+	*reply = args[0] + args[1]
+	return nil
+}
+
+func startServer(t *testing.T) string {
+	return startServerWith(t, 0)
+}
+
+// startServerWith s 为 Wait 的延迟，单位 100ms
+func startServerWith(t *testing.T, s Sum) string {
+	server := myGoRPC.NewServer()
+	_ = server.Register(&s)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Broadcast(t *testing.T) {
+	t.Parallel()
+	live := startServer(t)
+	// 监听后立即关闭，模拟不可用的服务实例
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{live, dead}), RoundRobinSelect, nil)
+	defer xc.Close()
+	var reply int
+	if err := xc.Broadcast(context.Background(), "Sum", "Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect one success to win, got %d, %v", reply, err)
+	}
+
+	xcDead := NewXClient(NewMultiServerDiscovery([]string{dead}), RandomSelect, nil)
+	defer xcDead.Close()
+	var bErr *BroadcastError
+	err := xcDead.Broadcast(context.Background(), "Sum", "Add", [2]int{1, 2}, &reply)
+	if !errors.As(err, &bErr) || bErr.Errors[dead] == nil {
+		t.Fatalf("expect a BroadcastError listing the dead server, got %v", err)
+	}
+
+	xcLive := NewXClient(NewMultiServerDiscovery([]string{live}), RandomSelect, nil)
+	defer xcLive.Close()
+	call := <-xcLive.Go("Sum", "Add", [2]int{2, 3}, &reply, nil).Done
+	if call.Error != nil || reply != 5 {
+		t.Fatalf("go failed: %d, %v", reply, call.Error)
+	}
+}
+
+// Slow 睡眠 100ms 后回复，使请求保持未结束
+func (s Sum) Slow(args int, reply *int) error {
+	time.Sleep(time.Millisecond * 100)
+	*reply = args
+	return nil
+}
+
+func TestXClient_ConsistentHash(t *testing.T) {
+	t.Parallel()
+	servers := []string{startServer(t), startServer(t), startServer(t)}
+	d := NewMultiServerDiscovery(servers)
+	xc := NewXClient(d, ConsistentHashSelect, nil)
+	defer xc.Close()
+
+	owners := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := "user-" + strconv.Itoa(i)
+		ctx := WithHashKey(context.Background(), key)
+		first, _ := xc.selectServer(ctx)
+		again, _ := xc.selectServer(ctx)
+		if first != again {
+			t.Fatalf("key %s moved between %s and %s", key, first, again)
+		}
+		owners[key] = first
+	}
+	var reply int
+	if err := xc.Call(WithHashKey(context.Background(), "user-1"), "Sum", "Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("call failed: %d, %v", reply, err)
+	}
+
+	// 去掉一个实例，只有原本属于它的键改变归属
+	_ = d.Update(servers[:2])
+	for key, owner := range owners {
+		now, _ := xc.selectServer(WithHashKey(context.Background(), key))
+		if owner != servers[2] && now != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, now)
+		}
+	}
+}
+
+func TestXClient_LeastPending(t *testing.T) {
+	t.Parallel()
+	busy, idle := startServer(t), startServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{busy, idle}), LeastPendingSelect, nil)
+	defer xc.Close()
+
+	client, _ := xc.dial(busy)
+	var reply int
+	slow := client.Go("Sum", "Slow", 1, &reply, nil)
+	if _, err := xc.dial(idle); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if server, _ := xc.selectServer(context.Background()); server != idle {
+			t.Fatalf("expect the idle server, got %s", server)
+		}
+	}
+	<-slow.Done
+}
+
+// Wait 按实例的延迟回复
+func (s Sum) Wait(args int, reply *int) error {
+	time.Sleep(time.Duration(s) * time.Millisecond * 100)
+	*reply = int(s)
+	return nil
+}
+
+func TestXClient_BroadcastCancelsOthers(t *testing.T) {
+	t.Parallel()
+	fast, slow := startServerWith(t, 0), startServerWith(t, 20)
+	xc := NewXClient(NewMultiServerDiscovery([]string{fast, slow}), RandomSelect, nil)
+	defer xc.Close()
+
+	start := time.Now()
+	reply := -1
+	if err := xc.Broadcast(context.Background(), "Sum", "Wait", 0, &reply); err != nil || reply != 0 {
+		t.Fatalf("expect the fast reply, got %d, %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("broadcast waited %v for the slow server", elapsed)
+	}
+}
+
+func TestXClient_Hedge(t *testing.T) {
+	t.Parallel()
+	slow, fast := startServerWith(t, 5), startServerWith(t, 0)
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	policy := &HedgePolicy{Delay: 50 * time.Millisecond, Idempotent: []string{"Sum.Wait"}}
+	if !policy.applies(context.Background(), "Sum", "Wait") || policy.applies(context.Background(), "Sum", "Add") ||
+		!policy.applies(myGoRPC.WithIdempotent(context.Background()), "Sum", "Add") {
+		t.Fatal("hedging should only apply to idempotent calls")
+	}
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{slow, fast}), RoundRobinSelect, nil)
+	defer xc.Close()
+	xc.SetHedgePolicy(policy)
+	reply := -1
+	start := time.Now()
+	if err := xc.hedgedCall(slow, policy, context.Background(), "Sum", "Wait", 0, &reply); err != nil || reply != 0 {
+		t.Fatalf("expect the hedge on the fast server to win, got %d, %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("hedged call should not wait for the slow server, took %s", elapsed)
+	}
+	if stats := xc.HedgeStats(); stats.Hedged != 1 || stats.Won != 1 {
+		t.Fatalf("unexpected hedge stats %+v", stats)
+	}
+
+	// 失败时立即对冲，不等待 Delay
+	xcDead := NewXClient(NewMultiServerDiscovery([]string{dead, fast}), RoundRobinSelect, nil)
+	defer xcDead.Close()
+	start = time.Now()
+	if err := xcDead.hedgedCall(dead, &HedgePolicy{Delay: 10 * time.Second}, context.Background(), "Sum", "Wait", 0, &reply); err != nil || reply != 0 {
+		t.Fatalf("expect a hedge after the failure, got %d, %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("a failed call should be hedged immediately, took %s", elapsed)
+	}
+
+	xcAllDead := NewXClient(NewMultiServerDiscovery([]string{dead}), RoundRobinSelect, nil)
+	defer xcAllDead.Close()
+	xcAllDead.SetHedgePolicy(policy)
+	if err := xcAllDead.Call(context.Background(), "Sum", "Wait", 0, &reply); err == nil {
+		t.Fatal("expect an error when every server fails")
+	}
+}
+
+func TestXClient_Breaker(t *testing.T) {
+	t.Parallel()
+	live := startServer(t)
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{live, dead}), RoundRobinSelect, nil)
+	defer xc.Close()
+	xc.SetBreakerPolicy(&BreakerPolicy{MinRequests: 2, OpenTimeout: 200 * time.Millisecond})
+	var reply int
+	for i := 0; i < 2; i++ {
+		if err := xc.call(dead, context.Background(), "Sum", "Add", [2]int{1, 2}, &reply); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expect a dial error, got %v", err)
+		}
+	}
+	if err := xc.call(dead, context.Background(), "Sum", "Add", [2]int{1, 2}, &reply); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+	if state := xc.BreakerState(dead); state != BreakerOpen {
+		t.Fatalf("expect an open circuit, got %s", state)
+	}
+	// 选择实例时跳过熔断的实例
+	for i := 0; i < 6; i++ {
+		if err := xc.Call(context.Background(), "Sum", "Add", [2]int{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("call should skip the open circuit, got %d, %v", reply, err)
+		}
+	}
+	// 方法不存在等错误不计为失败
+	for i := 0; i < 4; i++ {
+		_ = xc.call(live, context.Background(), "Sum", "Missing", 1, &reply)
+	}
+	if state := xc.BreakerState(live); state != BreakerClosed {
+		t.Fatalf("application errors should not open the circuit, got %s", state)
+	}
+
+	// 半开后探测成功即关闭
+	time.Sleep(250 * time.Millisecond)
+	if state := xc.BreakerState(dead); state != BreakerHalfOpen {
+		t.Fatalf("expect a half-open circuit, got %s", state)
+	}
+	server := myGoRPC.NewServer()
+	_ = server.Register(new(Sum))
+	revived, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", port, err)
+	}
+	go server.Accept(revived)
+	defer func() { _ = revived.Close() }()
+	if err := xc.call(dead, context.Background(), "Sum", "Add", [2]int{2, 2}, &reply); err != nil || reply != 4 {
+		t.Fatalf("probe call failed: %d, %v", reply, err)
+	}
+	if state := xc.BreakerState(dead); state != BreakerClosed {
+		t.Fatalf("a successful probe should close the circuit, got %s", state)
+	}
+
+	l2, _ := net.Listen("tcp", ":0")
+	gone := "tcp@" + l2.Addr().String()
+	_ = l2.Close()
+	xcGone := NewXClient(NewMultiServerDiscovery([]string{gone}), RandomSelect, nil)
+	defer xcGone.Close()
+	xcGone.SetBreakerPolicy(&BreakerPolicy{MinRequests: 1})
+	_ = xcGone.Call(context.Background(), "Sum", "Add", [2]int{1, 2}, &reply)
+	if err := xcGone.Call(context.Background(), "Sum", "Add", [2]int{1, 2}, &reply); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect ErrCircuitOpen when every server is open, got %v", err)
+	}
+}
+
+// 被取消的探测调用不计入统计，熔断器保持半开，探测名额归还
+func TestXClient_BreakerCancelledProbes(t *testing.T) {
+	t.Parallel()
+	xc := NewXClient(NewMultiServerDiscovery(nil), RandomSelect, nil)
+	defer xc.Close()
+	xc.SetBreakerPolicy(&BreakerPolicy{MinRequests: 1, OpenTimeout: 10 * time.Millisecond})
+	const addr = "tcp@127.0.0.1:1"
+	xc.record(addr, errors.New("dial failed"))
+	if state := xc.BreakerState(addr); state != BreakerOpen {
+		t.Fatalf("expect an open circuit, got %s", state)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := xc.allow(addr); err != nil {
+			t.Fatalf("probe %d should be allowed: %v", i, err)
+		}
+		if err := xc.allow(addr); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("only one probe should run at a time, got %v", err)
+		}
+		xc.record(addr, fmt.Errorf("rpc client: call failed: %w", context.Canceled))
+		if state := xc.BreakerState(addr); state != BreakerHalfOpen {
+			t.Fatalf("cancelled probes should leave the circuit half-open, got %s", state)
+		}
+	}
+	if err := xc.allow(addr); err != nil {
+		t.Fatalf("the probe slot should be given back: %v", err)
+	}
+	xc.record(addr, nil)
+	if state := xc.BreakerState(addr); state != BreakerClosed {
+		t.Fatalf("a successful probe should close the circuit, got %s", state)
+	}
+}
+
+type levelLogger []myGoRPC.Level
+
+func (l *levelLogger) Log(level myGoRPC.Level, msg string, fields ...myGoRPC.Field) {
+	*l = append(*l, level)
+}
+
+func TestGoRegistryDiscovery_Logger(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	logs := &levelLogger{}
+	d := NewGoRegistryDiscovery("http://"+addr+"/mygorpc/registry", 0)
+	d.Logger = logs
+	if _, err := d.GetAll(); err == nil {
+		t.Fatal("expect an error from an unreachable registry")
+	}
+	if len(*logs) != 2 || (*logs)[0] != myGoRPC.LevelDebug || (*logs)[1] != myGoRPC.LevelError {
+		t.Fatalf("expect a refresh and an error log, got %v", *logs)
+	}
+}
+
+type Who string
+
+func (w Who) Name(_ int, reply *string) error {
+	*reply = string(w)
+	return nil
+}
+
+// startLoadServer 报告固定利用率的实例，Who.Name 返回 name
+func startLoadServer(t *testing.T, name string, util float64) string {
+	server := myGoRPC.NewServer()
+	server.ReportLoad = true
+	server.Utilization = func() float64 { return util }
+	_ = server.Register(Who(name))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_LeastLoad(t *testing.T) {
+	t.Parallel()
+	busy, idle := startLoadServer(t, "busy", 0.9), startLoadServer(t, "idle", 0)
+	xc := NewXClient(NewMultiServerDiscovery([]string{busy, idle}), LeastLoadSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	// 两个实例都建立连接、收到报告之前，没有报告的实例与空闲的实例相同
+	seen := map[string]bool{}
+	for i := 0; i < 10 && len(seen) < 2; i++ {
+		var name string
+		if err := xc.Call(context.Background(), "Who", "Name", 0, &name); err != nil {
+			t.Fatal(err)
+		}
+		seen[name] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expect both servers to be tried before any report, got %v", seen)
+	}
+	for i := 0; i < 20; i++ {
+		var name string
+		if err := xc.Call(context.Background(), "Who", "Name", 0, &name); err != nil || name != "idle" {
+			t.Fatalf("expect the reported load to steer calls to the idle server, got %q %v", name, err)
+		}
+	}
+}